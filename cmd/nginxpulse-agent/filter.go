@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+// filterConfig 是发送前的行处理规则，按 include/exclude → sample → redact 的顺序执行。
+type filterConfig struct {
+	// Include：非空时只保留匹配任一正则的行。
+	Include []string `json:"include"`
+	// Exclude：丢弃匹配任一正则的行（例如健康检查、静态资源）。
+	Exclude []string `json:"exclude"`
+	// Sample：按正则采样，使用第一条匹配的规则；采样率会随日志上报，服务端据此放大计数。
+	Sample []sampleRuleConfig `json:"sample"`
+	// Redact：正则替换，用于在日志离开主机前脱敏（例如 query 中的 token、邮箱）。
+	Redact []redactRuleConfig `json:"redact"`
+}
+
+type sampleRuleConfig struct {
+	Pattern string  `json:"pattern"`
+	Rate    float64 `json:"rate"`
+}
+
+type redactRuleConfig struct {
+	Pattern string `json:"pattern"`
+	// Replace 支持 $1 / ${name} 引用分组，默认 "***"。
+	Replace string `json:"replace"`
+}
+
+type sampleRule struct {
+	re        *regexp.Regexp
+	rate      float64
+	threshold uint32
+}
+
+type redactRule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+// lineFilter 是编译后的规则；采样基于行内容的哈希，同一行在重读/重启后得到相同的结果。
+type lineFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	sample  []sampleRule
+	redact  []redactRule
+	stats   filterStats
+}
+
+type filterStats struct {
+	excluded   int64
+	sampledOut int64
+	redacted   int64
+}
+
+func compileLineFilter(cfg *filterConfig) (*lineFilter, error) {
+	filter := &lineFilter{}
+	if cfg == nil {
+		return filter, nil
+	}
+	compileAll := func(field string, patterns []string) ([]*regexp.Regexp, error) {
+		compiled := make([]*regexp.Regexp, 0, len(patterns))
+		for i, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("filters.%s[%d] 正则无效: %w", field, i, err)
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled, nil
+	}
+	var err error
+	if filter.include, err = compileAll("include", cfg.Include); err != nil {
+		return nil, err
+	}
+	if filter.exclude, err = compileAll("exclude", cfg.Exclude); err != nil {
+		return nil, err
+	}
+	for i, rule := range cfg.Sample {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filters.sample[%d] 正则无效: %w", i, err)
+		}
+		if rule.Rate < 0 || rule.Rate > 1 {
+			return nil, fmt.Errorf("filters.sample[%d].rate 必须在 0~1 之间", i)
+		}
+		filter.sample = append(filter.sample, sampleRule{
+			re:        re,
+			rate:      rule.Rate,
+			threshold: uint32(rule.Rate * float64(^uint32(0))),
+		})
+	}
+	for i, rule := range cfg.Redact {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filters.redact[%d] 正则无效: %w", i, err)
+		}
+		replace := rule.Replace
+		if replace == "" {
+			replace = "***"
+		}
+		filter.redact = append(filter.redact, redactRule{re: re, replace: replace})
+	}
+	return filter, nil
+}
+
+func (f *lineFilter) Empty() bool {
+	return f == nil || (len(f.include) == 0 && len(f.exclude) == 0 && len(f.sample) == 0 && len(f.redact) == 0)
+}
+
+// Apply 处理一批行，返回保留的行及其采样率；所有行都未采样时 rates 为 nil。
+func (f *lineFilter) Apply(lines []string) ([]string, []float64) {
+	if f.Empty() {
+		return lines, nil
+	}
+	kept := lines[:0:0]
+	var rates []float64
+	for _, line := range lines {
+		if !f.allowed(line) {
+			f.stats.excluded++
+			continue
+		}
+		rate := 1.0
+		if rule, ok := f.matchSample(line); ok {
+			if rule.rate <= 0 || (rule.rate < 1 && lineHash(line) > rule.threshold) {
+				f.stats.sampledOut++
+				continue
+			}
+			rate = rule.rate
+		}
+		if rate < 1 && rates == nil {
+			rates = make([]float64, len(kept), len(lines))
+			for i := range rates {
+				rates[i] = 1
+			}
+		}
+		if rates != nil {
+			rates = append(rates, rate)
+		}
+		kept = append(kept, f.redactLine(line))
+	}
+	return kept, rates
+}
+
+func (f *lineFilter) allowed(line string) bool {
+	if len(f.include) > 0 {
+		matched := false
+		for _, re := range f.include {
+			if re.MatchString(line) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *lineFilter) matchSample(line string) (sampleRule, bool) {
+	for _, rule := range f.sample {
+		if rule.re.MatchString(line) {
+			return rule, true
+		}
+	}
+	return sampleRule{}, false
+}
+
+func (f *lineFilter) redactLine(line string) string {
+	if len(f.redact) == 0 {
+		return line
+	}
+	out := line
+	for _, rule := range f.redact {
+		out = rule.re.ReplaceAllString(out, rule.replace)
+	}
+	if out != line {
+		f.stats.redacted++
+	}
+	return out
+}
+
+func lineHash(line string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(strings.TrimSpace(line)))
+	return h.Sum32()
+}
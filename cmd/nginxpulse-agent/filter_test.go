@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLineFilterSamplingIsDeterministic(t *testing.T) {
+	cfg := &filterConfig{Sample: []sampleRuleConfig{{Pattern: `\.js`, Rate: 0.1}}}
+	lines := make([]string, 0, 20000)
+	for i := 0; i < 20000; i++ {
+		lines = append(lines, fmt.Sprintf(`10.0.0.%d - - "GET /static/app-%d.js HTTP/1.1" 200 512`, i%255, i))
+	}
+
+	first, err := compileLineFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keptFirst, ratesFirst := first.Apply(append([]string(nil), lines...))
+
+	// 重启后重新编译规则、重读同一批行，采样结果必须完全一致
+	second, err := compileLineFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keptSecond, _ := second.Apply(append([]string(nil), lines...))
+	if len(keptFirst) != len(keptSecond) {
+		t.Fatalf("sampling differs between runs: %d vs %d", len(keptFirst), len(keptSecond))
+	}
+	for i := range keptFirst {
+		if keptFirst[i] != keptSecond[i] {
+			t.Fatalf("line %d differs between runs", i)
+		}
+	}
+
+	if ratio := float64(len(keptFirst)) / float64(len(lines)); ratio < 0.08 || ratio > 0.12 {
+		t.Fatalf("kept ratio %.3f is far from the configured rate 0.1", ratio)
+	}
+	if len(ratesFirst) != len(keptFirst) {
+		t.Fatalf("rates must align with kept lines: %d rates, %d lines", len(ratesFirst), len(keptFirst))
+	}
+	for _, rate := range ratesFirst {
+		if rate != 0.1 {
+			t.Fatalf("unexpected rate %v", rate)
+		}
+	}
+	if first.stats.sampledOut != int64(len(lines)-len(keptFirst)) {
+		t.Fatalf("sampledOut = %d, want %d", first.stats.sampledOut, len(lines)-len(keptFirst))
+	}
+
+	// 行尾空白不影响采样结果
+	if lineHash(lines[0]) != lineHash(lines[0]+"\r\n") {
+		t.Fatal("trailing whitespace must not change the sampling hash")
+	}
+}
+
+func TestLineFilterApplyOrder(t *testing.T) {
+	filter, err := compileLineFilter(&filterConfig{
+		Include: []string{`GET `},
+		Exclude: []string{`/healthz`},
+		Sample: []sampleRuleConfig{
+			{Pattern: `/drop`, Rate: 0},
+			{Pattern: `/keep`, Rate: 1},
+		},
+		Redact: []redactRuleConfig{{Pattern: `token=[^ &]+`, Replace: "token=***"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, rates := filter.Apply([]string{
+		`"GET /healthz HTTP/1.1" 200`,
+		`"POST /api HTTP/1.1" 200`,
+		`"GET /drop HTTP/1.1" 200`,
+		`"GET /keep?token=abc HTTP/1.1" 200`,
+		`"GET /other HTTP/1.1" 200`,
+	})
+	want := []string{`"GET /keep?token=*** HTTP/1.1" 200`, `"GET /other HTTP/1.1" 200`}
+	if len(kept) != len(want) || kept[0] != want[0] || kept[1] != want[1] {
+		t.Fatalf("unexpected kept lines %q", kept)
+	}
+	if rates != nil {
+		t.Fatalf("rates should be nil when nothing is sampled, got %v", rates)
+	}
+	if filter.stats.excluded != 2 || filter.stats.sampledOut != 1 || filter.stats.redacted != 1 {
+		t.Fatalf("unexpected stats %+v", filter.stats)
+	}
+
+	if _, err := compileLineFilter(&filterConfig{Sample: []sampleRuleConfig{{Pattern: `.`, Rate: 1.5}}}); err == nil {
+		t.Fatal("rate above 1 must be rejected")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows 上 os.FileInfo 不提供 inode，轮转检测退化为仅按文件大小判断。
+func fileInode(info os.FileInfo) uint64 {
+	_ = info
+	return 0
+}
@@ -10,24 +10,35 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/likaia/nginxpulse/internal/agentproto"
+	"github.com/likaia/nginxpulse/internal/version"
 	"github.com/sirupsen/logrus"
 )
 
 type agentConfig struct {
-	Server        string   `json:"server"`
-	AccessKey     string   `json:"accessKey"`
-	WebsiteID     string   `json:"websiteID"`
-	SourceID      string   `json:"sourceID"`
-	Paths         []string `json:"paths"`
-	PollInterval  string   `json:"pollInterval"`
-	BatchSize     int      `json:"batchSize"`
-	FlushInterval string   `json:"flushInterval"`
+	Server    string `json:"server"`
+	AccessKey string `json:"accessKey"`
+	WebsiteID string `json:"websiteID"`
+	SourceID  string `json:"sourceID"`
+	// Paths：推送到顶层 websiteID/sourceID 的日志路径，支持 glob（例如 "/var/log/nginx/*.access.log"）。
+	Paths []string `json:"paths"`
+	// Inputs：按路径（或 glob）映射到不同站点/来源，便于一台主机上的单个 agent 采集所有站点。
+	// 未填写 websiteID/sourceID 时使用顶层配置。
+	Inputs []agentInput `json:"inputs"`
+	// DiscoverInterval：重新展开 glob、发现新文件的间隔（例如 "30s"）。
+	DiscoverInterval string `json:"discoverInterval"`
+	PollInterval     string `json:"pollInterval"`
+	BatchSize        int    `json:"batchSize"`
+	FlushInterval    string `json:"flushInterval"`
 	// RequestTimeout：推送日志时的 HTTP 请求超时（例如 "30s", "2m"）。
 	RequestTimeout string `json:"requestTimeout"`
 	// MaxPendingLines：内存中待发送缓冲区（pending）的最大积压行数；达到后会暂停继续读取新日志，直到积压被发送消化。
@@ -43,34 +54,60 @@ type agentConfig struct {
 	// ExitOnMaxBackoff：当退避已达到 RetryBackoffMax 且再次推送仍失败时，是否直接退出进程（让 k8s 重启容器）。
 	// 默认：false。
 	ExitOnMaxBackoff bool `json:"exitOnMaxBackoff"`
+	// StateFile：读取进度（inode + offset）的持久化文件，重启后从上次位置继续读取。
+	// 默认："./var/nginxpulse_agent/state.json"。
+	StateFile string `json:"stateFile"`
+	// SpoolDir：推送失败时待发送批次的落盘目录，恢复后按写入顺序回放。
+	// 默认：StateFile 所在目录下的 spool 子目录。
+	SpoolDir string `json:"spoolDir"`
+	// MaxSpoolBytes：spool 最大占用字节数；写满后 pending 留在内存中，达到 MaxPendingLines 后暂停读取。
+	// 默认：256MiB。
+	MaxSpoolBytes int64 `json:"maxSpoolBytes"`
+	// Filters：发送前的过滤、采样与脱敏规则。
+	Filters *filterConfig `json:"filters"`
+	// Protocol：推送协议，auto（默认，优先 v2，不支持时回退 v1）、v1 或 v2。
+	Protocol string `json:"protocol"`
+	// Compression：v2 请求体压缩方式，zstd（默认）、gzip 或 none。
+	Compression string `json:"compression"`
+	// AgentID：agent 标识，服务端按 agent/站点/来源 记录已确认的批次序号。
+	// 默认：主机名。
+	AgentID string `json:"agentID"`
+	// AgentToken：服务端 system.agentTokens 中绑定到该站点的令牌，可代替 accessKey（仅 v2）。
+	AgentToken string `json:"agentToken"`
+	// HMACSecret：与服务端令牌配置一致的签名密钥；为空时不签名。
+	HMACSecret string `json:"hmacSecret"`
+	// HeartbeatInterval：向服务端上报心跳（版本、文件进度、积压、最近错误）的间隔，"0" 表示关闭。
+	// 默认："30s"；仅 v2 服务端支持。
+	HeartbeatInterval string `json:"heartbeatInterval"`
+	// TLSCertFile / TLSKeyFile：客户端证书与私钥，服务端配置 server.tls.clientCaFile（mTLS）时必填。
+	// 证书文件轮换后自动重新加载。
+	TLSCertFile string `json:"tlsCertFile"`
+	TLSKeyFile  string `json:"tlsKeyFile"`
+	// TLSCAFile：校验服务端证书的 CA，服务端使用自签名证书时填写；为空时使用系统根证书。
+	TLSCAFile string `json:"tlsCAFile"`
 }
 
-type ingestRequest struct {
-	WebsiteID string   `json:"website_id"`
-	SourceID  string   `json:"source_id"`
-	Lines     []string `json:"lines"`
+type agentInput struct {
+	Paths     []string `json:"paths"`
+	WebsiteID string   `json:"websiteID"`
+	SourceID  string   `json:"sourceID"`
 }
 
-type fileState struct {
-	offset   int64
-	lastSize int64
-	partial  string
-}
+const (
+	defaultStateFile     = "./var/nginxpulse_agent/state.json"
+	defaultMaxSpoolBytes = 256 * 1024 * 1024
+)
 
-type readStats struct {
-	path      string
-	from      int64
-	to        int64
-	fileSize  int64
-	lines     int
-	bytes     int64
-	hasPartial bool
-	skippedLines int
-	maxLineBytes int
+type ingestRequest struct {
+	WebsiteID   string    `json:"website_id"`
+	SourceID    string    `json:"source_id"`
+	Lines       []string  `json:"lines"`
+	SampleRates []float64 `json:"sample_rates,omitempty"`
 }
 
 func main() {
 	configPath := flag.String("config", "configs/nginxpulse_agent.json", "agent config path")
+	showStatus := flag.Bool("status", false, "print persisted offsets and spool backlog, then exit")
 	flag.Parse()
 
 	cfg, err := loadConfig(*configPath)
@@ -80,6 +117,27 @@ func main() {
 	}
 	applyEnvOverrides(cfg)
 
+	stateFile := strings.TrimSpace(cfg.StateFile)
+	if stateFile == "" {
+		stateFile = defaultStateFile
+	}
+	spoolDir := strings.TrimSpace(cfg.SpoolDir)
+	if spoolDir == "" {
+		spoolDir = filepath.Join(filepath.Dir(stateFile), "spool")
+	}
+	maxSpoolBytes := cfg.MaxSpoolBytes
+	if maxSpoolBytes <= 0 {
+		maxSpoolBytes = defaultMaxSpoolBytes
+	}
+
+	if *showStatus {
+		if err := printStatus(cfg, stateFile, spoolDir, maxSpoolBytes); err != nil {
+			fmt.Fprintf(os.Stderr, "读取 agent 状态失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	pollInterval := parseDuration(cfg.PollInterval, time.Second)
 	flushInterval := parseDuration(cfg.FlushInterval, 2*time.Second)
 	requestTimeout := parseDuration(cfg.RequestTimeout, 90*time.Second)
@@ -101,19 +159,52 @@ func main() {
 	if sourceID == "" {
 		sourceID = "agent"
 	}
+	discoverInterval := parseDuration(cfg.DiscoverInterval, 30*time.Second)
+	filter, err := compileLineFilter(cfg.Filters)
+	if err != nil {
+		logrus.WithError(err).Error("加载 agent 过滤规则失败")
+		os.Exit(1)
+	}
+	hostname, _ := os.Hostname()
+	agentID := strings.TrimSpace(cfg.AgentID)
+	if agentID == "" {
+		agentID = hostname
+	}
+	heartbeatInterval := parseDuration(cfg.HeartbeatInterval, 30*time.Second)
+
+	savedState, err := loadPersistedState(stateFile)
+	if err != nil {
+		logrus.WithError(err).Errorf("读取 agent 状态文件失败: %s", stateFile)
+		os.Exit(1)
+	}
+	spool, err := openDiskSpool(spoolDir, maxSpoolBytes)
+	if err != nil {
+		logrus.WithError(err).Errorf("初始化 spool 目录失败: %s", spoolDir)
+		os.Exit(1)
+	}
 
-	endpoint := strings.TrimRight(cfg.Server, "/") + "/api/ingest/logs"
-	states := make(map[string]*fileState)
-	pending := make([]string, 0, batchSize)
+	client, err := newPusher(cfg, agentID, requestTimeout)
+	if err != nil {
+		logrus.WithError(err).Error("加载 agent TLS 证书失败")
+		os.Exit(1)
+	}
+	files := newTailer(buildInputPatterns(cfg, sourceID), savedState)
+	files.Discover()
+	defer files.Close()
+	pending := newPendingBuffer()
 	var (
-		nextPushAt    time.Time
-		failures      int
-		reachedMax    bool
-		lastErrLogged time.Time
-		lastMemLogged time.Time
-		lastReadLogged time.Time
-		lastPushLogged time.Time
+		nextPushAt             time.Time
+		failures               int
+		reachedMax             bool
+		lastErrLogged          time.Time
+		lastMemLogged          time.Time
+		lastReadLogged         time.Time
+		lastPushLogged         time.Time
 		lastBackpressureLogged time.Time
+		lastSpoolFullLogged    time.Time
+		lastError              string
+		lastErrorAt            time.Time
+		lastSkewLogged         time.Time
 	)
 	// 用于比较的“有效最大退避时间”（computeBackoff 在 max<=0 时会使用默认值）。
 	effectiveBackoffMax := backoffMax
@@ -121,56 +212,293 @@ func main() {
 		effectiveBackoffMax = 30 * time.Second
 	}
 
+	spoolStatsAtStart := spool.Stats()
 	logrus.WithFields(logrus.Fields{
-		"endpoint":              endpoint,
-		"poll_interval":         pollInterval.String(),
-		"flush_interval":        flushInterval.String(),
-		"batch_size":            batchSize,
-		"max_pending_lines":     maxPending,
-		"max_line_bytes":        maxLineBytes,
-		"request_timeout":       requestTimeout.String(),
-		"retry_backoff_min":     backoffMin.String(),
-		"retry_backoff_max":     effectiveBackoffMax.String(),
-		"exit_on_max_backoff":   cfg.ExitOnMaxBackoff,
-		"paths":                 cfg.Paths,
-		"website_id":            cfg.WebsiteID,
-		"source_id":             sourceID,
+		"server":              strings.TrimRight(cfg.Server, "/"),
+		"protocol":            client.Describe(),
+		"agent_id":            agentID,
+		"stream_id":           spool.StreamID(),
+		"poll_interval":       pollInterval.String(),
+		"flush_interval":      flushInterval.String(),
+		"batch_size":          batchSize,
+		"max_pending_lines":   maxPending,
+		"max_line_bytes":      maxLineBytes,
+		"request_timeout":     requestTimeout.String(),
+		"retry_backoff_min":   backoffMin.String(),
+		"retry_backoff_max":   effectiveBackoffMax.String(),
+		"exit_on_max_backoff": cfg.ExitOnMaxBackoff,
+		"paths":               cfg.Paths,
+		"inputs":              len(cfg.Inputs),
+		"discover_interval":   discoverInterval.String(),
+		"heartbeat_interval":  heartbeatInterval.String(),
+		"filters":             !filter.Empty(),
+		"website_id":          cfg.WebsiteID,
+		"source_id":           sourceID,
+		"state_file":          stateFile,
+		"spool_dir":           spoolDir,
+		"max_spool_bytes":     formatBytes(maxSpoolBytes),
+		"files":               len(files.Paths()),
+		"spool_segments":      spoolStatsAtStart.Segments,
+		"spool_lines":         spoolStatsAtStart.Lines,
 	}).Info("nginxpulse-agent: config loaded")
 
+	// persist 只在 pending 为空时调用：此时内存中的 offset 之前的日志要么已推送，要么已写入 spool。
+	persist := func() {
+		if pending.Len() > 0 {
+			return
+		}
+		if err := savePersistedState(stateFile, files.states); err != nil {
+			logrus.WithError(err).Warnf("保存 agent 状态失败: %s", stateFile)
+		}
+	}
+
+	// spillBatch 把一个分组写入 spool；spool 已满时保留在内存中，由 maxPending 背压兜底。
+	// batch.Seq 非 0 表示该批次已经以此序号推送过，落盘时沿用，保证重试可被服务端识别。
+	spillBatch := func(batch spoolBatch) bool {
+		if err := spool.Append(batch); err != nil {
+			if time.Since(lastSpoolFullLogged) > 10*time.Second {
+				lastSpoolFullLogged = time.Now()
+				if errors.Is(err, errSpoolFull) {
+					logrus.WithFields(logrus.Fields{
+						"pending_lines":   pending.Len(),
+						"max_spool_bytes": formatBytes(maxSpoolBytes),
+					}).Warn("spool is full; keeping pending in memory")
+				} else {
+					logrus.WithError(err).Warn("写入 spool 失败")
+				}
+			}
+			return false
+		}
+		pending.Remove(tailTarget{WebsiteID: batch.WebsiteID, SourceID: batch.SourceID}, batch.Seq)
+		return true
+	}
+
+	// spill 把 pending 的所有分组写入 spool。
+	spill := func() bool {
+		for _, batch := range pending.Batches() {
+			if !spillBatch(batch) {
+				return false
+			}
+		}
+		persist()
+		return true
+	}
+
+	recordFailure := func(err error, trigger string) {
+		lastError = err.Error()
+		lastErrorAt = time.Now()
+		failures++
+		delay := computeBackoff(failures, backoffMin, backoffMax)
+		// 如果此前已达到最大退避，并且等待后依然失败，则按配置可选择直接退出进程。
+		if cfg.ExitOnMaxBackoff && reachedMax && delay >= effectiveBackoffMax {
+			spill()
+			logrus.WithError(err).Errorf("日志推送连续失败且退避已达上限 %s，终止 agent 进程", effectiveBackoffMax)
+			os.Exit(1)
+		}
+		nextPushAt = time.Now().Add(delay)
+		reachedMax = delay >= effectiveBackoffMax
+		// 避免刷屏：最多每 5 秒打印一次 warning。
+		if time.Since(lastErrLogged) > 5*time.Second {
+			lastErrLogged = time.Now()
+			logrus.WithError(err).Warnf("日志推送失败，将在 %s 后重试", time.Until(nextPushAt).Truncate(time.Millisecond))
+			logrus.WithFields(logrus.Fields{
+				"pending_lines":       pending.Len(),
+				"spool_segments":      spool.Len(),
+				"batch_size":          batchSize,
+				"failures":            failures,
+				"backoff_next":        delay.String(),
+				"backoff_max":         effectiveBackoffMax.String(),
+				"reached_max_backoff": reachedMax,
+				"trigger":             trigger,
+				"protocol":            client.Describe(),
+			}).Warn("push failed (debug)")
+		}
+	}
+
+	recordSuccess := func(pushed int, trigger string) {
+		// 成功后：周期性打印推送摘要，方便观测吞吐与积压变化。
+		if time.Since(lastPushLogged) > 30*time.Second || failures > 0 {
+			lastPushLogged = time.Now()
+			logrus.WithFields(logrus.Fields{
+				"pushed_lines":   pushed,
+				"pending_lines":  pending.Len(),
+				"spool_segments": spool.Len(),
+				"failures_reset": failures,
+				"trigger":        trigger,
+			}).Info("push succeeded")
+		}
+		failures = 0
+		reachedMax = false
+		nextPushAt = time.Time{}
+	}
+
+	// deliver 先按顺序回放 spool，再推送内存中的 pending。
+	// spool 非空时 pending 会先落盘排到队尾，保证服务端收到的顺序与读取顺序一致。
+	deliver := func(trigger string) {
+		if spool.Len() > 0 && pending.Len() > 0 {
+			spill()
+		}
+		// 遵守退避窗口：在 backoff 时间内不进行推送尝试。
+		if !nextPushAt.IsZero() && time.Now().Before(nextPushAt) {
+			if pending.Len() >= batchSize {
+				spill()
+			}
+			return
+		}
+		for spool.Len() > 0 {
+			segment, batch, ok, err := spool.Oldest()
+			if err != nil {
+				logrus.WithError(err).Errorf("读取 spool 段文件失败，已丢弃: %s", segment.name)
+				_ = spool.Remove(segment)
+				continue
+			}
+			if !ok {
+				break
+			}
+			if err := client.Push(batch, spool.StreamID()); err != nil {
+				recordFailure(err, trigger+"/spool")
+				return
+			}
+			if err := spool.Remove(segment); err != nil {
+				logrus.WithError(err).Warnf("删除 spool 段文件失败: %s", segment.name)
+			}
+			recordSuccess(len(batch.Lines), trigger+"/spool")
+		}
+		if pending.Len() == 0 {
+			return
+		}
+		for _, batch := range pending.Batches() {
+			target := tailTarget{WebsiteID: batch.WebsiteID, SourceID: batch.SourceID}
+			// 序号记录在 pending 分组上直到确认送达：推送失败且 spool 已满时，
+			// 下次重试沿用同一序号，服务端才能识别为重复批次。
+			// 分配序号失败时以 seq=0 推送，此时仅依赖服务端的内容去重。
+			if batch.Seq == 0 {
+				seq, err := spool.AllocSeq()
+				if err != nil {
+					logrus.WithError(err).Warn("分配批次序号失败")
+				}
+				pending.Seal(target, seq)
+				batch.Seq = seq
+			}
+			if err := client.Push(batch, spool.StreamID()); err != nil {
+				// 先落盘再记录失败：recordFailure 可能直接退出进程。
+				if spillBatch(batch) {
+					spill()
+				}
+				recordFailure(err, trigger)
+				return
+			}
+			pending.Remove(target, batch.Seq)
+			recordSuccess(len(batch.Lines), trigger)
+		}
+		persist()
+	}
+
+	// heartbeat 上报运行状态，服务端据此维护 agent 列表、发现心跳中断与时钟偏差。
+	heartbeat := func() {
+		hb := agentproto.Heartbeat{
+			AgentID:         agentID,
+			Version:         version.Version,
+			Hostname:        hostname,
+			StreamID:        spool.StreamID(),
+			IntervalSeconds: int(heartbeatInterval / time.Second),
+			Files:           make([]agentproto.HeartbeatFile, 0, len(files.Paths())),
+			LastError:       lastError,
+		}
+		if !lastErrorAt.IsZero() {
+			hb.LastErrorAt = lastErrorAt.UnixMilli()
+		}
+		for _, path := range files.Paths() {
+			state := files.State(path)
+			hb.Files = append(hb.Files, agentproto.HeartbeatFile{
+				Path:      path,
+				WebsiteID: state.target.WebsiteID,
+				SourceID:  state.target.SourceID,
+				Offset:    state.offset,
+				Size:      state.lastSize,
+				Draining:  state.draining != nil,
+			})
+		}
+		stats := spool.Stats()
+		hb.Backlog = agentproto.HeartbeatBacklog{
+			PendingLines:  pending.Len(),
+			SpoolSegments: stats.Segments,
+			SpoolLines:    stats.Lines,
+			SpoolBytes:    stats.Bytes,
+		}
+		ack, sent, err := client.SendHeartbeat(hb)
+		if err != nil {
+			logrus.WithError(err).Debug("发送心跳失败")
+			return
+		}
+		if !sent {
+			return
+		}
+		skew := time.Duration(ack.ClockSkewMs) * time.Millisecond
+		if (skew > agentproto.MaxClockSkew || skew < -agentproto.MaxClockSkew) && time.Since(lastSkewLogged) > 10*time.Minute {
+			lastSkewLogged = time.Now()
+			logrus.WithField("clock_skew", skew.Round(time.Millisecond).String()).Warn("local clock differs from server; signed pushes may be rejected")
+		}
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+
 	pollTicker := time.NewTicker(pollInterval)
 	flushTicker := time.NewTicker(flushInterval)
+	discoverTicker := time.NewTicker(discoverInterval)
 	defer pollTicker.Stop()
 	defer flushTicker.Stop()
+	defer discoverTicker.Stop()
+	var heartbeatC <-chan time.Time
+	if heartbeatInterval > 0 {
+		heartbeatTicker := time.NewTicker(heartbeatInterval)
+		defer heartbeatTicker.Stop()
+		heartbeatC = heartbeatTicker.C
+		heartbeat()
+	}
 
 	for {
 		select {
+		case sig := <-shutdown:
+			// 退出前尽量把 pending 落盘；落盘失败时不更新状态文件，重启后会从上次安全位置重读。
+			spill()
+			stats := spool.Stats()
+			logrus.WithFields(logrus.Fields{
+				"signal":         sig.String(),
+				"pending_lines":  pending.Len(),
+				"spool_segments": stats.Segments,
+				"spool_lines":    stats.Lines,
+			}).Info("nginxpulse-agent: shutting down")
+			return
 		case <-pollTicker.C:
-			// 背压：如果 pending 积压过大，则暂停读取，直到成功推送一部分数据。
-			if len(pending) >= maxPending {
+			// 背压：如果 pending 积压过大（spool 也已写满），则暂停读取，直到成功推送一部分数据。
+			if pending.Len() >= maxPending {
 				if time.Since(lastBackpressureLogged) > 10*time.Second {
 					lastBackpressureLogged = time.Now()
 					logrus.WithFields(logrus.Fields{
-						"pending_lines":      len(pending),
-						"max_pending_lines":  maxPending,
-						"failures":           failures,
-						"next_push_in":       durationUntil(nextPushAt).Truncate(time.Millisecond).String(),
+						"pending_lines":     pending.Len(),
+						"max_pending_lines": maxPending,
+						"spool_full":        spool.Full(),
+						"failures":          failures,
+						"next_push_in":      durationUntil(nextPushAt).Truncate(time.Millisecond).String(),
 					}).Warn("pending buffer is full; pausing reads")
 				}
 				continue
 			}
-			for _, path := range cfg.Paths {
-				if len(pending) >= maxPending {
+			for _, path := range files.Paths() {
+				if pending.Len() >= maxPending {
 					break
 				}
 				if strings.HasSuffix(strings.ToLower(path), ".gz") {
 					continue
 				}
-				state := states[path]
-				if state == nil {
-					state = &fileState{}
-					states[path] = state
-				}
+				state := files.State(path)
 				lines, st, err := readNewLines(path, state, maxLineBytes)
+				if len(lines) > 0 {
+					kept, rates := filter.Apply(lines)
+					pending.Add(state.target, kept, rates)
+				}
 				if err != nil {
 					logrus.WithError(err).Warnf("读取日志失败: %s", path)
 					continue
@@ -182,122 +510,130 @@ func main() {
 				if st.lines >= batchSize || st.bytes >= 4*1024*1024 || time.Since(lastReadLogged) > 30*time.Second {
 					lastReadLogged = time.Now()
 					logrus.WithFields(logrus.Fields{
-						"path":          st.path,
-						"lines":         st.lines,
-						"skipped_lines": st.skippedLines,
+						"path":           st.path,
+						"lines":          st.lines,
+						"skipped_lines":  st.skippedLines,
 						"max_line_bytes": st.maxLineBytes,
-						"bytes":         formatBytes(st.bytes),
-						"file_size":     formatBytes(st.fileSize),
-						"offset_from":   st.from,
-						"offset_to":     st.to,
-						"offset_delta":  st.to - st.from,
-						"has_partial":   st.hasPartial,
-						"pending_lines": len(pending),
+						"bytes":          formatBytes(st.bytes),
+						"file_size":      formatBytes(st.fileSize),
+						"offset_from":    st.from,
+						"offset_to":      st.to,
+						"offset_delta":   st.to - st.from,
+						"has_partial":    st.hasPartial,
+						"drained_lines":  st.drainedLines,
+						"rotated":        st.rotated,
+						"website_id":     state.target.WebsiteID,
+						"source_id":      state.target.SourceID,
+						"pending_lines":  pending.Len(),
 					}).Info("read new lines")
 				}
-				pending = append(pending, lines...)
-				if len(pending) >= batchSize {
-					// 遵守退避窗口：在 backoff 时间内不进行推送尝试。
-					if !nextPushAt.IsZero() && time.Now().Before(nextPushAt) {
-						continue
-					}
-					if err := pushLines(requestTimeout, endpoint, cfg.AccessKey, cfg.WebsiteID, sourceID, pending); err != nil {
-						failures++
-						delay := computeBackoff(failures, backoffMin, backoffMax)
-						// 如果此前已达到最大退避，并且等待后依然失败，则按配置可选择直接退出进程。
-						if cfg.ExitOnMaxBackoff && reachedMax && delay >= effectiveBackoffMax {
-							logrus.WithError(err).Errorf("日志推送连续失败且退避已达上限 %s，终止 agent 进程", effectiveBackoffMax)
-							os.Exit(1)
-						}
-						nextPushAt = time.Now().Add(delay)
-						reachedMax = delay >= effectiveBackoffMax
-						// 避免刷屏：最多每 5 秒打印一次 warning。
-						if time.Since(lastErrLogged) > 5*time.Second {
-							lastErrLogged = time.Now()
-							logrus.WithError(err).Warnf("日志推送失败，将在 %s 后重试", time.Until(nextPushAt).Truncate(time.Millisecond))
-							logrus.WithFields(logrus.Fields{
-								"pending_lines":      len(pending),
-								"batch_size":         batchSize,
-								"failures":           failures,
-								"backoff_next":       delay.String(),
-								"backoff_max":        effectiveBackoffMax.String(),
-								"reached_max_backoff": reachedMax,
-							}).Warn("push failed (debug)")
-						}
-						continue
-					}
-					// 成功后：周期性打印推送摘要，方便观测吞吐与 pending 容量变化。
-					if time.Since(lastPushLogged) > 30*time.Second || failures > 0 {
-						lastPushLogged = time.Now()
-						logrus.WithFields(logrus.Fields{
-							"pushed_lines":     len(pending),
-							"pending_lines":    len(pending),
-							"pending_cap":      cap(pending),
-							"failures_reset":   failures,
-						}).Info("push succeeded")
-					}
-					pending = resetPending(pending, batchSize, maxPending)
-					failures = 0
-					reachedMax = false
-					nextPushAt = time.Time{}
+				if pending.Len() >= batchSize {
+					deliver("batch_size")
 				}
 			}
 			// 周期性内存统计：用于与 OOMKilled 时间点对齐分析。
 			if time.Since(lastMemLogged) > 30*time.Second {
 				lastMemLogged = time.Now()
 				logMemStats("mem")
+				stats := spool.Stats()
 				logrus.WithFields(logrus.Fields{
-					"pending_lines":     len(pending),
+					"pending_lines":     pending.Len(),
+					"files":             len(files.Paths()),
 					"batch_size":        batchSize,
 					"max_pending_lines": maxPending,
+					"spool_segments":    stats.Segments,
+					"spool_lines":       stats.Lines,
+					"spool_bytes":       formatBytes(stats.Bytes),
+					"filtered_lines":    filter.stats.excluded,
+					"sampled_out_lines": filter.stats.sampledOut,
+					"redacted_lines":    filter.stats.redacted,
 					"failures":          failures,
 					"next_push_in":      durationUntil(nextPushAt).Truncate(time.Millisecond).String(),
 				}).Info("agent status")
 			}
-		case <-flushTicker.C:
-			if len(pending) == 0 {
-				continue
-			}
-			if !nextPushAt.IsZero() && time.Now().Before(nextPushAt) {
-				continue
+		case <-discoverTicker.C:
+			// 周期性重新展开 glob：新站点/新文件无需重启 agent 即可开始采集。
+			if added := files.Discover(); len(added) > 0 {
+				logrus.WithField("paths", added).Info("discovered new log files")
 			}
-			if err := pushLines(requestTimeout, endpoint, cfg.AccessKey, cfg.WebsiteID, sourceID, pending); err != nil {
-				failures++
-				delay := computeBackoff(failures, backoffMin, backoffMax)
-				if cfg.ExitOnMaxBackoff && reachedMax && delay >= effectiveBackoffMax {
-					logrus.WithError(err).Errorf("日志推送连续失败且退避已达上限 %s，终止 agent 进程", effectiveBackoffMax)
-					os.Exit(1)
-				}
-				nextPushAt = time.Now().Add(delay)
-				reachedMax = delay >= effectiveBackoffMax
-				if time.Since(lastErrLogged) > 5*time.Second {
-					lastErrLogged = time.Now()
-					logrus.WithError(err).Warnf("日志推送失败，将在 %s 后重试", time.Until(nextPushAt).Truncate(time.Millisecond))
-					logrus.WithFields(logrus.Fields{
-						"pending_lines":      len(pending),
-						"batch_size":         batchSize,
-						"failures":           failures,
-						"backoff_next":       delay.String(),
-						"backoff_max":        effectiveBackoffMax.String(),
-						"reached_max_backoff": reachedMax,
-					}).Warn("push failed on flush tick (debug)")
-				}
+			files.Prune()
+		case <-heartbeatC:
+			heartbeat()
+		case <-flushTicker.C:
+			if pending.Len() == 0 && spool.Len() == 0 {
 				continue
 			}
-			if time.Since(lastPushLogged) > 30*time.Second || failures > 0 {
-				lastPushLogged = time.Now()
-				logrus.WithFields(logrus.Fields{
-					"pushed_lines":   len(pending),
-					"pending_cap":    cap(pending),
-					"trigger":        "flush_interval",
-				}).Info("push succeeded")
+			deliver("flush_interval")
+		}
+	}
+}
+
+// printStatus 输出落盘的读取进度与 spool 积压，供运维排查（-status）。
+func printStatus(cfg *agentConfig, stateFile, spoolDir string, maxSpoolBytes int64) error {
+	saved, err := loadPersistedState(stateFile)
+	if err != nil {
+		return err
+	}
+	stats, streamID, err := inspectSpool(spoolDir, maxSpoolBytes)
+	if err != nil {
+		return err
+	}
+	if streamID == "" {
+		streamID = "-"
+	}
+
+	fmt.Printf("state file: %s\n", stateFile)
+	if !saved.UpdatedAt.IsZero() {
+		fmt.Printf("updated at: %s\n", saved.UpdatedAt.Format(time.RFC3339))
+	}
+	fmt.Printf("spool dir:  %s\n", spoolDir)
+	fmt.Printf("stream:     %s\n", streamID)
+	fmt.Printf("backlog:    %d segments, %d lines, %s / %s\n",
+		stats.Segments, stats.Lines, formatBytes(stats.Bytes), formatBytes(stats.MaxBytes))
+	sourceID := strings.TrimSpace(cfg.SourceID)
+	if sourceID == "" {
+		sourceID = "agent"
+	}
+	discovered := discoverFiles(buildInputPatterns(cfg, sourceID))
+	paths := make([]string, 0, len(discovered))
+	for path := range discovered {
+		paths = append(paths, path)
+	}
+	for path := range saved.Files {
+		if _, ok := discovered[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	fmt.Println("files:")
+	for _, path := range paths {
+		target, matched := discovered[path]
+		route := "unmatched"
+		if matched {
+			route = target.WebsiteID + "/" + target.SourceID
+		}
+		entry, ok := saved.Files[path]
+		if !ok {
+			fmt.Printf("  %s [%s]: no saved offset\n", path, route)
+			continue
+		}
+		unread := "-"
+		if info, err := os.Stat(path); err == nil {
+			if inode := fileInode(info); entry.Inode != 0 && inode != 0 && inode != entry.Inode {
+				unread = "rotated"
+			} else if info.Size() >= entry.Offset {
+				unread = formatBytes(info.Size() - entry.Offset)
+			} else {
+				unread = "truncated"
 			}
-			pending = resetPending(pending, batchSize, maxPending)
-			failures = 0
-			reachedMax = false
-			nextPushAt = time.Time{}
+		}
+		fmt.Printf("  %s [%s]: inode=%d offset=%d unread=%s\n", path, route, entry.Inode, entry.Offset, unread)
+		if d := entry.Draining; d != nil {
+			fmt.Printf("    draining rotated file: inode=%d offset=%d\n", d.Inode, d.Offset)
 		}
 	}
+	return nil
 }
 
 func loadConfig(path string) (*agentConfig, error) {
@@ -318,105 +654,26 @@ func loadConfig(path string) (*agentConfig, error) {
 	if strings.TrimSpace(cfg.Server) == "" {
 		return nil, errors.New("server 不能为空")
 	}
-	if strings.TrimSpace(cfg.WebsiteID) == "" {
-		return nil, errors.New("websiteID 不能为空")
-	}
-	if len(cfg.Paths) == 0 {
-		return nil, errors.New("paths 不能为空")
-	}
-	return cfg, nil
-}
-
-func readNewLines(path string, state *fileState, maxLineBytes int) ([]string, readStats, error) {
-	stats := readStats{path: path}
-	stats.maxLineBytes = maxLineBytes
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, stats, err
-	}
-	size := info.Size()
-	stats.fileSize = size
-	if size < state.offset {
-		state.offset = 0
-		state.partial = ""
-	}
-	if size == state.offset {
-		return nil, stats, nil
-	}
-
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, stats, err
+	if len(cfg.Paths) == 0 && len(cfg.Inputs) == 0 {
+		return nil, errors.New("paths 与 inputs 不能同时为空")
 	}
-	defer file.Close()
-
-	stats.from = state.offset
-	if _, err := file.Seek(state.offset, io.SeekStart); err != nil {
-		return nil, stats, err
-	}
-
-	// Use ReadSlice-based line reading with a bounded line size to avoid huge allocations
-	// when input contains abnormally long lines.
-	reader := bufio.NewReaderSize(file, 64*1024)
-	lines := []string{}
-	seed := state.partial
-	state.partial = ""
-
-	var lastOverlongLogged time.Time
-	logOverlong := func(actualLineBytes int64, from, to int64) {
-		// avoid log spam if the input continuously produces overlong lines
-		if time.Since(lastOverlongLogged) < 5*time.Second {
-			return
-		}
-		lastOverlongLogged = time.Now()
-		logrus.WithFields(logrus.Fields{
-			"path":           path,
-			"max_line_bytes": maxLineBytes,
-			"line_bytes":     actualLineBytes,
-			"offset_from":    from,
-			"offset_to":      to,
-		}).Warn("skipping overlong log line (exceeds maxLineBytes)")
+	if len(cfg.Paths) > 0 && strings.TrimSpace(cfg.WebsiteID) == "" {
+		return nil, errors.New("websiteID 不能为空")
 	}
-
-	for {
-		line, overlong, bytesRead, hasNewline, eof, err, actualLineBytes := readOneLineLimited(reader, maxLineBytes, seed)
-		seed = ""
-		if bytesRead > 0 {
-			state.offset += bytesRead
-			stats.bytes += bytesRead
-		}
-		if err != nil {
-			return lines, stats, err
+	for i, input := range cfg.Inputs {
+		if len(input.Paths) == 0 {
+			return nil, fmt.Errorf("inputs[%d].paths 不能为空", i)
 		}
-		if bytesRead == 0 && eof {
-			break
+		if strings.TrimSpace(input.WebsiteID) == "" && strings.TrimSpace(cfg.WebsiteID) == "" {
+			return nil, fmt.Errorf("inputs[%d].websiteID 不能为空", i)
 		}
-		if overlong {
-			stats.skippedLines++
-			logOverlong(actualLineBytes, state.offset-bytesRead, state.offset)
-			// Overlong line discarded; do not carry partial forward.
-			if eof && !hasNewline {
-				state.partial = ""
-				break
+		for _, pattern := range input.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("inputs[%d].paths 格式错误: %s", i, pattern)
 			}
-			continue
-		}
-		if eof && !hasNewline {
-			// store bounded partial for next read
-			if line != "" {
-				state.partial = line
-				stats.hasPartial = true
-			}
-			break
-		}
-		if line != "" {
-			lines = append(lines, line)
-			stats.lines++
 		}
 	}
-	state.lastSize = size
-	stats.to = state.offset
-	return lines, stats, nil
+	return cfg, nil
 }
 
 // readOneLineLimited reads one logical line (terminated by '\n' or EOF) without ever buffering more than maxLineBytes.
@@ -492,11 +749,12 @@ func readOneLineLimited(reader *bufio.Reader, maxLineBytes int, seed string) (li
 	return buf.String(), false, bytesRead, hasNewline, eof, nil, actualLineBytes
 }
 
-func pushLines(timeout time.Duration, endpoint, accessKey, websiteID, sourceID string, lines []string) error {
+func pushLines(client *http.Client, endpoint, accessKey, websiteID, sourceID string, lines []string, sampleRates []float64) error {
 	payload := ingestRequest{
-		WebsiteID: websiteID,
-		SourceID:  sourceID,
-		Lines:     lines,
+		WebsiteID:   websiteID,
+		SourceID:    sourceID,
+		Lines:       lines,
+		SampleRates: sampleRates,
 	}
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -512,7 +770,6 @@ func pushLines(timeout time.Duration, endpoint, accessKey, websiteID, sourceID s
 		req.Header.Set("X-NginxPulse-Key", strings.TrimSpace(accessKey))
 	}
 
-	client := &http.Client{Timeout: timeout}
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -577,6 +834,48 @@ func applyEnvOverrides(cfg *agentConfig) {
 			cfg.ExitOnMaxBackoff = b
 		}
 	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_STATE_FILE"); ok && strings.TrimSpace(v) != "" {
+		cfg.StateFile = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_SPOOL_DIR"); ok && strings.TrimSpace(v) != "" {
+		cfg.SpoolDir = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_MAX_SPOOL_BYTES"); ok && strings.TrimSpace(v) != "" {
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			cfg.MaxSpoolBytes = n
+		}
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_DISCOVER_INTERVAL"); ok && strings.TrimSpace(v) != "" {
+		cfg.DiscoverInterval = v
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_PROTOCOL"); ok && strings.TrimSpace(v) != "" {
+		cfg.Protocol = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_COMPRESSION"); ok && strings.TrimSpace(v) != "" {
+		cfg.Compression = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_ID"); ok && strings.TrimSpace(v) != "" {
+		cfg.AgentID = strings.TrimSpace(v)
+	}
+	// 令牌与签名密钥建议通过环境变量（k8s Secret）注入，避免写入配置文件。
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_TOKEN"); ok && strings.TrimSpace(v) != "" {
+		cfg.AgentToken = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_HMAC_SECRET"); ok && strings.TrimSpace(v) != "" {
+		cfg.HMACSecret = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_HEARTBEAT_INTERVAL"); ok && strings.TrimSpace(v) != "" {
+		cfg.HeartbeatInterval = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_TLS_CERT_FILE"); ok && strings.TrimSpace(v) != "" {
+		cfg.TLSCertFile = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_TLS_KEY_FILE"); ok && strings.TrimSpace(v) != "" {
+		cfg.TLSKeyFile = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv("NGINXPULSE_AGENT_TLS_CA_FILE"); ok && strings.TrimSpace(v) != "" {
+		cfg.TLSCAFile = strings.TrimSpace(v)
+	}
 }
 
 func computeBackoff(failures int, min, max time.Duration) time.Duration {
@@ -648,12 +947,3 @@ func formatBytes(v int64) string {
 		return fmt.Sprintf("%dB", v)
 	}
 }
-
-// resetPending 用于释放 pending slice 持有的引用。
-// 这里采用“始终换新 slice”的策略：每次推送成功后都丢弃旧的底层数组，
-// 让 GC 更容易回收历史积压/异常输入导致的内存占用，从而抑制 heap_sys 长期走高。
-func resetPending(pending []string, batchSize, maxPending int) []string {
-	_ = pending
-	_ = maxPending
-	return make([]string, 0, batchSize)
-}
@@ -0,0 +1,113 @@
+package main
+
+// pendingBuffer 按站点/来源分组缓存待发送日志，每组推送或落盘为独立批次。
+// 分组按创建的顺序输出，同一组内保持读取顺序。
+// 分组以某个序号推送过（Seal）后内容不再变化，直到确认送达或落盘：
+// 重试必须沿用同一序号与同样的行，之后读到的行进入该来源的新分组。
+type pendingBuffer struct {
+	groups []*pendingGroup
+	total  int
+}
+
+type pendingGroup struct {
+	target tailTarget
+	seq    uint64
+	lines  []string
+	// rates 只在分组内出现过采样行时才分配，与 lines 一一对应。
+	rates []float64
+}
+
+func newPendingBuffer() *pendingBuffer {
+	return &pendingBuffer{}
+}
+
+// open 返回某个来源尚未分配序号的分组
+func (b *pendingBuffer) open(target tailTarget) *pendingGroup {
+	for _, group := range b.groups {
+		if group.target == target && group.seq == 0 {
+			return group
+		}
+	}
+	return nil
+}
+
+func (b *pendingBuffer) find(target tailTarget, seq uint64) int {
+	for i, group := range b.groups {
+		if group.target == target && group.seq == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add 追加一组行；sampleRates 为 nil 表示这些行都未采样。
+func (b *pendingBuffer) Add(target tailTarget, lines []string, sampleRates []float64) {
+	if len(lines) == 0 {
+		return
+	}
+	group := b.open(target)
+	if group == nil {
+		group = &pendingGroup{target: target}
+		b.groups = append(b.groups, group)
+	}
+	if sampleRates != nil && group.rates == nil {
+		group.rates = make([]float64, len(group.lines), len(group.lines)+len(lines))
+		for i := range group.rates {
+			group.rates[i] = 1
+		}
+	}
+	if group.rates != nil {
+		if sampleRates != nil {
+			group.rates = append(group.rates, sampleRates...)
+		} else {
+			for range lines {
+				group.rates = append(group.rates, 1)
+			}
+		}
+	}
+	group.lines = append(group.lines, lines...)
+	b.total += len(lines)
+}
+
+func (b *pendingBuffer) Len() int {
+	return b.total
+}
+
+// Batches 返回当前所有分组的批次，尚未分配序号的分组 Seq 为 0。
+func (b *pendingBuffer) Batches() []spoolBatch {
+	batches := make([]spoolBatch, 0, len(b.groups))
+	for _, group := range b.groups {
+		batches = append(batches, spoolBatch{
+			WebsiteID:   group.target.WebsiteID,
+			SourceID:    group.target.SourceID,
+			Seq:         group.seq,
+			Lines:       group.lines,
+			SampleRates: group.rates,
+		})
+	}
+	return batches
+}
+
+// Seal 为来源当前未分配序号的分组记录序号，此后该分组不再追加新行。
+func (b *pendingBuffer) Seal(target tailTarget, seq uint64) {
+	if seq == 0 {
+		return
+	}
+	if group := b.open(target); group != nil {
+		group.seq = seq
+	}
+}
+
+// Remove 丢弃某个分组（seq 为 0 表示尚未分配序号的分组）；
+// 同样采用“换新 slice”的策略，释放历史积压占用的内存。
+func (b *pendingBuffer) Remove(target tailTarget, seq uint64) {
+	index := b.find(target, seq)
+	if index < 0 {
+		return
+	}
+	b.total -= len(b.groups[index].lines)
+	groups := make([]*pendingGroup, 0, len(b.groups))
+	groups = append(groups, b.groups[:index]...)
+	groups = append(groups, b.groups[index+1:]...)
+	b.groups = groups
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 推送失败且 spool 已满时，批次留在内存中，重试必须沿用原序号与原来的行。
+func TestPendingKeepsSeqWhenSpoolIsFull(t *testing.T) {
+	spool, err := openDiskSpool(t.TempDir(), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := tailTarget{WebsiteID: "site", SourceID: "src"}
+	pending := newPendingBuffer()
+	pending.Add(target, []string{"a line that does not fit into sixty-four bytes of spool"}, nil)
+
+	// 第一次推送：分配序号后推送失败，落盘也因 spool 已满失败
+	batch := pending.Batches()[0]
+	seq, err := spool.AllocSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending.Seal(target, seq)
+	batch.Seq = seq
+	if err := spool.Append(batch); err != errSpoolFull {
+		t.Fatalf("expected errSpoolFull, got %v", err)
+	}
+
+	pending.Add(target, []string{"read after the failed push"}, []float64{0.5})
+	batches := pending.Batches()
+	if len(batches) != 2 || pending.Len() != 2 {
+		t.Fatalf("expected the sealed batch and a new one, got %+v", batches)
+	}
+	if batches[0].Seq != seq || !reflect.DeepEqual(batches[0].Lines, batch.Lines) {
+		t.Fatalf("retry must reuse seq %d and the original lines, got %+v", seq, batches[0])
+	}
+	if batches[1].Seq != 0 || batches[1].Lines[0] != "read after the failed push" || batches[1].SampleRates[0] != 0.5 {
+		t.Fatalf("unexpected new batch %+v", batches[1])
+	}
+
+	pending.Remove(target, seq)
+	if pending.Len() != 1 || pending.Batches()[0].Seq != 0 {
+		t.Fatalf("acknowledging the sealed batch must keep the new one, got %+v", pending.Batches())
+	}
+	pending.Seal(target, 0)
+	if pending.Batches()[0].Seq != 0 {
+		t.Fatal("seq 0 must not seal a batch")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/likaia/nginxpulse/internal/agentproto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	protocolAuto = "auto"
+	protocolV1   = "v1"
+	protocolV2   = "v2"
+
+	// v1 回退后每隔一段时间重新协商，服务端升级后可自动切换到 v2。
+	renegotiateInterval = 10 * time.Minute
+	// 心跳请求超时，避免服务端不可用时阻塞读取循环太久。
+	heartbeatTimeout = 10 * time.Second
+)
+
+// pusher 负责与服务端协商推送协议并发送批次：
+// 服务端支持 v2 时使用压缩 NDJSON + 批次序号（幂等确认），否则回退到 v1 JSON 接口。
+type pusher struct {
+	server      string
+	accessKey   string
+	agentToken  string
+	hmacSecret  string
+	agentID     string
+	protocol    string
+	compression string
+	timeout     time.Duration
+	transport   *http.Transport
+
+	version      int
+	encoding     string
+	negotiatedAt time.Time
+	zstdEncoder  *zstd.Encoder
+	// heartbeat 表示服务端接受心跳（协商得到，或 protocol=v2 时默认开启）。
+	heartbeat bool
+}
+
+func newPusher(cfg *agentConfig, agentID string, timeout time.Duration) (*pusher, error) {
+	transport, err := newAgentTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	protocol := strings.ToLower(strings.TrimSpace(cfg.Protocol))
+	if protocol == "" {
+		protocol = protocolAuto
+	}
+	compression := strings.ToLower(strings.TrimSpace(cfg.Compression))
+	switch compression {
+	case agentproto.EncodingGzip:
+	case "none", agentproto.EncodingIdentity:
+		compression = agentproto.EncodingIdentity
+	default:
+		compression = agentproto.EncodingZstd
+	}
+	return &pusher{
+		server:      strings.TrimRight(cfg.Server, "/"),
+		accessKey:   strings.TrimSpace(cfg.AccessKey),
+		agentToken:  strings.TrimSpace(cfg.AgentToken),
+		hmacSecret:  cfg.HMACSecret,
+		agentID:     agentID,
+		protocol:    protocol,
+		compression: compression,
+		timeout:     timeout,
+		transport:   transport,
+	}, nil
+}
+
+// httpClient 返回共用连接池的 HTTP 客户端
+func (p *pusher) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: p.transport, Timeout: timeout}
+}
+
+// Push 发送一批日志。streamID/seq 仅在 v2 下生效，服务端据此对重试做幂等确认。
+func (p *pusher) Push(batch spoolBatch, streamID string) error {
+	if err := p.negotiate(); err != nil {
+		return err
+	}
+	if p.version == 2 {
+		err := p.pushV2(batch, streamID)
+		if errors.Is(err, errEndpointNotFound) && p.protocol == protocolAuto {
+			// 服务端回滚到不支持 v2 的版本：下次推送重新协商。
+			p.version = 0
+		}
+		return err
+	}
+	endpoint := p.server + "/api/ingest/logs"
+	return pushLines(p.httpClient(p.timeout), endpoint, p.accessKey, batch.WebsiteID, batch.SourceID, batch.Lines, batch.SampleRates)
+}
+
+// Describe 返回当前协商结果，用于日志输出。
+func (p *pusher) Describe() string {
+	switch p.version {
+	case 1:
+		return protocolV1
+	case 2:
+		return protocolV2 + "/" + p.encoding
+	default:
+		return p.protocol
+	}
+}
+
+var errEndpointNotFound = errors.New("endpoint not found")
+
+func (p *pusher) negotiate() error {
+	switch p.protocol {
+	case protocolV1:
+		p.version = 1
+		return nil
+	case protocolV2:
+		if p.version == 0 {
+			p.version = 2
+			p.encoding = p.compression
+			p.heartbeat = true
+		}
+		return nil
+	}
+	if p.version == 2 || (p.version == 1 && time.Since(p.negotiatedAt) < renegotiateInterval) {
+		return nil
+	}
+
+	caps, err := p.fetchCapabilities()
+	if err != nil {
+		if errors.Is(err, errEndpointNotFound) {
+			if p.version != 1 {
+				logrus.WithField("server", p.server).Info("server does not support ingest v2; falling back to v1")
+			}
+			p.version = 1
+			p.heartbeat = false
+			p.negotiatedAt = time.Now()
+			return nil
+		}
+		if p.version == 1 {
+			// 已回退到 v1 时，重新协商失败不影响继续使用 v1。
+			p.negotiatedAt = time.Now()
+			return nil
+		}
+		return fmt.Errorf("协商推送协议失败: %w", err)
+	}
+	p.negotiatedAt = time.Now()
+	p.heartbeat = caps.SupportsFeature(agentproto.FeatureHeartbeat)
+	if !caps.SupportsVersion(2) {
+		p.version = 1
+		return nil
+	}
+	p.version = 2
+	p.encoding = agentproto.EncodingIdentity
+	for _, candidate := range []string{p.compression, agentproto.EncodingGzip} {
+		if candidate == agentproto.EncodingIdentity || caps.SupportsEncoding(candidate) {
+			p.encoding = candidate
+			break
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"server":   p.server,
+		"encoding": p.encoding,
+	}).Info("negotiated ingest v2")
+	return nil
+}
+
+func (p *pusher) fetchCapabilities() (agentproto.Capabilities, error) {
+	var caps agentproto.Capabilities
+	req, err := http.NewRequest(http.MethodGet, p.server+agentproto.CapabilitiesPath, nil)
+	if err != nil {
+		return caps, err
+	}
+	p.setAuthHeaders(req)
+	client := p.httpClient(p.timeout)
+	resp, err := client.Do(req)
+	if err != nil {
+		return caps, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return caps, errEndpointNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return caps, fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&caps); err != nil {
+		return caps, err
+	}
+	return caps, nil
+}
+
+func (p *pusher) pushV2(batch spoolBatch, streamID string) error {
+	body, err := p.encodeBatch(batch.Lines, batch.SampleRates)
+	if err != nil {
+		return err
+	}
+	meta := agentproto.BatchMeta{
+		AgentID:   p.agentID,
+		WebsiteID: batch.WebsiteID,
+		SourceID:  batch.SourceID,
+		StreamID:  streamID,
+		Seq:       int64(batch.Seq),
+		Timestamp: time.Now().Unix(),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.server+agentproto.IngestV2Path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", agentproto.ContentTypeNDJSON)
+	if p.encoding != agentproto.EncodingIdentity {
+		req.Header.Set("Content-Encoding", p.encoding)
+	}
+	p.setAuthHeaders(req)
+	req.Header.Set(agentproto.HeaderAgentID, meta.AgentID)
+	req.Header.Set(agentproto.HeaderWebsiteID, meta.WebsiteID)
+	req.Header.Set(agentproto.HeaderSourceID, meta.SourceID)
+	if meta.Seq > 0 {
+		req.Header.Set(agentproto.HeaderStreamID, meta.StreamID)
+		req.Header.Set(agentproto.HeaderBatchSeq, strconv.FormatInt(meta.Seq, 10))
+	}
+	req.Header.Set(agentproto.HeaderTimestamp, strconv.FormatInt(meta.Timestamp, 10))
+	if p.hmacSecret != "" {
+		req.Header.Set(agentproto.HeaderSignature, agentproto.Sign(p.hmacSecret, meta, body))
+	}
+
+	client := p.httpClient(p.timeout)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errEndpointNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	var ack struct {
+		Duplicate bool  `json:"duplicate"`
+		AckSeq    int64 `json:"ack_seq"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ack); err == nil && ack.Duplicate {
+		logrus.WithFields(logrus.Fields{
+			"seq":     meta.Seq,
+			"ack_seq": ack.AckSeq,
+		}).Debug("batch already acknowledged by server")
+	}
+	return nil
+}
+
+// SendHeartbeat 上报 agent 运行状态；服务端不支持心跳时返回 false 且不发送。
+func (p *pusher) SendHeartbeat(hb agentproto.Heartbeat) (agentproto.HeartbeatAck, bool, error) {
+	var ack agentproto.HeartbeatAck
+	if err := p.negotiate(); err != nil {
+		return ack, false, err
+	}
+	if !p.heartbeat {
+		return ack, false, nil
+	}
+	hb.Protocol = p.Describe()
+	hb.SentAt = time.Now().UnixMilli()
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return ack, false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.server+agentproto.HeartbeatPath, bytes.NewReader(body))
+	if err != nil {
+		return ack, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	p.setAuthHeaders(req)
+
+	timeout := p.timeout
+	if timeout <= 0 || timeout > heartbeatTimeout {
+		timeout = heartbeatTimeout
+	}
+	client := p.httpClient(timeout)
+	resp, err := client.Do(req)
+	if err != nil {
+		return ack, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// 服务端不支持心跳：等待下次协商再尝试。
+		p.heartbeat = false
+		return ack, false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return ack, true, fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ack); err != nil {
+		return ack, true, err
+	}
+	return ack, true, nil
+}
+
+func (p *pusher) setAuthHeaders(req *http.Request) {
+	if p.accessKey != "" {
+		req.Header.Set(agentproto.HeaderAccessKey, p.accessKey)
+	}
+	if p.agentToken != "" {
+		req.Header.Set(agentproto.HeaderAgentToken, p.agentToken)
+	}
+}
+
+// encodeBatch 将日志编码为 NDJSON（每行 {"line": "...", "sample_rate": 0.1}）并按协商结果压缩。
+func (p *pusher) encodeBatch(lines []string, sampleRates []float64) ([]byte, error) {
+	var raw bytes.Buffer
+	encoder := json.NewEncoder(&raw)
+	encoder.SetEscapeHTML(false)
+	for i, line := range lines {
+		record := agentproto.Record{Line: line}
+		if i < len(sampleRates) && sampleRates[i] < 1 {
+			record.SampleRate = sampleRates[i]
+		}
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+
+	switch p.encoding {
+	case agentproto.EncodingZstd:
+		if p.zstdEncoder == nil {
+			enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+			if err != nil {
+				return nil, err
+			}
+			p.zstdEncoder = enc
+		}
+		return p.zstdEncoder.EncodeAll(raw.Bytes(), make([]byte, 0, raw.Len()/4)), nil
+	case agentproto.EncodingGzip:
+		var out bytes.Buffer
+		gz := gzip.NewWriter(&out)
+		if _, err := gz.Write(raw.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	default:
+		return raw.Bytes(), nil
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errSpoolFull = errors.New("spool is full")
+
+// spoolBatch 是落盘的一批待发送日志，回放时按写入顺序推送。
+// Seq 是 v2 协议的批次序号（与段文件序号一致），重试同一批次时保持不变。
+type spoolBatch struct {
+	WebsiteID string   `json:"website_id"`
+	SourceID  string   `json:"source_id"`
+	Seq       uint64   `json:"seq,omitempty"`
+	Lines     []string `json:"lines"`
+	// SampleRates 为空表示未采样，否则与 Lines 一一对应。
+	SampleRates []float64 `json:"sample_rates,omitempty"`
+}
+
+// streamFile 记录批次序号所属的流 ID 与已预留的序号上限，与段文件放在同一目录。
+const (
+	streamFileName   = "stream.json"
+	seqReserveBlock  = 1000
+	streamIDByteSize = 8
+)
+
+type streamState struct {
+	StreamID string `json:"stream_id"`
+	Reserved uint64 `json:"reserved"`
+}
+
+type spoolSegment struct {
+	name  string
+	seq   uint64
+	lines int
+	bytes int64
+}
+
+// diskSpool 是按序号命名的段文件队列：<seq>-<lines>.json。
+// 文件名携带行数，-status 无需读取内容即可统计积压。
+// 序号同时作为 v2 协议的批次序号：按块预留并写入 stream.json，
+// 保证进程重启后不会复用已发出的序号（否则会被服务端当作重复批次丢弃）。
+type diskSpool struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	segments []spoolSegment
+	bytes    int64
+	nextSeq  uint64
+	streamID string
+	reserved uint64
+}
+
+func openDiskSpool(dir string, maxBytes int64) (*diskSpool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	spool := &diskSpool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		nextSeq:  1,
+	}
+	segments, tmpFiles, err := readSpoolDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range tmpFiles {
+		// 上次写入中断留下的临时文件，对应的 pending 未被确认落盘，直接清理。
+		_ = os.Remove(filepath.Join(dir, name))
+	}
+	spool.segments = segments
+	for _, segment := range segments {
+		spool.bytes += segment.bytes
+		if segment.seq >= spool.nextSeq {
+			spool.nextSeq = segment.seq + 1
+		}
+	}
+	if err := spool.loadStream(); err != nil {
+		return nil, err
+	}
+	return spool, nil
+}
+
+// readSpoolDir 返回按序号排序的段文件与遗留的 .tmp 文件名，不修改目录。
+func readSpoolDir(dir string) ([]spoolSegment, []string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var segments []spoolSegment
+	var tmpFiles []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			tmpFiles = append(tmpFiles, name)
+			continue
+		}
+		segment, ok := parseSpoolSegmentName(name)
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, nil, err
+		}
+		segment.bytes = info.Size()
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].seq < segments[j].seq
+	})
+	return segments, tmpFiles, nil
+}
+
+// readStreamState 读取 stream.json，文件不存在时返回零值。
+func readStreamState(dir string) (streamState, error) {
+	var state streamState
+	data, err := os.ReadFile(filepath.Join(dir, streamFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("stream 文件损坏: %w", err)
+	}
+	return state, nil
+}
+
+// inspectSpool 只读地统计 spool 积压与流 ID，供 -status 使用：
+// 不创建目录、不清理临时文件，也不为尚未建立的流生成 ID（返回空字符串）。
+func inspectSpool(dir string, maxBytes int64) (spoolStats, string, error) {
+	stats := spoolStats{MaxBytes: maxBytes}
+	segments, _, err := readSpoolDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, "", nil
+		}
+		return stats, "", err
+	}
+	for _, segment := range segments {
+		stats.Segments++
+		stats.Lines += segment.lines
+		stats.Bytes += segment.bytes
+	}
+	state, err := readStreamState(dir)
+	if err != nil {
+		return stats, "", err
+	}
+	return stats, state.StreamID, nil
+}
+
+func (s *diskSpool) loadStream() error {
+	state, err := readStreamState(s.dir)
+	if err != nil {
+		return err
+	}
+	if state.StreamID == "" {
+		buf := make([]byte, streamIDByteSize)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		state.StreamID = hex.EncodeToString(buf)
+		state.Reserved = 0
+	}
+	s.streamID = state.StreamID
+	// 上次预留范围内的序号可能已经发出，从预留上限之后继续分配。
+	if state.Reserved > s.nextSeq {
+		s.nextSeq = state.Reserved
+	}
+	s.reserved = s.nextSeq
+	return nil
+}
+
+// StreamID 返回批次序号所属的流 ID；删除 spool 目录后会生成新的流，序号从头计数。
+func (s *diskSpool) StreamID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streamID
+}
+
+// AllocSeq 为直接推送（未落盘）的批次分配序号；推送失败落盘时沿用该序号。
+func (s *diskSpool) AllocSeq() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.allocSeqLocked()
+}
+
+func (s *diskSpool) allocSeqLocked() (uint64, error) {
+	if s.nextSeq >= s.reserved {
+		reserved := s.nextSeq + seqReserveBlock
+		data, err := json.Marshal(streamState{StreamID: s.streamID, Reserved: reserved})
+		if err != nil {
+			return 0, err
+		}
+		if err := writeFileAtomic(filepath.Join(s.dir, streamFileName), data); err != nil {
+			return 0, err
+		}
+		s.reserved = reserved
+	}
+	seq := s.nextSeq
+	s.nextSeq++
+	return seq, nil
+}
+
+func parseSpoolSegmentName(name string) (spoolSegment, bool) {
+	if !strings.HasSuffix(name, ".json") {
+		return spoolSegment{}, false
+	}
+	base := strings.TrimSuffix(name, ".json")
+	seqRaw, linesRaw, ok := strings.Cut(base, "-")
+	if !ok {
+		return spoolSegment{}, false
+	}
+	seq, err := strconv.ParseUint(seqRaw, 10, 64)
+	if err != nil {
+		return spoolSegment{}, false
+	}
+	lines, err := strconv.Atoi(linesRaw)
+	if err != nil {
+		return spoolSegment{}, false
+	}
+	return spoolSegment{name: name, seq: seq, lines: lines}, true
+}
+
+// Append 将一批日志写入 spool；超过 maxBytes 时返回 errSpoolFull（调用方负责背压）。
+func (s *diskSpool) Append(batch spoolBatch) error {
+	if len(batch.Lines) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 粗略估算占用，避免在 spool 已满时仍消耗序号。
+	if s.maxBytes > 0 && s.bytes >= s.maxBytes {
+		return errSpoolFull
+	}
+	if batch.Seq == 0 {
+		seq, err := s.allocSeqLocked()
+		if err != nil {
+			return err
+		}
+		batch.Seq = seq
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	if s.maxBytes > 0 && s.bytes+int64(len(data)) > s.maxBytes {
+		return errSpoolFull
+	}
+	segment := spoolSegment{
+		name:  fmt.Sprintf("%020d-%d.json", batch.Seq, len(batch.Lines)),
+		seq:   batch.Seq,
+		lines: len(batch.Lines),
+		bytes: int64(len(data)),
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, segment.name), data); err != nil {
+		return err
+	}
+	s.segments = append(s.segments, segment)
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+	s.bytes += segment.bytes
+	return nil
+}
+
+// Oldest 返回最早写入的一批日志；spool 为空时 ok=false。
+func (s *diskSpool) Oldest() (spoolSegment, spoolBatch, bool, error) {
+	s.mu.Lock()
+	if len(s.segments) == 0 {
+		s.mu.Unlock()
+		return spoolSegment{}, spoolBatch{}, false, nil
+	}
+	segment := s.segments[0]
+	s.mu.Unlock()
+
+	var batch spoolBatch
+	data, err := os.ReadFile(filepath.Join(s.dir, segment.name))
+	if err != nil {
+		return segment, batch, false, err
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return segment, batch, false, fmt.Errorf("spool 段文件损坏 %s: %w", segment.name, err)
+	}
+	// 旧版本写入的段文件没有 seq 字段，以文件名中的序号为准。
+	batch.Seq = segment.seq
+	return segment, batch, true, nil
+}
+
+// Remove 在段文件推送成功（或确认损坏）后删除它。
+func (s *diskSpool) Remove(segment spoolSegment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(filepath.Join(s.dir, segment.name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i, item := range s.segments {
+		if item.seq == segment.seq {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			s.bytes -= item.bytes
+			break
+		}
+	}
+	return nil
+}
+
+func (s *diskSpool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments)
+}
+
+func (s *diskSpool) Full() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxBytes > 0 && s.bytes >= s.maxBytes
+}
+
+type spoolStats struct {
+	Segments int   `json:"segments"`
+	Lines    int   `json:"lines"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+func (s *diskSpool) Stats() spoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := spoolStats{
+		Segments: len(s.segments),
+		Bytes:    s.bytes,
+		MaxBytes: s.maxBytes,
+	}
+	for _, segment := range s.segments {
+		stats.Lines += segment.lines
+	}
+	return stats
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskSpoolAppendKeepsSeqAndOrder(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := openDiskSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 直接推送失败的批次带着已分配的序号落盘，之后落盘的批次分配新序号
+	pushedSeq, err := spool.AllocSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Append(spoolBatch{WebsiteID: "site", SourceID: "a", Lines: []string{"later"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Append(spoolBatch{WebsiteID: "site", SourceID: "a", Seq: pushedSeq, Lines: []string{"first", "second"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Append(spoolBatch{WebsiteID: "site", SourceID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if spool.Len() != 2 {
+		t.Fatalf("empty batch must not be spooled, got %d segments", spool.Len())
+	}
+	if stats := spool.Stats(); stats.Lines != 3 {
+		t.Fatalf("unexpected spool stats %+v", stats)
+	}
+
+	segment, batch, ok, err := spool.Oldest()
+	if err != nil || !ok {
+		t.Fatalf("Oldest: ok=%v err=%v", ok, err)
+	}
+	if batch.Seq != pushedSeq || len(batch.Lines) != 2 || batch.Lines[0] != "first" {
+		t.Fatalf("the retried batch must replay first with its original seq, got %+v", batch)
+	}
+	if err := spool.Remove(segment); err != nil {
+		t.Fatal(err)
+	}
+	_, batch, _, _ = spool.Oldest()
+	if batch.Seq <= pushedSeq || batch.Lines[0] != "later" {
+		t.Fatalf("unexpected second batch %+v", batch)
+	}
+	lastSeq := batch.Seq
+
+	// 重启后流 ID 不变、积压保留，且不会复用已发出的序号
+	reopened, err := openDiskSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.StreamID() != spool.StreamID() {
+		t.Fatalf("stream id changed after reopen: %s vs %s", reopened.StreamID(), spool.StreamID())
+	}
+	if reopened.Len() != 1 {
+		t.Fatalf("reopened spool should keep 1 segment, got %d", reopened.Len())
+	}
+	next, err := reopened.AllocSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next <= lastSeq {
+		t.Fatalf("seq %d reused after restart (last issued %d)", next, lastSeq)
+	}
+}
+
+func TestDiskSpoolFull(t *testing.T) {
+	spool, err := openDiskSpool(t.TempDir(), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Append(spoolBatch{WebsiteID: "site", Lines: []string{"a line that does not fit into sixty-four bytes of spool"}}); err != errSpoolFull {
+		t.Fatalf("expected errSpoolFull, got %v", err)
+	}
+	if spool.Len() != 0 || spool.Full() {
+		t.Fatal("rejected batch must not be counted")
+	}
+}
+
+func TestInspectSpoolIsReadOnly(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "spool")
+	stats, streamID, err := inspectSpool(missing, 1024)
+	if err != nil || stats.Segments != 0 || stats.MaxBytes != 1024 || streamID != "" {
+		t.Fatalf("missing dir: %+v %q %v", stats, streamID, err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatal("inspecting must not create the spool dir")
+	}
+
+	dir := t.TempDir()
+	spool, err := openDiskSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Append(spoolBatch{WebsiteID: "site", Lines: []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "00000000000000000009-1.json.tmp")
+	if err := os.WriteFile(tmp, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, streamID, err = inspectSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Segments != 1 || stats.Lines != 2 || stats.Bytes != spool.Stats().Bytes {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if streamID != spool.StreamID() {
+		t.Fatalf("stream id = %q, want %q", streamID, spool.StreamID())
+	}
+	if _, err := os.Stat(tmp); err != nil {
+		t.Fatal("inspecting must not remove temporary files")
+	}
+}
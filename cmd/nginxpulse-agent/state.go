@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// persistedState 是落盘的读取进度，重启后据此从上次位置继续读取。
+// 只有在 pending 为空（已推送或已写入 spool）时才会保存，
+// 因此文件中的 offset 之前的内容一定已经“安全”。
+type persistedState struct {
+	Files     map[string]persistedFile `json:"files"`
+	UpdatedAt time.Time                `json:"updated_at"`
+}
+
+type persistedFile struct {
+	Inode   uint64 `json:"inode,omitempty"`
+	Offset  int64  `json:"offset"`
+	Size    int64  `json:"size"`
+	Partial string `json:"partial,omitempty"`
+	// Draining 是尚未读完的轮转旧文件，重启后按 inode 在同目录下查找并继续读取。
+	Draining *persistedDrain `json:"draining,omitempty"`
+}
+
+type persistedDrain struct {
+	Inode   uint64 `json:"inode"`
+	Offset  int64  `json:"offset"`
+	Partial string `json:"partial,omitempty"`
+}
+
+func loadPersistedState(path string) (*persistedState, error) {
+	state := &persistedState{Files: make(map[string]persistedFile)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]persistedFile)
+	}
+	return state, nil
+}
+
+// savePersistedState 以“写临时文件 + rename”的方式原子写入，避免进程中断留下半截文件。
+func savePersistedState(path string, states map[string]*fileState) error {
+	snapshot := persistedState{
+		Files:     make(map[string]persistedFile, len(states)),
+		UpdatedAt: time.Now(),
+	}
+	for key, state := range states {
+		if state == nil {
+			continue
+		}
+		entry := persistedFile{
+			Inode:   state.inode,
+			Offset:  state.offset,
+			Size:    state.lastSize,
+			Partial: state.partial,
+		}
+		if d := state.draining; d != nil {
+			entry.Draining = &persistedDrain{
+				Inode:   d.inode,
+				Offset:  d.offset,
+				Partial: d.partial,
+			}
+		}
+		snapshot.Files[key] = entry
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// restoreFileState 将落盘进度还原为内存中的 fileState。
+// 如果文件 inode 变化（重启期间发生了 rename 轮转），先在同目录下按 inode 找到旧文件并从保存的 offset 读完，
+// 新文件从头读取；如果文件变小（copytruncate），从头读取。
+func restoreFileState(path string, entry persistedFile, ok bool) *fileState {
+	state := &fileState{}
+	if !ok {
+		return state
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return state
+	}
+	inode := fileInode(info)
+	dir := filepath.Dir(path)
+	if d := entry.Draining; d != nil {
+		state.draining = openDrain(dir, d.Inode, d.Offset, d.Partial)
+	}
+	if entry.Inode != 0 && inode != 0 && entry.Inode != inode {
+		if state.draining != nil {
+			// 连续两次轮转：较早的旧文件只能放弃，优先保证最近一个旧文件被读完。
+			state.draining.file.Close()
+		}
+		state.draining = openDrain(dir, entry.Inode, entry.Offset, entry.Partial)
+		fields := logrus.Fields{
+			"path":        path,
+			"saved_inode": entry.Inode,
+			"inode":       inode,
+		}
+		if state.draining != nil {
+			logrus.WithFields(fields).Warn("file was rotated while agent was stopped; draining the rotated file first")
+		} else {
+			logrus.WithFields(fields).Warn("file was rotated while agent was stopped; rotated file not found, reading from start")
+		}
+		return state
+	}
+	if info.Size() < entry.Offset {
+		logrus.WithFields(logrus.Fields{
+			"path":         path,
+			"saved_offset": entry.Offset,
+			"file_size":    info.Size(),
+		}).Warn("file was truncated while agent was stopped; reading from start")
+		return state
+	}
+	state.inode = inode
+	state.offset = entry.Offset
+	state.lastSize = entry.Size
+	state.partial = entry.Partial
+	return state
+}
+
+func openDrain(dir string, inode uint64, offset int64, partial string) *drainState {
+	rotated, found := findFileByInode(dir, inode)
+	if !found {
+		return nil
+	}
+	file, err := os.Open(rotated)
+	if err != nil {
+		return nil
+	}
+	return &drainState{
+		file:    file,
+		inode:   inode,
+		offset:  offset,
+		partial: partial,
+		until:   time.Now().Add(rotatedDrainGrace),
+	}
+}
@@ -0,0 +1,80 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPersistedStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state", "agent.json")
+	if state, err := loadPersistedState(statePath); err != nil || len(state.Files) != 0 {
+		t.Fatalf("missing state file: %+v %v", state, err)
+	}
+
+	logPath := filepath.Join(dir, "access.log")
+	appendFile(t, logPath, "a\nb\npart")
+	state := &fileState{}
+	defer func() { state.file.Close() }()
+	readLines(t, logPath, state)
+	if err := savePersistedState(statePath, map[string]*fileState{logPath: state, "gone": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(statePath + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("temporary state file should be renamed away")
+	}
+	loaded, err := loadPersistedState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := loaded.Files[logPath]
+	if len(loaded.Files) != 1 || !ok || entry.Offset != state.offset || entry.Partial != "part" || entry.Inode == 0 {
+		t.Fatalf("unexpected persisted state %+v", loaded.Files)
+	}
+
+	// 重启后从保存的位置继续读取，残行与新内容拼接
+	appendFile(t, logPath, "ial\nc\n")
+	restored := restoreFileState(logPath, entry, true)
+	defer func() { restored.file.Close() }()
+	if got := readLines(t, logPath, restored); !reflect.DeepEqual(got, []string{"partial", "c"}) {
+		t.Fatalf("restored read: %q", got)
+	}
+
+	// 重启期间被截断的文件从头读取
+	if err := os.WriteFile(logPath, []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	truncated := restoreFileState(logPath, entry, true)
+	if truncated.offset != 0 || truncated.partial != "" {
+		t.Fatalf("truncated file should restart from zero, got %+v", truncated)
+	}
+	if fresh := restoreFileState(logPath, persistedFile{}, false); fresh.offset != 0 {
+		t.Fatal("unknown file should start from zero")
+	}
+}
+
+func TestLoadPersistedStateRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPersistedState(path); err == nil {
+		t.Fatal("expected an error for a corrupt state file")
+	}
+}
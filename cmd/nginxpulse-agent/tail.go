@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rotatedDrainGrace 是文件被轮转（rename）后继续读取旧文件的宽限时间：
+// nginx 在收到 reopen 信号前仍会写入旧文件，宽限期内没有新数据才关闭旧文件。
+const rotatedDrainGrace = 10 * time.Second
+
+// tailTarget 描述一个日志文件推送到哪个站点/来源。
+type tailTarget struct {
+	WebsiteID string
+	SourceID  string
+}
+
+// inputPattern 是展开前的路径或 glob，及其对应的站点/来源。
+type inputPattern struct {
+	pattern string
+	target  tailTarget
+}
+
+type fileState struct {
+	inode    uint64
+	offset   int64
+	lastSize int64
+	partial  string
+	target   tailTarget
+	// file 始终持有当前文件句柄，rename 轮转后仍能读到旧文件的剩余内容。
+	file     *os.File
+	draining *drainState
+	// orphan 表示该路径已不再被任何 glob 匹配，读完剩余内容后移除。
+	orphan bool
+}
+
+// drainState 是被轮转走的旧文件，在宽限期内读完剩余内容后关闭。
+type drainState struct {
+	file    *os.File
+	inode   uint64
+	offset  int64
+	partial string
+	until   time.Time
+}
+
+type readStats struct {
+	path         string
+	from         int64
+	to           int64
+	fileSize     int64
+	lines        int
+	bytes        int64
+	hasPartial   bool
+	skippedLines int
+	maxLineBytes int
+	drainedLines int
+	rotated      bool
+}
+
+// buildInputPatterns 合并顶层 paths（使用顶层 websiteID/sourceID）与 inputs 中的映射。
+func buildInputPatterns(cfg *agentConfig, defaultSourceID string) []inputPattern {
+	patterns := make([]inputPattern, 0, len(cfg.Paths)+len(cfg.Inputs))
+	defaultTarget := tailTarget{
+		WebsiteID: strings.TrimSpace(cfg.WebsiteID),
+		SourceID:  defaultSourceID,
+	}
+	for _, path := range cfg.Paths {
+		if path = strings.TrimSpace(path); path != "" {
+			patterns = append(patterns, inputPattern{pattern: path, target: defaultTarget})
+		}
+	}
+	for _, input := range cfg.Inputs {
+		target := tailTarget{
+			WebsiteID: strings.TrimSpace(input.WebsiteID),
+			SourceID:  strings.TrimSpace(input.SourceID),
+		}
+		if target.WebsiteID == "" {
+			target.WebsiteID = defaultTarget.WebsiteID
+		}
+		if target.SourceID == "" {
+			target.SourceID = defaultTarget.SourceID
+		}
+		for _, path := range input.Paths {
+			if path = strings.TrimSpace(path); path != "" {
+				patterns = append(patterns, inputPattern{pattern: path, target: target})
+			}
+		}
+	}
+	return patterns
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// discoverFiles 展开 glob，返回 path -> target；同一文件被多个规则匹配时以先出现的规则为准。
+// 非 glob 路径即使暂不存在也会保留，等待文件出现。
+func discoverFiles(patterns []inputPattern) map[string]tailTarget {
+	files := make(map[string]tailTarget)
+	for _, item := range patterns {
+		if !hasGlobMeta(item.pattern) {
+			if _, ok := files[item.pattern]; !ok {
+				files[item.pattern] = item.target
+			}
+			continue
+		}
+		matches, err := filepath.Glob(item.pattern)
+		if err != nil {
+			logrus.WithError(err).Warnf("glob 格式错误: %s", item.pattern)
+			continue
+		}
+		for _, match := range matches {
+			if strings.HasSuffix(strings.ToLower(match), ".gz") {
+				continue
+			}
+			if info, err := os.Stat(match); err != nil || info.IsDir() {
+				continue
+			}
+			if _, ok := files[match]; !ok {
+				files[match] = item.target
+			}
+		}
+	}
+	return files
+}
+
+// tailer 管理所有被跟踪的文件：周期性重新展开 glob，并处理轮转。
+type tailer struct {
+	patterns []inputPattern
+	states   map[string]*fileState
+	saved    *persistedState
+}
+
+func newTailer(patterns []inputPattern, saved *persistedState) *tailer {
+	return &tailer{
+		patterns: patterns,
+		states:   make(map[string]*fileState),
+		saved:    saved,
+	}
+}
+
+// Discover 重新展开 glob：新文件从落盘进度（或从头）开始读取；
+// 如果新匹配的文件正是某个正在排空的轮转文件（glob 同时匹配了 access.log.1），
+// 则接管其句柄与 offset，避免重复读取。
+func (t *tailer) Discover() (added []string) {
+	files := discoverFiles(t.patterns)
+	for path, state := range t.states {
+		if _, ok := files[path]; !ok {
+			state.orphan = true
+		}
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		target := files[path]
+		if state, ok := t.states[path]; ok {
+			state.orphan = false
+			state.target = target
+			continue
+		}
+		state := t.adoptDraining(path)
+		if state == nil {
+			entry, ok := t.savedEntry(path)
+			state = restoreFileState(path, entry, ok)
+		}
+		state.target = target
+		t.states[path] = state
+		added = append(added, path)
+	}
+	return added
+}
+
+func (t *tailer) savedEntry(path string) (persistedFile, bool) {
+	if t.saved == nil {
+		return persistedFile{}, false
+	}
+	entry, ok := t.saved.Files[path]
+	return entry, ok
+}
+
+func (t *tailer) adoptDraining(path string) *fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	inode := fileInode(info)
+	if inode == 0 {
+		return nil
+	}
+	for _, other := range t.states {
+		d := other.draining
+		if d == nil || d.inode != inode {
+			continue
+		}
+		other.draining = nil
+		return &fileState{
+			inode:   d.inode,
+			offset:  d.offset,
+			partial: d.partial,
+			file:    d.file,
+		}
+	}
+	return nil
+}
+
+// Paths 返回当前跟踪的文件（排序后），保证每轮读取顺序稳定。
+func (t *tailer) Paths() []string {
+	paths := make([]string, 0, len(t.states))
+	for path := range t.states {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (t *tailer) State(path string) *fileState {
+	return t.states[path]
+}
+
+// Prune 移除已不再匹配且没有剩余内容的文件。
+func (t *tailer) Prune() {
+	for path, state := range t.states {
+		if !state.orphan || state.draining != nil {
+			continue
+		}
+		if state.file != nil {
+			if info, err := state.file.Stat(); err == nil && info.Size() > state.offset {
+				continue
+			}
+			state.file.Close()
+		}
+		delete(t.states, path)
+	}
+}
+
+func (t *tailer) Close() {
+	for _, state := range t.states {
+		if state.file != nil {
+			state.file.Close()
+		}
+		if state.draining != nil {
+			state.draining.file.Close()
+		}
+	}
+}
+
+// readNewLines 先读完轮转走的旧文件，再读取当前文件的新增内容。
+func readNewLines(path string, state *fileState, maxLineBytes int) ([]string, readStats, error) {
+	stats := readStats{path: path, maxLineBytes: maxLineBytes}
+	var lines []string
+
+	// 先判断是否发生了轮转：路径被移走（新文件尚未创建）或指向了新的 inode。
+	info, statErr := os.Stat(path)
+	if state.file != nil {
+		if statErr != nil && os.IsNotExist(statErr) {
+			state.startDrain()
+			stats.rotated = true
+		} else if statErr == nil {
+			inode := fileInode(info)
+			if state.inode != 0 && inode != 0 && inode != state.inode {
+				state.startDrain()
+				stats.rotated = true
+			}
+		}
+	}
+
+	if d := state.draining; d != nil {
+		drained, offset, partial, st, err := readLinesAt(d.file, path, d.offset, d.partial, maxLineBytes)
+		d.offset = offset
+		d.partial = partial
+		lines = append(lines, drained...)
+		stats.drainedLines = len(drained)
+		stats.skippedLines += st.skippedLines
+		if err != nil || (len(drained) == 0 && time.Now().After(d.until)) {
+			// 旧文件不会再有新内容：末尾没有换行的残行也视为完整的一行。
+			if d.partial != "" {
+				lines = append(lines, d.partial)
+				stats.drainedLines++
+			}
+			d.file.Close()
+			state.draining = nil
+			logrus.WithFields(logrus.Fields{
+				"path":   path,
+				"inode":  d.inode,
+				"offset": d.offset,
+			}).Info("finished draining rotated file")
+		}
+	}
+
+	if statErr != nil {
+		stats.lines = len(lines)
+		if os.IsNotExist(statErr) && stats.rotated {
+			return lines, stats, nil
+		}
+		return lines, stats, statErr
+	}
+	inode := fileInode(info)
+	if state.file == nil {
+		file, err := os.Open(path)
+		if err != nil {
+			stats.lines = len(lines)
+			return lines, stats, err
+		}
+		if opened, err := file.Stat(); err == nil {
+			inode = fileInode(opened)
+			info = opened
+		}
+		if state.inode != 0 && inode != 0 && inode != state.inode {
+			state.offset = 0
+			state.partial = ""
+		}
+		state.file = file
+		state.inode = inode
+	}
+
+	size := info.Size()
+	stats.fileSize = size
+	if size < state.offset {
+		// copytruncate 方式的轮转：文件被截断后从头读取。
+		state.offset = 0
+		state.partial = ""
+	}
+	stats.from = state.offset
+	stats.to = state.offset
+	if size == state.offset {
+		stats.lines = len(lines)
+		return lines, stats, nil
+	}
+
+	current, offset, partial, st, err := readLinesAt(state.file, path, state.offset, state.partial, maxLineBytes)
+	state.offset = offset
+	state.partial = partial
+	state.lastSize = size
+	lines = append(lines, current...)
+	stats.to = offset
+	stats.bytes = st.bytes
+	stats.hasPartial = st.hasPartial
+	stats.skippedLines += st.skippedLines
+	stats.lines = len(lines)
+	return lines, stats, err
+}
+
+// startDrain 把当前句柄转为排空状态；若已有旧文件在排空，先将其剩余残行丢弃并关闭（连续两次轮转）。
+func (s *fileState) startDrain() {
+	if s.file == nil {
+		return
+	}
+	if s.draining != nil {
+		s.draining.file.Close()
+	}
+	s.draining = &drainState{
+		file:    s.file,
+		inode:   s.inode,
+		offset:  s.offset,
+		partial: s.partial,
+		until:   time.Now().Add(rotatedDrainGrace),
+	}
+	logrus.WithFields(logrus.Fields{
+		"inode":  s.inode,
+		"offset": s.offset,
+	}).Info("file was rotated; draining the old file before switching")
+	s.file = nil
+	s.inode = 0
+	s.offset = 0
+	s.lastSize = 0
+	s.partial = ""
+}
+
+// readLinesAt 从 offset 开始读取完整行；文件末尾没有换行的残行通过 partial 返回，下次继续拼接。
+func readLinesAt(file *os.File, path string, offset int64, seed string, maxLineBytes int) ([]string, int64, string, readStats, error) {
+	stats := readStats{path: path, from: offset, maxLineBytes: maxLineBytes}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, seed, stats, err
+	}
+
+	// Use ReadSlice-based line reading with a bounded line size to avoid huge allocations
+	// when input contains abnormally long lines.
+	reader := bufio.NewReaderSize(file, 64*1024)
+	lines := []string{}
+	partial := ""
+
+	var lastOverlongLogged time.Time
+	logOverlong := func(actualLineBytes int64, from, to int64) {
+		// avoid log spam if the input continuously produces overlong lines
+		if time.Since(lastOverlongLogged) < 5*time.Second {
+			return
+		}
+		lastOverlongLogged = time.Now()
+		logrus.WithFields(logrus.Fields{
+			"path":           path,
+			"max_line_bytes": maxLineBytes,
+			"line_bytes":     actualLineBytes,
+			"offset_from":    from,
+			"offset_to":      to,
+		}).Warn("skipping overlong log line (exceeds maxLineBytes)")
+	}
+
+	for {
+		line, overlong, bytesRead, hasNewline, eof, err, actualLineBytes := readOneLineLimited(reader, maxLineBytes, seed)
+		seed = ""
+		if bytesRead > 0 {
+			offset += bytesRead
+			stats.bytes += bytesRead
+		}
+		if err != nil {
+			stats.to = offset
+			return lines, offset, partial, stats, err
+		}
+		if bytesRead == 0 && eof {
+			if line != "" {
+				// 没有新数据时保留上次的残行。
+				partial = line
+				stats.hasPartial = true
+			}
+			break
+		}
+		if overlong {
+			stats.skippedLines++
+			logOverlong(actualLineBytes, offset-bytesRead, offset)
+			// Overlong line discarded; do not carry partial forward.
+			if eof && !hasNewline {
+				break
+			}
+			continue
+		}
+		if eof && !hasNewline {
+			// store bounded partial for next read
+			if line != "" {
+				partial = line
+				stats.hasPartial = true
+			}
+			break
+		}
+		if line != "" {
+			lines = append(lines, line)
+			stats.lines++
+		}
+	}
+	stats.to = offset
+	return lines, offset, partial, stats, nil
+}
+
+// findFileByInode 在目录中查找指定 inode 的文件，用于定位重启期间被轮转走的旧文件。
+func findFileByInode(dir string, inode uint64) (string, bool) {
+	if inode == 0 {
+		return "", false
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(strings.ToLower(entry.Name()), ".gz") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if fileInode(info) == inode {
+			return filepath.Join(dir, entry.Name()), true
+		}
+	}
+	return "", false
+}
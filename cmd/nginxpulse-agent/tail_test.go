@@ -0,0 +1,148 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readLines(t *testing.T, path string, state *fileState) []string {
+	t.Helper()
+	lines, _, err := readNewLines(path, state, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestReadNewLinesDrainsRenamedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	appendFile(t, path, "a\nb\n")
+	state := &fileState{}
+	defer func() {
+		tl := &tailer{states: map[string]*fileState{path: state}}
+		tl.Close()
+	}()
+	if got := readLines(t, path, state); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("initial read: %q", got)
+	}
+
+	// logrotate rename 之后 nginx 在 reopen 前仍写入旧文件
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "c\ntail")
+	appendFile(t, path, "d\n")
+	lines, stats, err := readNewLines(path, state, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.rotated || stats.drainedLines != 1 || !reflect.DeepEqual(lines, []string{"c", "d"}) {
+		t.Fatalf("rotation read: lines=%q stats=%+v", lines, stats)
+	}
+	if state.draining == nil || state.draining.partial != "tail" {
+		t.Fatal("old file should keep draining within the grace period")
+	}
+
+	// 宽限期内旧文件继续写入的内容仍会被读到
+	appendFile(t, path+".1", "-end\n")
+	if got := readLines(t, path, state); !reflect.DeepEqual(got, []string{"tail-end"}) {
+		t.Fatalf("drain within grace period: %q", got)
+	}
+
+	// 宽限期结束且没有新内容时关闭旧文件，末尾残行作为完整一行输出
+	appendFile(t, path+".1", "last")
+	readLines(t, path, state)
+	state.draining.until = time.Now().Add(-time.Second)
+	if got := readLines(t, path, state); !reflect.DeepEqual(got, []string{"last"}) {
+		t.Fatalf("final drain: %q", got)
+	}
+	if state.draining != nil {
+		t.Fatal("drain should be finished after the grace period")
+	}
+	appendFile(t, path, "e\n")
+	if got := readLines(t, path, state); !reflect.DeepEqual(got, []string{"e"}) {
+		t.Fatalf("read after drain: %q", got)
+	}
+}
+
+func TestReadNewLinesCopyTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	appendFile(t, path, "a\nb\n")
+	state := &fileState{}
+	defer func() { state.file.Close() }()
+	readLines(t, path, state)
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "c\n")
+	if got := readLines(t, path, state); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Fatalf("read after truncate: %q", got)
+	}
+}
+
+func TestTailerAdoptsDrainingFileMatchedByGlob(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	appendFile(t, path, "a\n")
+	target := tailTarget{WebsiteID: "site", SourceID: "main"}
+	tl := newTailer([]inputPattern{{pattern: filepath.Join(dir, "access.log*"), target: target}}, nil)
+	defer tl.Close()
+	if added := tl.Discover(); len(added) != 1 {
+		t.Fatalf("unexpected discovered files %v", added)
+	}
+	readLines(t, path, tl.State(path))
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "b\n")
+	appendFile(t, path, "c\n")
+	if got := readLines(t, path, tl.State(path)); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Fatalf("rotation read: %q", got)
+	}
+
+	// glob 同时匹配到 access.log.1 时接管排空中的句柄，不会从头重读
+	tl.Discover()
+	rotated := tl.State(path + ".1")
+	if rotated == nil || rotated.target != target {
+		t.Fatal("rotated file should be tracked with the same target")
+	}
+	if tl.State(path).draining != nil {
+		t.Fatal("draining handle should be handed over to the rotated path")
+	}
+	appendFile(t, path+".1", "d\n")
+	if got := readLines(t, path+".1", rotated); !reflect.DeepEqual(got, []string{"d"}) {
+		t.Fatalf("adopted file re-read old content: %q", got)
+	}
+}
+
+func TestRestoreFileStateFindsFileRotatedWhileStopped(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	appendFile(t, path, "a\nb\n")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := persistedFile{Inode: fileInode(info), Offset: 2, Size: 4}
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "c\n")
+	state := restoreFileState(path, saved, true)
+	if state.draining == nil {
+		t.Fatal("rotated file should be located by inode and drained")
+	}
+	state.draining.until = time.Now().Add(-time.Second)
+	defer func() { state.file.Close() }()
+	if got := readLines(t, path, state); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Fatalf("restart read: %q", got)
+	}
+}
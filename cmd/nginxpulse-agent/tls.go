@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/tlsutil"
+)
+
+// newAgentTransport 创建推送共用的 HTTP Transport。
+// 配置客户端证书时用于服务端 mTLS 校验，证书文件轮换后自动重新加载；
+// 配置 tlsCAFile 时使用该 CA 校验服务端证书（自签名证书场景）。
+func newAgentTransport(cfg *agentConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	certFile := strings.TrimSpace(cfg.TLSCertFile)
+	keyFile := strings.TrimSpace(cfg.TLSKeyFile)
+	caFile := strings.TrimSpace(cfg.TLSCAFile)
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("tlsCertFile 与 tlsKeyFile 需要同时配置")
+		}
+		certs, err := tlsutil.NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = certs.GetClientCertificate
+	}
+	if caFile != "" {
+		pool, err := tlsutil.LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
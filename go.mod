@@ -10,11 +10,14 @@ require (
 	github.com/gin-contrib/cors v1.7.4
 	github.com/gin-gonic/gin v1.10.0
 	github.com/jackc/pgx/v5 v5.8.0
+	github.com/klauspost/compress v1.17.11
 	github.com/lionsoul2014/ip2region/binding/golang v0.0.0-20260121081438-f2c988287c27
 	github.com/mileusna/useragent v1.3.5
 	github.com/pkg/sftp v1.13.6
 	github.com/sirupsen/logrus v1.9.3
+	github.com/xuri/excelize/v2 v2.9.1
 	golang.org/x/crypto v0.38.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -62,7 +65,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	github.com/xuri/efp v0.0.1 // indirect
-	github.com/xuri/excelize/v2 v2.9.1 // indirect
 	github.com/xuri/nfp v0.0.1 // indirect
 	golang.org/x/arch v0.12.0 // indirect
 	golang.org/x/net v0.40.0 // indirect
@@ -70,5 +72,4 @@ require (
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.29.0 // indirect
 	google.golang.org/protobuf v1.36.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,169 @@
+// Package agentproto 定义 nginxpulse-agent 与服务端之间 v2 推送协议的公共部分，
+// 由 agent 与服务端共同引用，避免两侧各自维护请求头与签名规则。
+package agentproto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CapabilitiesPath = "/api/ingest/capabilities"
+	IngestV2Path     = "/api/ingest/v2/logs"
+	HeartbeatPath    = "/api/ingest/heartbeat"
+
+	HeaderAccessKey  = "X-NginxPulse-Key"
+	HeaderAgentToken = "X-NginxPulse-Agent-Token"
+	HeaderAgentID    = "X-NginxPulse-Agent-ID"
+	HeaderWebsiteID  = "X-NginxPulse-Website-ID"
+	HeaderSourceID   = "X-NginxPulse-Source-ID"
+	HeaderStreamID   = "X-NginxPulse-Batch-Stream"
+	HeaderBatchSeq   = "X-NginxPulse-Batch-Seq"
+	HeaderTimestamp  = "X-NginxPulse-Timestamp"
+	HeaderSignature  = "X-NginxPulse-Signature"
+
+	ContentTypeNDJSON = "application/x-ndjson"
+
+	EncodingGzip     = "gzip"
+	EncodingZstd     = "zstd"
+	EncodingIdentity = "identity"
+
+	signaturePrefix = "sha256="
+	// MaxClockSkew 是签名时间戳允许的最大偏差。
+	MaxClockSkew = 5 * time.Minute
+)
+
+// Record 是 NDJSON 请求体中的一行。
+// SampleRate 为 agent 侧的采样率（0,1]，省略表示未采样；服务端据此放大计数。
+type Record struct {
+	Line       string  `json:"line"`
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// Capabilities 是服务端在 CapabilitiesPath 返回的协议能力，agent 据此协商版本与压缩方式。
+type Capabilities struct {
+	Versions   []int    `json:"versions"`
+	Encodings  []string `json:"encodings"`
+	Signatures []string `json:"signatures"`
+	Features   []string `json:"features,omitempty"`
+}
+
+// FeatureHeartbeat 表示服务端接受 HeartbeatPath 心跳。
+const FeatureHeartbeat = "heartbeat"
+
+func (c Capabilities) SupportsFeature(feature string) bool {
+	for _, v := range c.Features {
+		if v == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Heartbeat 是 agent 周期性上报的运行状态。
+type Heartbeat struct {
+	AgentID  string `json:"agent_id"`
+	Version  string `json:"version"`
+	Hostname string `json:"hostname"`
+	StreamID string `json:"stream_id,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	// IntervalSeconds 是 agent 的心跳间隔，服务端据此判断心跳是否超时。
+	IntervalSeconds int              `json:"interval_seconds"`
+	Files           []HeartbeatFile  `json:"files"`
+	Backlog         HeartbeatBacklog `json:"backlog"`
+	LastError       string           `json:"last_error,omitempty"`
+	LastErrorAt     int64            `json:"last_error_at,omitempty"`
+	// SentAt 是 agent 本地时间（Unix 毫秒），服务端与接收时间比较得出时钟偏差。
+	SentAt int64 `json:"sent_at"`
+}
+
+type HeartbeatFile struct {
+	Path      string `json:"path"`
+	WebsiteID string `json:"website_id"`
+	SourceID  string `json:"source_id"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	Draining  bool   `json:"draining,omitempty"`
+}
+
+type HeartbeatBacklog struct {
+	PendingLines  int   `json:"pending_lines"`
+	SpoolSegments int   `json:"spool_segments"`
+	SpoolLines    int   `json:"spool_lines"`
+	SpoolBytes    int64 `json:"spool_bytes"`
+}
+
+// HeartbeatAck 是服务端对心跳的响应；ClockSkewMs = agent 时间 - 服务端时间。
+type HeartbeatAck struct {
+	ServerTime  int64 `json:"server_time"`
+	ClockSkewMs int64 `json:"clock_skew_ms"`
+}
+
+func (c Capabilities) SupportsVersion(version int) bool {
+	for _, v := range c.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) SupportsEncoding(encoding string) bool {
+	for _, v := range c.Encodings {
+		if strings.EqualFold(v, encoding) {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchMeta 是参与签名的批次元信息（与请求头一一对应）。
+type BatchMeta struct {
+	AgentID   string
+	WebsiteID string
+	SourceID  string
+	StreamID  string
+	Seq       int64
+	Timestamp int64
+}
+
+// Sign 计算批次签名：HMAC-SHA256(secret, 元信息 + 请求体摘要)。
+// body 为实际传输的（压缩后的）字节，签名同时覆盖元信息，避免请求头被篡改。
+func Sign(secret string, meta BatchMeta, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonicalString(meta, body)))
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验签名与时间戳。
+func Verify(secret string, meta BatchMeta, body []byte, signature string, now time.Time) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	skew := now.Sub(time.Unix(meta.Timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > MaxClockSkew {
+		return false
+	}
+	expected := Sign(secret, meta, body)
+	return hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature)))
+}
+
+func canonicalString(meta BatchMeta, body []byte) string {
+	digest := sha256.Sum256(body)
+	return strings.Join([]string{
+		strconv.FormatInt(meta.Timestamp, 10),
+		meta.AgentID,
+		meta.WebsiteID,
+		meta.SourceID,
+		meta.StreamID,
+		strconv.FormatInt(meta.Seq, 10),
+		hex.EncodeToString(digest[:]),
+	}, "\n")
+}
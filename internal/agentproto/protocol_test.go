@@ -0,0 +1,44 @@
+package agentproto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	meta := BatchMeta{
+		AgentID:   "edge-1",
+		WebsiteID: "site",
+		SourceID:  "main",
+		StreamID:  "0123456789abcdef",
+		Seq:       42,
+		Timestamp: now.Unix(),
+	}
+	body := []byte(`{"line":"GET / 200"}` + "\n")
+	signature := Sign("secret", meta, body)
+
+	if !Verify("secret", meta, body, signature, now) {
+		t.Fatal("valid signature rejected")
+	}
+	if !Verify("secret", meta, body, " "+signature+"\n", now.Add(MaxClockSkew)) {
+		t.Fatal("signature within the allowed clock skew rejected")
+	}
+
+	tampered := meta
+	tampered.Seq = 43
+	cases := map[string]func() bool{
+		"wrong secret":    func() bool { return Verify("other", meta, body, signature, now) },
+		"empty secret":    func() bool { return Verify("", meta, body, Sign("", meta, body), now) },
+		"empty sig":       func() bool { return Verify("secret", meta, body, "", now) },
+		"tampered meta":   func() bool { return Verify("secret", tampered, body, signature, now) },
+		"tampered body":   func() bool { return Verify("secret", meta, []byte("x"), signature, now) },
+		"too old":         func() bool { return Verify("secret", meta, body, signature, now.Add(MaxClockSkew+time.Second)) },
+		"from the future": func() bool { return Verify("secret", meta, body, signature, now.Add(-MaxClockSkew-time.Second)) },
+	}
+	for name, verify := range cases {
+		if verify() {
+			t.Errorf("%s: signature must be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/querylang"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+)
+
+// adhocQueryDefaultLimit 为语句未指定 limit 时返回的行数
+const adhocQueryDefaultLimit = 100
+
+// ErrAdhocQueryTimeout 表示表达式查询超过了 system.queryApi.timeout
+var ErrAdhocQueryTimeout = errors.New("查询超时")
+
+// AdhocQueryRequest 为 /api/query 的请求参数，Query 为 querylang 语句。
+type AdhocQueryRequest struct {
+	WebsiteID string `json:"id"`
+	TimeRange string `json:"timeRange"`
+	Query     string `json:"q"`
+	// Explain 为 true 时只返回生成的 SQL 与执行计划，不执行查询
+	Explain bool `json:"explain"`
+}
+
+type AdhocQueryResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated"`
+	Limit     int             `json:"limit"`
+	ElapsedMs int64           `json:"elapsedMs"`
+	SQL       string          `json:"sql,omitempty"`
+	Args      []interface{}   `json:"args,omitempty"`
+	Plan      []string        `json:"plan,omitempty"`
+}
+
+// RunAdhocQuery 解析并执行表达式查询。参数或语句错误返回 *querylang.Error，
+// 超时返回 ErrAdhocQueryTimeout。查询在只读事务中执行，并同时设置数据库侧的 statement_timeout。
+func (f *StatsFactory) RunAdhocQuery(req AdhocQueryRequest) (*AdhocQueryResult, error) {
+	limits := config.GetQueryAPILimits()
+	websiteID := strings.TrimSpace(req.WebsiteID)
+	if websiteID == "" {
+		return nil, &querylang.Error{Pos: -1, Msg: "id 参数缺失"}
+	}
+	if _, ok := config.GetWebsiteByID(websiteID); !ok {
+		return nil, &querylang.Error{Pos: -1, Msg: fmt.Sprintf("站点不存在: %s", websiteID)}
+	}
+	timeRange := strings.TrimSpace(req.TimeRange)
+	if timeRange == "" {
+		return nil, &querylang.Error{Pos: -1, Msg: "timeRange 参数缺失"}
+	}
+	startTime, endTime, err := timeutil.TimePeriod(timeRange)
+	if err != nil {
+		return nil, &querylang.Error{Pos: -1, Msg: fmt.Sprintf("解析时间范围失败: %v", err)}
+	}
+
+	parsed, err := querylang.Parse(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := querylang.Compile(parsed, querylang.Options{
+		WebsiteID:    websiteID,
+		StartTs:      startTime.Unix(),
+		EndTs:        endTime.Unix(),
+		DefaultLimit: adhocQueryDefaultLimit,
+		MaxRows:      limits.MaxRows,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AdhocQueryResult{
+		Columns: compiled.Columns,
+		Rows:    make([][]interface{}, 0),
+		Limit:   compiled.Limit,
+	}
+	if req.Explain {
+		result.SQL = compiled.SQL
+		result.Args = compiled.Args
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), limits.Timeout)
+	defer cancel()
+	started := time.Now()
+
+	tx, err := f.repo.GetDB().BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, adhocQueryError(ctx, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", limits.Timeout.Milliseconds())); err != nil {
+		return nil, adhocQueryError(ctx, err)
+	}
+
+	if req.Explain {
+		rows, err := tx.QueryContext(ctx, "EXPLAIN "+compiled.SQL, compiled.Args...)
+		if err != nil {
+			return nil, adhocQueryError(ctx, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				return nil, fmt.Errorf("解析执行计划失败: %v", err)
+			}
+			result.Plan = append(result.Plan, line)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, adhocQueryError(ctx, err)
+		}
+		result.ElapsedMs = time.Since(started).Milliseconds()
+		return result, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, compiled.SQL, compiled.Args...)
+	if err != nil {
+		return nil, adhocQueryError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if len(result.Rows) >= compiled.Limit {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(compiled.Columns))
+		pointers := make([]interface{}, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("解析查询结果失败: %v", err)
+		}
+		for i, value := range values {
+			if raw, ok := value.([]byte); ok {
+				values[i] = string(raw)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, adhocQueryError(ctx, err)
+	}
+	result.ElapsedMs = time.Since(started).Milliseconds()
+	return result, nil
+}
+
+// adhocQueryError 将超时（客户端截止或数据库 statement_timeout，SQLSTATE 57014）统一为 ErrAdhocQueryTimeout
+func adhocQueryError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || strings.Contains(err.Error(), "57014") {
+		return ErrAdhocQueryTimeout
+	}
+	return fmt.Errorf("执行查询失败: %v", err)
+}
@@ -0,0 +1,166 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+)
+
+// ErrAnomaliesSegment 表示异常查询带了分群条件：异常由全站的小时聚合检测得出，无法按分群筛选。
+var ErrAnomaliesSegment = errors.New("anomalies 不支持分群条件")
+
+// anomalyMetricNames 为异常检测支持的指标，与 ingest 中的检测指标保持一致。
+var anomalyMetricNames = []string{"pv", "uv", "s4xx", "s5xx", "traffic"}
+
+type AnomalyItem struct {
+	ID        int64   `json:"id"`
+	WebsiteID string  `json:"websiteId"`
+	Name      string  `json:"name"`
+	Bucket    int64   `json:"bucket"` // 小时桶起始时间戳（秒）
+	Time      string  `json:"time"`   // 本地时间，格式 2006-01-02 15:00
+	Metric    string  `json:"metric"`
+	Value     float64 `json:"value"`
+	Baseline  float64 `json:"baseline"` // 季节基线（历史同时段中位数）
+	MAD       float64 `json:"mad"`
+	Score     float64 `json:"score"` // 稳健 z 分数，负数表示下降
+	Severity  string  `json:"severity"`
+	Direction string  `json:"direction"` // spike / drop
+}
+
+type AnomaliesSummary struct {
+	Total      int            `json:"total"`
+	BySeverity map[string]int `json:"bySeverity"`
+	ByMetric   map[string]int `json:"byMetric"`
+}
+
+type AnomaliesStats struct {
+	Items   []AnomalyItem    `json:"items"`
+	Summary AnomaliesSummary `json:"summary"`
+	QueryMeta
+}
+
+// AnomaliesStats 实现 StatsResult 接口
+func (s AnomaliesStats) GetType() string {
+	return "anomalies"
+}
+
+type AnomaliesStatsManager struct {
+	repo *store.Repository
+}
+
+// NewAnomaliesStatsManager 创建流量异常统计管理器
+func NewAnomaliesStatsManager(userRepoPtr *store.Repository) *AnomaliesStatsManager {
+	return &AnomaliesStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// 实现 StatsManager 接口
+func (s *AnomaliesStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := AnomaliesStats{
+		Items: make([]AnomalyItem, 0),
+		Summary: AnomaliesSummary{
+			BySeverity: make(map[string]int),
+			ByMetric:   make(map[string]int),
+		},
+		QueryMeta: newQueryMeta(Segment{}, QueryPathAggregate),
+	}
+	if !query.Segment.IsEmpty() {
+		return result, ErrAnomaliesSegment
+	}
+
+	timeRange := query.ExtraParam["timeRange"].(string)
+	startTime, endTime, err := timeutil.TimePeriod(timeRange)
+	if err != nil {
+		return result, err
+	}
+	severity, _ := query.ExtraParam["severity"].(string)
+	metric, _ := query.ExtraParam["metric"].(string)
+
+	anomalies, err := loadAnomalies(s.repo, query.Sites(), startTime.Unix(), endTime.Unix())
+	if err != nil {
+		return result, fmt.Errorf("获取流量异常失败: %v", err)
+	}
+	minRank := config.AnomalySeverityRank(severity)
+	for _, anomaly := range anomalies {
+		if metric != "" && anomaly.Metric != metric {
+			continue
+		}
+		if minRank > 0 && config.AnomalySeverityRank(anomaly.Severity) < minRank {
+			continue
+		}
+		result.Items = append(result.Items, newAnomalyItem(anomaly))
+		result.Summary.Total++
+		result.Summary.BySeverity[anomaly.Severity]++
+		result.Summary.ByMetric[anomaly.Metric]++
+	}
+	return result, nil
+}
+
+func loadAnomalies(repo *store.Repository, sites []string, startBucket, endBucket int64) ([]store.TrafficAnomaly, error) {
+	if repo == nil {
+		return nil, nil
+	}
+	return repo.ListTrafficAnomalies(sites, startBucket, endBucket)
+}
+
+func newAnomalyItem(anomaly store.TrafficAnomaly) AnomalyItem {
+	name := anomaly.WebsiteID
+	if website, ok := config.GetWebsiteByID(anomaly.WebsiteID); ok {
+		name = website.Name
+	}
+	return AnomalyItem{
+		ID:        anomaly.ID,
+		WebsiteID: anomaly.WebsiteID,
+		Name:      name,
+		Bucket:    anomaly.Bucket,
+		Time:      time.Unix(anomaly.Bucket, 0).In(time.Local).Format("2006-01-02 15:00"),
+		Metric:    anomaly.Metric,
+		Value:     anomaly.Value,
+		Baseline:  anomaly.Baseline,
+		MAD:       anomaly.MAD,
+		Score:     anomaly.Score,
+		Severity:  anomaly.Severity,
+		Direction: anomaly.Direction,
+	}
+}
+
+// TimeSeriesAnnotation 是标注在趋势图上的异常点，Index 对应 labels 的下标。
+type TimeSeriesAnnotation struct {
+	Index int `json:"index"`
+	AnomalyItem
+}
+
+// anomalyAnnotations 将时间范围内的异常映射到趋势图的时间点上。
+func anomalyAnnotations(repo *store.Repository, sites []string, boundaries []int64) ([]TimeSeriesAnnotation, error) {
+	annotations := make([]TimeSeriesAnnotation, 0)
+	if len(boundaries) < 2 {
+		return annotations, nil
+	}
+	anomalies, err := loadAnomalies(repo, sites, boundaries[0], boundaries[len(boundaries)-1])
+	if err != nil {
+		return annotations, err
+	}
+	for _, anomaly := range anomalies {
+		// boundaries 升序，找到第一个大于 bucket 的边界，其前一个区间即为所属时间点
+		idx := sort.Search(len(boundaries), func(i int) bool {
+			return boundaries[i] > anomaly.Bucket
+		}) - 1
+		if idx < 0 || idx >= len(boundaries)-1 {
+			continue
+		}
+		annotations = append(annotations, TimeSeriesAnnotation{
+			Index:       idx,
+			AnomalyItem: newAnomalyItem(anomaly),
+		})
+	}
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return annotations[i].Index < annotations[j].Index
+	})
+	return annotations, nil
+}
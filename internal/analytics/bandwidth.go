@@ -0,0 +1,321 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+)
+
+// 带宽统计的内容分类
+const (
+	contentClassImage    = "image"
+	contentClassScript   = "script" // JS / CSS / source map
+	contentClassFont     = "font"
+	contentClassVideo    = "video" // 视频与音频
+	contentClassDownload = "download"
+	contentClassAPI      = "api"
+	contentClassPage     = "page"
+	contentClassOther    = "other"
+)
+
+var bandwidthClasses = []string{
+	contentClassImage, contentClassScript, contentClassFont, contentClassVideo,
+	contentClassDownload, contentClassAPI, contentClassPage, contentClassOther,
+}
+
+var bandwidthSortBys = []string{"traffic", "requests", "cacheable"}
+
+var extensionClasses = map[string]string{
+	"jpg": contentClassImage, "jpeg": contentClassImage, "png": contentClassImage, "gif": contentClassImage,
+	"webp": contentClassImage, "avif": contentClassImage, "svg": contentClassImage, "ico": contentClassImage,
+	"bmp": contentClassImage, "tif": contentClassImage, "tiff": contentClassImage,
+	"js": contentClassScript, "mjs": contentClassScript, "css": contentClassScript, "map": contentClassScript,
+	"woff": contentClassFont, "woff2": contentClassFont, "ttf": contentClassFont, "otf": contentClassFont,
+	"eot": contentClassFont,
+	"mp4": contentClassVideo, "webm": contentClassVideo, "mov": contentClassVideo, "mkv": contentClassVideo,
+	"avi": contentClassVideo, "flv": contentClassVideo, "m3u8": contentClassVideo, "ts": contentClassVideo,
+	"m4s": contentClassVideo, "mp3": contentClassVideo, "m4a": contentClassVideo, "ogg": contentClassVideo,
+	"wav": contentClassVideo, "flac": contentClassVideo,
+	"zip": contentClassDownload, "gz": contentClassDownload, "tgz": contentClassDownload, "tar": contentClassDownload,
+	"bz2": contentClassDownload, "xz": contentClassDownload, "rar": contentClassDownload, "7z": contentClassDownload,
+	"exe": contentClassDownload, "msi": contentClassDownload, "dmg": contentClassDownload, "pkg": contentClassDownload,
+	"apk": contentClassDownload, "ipa": contentClassDownload, "deb": contentClassDownload, "rpm": contentClassDownload,
+	"iso": contentClassDownload, "img": contentClassDownload, "bin": contentClassDownload, "jar": contentClassDownload,
+	"whl": contentClassDownload, "pdf": contentClassDownload, "doc": contentClassDownload, "docx": contentClassDownload,
+	"xls": contentClassDownload, "xlsx": contentClassDownload, "ppt": contentClassDownload, "pptx": contentClassDownload,
+	"json": contentClassAPI,
+	"html": contentClassPage, "htm": contentClassPage, "php": contentClassPage, "asp": contentClassPage,
+	"aspx": contentClassPage, "jsp": contentClassPage, "shtml": contentClassPage,
+}
+
+// cacheableClasses 为可以交给 CDN / 浏览器长期缓存的内容分类
+var cacheableClasses = map[string]bool{
+	contentClassImage:    true,
+	contentClassScript:   true,
+	contentClassFont:     true,
+	contentClassVideo:    true,
+	contentClassDownload: true,
+}
+
+const (
+	// cacheWorthyMinRequests / cacheWorthyMinScore 为标记 cacheWorthy 的门槛
+	cacheWorthyMinRequests = 20
+	cacheWorthyMinScore    = 80
+)
+
+type BandwidthGroupItem struct {
+	Key         string  `json:"key"`
+	Requests    int64   `json:"requests"`
+	Traffic     int64   `json:"traffic"`
+	NotModified int64   `json:"notModified"`
+	Share       float64 `json:"share"` // 占总字节数的百分比
+}
+
+type BandwidthAssetItem struct {
+	URL         string `json:"url"`
+	Class       string `json:"class"`
+	Extension   string `json:"extension"`
+	Requests    int64  `json:"requests"`
+	Traffic     int64  `json:"traffic"`
+	AvgBytes    int64  `json:"avgBytes"`
+	NotModified int64  `json:"notModified"`
+	ActiveDays  int64  `json:"activeDays"`
+	// RepeatHits 为扣除每天首个请求后的重复请求数，理想缓存下这些请求不必回源
+	RepeatHits int64 `json:"repeatHits"`
+	// CacheableTraffic 为重复请求按平均大小估算的可节省字节数
+	CacheableTraffic int64   `json:"cacheableTraffic"`
+	CacheScore       float64 `json:"cacheScore"`
+	CacheWorthy      bool    `json:"cacheWorthy"`
+}
+
+type BandwidthSummary struct {
+	Requests         int64 `json:"requests"`
+	Traffic          int64 `json:"traffic"`
+	NotModified      int64 `json:"notModified"`
+	CacheableTraffic int64 `json:"cacheableTraffic"`
+	URLs             int64 `json:"urls"`
+}
+
+type BandwidthStats struct {
+	Class      string               `json:"class,omitempty"`
+	SortBy     string               `json:"sortBy"`
+	Summary    BandwidthSummary     `json:"summary"`
+	Classes    []BandwidthGroupItem `json:"classes"`
+	Extensions []BandwidthGroupItem `json:"extensions"`
+	Assets     []BandwidthAssetItem `json:"assets"`
+	QueryMeta
+}
+
+func (s BandwidthStats) GetType() string {
+	return "bandwidth"
+}
+
+type BandwidthStatsManager struct {
+	repo *store.Repository
+}
+
+func NewBandwidthStatsManager(userRepoPtr *store.Repository) *BandwidthStatsManager {
+	return &BandwidthStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 读取 _agg_url_daily 按 URL 汇总后在内存中分类；带分群条件时回退到原始日志。
+func (m *BandwidthStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	class, _ := query.ExtraParam["class"].(string)
+	sortBy, _ := query.ExtraParam["sortBy"].(string)
+	if sortBy == "" {
+		sortBy = "traffic"
+	}
+	limit, _ := query.ExtraParam["limit"].(int)
+	if limit <= 0 {
+		limit = 20
+	}
+	result := BandwidthStats{
+		Class:      class,
+		SortBy:     sortBy,
+		Classes:    make([]BandwidthGroupItem, 0),
+		Extensions: make([]BandwidthGroupItem, 0),
+		Assets:     make([]BandwidthAssetItem, 0),
+		QueryMeta:  newQueryMeta(query.Segment, query.Segment.queryPath()),
+	}
+
+	timeRange, ok := query.ExtraParam["timeRange"].(string)
+	if !ok || timeRange == "" {
+		return result, fmt.Errorf("timeRange 参数缺失")
+	}
+	startTime, endTime, err := timeutil.TimePeriod(timeRange)
+	if err != nil {
+		return result, fmt.Errorf("解析时间范围失败: %v", err)
+	}
+
+	var (
+		source string
+		args   []interface{}
+	)
+	if query.Segment.IsEmpty() {
+		source = fmt.Sprintf(
+			`SELECT day, url_id, requests, traffic, not_modified
+             FROM "%s_agg_url_daily" WHERE day >= ? AND day <= ?`, query.WebsiteID)
+		args = []interface{}{dayBucket(startTime), dayBucket(endTime)}
+	} else {
+		segmentJoin, segmentCondition, segmentArgs := query.Segment.filter(query.WebsiteID, "l")
+		source = fmt.Sprintf(
+			`SELECT date(to_timestamp(l.timestamp)) AS day, l.url_id, l.sample_weight AS requests,
+                    l.bytes_sent * l.sample_weight AS traffic, CASE WHEN l.status_code = 304 THEN l.sample_weight ELSE 0 END AS not_modified
+             FROM "%s_nginx_logs" l%s
+             WHERE l.timestamp >= ? AND l.timestamp < ?%s`,
+			query.WebsiteID, segmentJoin, segmentCondition)
+		args = append([]interface{}{startTime.Unix(), endTime.Unix()}, segmentArgs...)
+	}
+
+	rows, err := m.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT u.url, SUM(e.requests), SUM(e.traffic), SUM(e.not_modified), COUNT(DISTINCT e.day)
+        FROM (%s) e
+        JOIN "%s_dim_url" u ON u.id = e.url_id
+        GROUP BY e.url_id, u.url`, source, query.WebsiteID)), args...)
+	if err != nil {
+		return result, fmt.Errorf("查询 URL 流量失败: %v", err)
+	}
+	defer rows.Close()
+
+	classes := make(map[string]*BandwidthGroupItem)
+	extensions := make(map[string]*BandwidthGroupItem)
+	for rows.Next() {
+		var asset BandwidthAssetItem
+		if err := rows.Scan(&asset.URL, &asset.Requests, &asset.Traffic, &asset.NotModified, &asset.ActiveDays); err != nil {
+			return result, fmt.Errorf("解析 URL 流量失败: %v", err)
+		}
+		asset.Extension = urlExtension(asset.URL)
+		asset.Class = contentClass(asset.URL, asset.Extension)
+		if class != "" && asset.Class != class {
+			continue
+		}
+		estimateCacheWorthiness(&asset)
+
+		addBandwidthGroup(classes, asset.Class, asset)
+		extKey := asset.Extension
+		if extKey == "" {
+			extKey = "(none)"
+		}
+		addBandwidthGroup(extensions, extKey, asset)
+
+		result.Summary.Requests += asset.Requests
+		result.Summary.Traffic += asset.Traffic
+		result.Summary.NotModified += asset.NotModified
+		result.Summary.CacheableTraffic += asset.CacheableTraffic
+		result.Summary.URLs++
+		result.Assets = append(result.Assets, asset)
+	}
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("遍历 URL 流量失败: %v", err)
+	}
+
+	result.Classes = sortBandwidthGroups(classes, result.Summary.Traffic)
+	result.Extensions = sortBandwidthGroups(extensions, result.Summary.Traffic)
+	if len(result.Extensions) > limit {
+		result.Extensions = result.Extensions[:limit]
+	}
+
+	sort.Slice(result.Assets, func(i, j int) bool {
+		a, b := result.Assets[i], result.Assets[j]
+		var av, bv int64
+		switch sortBy {
+		case "requests":
+			av, bv = a.Requests, b.Requests
+		case "cacheable":
+			av, bv = a.CacheableTraffic, b.CacheableTraffic
+		default:
+			av, bv = a.Traffic, b.Traffic
+		}
+		if av != bv {
+			return av > bv
+		}
+		return a.URL < b.URL
+	})
+	if len(result.Assets) > limit {
+		result.Assets = result.Assets[:limit]
+	}
+	return result, nil
+}
+
+// estimateCacheWorthiness 以每天首个请求为回源、其余为重复请求估算缓存收益，页面与 API 不计入。
+func estimateCacheWorthiness(asset *BandwidthAssetItem) {
+	if asset.Requests > 0 {
+		asset.AvgBytes = asset.Traffic / asset.Requests
+	}
+	if !cacheableClasses[asset.Class] || asset.Requests == 0 {
+		return
+	}
+	asset.RepeatHits = asset.Requests - asset.ActiveDays
+	if asset.RepeatHits < 0 {
+		asset.RepeatHits = 0
+	}
+	asset.CacheableTraffic = asset.AvgBytes * asset.RepeatHits
+	asset.CacheScore = math.Round(float64(asset.RepeatHits)/float64(asset.Requests)*1000) / 10
+	asset.CacheWorthy = asset.Requests >= cacheWorthyMinRequests && asset.CacheScore >= cacheWorthyMinScore
+}
+
+func addBandwidthGroup(groups map[string]*BandwidthGroupItem, key string, asset BandwidthAssetItem) {
+	group := groups[key]
+	if group == nil {
+		group = &BandwidthGroupItem{Key: key}
+		groups[key] = group
+	}
+	group.Requests += asset.Requests
+	group.Traffic += asset.Traffic
+	group.NotModified += asset.NotModified
+}
+
+func sortBandwidthGroups(groups map[string]*BandwidthGroupItem, total int64) []BandwidthGroupItem {
+	items := make([]BandwidthGroupItem, 0, len(groups))
+	for _, group := range groups {
+		item := *group
+		if total > 0 {
+			item.Share = math.Round(float64(item.Traffic)/float64(total)*1000) / 10
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Traffic != items[j].Traffic {
+			return items[i].Traffic > items[j].Traffic
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
+
+// urlExtension 返回 URL 路径最后一段的小写扩展名，忽略查询串与片段；以 / 结尾的目录路径没有扩展名。
+func urlExtension(rawURL string) string {
+	if idx := strings.IndexAny(rawURL, "?#"); idx >= 0 {
+		rawURL = rawURL[:idx]
+	}
+	if strings.HasSuffix(rawURL, "/") {
+		return ""
+	}
+	ext := strings.TrimPrefix(path.Ext(path.Base(rawURL)), ".")
+	if ext == "" || len(ext) > 10 {
+		return ""
+	}
+	return strings.ToLower(ext)
+}
+
+// contentClass 按扩展名分类，/api/ 开头的路径一律视为 API，无扩展名的路径视为页面。
+func contentClass(rawURL, ext string) string {
+	if strings.HasPrefix(rawURL, "/api/") || rawURL == "/api" {
+		return contentClassAPI
+	}
+	if ext == "" {
+		return contentClassPage
+	}
+	if class, ok := extensionClasses[ext]; ok {
+		return class
+	}
+	return contentClassOther
+}
@@ -0,0 +1,82 @@
+package analytics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContentClass(t *testing.T) {
+	cases := []struct {
+		url   string
+		ext   string
+		class string
+	}{
+		{"/static/logo.PNG?v=3", "png", contentClassImage},
+		{"/app.min.js#x", "js", contentClassScript},
+		{"/fonts/a.woff2", "woff2", contentClassFont},
+		{"/video/seg-1.ts", "ts", contentClassVideo},
+		{"/dl/setup.tar.gz", "gz", contentClassDownload},
+		{"/api/users/1.json", "json", contentClassAPI},
+		{"/api", "", contentClassAPI},
+		{"/about", "", contentClassPage},
+		{"/index.php?id=1", "php", contentClassPage},
+		{"/v1.2/", "", contentClassPage},
+		{"/robots.txt", "txt", contentClassOther},
+		{"/file.verylongextension", "", contentClassPage},
+	}
+	for _, tc := range cases {
+		ext := urlExtension(tc.url)
+		if ext != tc.ext {
+			t.Errorf("urlExtension(%q) = %q, want %q", tc.url, ext, tc.ext)
+		}
+		if class := contentClass(tc.url, ext); class != tc.class {
+			t.Errorf("contentClass(%q) = %q, want %q", tc.url, class, tc.class)
+		}
+	}
+}
+
+func TestEstimateCacheWorthiness(t *testing.T) {
+	asset := BandwidthAssetItem{Class: contentClassImage, Requests: 100, Traffic: 100 * 2048, ActiveDays: 5}
+	estimateCacheWorthiness(&asset)
+	if asset.AvgBytes != 2048 || asset.RepeatHits != 95 || asset.CacheableTraffic != 95*2048 || asset.CacheScore != 95 || !asset.CacheWorthy {
+		t.Fatalf("unexpected estimate %+v", asset)
+	}
+
+	// 请求数低于门槛时只计算分数，不标记 cacheWorthy
+	few := BandwidthAssetItem{Class: contentClassScript, Requests: 10, Traffic: 1000, ActiveDays: 1}
+	estimateCacheWorthiness(&few)
+	if few.CacheScore != 90 || few.CacheWorthy {
+		t.Fatalf("unexpected estimate %+v", few)
+	}
+
+	// 页面与 API 不计入缓存收益
+	page := BandwidthAssetItem{Class: contentClassPage, Requests: 100, Traffic: 1000, ActiveDays: 1}
+	estimateCacheWorthiness(&page)
+	if page.AvgBytes != 10 || page.RepeatHits != 0 || page.CacheScore != 0 || page.CacheWorthy {
+		t.Fatalf("pages must not be cache candidates: %+v", page)
+	}
+
+	empty := BandwidthAssetItem{Class: contentClassImage}
+	estimateCacheWorthiness(&empty)
+	if empty != (BandwidthAssetItem{Class: contentClassImage}) {
+		t.Fatalf("empty asset changed: %+v", empty)
+	}
+}
+
+func TestSortBandwidthGroups(t *testing.T) {
+	groups := map[string]*BandwidthGroupItem{}
+	addBandwidthGroup(groups, "png", BandwidthAssetItem{Requests: 2, Traffic: 300, NotModified: 1})
+	addBandwidthGroup(groups, "png", BandwidthAssetItem{Requests: 1, Traffic: 300})
+	addBandwidthGroup(groups, "js", BandwidthAssetItem{Requests: 5, Traffic: 300})
+	addBandwidthGroup(groups, "css", BandwidthAssetItem{Requests: 1, Traffic: 300})
+
+	items := sortBandwidthGroups(groups, 1200)
+	want := []BandwidthGroupItem{
+		{Key: "png", Requests: 3, Traffic: 600, NotModified: 1, Share: 50},
+		{Key: "css", Requests: 1, Traffic: 300, Share: 25},
+		{Key: "js", Requests: 5, Traffic: 300, Share: 25},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("groups = %+v, want %+v", items, want)
+	}
+}
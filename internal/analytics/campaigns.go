@@ -0,0 +1,312 @@
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+)
+
+// campaignGroupByParamPrefix 按自定义参数分组时的前缀，例如 param:ref。
+const campaignGroupByParamPrefix = "param:"
+
+// campaignGroupBys 为 campaigns 统计支持的分组方式，campaign 按 source/medium/campaign 三元组分组。
+var campaignGroupBys = []string{"campaign", "source", "medium", "source_medium", "term", "content", "clickSource"}
+
+type CampaignItem struct {
+	Source   string `json:"source"`
+	Medium   string `json:"medium"`
+	Campaign string `json:"campaign"`
+	// Value 为按 term / content / clickSource / param:<name> 分组时的分组值
+	Value              string  `json:"value,omitempty"`
+	Visits             int     `json:"visits"` // 携带投放参数的页面浏览次数
+	Sessions           int     `json:"sessions"`
+	Visitors           int     `json:"visitors"`
+	Pageviews          int     `json:"pageviews"` // 归因会话内的全部页面浏览
+	PagesPerSession    float64 `json:"pagesPerSession"`
+	BounceRate         float64 `json:"bounceRate"`
+	AvgDurationSeconds int64   `json:"avgDurationSeconds"`
+}
+
+type CampaignStats struct {
+	GroupBy string         `json:"groupBy"`
+	Items   []CampaignItem `json:"items"`
+	Summary CampaignItem   `json:"summary"`
+	QueryMeta
+}
+
+func (s CampaignStats) GetType() string {
+	return "campaigns"
+}
+
+type CampaignStatsManager struct {
+	repo *store.Repository
+}
+
+func NewCampaignStatsManager(userRepoPtr *store.Repository) *CampaignStatsManager {
+	return &CampaignStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// campaignAccumulator 累加同一分组下的会话指标，访客按 ip_id 去重。
+type campaignAccumulator struct {
+	item          CampaignItem
+	visitors      map[int64]struct{}
+	bounces       int
+	totalDuration int64
+}
+
+func newCampaignAccumulator() *campaignAccumulator {
+	return &campaignAccumulator{visitors: make(map[int64]struct{})}
+}
+
+func (a *campaignAccumulator) merge(other *campaignAccumulator) {
+	a.item.Visits += other.item.Visits
+	a.item.Sessions += other.item.Sessions
+	a.item.Pageviews += other.item.Pageviews
+	a.bounces += other.bounces
+	a.totalDuration += other.totalDuration
+	for ipID := range other.visitors {
+		a.visitors[ipID] = struct{}{}
+	}
+}
+
+func (a *campaignAccumulator) finalize() CampaignItem {
+	item := a.item
+	item.Visitors = len(a.visitors)
+	if item.Sessions > 0 {
+		item.PagesPerSession = float64(item.Pageviews) / float64(item.Sessions)
+		item.BounceRate = float64(a.bounces) / float64(item.Sessions)
+		item.AvgDurationSeconds = a.totalDuration / int64(item.Sessions)
+	}
+	return item
+}
+
+// Query 按会话归因投放：会话归属于会话内第一个携带投放参数的页面浏览，会话划分规则与 session_summary 一致。
+func (m *CampaignStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	groupBy, _ := query.ExtraParam["groupBy"].(string)
+	if groupBy == "" {
+		groupBy = "campaign"
+	}
+	limit, _ := query.ExtraParam["limit"].(int)
+	result := CampaignStats{
+		GroupBy:   groupBy,
+		Items:     make([]CampaignItem, 0),
+		QueryMeta: newQueryMeta(query.Segment, QueryPathRawLogs),
+	}
+
+	timeRange, ok := query.ExtraParam["timeRange"].(string)
+	if !ok || timeRange == "" {
+		return result, fmt.Errorf("timeRange 参数缺失")
+	}
+	startTime, endTime, err := timeutil.TimePeriod(timeRange)
+	if err != nil {
+		return result, fmt.Errorf("解析时间范围失败: %v", err)
+	}
+
+	byCampaign, err := m.scanCampaignSessions(query, startTime.Unix(), endTime.Unix())
+	if err != nil {
+		return result, err
+	}
+	if len(byCampaign) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int64, 0, len(byCampaign))
+	for id := range byCampaign {
+		ids = append(ids, id)
+	}
+	campaigns, err := m.repo.LoadCampaigns(query.WebsiteID, ids)
+	if err != nil {
+		return result, fmt.Errorf("读取投放维度失败: %v", err)
+	}
+
+	groups := make(map[string]*campaignAccumulator)
+	summary := newCampaignAccumulator()
+	for id, acc := range byCampaign {
+		item := campaignGroupItem(groupBy, campaigns[id])
+		key := strings.Join([]string{item.Source, item.Medium, item.Campaign, item.Value}, "\x1f")
+		group := groups[key]
+		if group == nil {
+			group = newCampaignAccumulator()
+			group.item = item
+			groups[key] = group
+		}
+		group.merge(acc)
+		summary.merge(acc)
+	}
+
+	for _, group := range groups {
+		result.Items = append(result.Items, group.finalize())
+	}
+	sort.Slice(result.Items, func(i, j int) bool {
+		a, b := result.Items[i], result.Items[j]
+		if a.Sessions != b.Sessions {
+			return a.Sessions > b.Sessions
+		}
+		if a.Visits != b.Visits {
+			return a.Visits > b.Visits
+		}
+		return strings.Join([]string{a.Source, a.Medium, a.Campaign, a.Value}, "/") <
+			strings.Join([]string{b.Source, b.Medium, b.Campaign, b.Value}, "/")
+	})
+	if limit > 0 && len(result.Items) > limit {
+		result.Items = result.Items[:limit]
+	}
+	result.Summary = summary.finalize()
+	return result, nil
+}
+
+// scanCampaignSessions 只扫描在时间范围内出现过投放参数的 (ip, ua) 组合，按 campaign_id 汇总会话。
+func (m *CampaignStatsManager) scanCampaignSessions(query StatsQuery, startTs, endTs int64) (map[int64]*campaignAccumulator, error) {
+	tableName := fmt.Sprintf("%s_nginx_logs", query.WebsiteID)
+	segmentJoin, segmentCondition, segmentArgs := query.Segment.filter(query.WebsiteID, "l")
+	args := []interface{}{startTs, endTs}
+	args = append(args, segmentArgs...)
+	args = append(args, startTs, endTs)
+	rows, err := m.repo.GetDB().Query(
+		sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT l.timestamp, l.ip_id, l.ua_id, l.campaign_id
+        FROM "%[1]s" l%[2]s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%[3]s
+          AND (l.ip_id, l.ua_id) IN (
+              SELECT DISTINCT c.ip_id, c.ua_id FROM "%[1]s" c
+              WHERE c.pageview_flag = 1 AND c.campaign_id <> 0 AND c.timestamp >= ? AND c.timestamp < ?
+          )
+        ORDER BY l.ip_id, l.ua_id, l.timestamp`,
+			tableName, segmentJoin, segmentCondition)),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("查询投放会话失败: %v", err)
+	}
+	defer rows.Close()
+
+	byCampaign := make(map[int64]*campaignAccumulator)
+	var (
+		initialized   bool
+		currentIP     int64
+		currentUA     int64
+		lastTimestamp int64
+		session       campaignSession
+	)
+	for rows.Next() {
+		var timestamp, ipID, uaID, campaignID int64
+		if err := rows.Scan(&timestamp, &ipID, &uaID, &campaignID); err != nil {
+			return nil, fmt.Errorf("解析投放会话失败: %v", err)
+		}
+		if !initialized || ipID != currentIP || uaID != currentUA || timestamp-lastTimestamp > sessionGapSeconds {
+			if initialized {
+				session.finalize(byCampaign)
+			}
+			currentIP, currentUA = ipID, uaID
+			session = campaignSession{ipID: ipID, start: timestamp}
+			initialized = true
+		}
+		session.add(timestamp, campaignID)
+		lastTimestamp = timestamp
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("遍历投放会话失败: %v", err)
+	}
+	if initialized {
+		session.finalize(byCampaign)
+	}
+	return byCampaign, nil
+}
+
+type campaignSession struct {
+	ipID       int64
+	start      int64
+	end        int64
+	pageCount  int
+	campaignID int64
+	// visits 为会话内各 campaign_id 携带投放参数的页面浏览次数
+	visits map[int64]int
+}
+
+func (s *campaignSession) add(timestamp, campaignID int64) {
+	s.end = timestamp
+	s.pageCount++
+	if campaignID == 0 {
+		return
+	}
+	if s.campaignID == 0 {
+		s.campaignID = campaignID
+	}
+	if s.visits == nil {
+		s.visits = make(map[int64]int)
+	}
+	s.visits[campaignID]++
+}
+
+func (s *campaignSession) finalize(byCampaign map[int64]*campaignAccumulator) {
+	for campaignID, visits := range s.visits {
+		acc := byCampaign[campaignID]
+		if acc == nil {
+			acc = newCampaignAccumulator()
+			byCampaign[campaignID] = acc
+		}
+		acc.item.Visits += visits
+	}
+	if s.campaignID == 0 {
+		return
+	}
+	acc := byCampaign[s.campaignID]
+	acc.item.Sessions++
+	acc.item.Pageviews += s.pageCount
+	acc.visitors[s.ipID] = struct{}{}
+	if s.pageCount <= 1 {
+		acc.bounces++
+	}
+	if s.end > s.start {
+		acc.totalDuration += s.end - s.start
+	}
+}
+
+// campaignGroupItem 根据分组方式提取分组字段，未使用的字段留空。
+func campaignGroupItem(groupBy string, campaign store.Campaign) CampaignItem {
+	switch groupBy {
+	case "source":
+		return CampaignItem{Source: campaign.Source}
+	case "medium":
+		return CampaignItem{Medium: campaign.Medium}
+	case "source_medium":
+		return CampaignItem{Source: campaign.Source, Medium: campaign.Medium}
+	case "term":
+		return CampaignItem{Value: campaign.Term}
+	case "content":
+		return CampaignItem{Value: campaign.Content}
+	case "clickSource":
+		return CampaignItem{Value: campaign.ClickSource}
+	}
+	if name, ok := strings.CutPrefix(groupBy, campaignGroupByParamPrefix); ok {
+		return CampaignItem{Value: campaign.Custom[name]}
+	}
+	return CampaignItem{Source: campaign.Source, Medium: campaign.Medium, Campaign: campaign.Name}
+}
+
+// parseCampaignGroupBy 校验分组方式，param:<name> 需为 system.campaigns.customParams 中配置的参数。
+func parseCampaignGroupBy(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	for _, item := range campaignGroupBys {
+		if value == item {
+			return value, nil
+		}
+	}
+	if name, ok := strings.CutPrefix(value, campaignGroupByParamPrefix); ok {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for _, param := range config.GetCampaignConfig().CustomParams {
+			if param == name {
+				return campaignGroupByParamPrefix + name, nil
+			}
+		}
+		return "", fmt.Errorf("groupBy 参数无效，%s 未在 system.campaigns.customParams 中配置", name)
+	}
+	return "", fmt.Errorf("groupBy 参数无效，必须为以下值之一: %v 或 param:<name>", campaignGroupBys)
+}
@@ -18,6 +18,9 @@ type ClientStats struct {
 	UV        []int    `json:"uv"`         // 独立访客数
 	PVPercent []int    `json:"pv_percent"` // PV 百分比
 	UVPercent []int    `json:"uv_percent"` // UV 百分比
+	// Comparison 为对比期逐行数据，仅在传入对比参数时返回
+	Comparison *ClientComparison `json:"comparison,omitempty"`
+	QueryMeta
 }
 
 func (s ClientStats) GetType() string {
@@ -86,8 +89,118 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		UV:        make([]int, 0),
 		PVPercent: make([]int, 0),
 		UVPercent: make([]int, 0),
+		QueryMeta: newQueryMeta(query.Segment, QueryPathRawLogs),
 	}
 
+	limit, _ := query.ExtraParam["limit"].(int)
+	timeRange := query.ExtraParam["timeRange"].(string)
+	startTime, endTime, err := timeutil.TimePeriod(timeRange)
+	if err != nil {
+		return result, err
+	}
+
+	// 构建、执行查询
+	var (
+		dbQueryStr string
+		args       []interface{}
+	)
+	sites := query.Sites()
+	if len(sites) == 1 {
+		selectExpr, groupExpr, joinClause, extraCondition := s.buildQueryParts(sites[0], query)
+		segmentJoin, segmentCondition, segmentArgs := query.Segment.filter(sites[0], "l")
+		joinClause += segmentJoin
+		extraCondition += segmentCondition
+		dbQueryStr = sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT 
+            %[1]s AS url, 
+            SUM(l.sample_weight) AS pv,
+            COUNT(DISTINCT l.ip_id) AS uv
+        FROM "%[2]s_nginx_logs" l
+        %[4]s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%[5]s
+        GROUP BY %[3]s
+        ORDER BY uv DESC
+        LIMIT ?`,
+			selectExpr, sites[0], groupExpr, joinClause, extraCondition))
+		args = append([]interface{}{startTime.Unix(), endTime.Unix()}, segmentArgs...)
+		args = append(args, limit)
+	} else {
+		// 跨站点：逐站点取出 (统计项, 访客 IP) 后合并，UV 按 IP 去重
+		union, unionArgs := unionAllSiteQueries(sites, func(websiteID string) (string, []interface{}) {
+			selectExpr, _, joinClause, extraCondition := s.buildQueryParts(websiteID, query)
+			branchArgs := make([]interface{}, 0)
+			if s.statsType == "url" {
+				selectExpr = "?::text || " + selectExpr
+				branchArgs = append(branchArgs, siteLabel(websiteID))
+			}
+			visitor, visitorJoin := visitorColumn(sites, websiteID, "l")
+			segmentJoin, segmentCondition, segmentArgs := query.Segment.filter(websiteID, "l")
+			branchArgs = append(branchArgs, startTime.Unix(), endTime.Unix())
+			return fmt.Sprintf(
+				`SELECT %s AS item, %s AS visitor, l.sample_weight AS weight FROM "%s_nginx_logs" l %s %s%s WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s%s`,
+				selectExpr, visitor, websiteID, joinClause, visitorJoin, segmentJoin, extraCondition, segmentCondition,
+			), append(branchArgs, segmentArgs...)
+		})
+		dbQueryStr = sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT 
+            item AS url, 
+            SUM(weight) AS pv,
+            COUNT(DISTINCT visitor) AS uv
+        FROM (
+        %s
+        ) t
+        GROUP BY item
+        ORDER BY uv DESC
+        LIMIT ?`,
+			union))
+		args = append(unionArgs, limit)
+	}
+
+	rows, err := s.repo.GetDB().Query(dbQueryStr, args...)
+	if err != nil {
+		return result, fmt.Errorf("查询客户端统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	totalPV := 0
+	totalUV := 0
+
+	for rows.Next() {
+		var url string
+		var pv, uv int
+		if err := rows.Scan(&url, &pv, &uv); err != nil {
+			return result, fmt.Errorf("解析客户端统计结果失败: %v", err)
+		}
+		result.Key = append(result.Key, url)
+		result.PV = append(result.PV, pv)
+		result.UV = append(result.UV, uv)
+		totalPV += pv
+		totalUV += uv
+	}
+
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("遍历客户端统计结果失败: %v", err)
+	}
+
+	if totalPV > 0 && totalUV > 0 {
+		for i := range result.PV {
+			result.PVPercent = append(
+				result.PVPercent, int(
+					math.Round(float64(result.PV[i])/float64(totalPV)*100)))
+			result.UVPercent = append(
+				result.UVPercent, int(
+					math.Round(float64(result.UV[i])/float64(totalUV)*100)))
+		}
+	}
+
+	return result, nil
+
+}
+
+// buildQueryParts 返回单个站点的统计项表达式、分组表达式、关联子句与附加条件
+func (s *ClientStatsManager) buildQueryParts(
+	websiteID string, query StatsQuery) (string, string, string, string) {
+
 	statsType := s.statsType
 	locationType := ""
 	joinClause := ""
@@ -120,7 +233,7 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	}
 	if s.statsType == "referer" {
 		internalCond := ""
-		if website, ok := config.GetWebsiteByID(query.WebsiteID); ok {
+		if website, ok := config.GetWebsiteByID(websiteID); ok {
 			internalCond = buildInternalRefererCondition(website.Domains, "r.referer")
 		}
 		if internalCond != "" {
@@ -133,26 +246,20 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 		groupExpr = selectExpr
 	}
-	limit, _ := query.ExtraParam["limit"].(int)
-	timeRange := query.ExtraParam["timeRange"].(string)
-	startTime, endTime, err := timeutil.TimePeriod(timeRange)
-	if err != nil {
-		return result, err
-	}
 
 	extraCondition := ""
 	switch s.statsType {
 	case "url":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_url" u ON u.id = l.url_id`, query.WebsiteID)
+		joinClause = fmt.Sprintf(`JOIN "%s_dim_url" u ON u.id = l.url_id`, websiteID)
 		selectExpr = "u.url"
 		groupExpr = "u.url"
 	case "referer":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_referer" r ON r.id = l.referer_id`, query.WebsiteID)
+		joinClause = fmt.Sprintf(`JOIN "%s_dim_referer" r ON r.id = l.referer_id`, websiteID)
 	case "referer_ip":
 		joinClause = fmt.Sprintf(
 			`JOIN "%s_dim_referer" r ON r.id = l.referer_id JOIN "%s_dim_ip" ip ON ip.id = l.ip_id`,
-			query.WebsiteID,
-			query.WebsiteID,
+			websiteID,
+			websiteID,
 		)
 		selectExpr = "ip.ip"
 		groupExpr = "ip.ip"
@@ -161,19 +268,19 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 			extraCondition += " AND " + sourceCondition
 		}
 	case "user_browser":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, query.WebsiteID)
+		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, websiteID)
 		selectExpr = "ua.browser"
 		groupExpr = "ua.browser"
 	case "user_os":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, query.WebsiteID)
+		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, websiteID)
 		selectExpr = "ua.os"
 		groupExpr = "ua.os"
 	case "user_device":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, query.WebsiteID)
+		joinClause = fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, websiteID)
 		selectExpr = "ua.device"
 		groupExpr = "ua.device"
 	case "location":
-		joinClause = fmt.Sprintf(`JOIN "%s_dim_location" loc ON loc.id = l.location_id`, query.WebsiteID)
+		joinClause = fmt.Sprintf(`JOIN "%s_dim_location" loc ON loc.id = l.location_id`, websiteID)
 		if locationType == "global" {
 			selectExpr = "loc.global"
 			groupExpr = "loc.global"
@@ -186,59 +293,7 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		extraCondition = " AND loc.global = '中国'"
 	}
 
-	// 构建、执行查询
-	dbQueryStr := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
-        SELECT 
-            %[1]s AS url, 
-            COUNT(*) AS pv,
-            COUNT(DISTINCT l.ip_id) AS uv
-        FROM "%[2]s_nginx_logs" l
-        %[4]s
-        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%[5]s
-        GROUP BY %[3]s
-        ORDER BY uv DESC
-        LIMIT ?`,
-		selectExpr, query.WebsiteID, groupExpr, joinClause, extraCondition))
-
-	rows, err := s.repo.GetDB().Query(dbQueryStr, startTime.Unix(), endTime.Unix(), limit)
-	if err != nil {
-		return result, fmt.Errorf("查询客户端统计失败: %v", err)
-	}
-	defer rows.Close()
-
-	totalPV := 0
-	totalUV := 0
-
-	for rows.Next() {
-		var url string
-		var pv, uv int
-		if err := rows.Scan(&url, &pv, &uv); err != nil {
-			return result, fmt.Errorf("解析客户端统计结果失败: %v", err)
-		}
-		result.Key = append(result.Key, url)
-		result.PV = append(result.PV, pv)
-		result.UV = append(result.UV, uv)
-		totalPV += pv
-		totalUV += uv
-	}
-
-	if err := rows.Err(); err != nil {
-		return result, fmt.Errorf("遍历客户端统计结果失败: %v", err)
-	}
-
-	if totalPV > 0 && totalUV > 0 {
-		for i := range result.PV {
-			result.PVPercent = append(
-				result.PVPercent, int(
-					math.Round(float64(result.PV[i])/float64(totalPV)*100)))
-			result.UVPercent = append(
-				result.UVPercent, int(
-					math.Round(float64(result.UV[i])/float64(totalUV)*100)))
-		}
-	}
-
-	return result, nil
-
+	return selectExpr, groupExpr, joinClause, extraCondition
 }
 
 func buildInternalRefererCondition(domains []string, refererColumn string) string {
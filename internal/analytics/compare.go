@@ -0,0 +1,328 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/timeutil"
+)
+
+// 对比预设：previous 为紧邻的上一期，其余为整体平移一周 / 一个月 / 一年。
+const (
+	ComparePresetPrevious  = "previous"
+	ComparePresetLastWeek  = "lastWeek"
+	ComparePresetLastMonth = "lastMonth"
+	ComparePresetLastYear  = "lastYear"
+)
+
+var comparePresets = []string{
+	ComparePresetPrevious,
+	ComparePresetLastWeek,
+	ComparePresetLastMonth,
+	ComparePresetLastYear,
+}
+
+// compareRowLimit 为表格类对比期查询的行数上限，主期的行按 key 在其中匹配，未命中记为 0。
+const compareRowLimit = 5000
+
+// 支持对比参数（compare / compareStart / compareEnd）的统计类型。
+var compareStatsTypes = map[string]bool{
+	"overall":       true,
+	"timeseries":    true,
+	"url":           true,
+	"referer":       true,
+	"referer_ip":    true,
+	"browser":       true,
+	"os":            true,
+	"device":        true,
+	"location":      true,
+	"sites_ranking": true,
+}
+
+// CompareRange 是对比期的日期区间（含首尾两天）。
+type CompareRange struct {
+	Preset string `json:"preset,omitempty"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+}
+
+// timeRange 返回对比期查询使用的 timeRange 取值。
+func (r CompareRange) timeRange() string {
+	if r.Start == r.End {
+		return r.Start
+	}
+	return r.Start + timeutil.DateRangeSeparator + r.End
+}
+
+// MetricComparison 是单个指标在本期与对比期的差异，Change 为百分比，对比期为 0 时为 null。
+type MetricComparison struct {
+	Current int64    `json:"current"`
+	Compare int64    `json:"compare"`
+	Delta   int64    `json:"delta"`
+	Change  *float64 `json:"change"`
+}
+
+func newMetricComparison(current, compare int64) MetricComparison {
+	return MetricComparison{
+		Current: current,
+		Compare: compare,
+		Delta:   current - compare,
+		Change:  changePercent(current, compare),
+	}
+}
+
+// ClientComparison 为表格类结果的逐行对比，数组与 Key 一一对应。
+type ClientComparison struct {
+	Range    CompareRange `json:"range"`
+	PV       []int        `json:"pv"`
+	UV       []int        `json:"uv"`
+	PVDelta  []int        `json:"pvDelta"`
+	UVDelta  []int        `json:"uvDelta"`
+	PVChange []*float64   `json:"pvChange"`
+	UVChange []*float64   `json:"uvChange"`
+}
+
+// TimeSeriesComparison 为趋势图的叠加序列，按下标与本期对齐，对比期较短时补 0。
+type TimeSeriesComparison struct {
+	Range     CompareRange     `json:"range"`
+	Labels    []string         `json:"labels"`
+	Visitors  []int            `json:"visitors"`
+	Pageviews []int            `json:"pageviews"`
+	PV        MetricComparison `json:"pv"` // 整段 PV 合计对比
+}
+
+type OverallComparison struct {
+	Range        CompareRange     `json:"range"`
+	PV           MetricComparison `json:"pv"`
+	UV           MetricComparison `json:"uv"`
+	Traffic      MetricComparison `json:"traffic"`
+	SessionCount MetricComparison `json:"sessionCount"`
+	NewVisitors  MetricComparison `json:"newVisitors"`
+	S4xx         MetricComparison `json:"s4xx"`
+	S5xx         MetricComparison `json:"s5xx"`
+}
+
+type SiteComparisonItem struct {
+	WebsiteID string           `json:"websiteId"`
+	PV        MetricComparison `json:"pv"`
+	UV        MetricComparison `json:"uv"`
+	Traffic   MetricComparison `json:"traffic"`
+}
+
+type SitesRankingComparison struct {
+	Range CompareRange         `json:"range"`
+	Total OverallComparison    `json:"total"`
+	Sites []SiteComparisonItem `json:"sites"`
+}
+
+// parseCompareRange 解析对比参数，未传入时返回 nil。
+func parseCompareRange(statsType string, params map[string]string, timeRange string) (*CompareRange, error) {
+	preset := strings.TrimSpace(params["compare"])
+	startRaw := strings.TrimSpace(params["compareStart"])
+	endRaw := strings.TrimSpace(params["compareEnd"])
+	if preset == "" && startRaw == "" && endRaw == "" {
+		return nil, nil
+	}
+	if !compareStatsTypes[statsType] {
+		return nil, fmt.Errorf("统计类型 %s 不支持对比参数", statsType)
+	}
+	if timeRange == "" {
+		return nil, fmt.Errorf("对比需要 timeRange 参数")
+	}
+
+	if startRaw != "" || endRaw != "" {
+		if startRaw == "" || endRaw == "" {
+			return nil, fmt.Errorf("compareStart 与 compareEnd 需同时提供")
+		}
+		start, end, ok := timeutil.ParseDateRange(startRaw + timeutil.DateRangeSeparator + endRaw)
+		if !ok {
+			return nil, fmt.Errorf("compareStart/compareEnd 无效，格式为 YYYY-MM-DD，且跨度不超过一年")
+		}
+		return &CompareRange{Start: timeutil.FormatDate(start), End: timeutil.FormatDate(end)}, nil
+	}
+
+	var start, end time.Time
+	switch preset {
+	case ComparePresetPrevious:
+		start, end = previousTimeRange(timeRange)
+	case ComparePresetLastWeek, ComparePresetLastMonth, ComparePresetLastYear:
+		mainStart, mainEnd, err := timeutil.TimePeriod(timeRange)
+		if err != nil {
+			return nil, err
+		}
+		shift := map[string][3]int{
+			ComparePresetLastWeek:  {0, 0, -7},
+			ComparePresetLastMonth: {0, -1, 0},
+			ComparePresetLastYear:  {-1, 0, 0},
+		}[preset]
+		start = mainStart.AddDate(shift[0], shift[1], shift[2])
+		end = mainEnd.AddDate(shift[0], shift[1], shift[2])
+	default:
+		return nil, fmt.Errorf("compare 参数无效，必须为以下值之一: %v", comparePresets)
+	}
+	if start.IsZero() || end.IsZero() {
+		return nil, fmt.Errorf("无法计算对比区间")
+	}
+	return &CompareRange{Preset: preset, Start: timeutil.FormatDate(start), End: timeutil.FormatDate(end)}, nil
+}
+
+// compareQuery 复制查询并替换为对比期的时间范围。
+func compareQuery(statsType string, query StatsQuery) StatsQuery {
+	extra := make(map[string]interface{}, len(query.ExtraParam))
+	for key, value := range query.ExtraParam {
+		extra[key] = value
+	}
+	extra["timeRange"] = query.Compare.timeRange()
+	if _, ok := extra["limit"]; ok && statsType != "timeseries" {
+		extra["limit"] = compareRowLimit
+	}
+	compare := query
+	compare.ExtraParam = extra
+	compare.Compare = nil
+	return compare
+}
+
+// attachComparison 将对比期结果合并进本期结果。
+func attachComparison(current, compare StatsResult, compareRange CompareRange) (StatsResult, error) {
+	switch cur := current.(type) {
+	case ClientStats:
+		prev, ok := compare.(ClientStats)
+		if !ok {
+			break
+		}
+		cur.Comparison = compareClientStats(cur, prev, compareRange)
+		return cur, nil
+	case TimeSeriesStats:
+		prev, ok := compare.(TimeSeriesStats)
+		if !ok {
+			break
+		}
+		cur.Comparison = compareTimeSeries(cur, prev, compareRange)
+		return cur, nil
+	case OverallStats:
+		prev, ok := compare.(OverallStats)
+		if !ok {
+			break
+		}
+		comparison := compareOverall(cur, prev, compareRange)
+		cur.Comparison = &comparison
+		return cur, nil
+	case SitesRankingStats:
+		prev, ok := compare.(SitesRankingStats)
+		if !ok {
+			break
+		}
+		cur.Comparison = compareSitesRanking(cur, prev, compareRange)
+		return cur, nil
+	}
+	return nil, fmt.Errorf("统计类型 %s 不支持对比", current.GetType())
+}
+
+func compareClientStats(cur, prev ClientStats, compareRange CompareRange) *ClientComparison {
+	index := make(map[string]int, len(prev.Key))
+	for i, key := range prev.Key {
+		index[key] = i
+	}
+	size := len(cur.Key)
+	comparison := &ClientComparison{
+		Range:    compareRange,
+		PV:       make([]int, size),
+		UV:       make([]int, size),
+		PVDelta:  make([]int, size),
+		UVDelta:  make([]int, size),
+		PVChange: make([]*float64, size),
+		UVChange: make([]*float64, size),
+	}
+	for i, key := range cur.Key {
+		if j, ok := index[key]; ok {
+			comparison.PV[i] = valueAt(prev.PV, j)
+			comparison.UV[i] = valueAt(prev.UV, j)
+		}
+		pv, uv := valueAt(cur.PV, i), valueAt(cur.UV, i)
+		comparison.PVDelta[i] = pv - comparison.PV[i]
+		comparison.UVDelta[i] = uv - comparison.UV[i]
+		comparison.PVChange[i] = changePercent(int64(pv), int64(comparison.PV[i]))
+		comparison.UVChange[i] = changePercent(int64(uv), int64(comparison.UV[i]))
+	}
+	return comparison
+}
+
+func compareTimeSeries(cur, prev TimeSeriesStats, compareRange CompareRange) *TimeSeriesComparison {
+	size := len(cur.Labels)
+	comparison := &TimeSeriesComparison{
+		Range:     compareRange,
+		Labels:    make([]string, size),
+		Visitors:  make([]int, size),
+		Pageviews: make([]int, size),
+	}
+	var curTotal, prevTotal int64
+	for i := 0; i < size; i++ {
+		if i < len(prev.Labels) {
+			comparison.Labels[i] = prev.Labels[i]
+		}
+		comparison.Visitors[i] = valueAt(prev.Visitors, i)
+		comparison.Pageviews[i] = valueAt(prev.Pageviews, i)
+		curTotal += int64(valueAt(cur.Pageviews, i))
+		prevTotal += int64(comparison.Pageviews[i])
+	}
+	comparison.PV = newMetricComparison(curTotal, prevTotal)
+	return comparison
+}
+
+func compareOverall(cur, prev OverallStats, compareRange CompareRange) OverallComparison {
+	return OverallComparison{
+		Range:        compareRange,
+		PV:           newMetricComparison(int64(cur.PV), int64(prev.PV)),
+		UV:           newMetricComparison(int64(cur.UV), int64(prev.UV)),
+		Traffic:      newMetricComparison(cur.Traffic, prev.Traffic),
+		SessionCount: newMetricComparison(int64(cur.SessionCount), int64(prev.SessionCount)),
+		NewVisitors:  newMetricComparison(int64(cur.NewVisitorCount), int64(prev.NewVisitorCount)),
+		S4xx:         newMetricComparison(int64(cur.StatusCodeHits.S4xx), int64(prev.StatusCodeHits.S4xx)),
+		S5xx:         newMetricComparison(int64(cur.StatusCodeHits.S5xx), int64(prev.StatusCodeHits.S5xx)),
+	}
+}
+
+func compareSitesRanking(cur, prev SitesRankingStats, compareRange CompareRange) *SitesRankingComparison {
+	prevSites := make(map[string]SiteRankingItem, len(prev.Sites))
+	for _, item := range prev.Sites {
+		prevSites[item.WebsiteID] = item
+	}
+	comparison := &SitesRankingComparison{
+		Range: compareRange,
+		Total: OverallComparison{
+			Range:   compareRange,
+			PV:      newMetricComparison(int64(cur.Total.PV), int64(prev.Total.PV)),
+			UV:      newMetricComparison(int64(cur.Total.UV), int64(prev.Total.UV)),
+			Traffic: newMetricComparison(cur.Total.Traffic, prev.Total.Traffic),
+		},
+		Sites: make([]SiteComparisonItem, 0, len(cur.Sites)),
+	}
+	for _, item := range cur.Sites {
+		before := prevSites[item.WebsiteID]
+		comparison.Sites = append(comparison.Sites, SiteComparisonItem{
+			WebsiteID: item.WebsiteID,
+			PV:        newMetricComparison(int64(item.PV), int64(before.PV)),
+			UV:        newMetricComparison(int64(item.UV), int64(before.UV)),
+			Traffic:   newMetricComparison(item.Traffic, before.Traffic),
+		})
+	}
+	return comparison
+}
+
+func valueAt(values []int, idx int) int {
+	if idx < 0 || idx >= len(values) {
+		return 0
+	}
+	return values[idx]
+}
+
+// changePercent 返回变化百分比（保留一位小数），对比值为 0 时返回 nil。
+func changePercent(current, compare int64) *float64 {
+	if compare == 0 {
+		return nil
+	}
+	value := math.Round(float64(current-compare)/float64(compare)*1000) / 10
+	return &value
+}
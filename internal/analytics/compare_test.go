@@ -0,0 +1,133 @@
+package analytics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func floatValues(values []*float64) []interface{} {
+	out := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		if value == nil {
+			out = append(out, nil)
+			continue
+		}
+		out = append(out, *value)
+	}
+	return out
+}
+
+func TestChangePercent(t *testing.T) {
+	if changePercent(10, 0) != nil {
+		t.Fatal("change against zero must be nil")
+	}
+	cases := []struct {
+		current, compare int64
+		want             float64
+	}{
+		{150, 100, 50},
+		{50, 100, -50},
+		{100, 100, 0},
+		{1, 3, -66.7},
+		{0, 7, -100},
+	}
+	for _, tc := range cases {
+		if got := changePercent(tc.current, tc.compare); got == nil || *got != tc.want {
+			t.Errorf("changePercent(%d, %d) = %v, want %v", tc.current, tc.compare, got, tc.want)
+		}
+	}
+}
+
+func TestCompareClientStatsMatchesRowsByKey(t *testing.T) {
+	cur := ClientStats{Key: []string{"/a", "/b", "/new"}, PV: []int{30, 10, 5}, UV: []int{3, 2, 1}}
+	prev := ClientStats{Key: []string{"/b", "/a", "/gone"}, PV: []int{20, 15, 9}, UV: []int{2, 4, 1}}
+	comparison := compareClientStats(cur, prev, CompareRange{Start: "2024-01-01", End: "2024-01-07"})
+
+	if !reflect.DeepEqual(comparison.PV, []int{15, 20, 0}) || !reflect.DeepEqual(comparison.UV, []int{4, 2, 0}) {
+		t.Fatalf("compare values pv=%v uv=%v", comparison.PV, comparison.UV)
+	}
+	if !reflect.DeepEqual(comparison.PVDelta, []int{15, -10, 5}) || !reflect.DeepEqual(comparison.UVDelta, []int{-1, 0, 1}) {
+		t.Fatalf("deltas pv=%v uv=%v", comparison.PVDelta, comparison.UVDelta)
+	}
+	if got := floatValues(comparison.PVChange); !reflect.DeepEqual(got, []interface{}{100.0, -50.0, nil}) {
+		t.Fatalf("pv change = %v", got)
+	}
+	if got := floatValues(comparison.UVChange); !reflect.DeepEqual(got, []interface{}{-25.0, 0.0, nil}) {
+		t.Fatalf("uv change = %v", got)
+	}
+}
+
+func TestCompareTimeSeriesPadsShorterPeriod(t *testing.T) {
+	cur := TimeSeriesStats{Labels: []string{"d1", "d2", "d3"}, Pageviews: []int{10, 20, 30}, Visitors: []int{1, 2, 3}}
+	prev := TimeSeriesStats{Labels: []string{"p1", "p2"}, Pageviews: []int{5, 15}, Visitors: []int{1, 1}}
+	comparison := compareTimeSeries(cur, prev, CompareRange{})
+	if !reflect.DeepEqual(comparison.Labels, []string{"p1", "p2", ""}) || !reflect.DeepEqual(comparison.Pageviews, []int{5, 15, 0}) {
+		t.Fatalf("overlay labels=%v pageviews=%v", comparison.Labels, comparison.Pageviews)
+	}
+	if comparison.PV.Current != 60 || comparison.PV.Compare != 20 || comparison.PV.Delta != 40 || *comparison.PV.Change != 200 {
+		t.Fatalf("total comparison %+v", comparison.PV)
+	}
+}
+
+func TestParseCompareRange(t *testing.T) {
+	cases := []struct {
+		name      string
+		params    map[string]string
+		timeRange string
+		want      *CompareRange
+	}{
+		{"none", map[string]string{}, "today", nil},
+		{"previous day", map[string]string{"compare": "previous"}, "2024-03-01", &CompareRange{Preset: "previous", Start: "2024-02-29", End: "2024-02-29"}},
+		{"previous range", map[string]string{"compare": "previous"}, "2024-03-08~2024-03-14", &CompareRange{Preset: "previous", Start: "2024-03-01", End: "2024-03-07"}},
+		{"last week", map[string]string{"compare": "lastWeek"}, "2024-03-08~2024-03-14", &CompareRange{Preset: "lastWeek", Start: "2024-03-01", End: "2024-03-07"}},
+		{"last year", map[string]string{"compare": "lastYear"}, "2024-03-01", &CompareRange{Preset: "lastYear", Start: "2023-03-01", End: "2023-03-01"}},
+		{"custom", map[string]string{"compareStart": "2024-01-01", "compareEnd": "2024-01-31"}, "today", &CompareRange{Start: "2024-01-01", End: "2024-01-31"}},
+	}
+	for _, tc := range cases {
+		got, err := parseCompareRange("overall", tc.params, tc.timeRange)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	errorCases := []struct {
+		statsType string
+		params    map[string]string
+		timeRange string
+	}{
+		{"overall", map[string]string{"compare": "lastDecade"}, "today"},
+		{"overall", map[string]string{"compareStart": "2024-01-01"}, "today"},
+		{"overall", map[string]string{"compareStart": "2024-02-01", "compareEnd": "2024-01-01"}, "today"},
+		{"overall", map[string]string{"compare": "previous"}, ""},
+		{"anomalies", map[string]string{"compare": "previous"}, "today"},
+	}
+	for _, tc := range errorCases {
+		if _, err := parseCompareRange(tc.statsType, tc.params, tc.timeRange); err == nil {
+			t.Errorf("%s %v: expected an error", tc.statsType, tc.params)
+		}
+	}
+}
+
+func TestCompareQuery(t *testing.T) {
+	query := StatsQuery{
+		ExtraParam: map[string]interface{}{"timeRange": "today", "limit": 10},
+		Compare:    &CompareRange{Start: "2024-01-01", End: "2024-01-07"},
+	}
+	compare := compareQuery("url", query)
+	if compare.Compare != nil || compare.ExtraParam["timeRange"] != "2024-01-01~2024-01-07" || compare.ExtraParam["limit"] != compareRowLimit {
+		t.Fatalf("unexpected compare query %+v", compare)
+	}
+	if query.ExtraParam["timeRange"] != "today" || query.ExtraParam["limit"] != 10 {
+		t.Fatal("compareQuery must not modify the original query")
+	}
+	if series := compareQuery("timeseries", query); series.ExtraParam["limit"] != 10 {
+		t.Fatalf("timeseries limit must be kept, got %v", series.ExtraParam["limit"])
+	}
+
+	if _, err := attachComparison(ClientStats{}, TimeSeriesStats{}, *query.Compare); err == nil {
+		t.Fatal("mismatched result types must be rejected")
+	}
+}
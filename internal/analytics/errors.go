@@ -0,0 +1,342 @@
+package analytics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+)
+
+// errorStatusFilters 为 errors 统计支持的状态码筛选，也可以传入 400-599 之间的具体状态码。
+var errorStatusFilters = []string{"all", "4xx", "5xx", "404"}
+
+type ErrorStatusCount struct {
+	StatusCode int   `json:"statusCode"`
+	Count      int64 `json:"count"`
+}
+
+type ErrorURLItem struct {
+	URL      string             `json:"url"`
+	Total    int64              `json:"total"`
+	NotFound int64              `json:"notFound"`
+	S5xx     int64              `json:"s5xx"`
+	Statuses []ErrorStatusCount `json:"statuses"`
+	// FirstSeen / LastSeen 为保留期内该路径首次、最近一次出错的时间（Unix 秒），不受 timeRange 限制
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen  int64 `json:"lastSeen"`
+}
+
+type BrokenRefererItem struct {
+	Referer string `json:"referer"`
+	URL     string `json:"url"`
+	Count   int64  `json:"count"`
+	// Internal 表示来源为站内页面（站内死链），否则为外部入站链接
+	Internal  bool  `json:"internal"`
+	FirstSeen int64 `json:"firstSeen"`
+	LastSeen  int64 `json:"lastSeen"`
+}
+
+type ErrorSummary struct {
+	Total    int64 `json:"total"`
+	NotFound int64 `json:"notFound"`
+	S4xx     int64 `json:"s4xx"`
+	S5xx     int64 `json:"s5xx"`
+	URLs     int64 `json:"urls"`
+}
+
+type ErrorStats struct {
+	Status         string              `json:"status"`
+	Summary        ErrorSummary        `json:"summary"`
+	StatusCodes    []ErrorStatusCount  `json:"statusCodes"`
+	URLs           []ErrorURLItem      `json:"urls"`
+	BrokenReferers []BrokenRefererItem `json:"brokenReferers"`
+	QueryMeta
+}
+
+func (s ErrorStats) GetType() string {
+	return "errors"
+}
+
+type ErrorStatsManager struct {
+	repo *store.Repository
+}
+
+func NewErrorStatsManager(userRepoPtr *store.Repository) *ErrorStatsManager {
+	return &ErrorStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 默认读取 _agg_error_daily 按天聚合的数据；带分群条件时回退到原始日志。
+func (m *ErrorStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	status, _ := query.ExtraParam["status"].(string)
+	if status == "" {
+		status = "all"
+	}
+	limit, _ := query.ExtraParam["limit"].(int)
+	if limit <= 0 {
+		limit = 20
+	}
+	result := ErrorStats{
+		Status:         status,
+		StatusCodes:    make([]ErrorStatusCount, 0),
+		URLs:           make([]ErrorURLItem, 0),
+		BrokenReferers: make([]BrokenRefererItem, 0),
+		QueryMeta:      newQueryMeta(query.Segment, query.Segment.queryPath()),
+	}
+
+	timeRange, ok := query.ExtraParam["timeRange"].(string)
+	if !ok || timeRange == "" {
+		return result, fmt.Errorf("timeRange 参数缺失")
+	}
+	startTime, endTime, err := timeutil.TimePeriod(timeRange)
+	if err != nil {
+		return result, fmt.Errorf("解析时间范围失败: %v", err)
+	}
+
+	var (
+		source     string
+		sourceArgs []interface{}
+	)
+	if query.Segment.IsEmpty() {
+		source = fmt.Sprintf(
+			`SELECT url_id, status_code, referer_id, count, first_ts, last_ts
+             FROM "%s_agg_error_daily" WHERE day >= ? AND day <= ?`, query.WebsiteID)
+		sourceArgs = []interface{}{dayBucket(startTime), dayBucket(endTime)}
+	} else {
+		segmentJoin, segmentCondition, segmentArgs := query.Segment.filter(query.WebsiteID, "l")
+		source = fmt.Sprintf(
+			`SELECT l.url_id, l.status_code,
+                    CASE WHEN l.status_code = 404 THEN l.referer_id ELSE 0 END AS referer_id,
+                    l.sample_weight AS count, l.timestamp AS first_ts, l.timestamp AS last_ts
+             FROM "%s_nginx_logs" l%s
+             WHERE l.status_code >= 400 AND l.timestamp >= ? AND l.timestamp < ?%s`,
+			query.WebsiteID, segmentJoin, segmentCondition)
+		sourceArgs = append([]interface{}{startTime.Unix(), endTime.Unix()}, segmentArgs...)
+	}
+	statusCondition := errorStatusCondition(status, "status_code")
+
+	if err := m.querySummary(&result, source, sourceArgs, statusCondition); err != nil {
+		return result, err
+	}
+	if result.Summary.Total == 0 {
+		return result, nil
+	}
+	if err := m.queryURLs(&result, query.WebsiteID, source, sourceArgs, statusCondition, limit); err != nil {
+		return result, err
+	}
+	if status == "all" || status == "4xx" || status == "404" {
+		if err := m.queryBrokenReferers(&result, query.WebsiteID, source, sourceArgs, limit); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (m *ErrorStatsManager) querySummary(result *ErrorStats, source string, args []interface{}, statusCondition string) error {
+	rows, err := m.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT status_code, SUM(count) AS hits
+        FROM (%s) e
+        WHERE %s
+        GROUP BY status_code
+        ORDER BY hits DESC, status_code`, source, statusCondition)), args...)
+	if err != nil {
+		return fmt.Errorf("查询错误状态码分布失败: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item ErrorStatusCount
+		if err := rows.Scan(&item.StatusCode, &item.Count); err != nil {
+			return fmt.Errorf("解析错误状态码分布失败: %v", err)
+		}
+		result.StatusCodes = append(result.StatusCodes, item)
+		result.Summary.Total += item.Count
+		switch {
+		case item.StatusCode >= 400 && item.StatusCode < 500:
+			result.Summary.S4xx += item.Count
+		case item.StatusCode >= 500 && item.StatusCode < 600:
+			result.Summary.S5xx += item.Count
+		}
+		if item.StatusCode == 404 {
+			result.Summary.NotFound += item.Count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历错误状态码分布失败: %v", err)
+	}
+
+	row := m.repo.GetDB().QueryRow(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT COUNT(DISTINCT url_id) FROM (%s) e WHERE %s`, source, statusCondition)), args...)
+	if err := row.Scan(&result.Summary.URLs); err != nil {
+		return fmt.Errorf("查询错误路径数失败: %v", err)
+	}
+	return nil
+}
+
+func (m *ErrorStatsManager) queryURLs(result *ErrorStats, websiteID, source string, args []interface{}, statusCondition string, limit int) error {
+	queryArgs := append(append([]interface{}{}, args...), limit)
+	rows, err := m.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT e.url_id, u.url, SUM(e.count) AS hits
+        FROM (%s) e
+        JOIN "%s_dim_url" u ON u.id = e.url_id
+        WHERE %s
+        GROUP BY e.url_id, u.url
+        ORDER BY hits DESC, u.url
+        LIMIT ?`, source, websiteID, errorStatusCondition(result.Status, "e.status_code"))), queryArgs...)
+	if err != nil {
+		return fmt.Errorf("查询错误路径失败: %v", err)
+	}
+	urlIDs := make([]int64, 0, limit)
+	index := make(map[int64]int, limit)
+	for rows.Next() {
+		var (
+			urlID int64
+			item  ErrorURLItem
+		)
+		if err := rows.Scan(&urlID, &item.URL, &item.Total); err != nil {
+			rows.Close()
+			return fmt.Errorf("解析错误路径失败: %v", err)
+		}
+		item.Statuses = make([]ErrorStatusCount, 0)
+		index[urlID] = len(result.URLs)
+		urlIDs = append(urlIDs, urlID)
+		result.URLs = append(result.URLs, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历错误路径失败: %v", err)
+	}
+	if len(urlIDs) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(urlIDs))
+	idArgs := make([]interface{}, len(urlIDs))
+	for i, id := range urlIDs {
+		placeholders[i] = "?"
+		idArgs[i] = id
+	}
+	inClause := strings.Join(placeholders, ",")
+
+	statusArgs := append(append([]interface{}{}, args...), idArgs...)
+	rows, err = m.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT url_id, status_code, SUM(count) AS hits
+        FROM (%s) e
+        WHERE url_id IN (%s) AND %s
+        GROUP BY url_id, status_code
+        ORDER BY url_id, hits DESC, status_code`, source, inClause, statusCondition)), statusArgs...)
+	if err != nil {
+		return fmt.Errorf("查询错误路径状态码分布失败: %v", err)
+	}
+	for rows.Next() {
+		var (
+			urlID int64
+			item  ErrorStatusCount
+		)
+		if err := rows.Scan(&urlID, &item.StatusCode, &item.Count); err != nil {
+			rows.Close()
+			return fmt.Errorf("解析错误路径状态码分布失败: %v", err)
+		}
+		url := &result.URLs[index[urlID]]
+		url.Statuses = append(url.Statuses, item)
+		if item.StatusCode == 404 {
+			url.NotFound += item.Count
+		}
+		if item.StatusCode >= 500 && item.StatusCode < 600 {
+			url.S5xx += item.Count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历错误路径状态码分布失败: %v", err)
+	}
+
+	// 首次/最近出现时间取整个保留期，便于判断错误是新出现还是长期存在
+	rows, err = m.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT url_id, MIN(first_ts), MAX(last_ts)
+        FROM "%s_agg_error_daily"
+        WHERE url_id IN (%s) AND %s
+        GROUP BY url_id`, websiteID, inClause, statusCondition)), idArgs...)
+	if err != nil {
+		return fmt.Errorf("查询错误路径出现时间失败: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var urlID, firstSeen, lastSeen int64
+		if err := rows.Scan(&urlID, &firstSeen, &lastSeen); err != nil {
+			return fmt.Errorf("解析错误路径出现时间失败: %v", err)
+		}
+		url := &result.URLs[index[urlID]]
+		url.FirstSeen = firstSeen
+		url.LastSeen = lastSeen
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历错误路径出现时间失败: %v", err)
+	}
+	return nil
+}
+
+// queryBrokenReferers 列出导致 404 的来源页面，空来源与 "-" 不计入。
+func (m *ErrorStatsManager) queryBrokenReferers(result *ErrorStats, websiteID, source string, args []interface{}, limit int) error {
+	internalExpr := "FALSE"
+	if website, ok := config.GetWebsiteByID(websiteID); ok {
+		if cond := buildInternalRefererCondition(website.Domains, "r.referer"); cond != "" {
+			internalExpr = cond
+		}
+	}
+	queryArgs := append(append([]interface{}{}, args...), limit)
+	rows, err := m.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT r.referer, u.url, SUM(e.count) AS hits, %s AS internal, MIN(e.first_ts), MAX(e.last_ts)
+        FROM (%s) e
+        JOIN "%s_dim_referer" r ON r.id = e.referer_id
+        JOIN "%s_dim_url" u ON u.id = e.url_id
+        WHERE e.status_code = 404 AND e.referer_id <> 0 AND r.referer NOT IN ('', '-')
+        GROUP BY r.referer, u.url
+        ORDER BY hits DESC, r.referer, u.url
+        LIMIT ?`, internalExpr, source, websiteID, websiteID)), queryArgs...)
+	if err != nil {
+		return fmt.Errorf("查询失效来源失败: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item BrokenRefererItem
+		if err := rows.Scan(&item.Referer, &item.URL, &item.Count, &item.Internal, &item.FirstSeen, &item.LastSeen); err != nil {
+			return fmt.Errorf("解析失效来源失败: %v", err)
+		}
+		result.BrokenReferers = append(result.BrokenReferers, item)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历失效来源失败: %v", err)
+	}
+	return nil
+}
+
+// errorStatusCondition 将状态码筛选转换为 SQL 条件，value 已由 parseErrorStatusFilter 校验。
+func errorStatusCondition(value, column string) string {
+	switch value {
+	case "4xx":
+		return fmt.Sprintf("%s >= 400 AND %s < 500", column, column)
+	case "5xx":
+		return fmt.Sprintf("%s >= 500 AND %s < 600", column, column)
+	case "all", "":
+		return fmt.Sprintf("%s >= 400", column)
+	}
+	return fmt.Sprintf("%s = %s", column, value)
+}
+
+func parseErrorStatusFilter(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, item := range errorStatusFilters {
+		if value == item {
+			return value, nil
+		}
+	}
+	if code, err := strconv.Atoi(value); err == nil && code >= 400 && code <= 599 {
+		return strconv.Itoa(code), nil
+	}
+	return "", fmt.Errorf("status 参数无效，必须为以下值之一: %v 或 400-599 之间的状态码", errorStatusFilters)
+}
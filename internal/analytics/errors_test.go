@@ -0,0 +1,38 @@
+package analytics
+
+import "testing"
+
+func TestParseErrorStatusFilter(t *testing.T) {
+	valid := map[string]string{
+		" 5XX ": "5xx",
+		"all":   "all",
+		"404":   "404",
+		"503":   "503",
+	}
+	for input, want := range valid {
+		got, err := parseErrorStatusFilter(input)
+		if err != nil || got != want {
+			t.Errorf("parseErrorStatusFilter(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"200", "399", "600", "6xx", "404; DROP TABLE x", "abc"} {
+		if _, err := parseErrorStatusFilter(input); err == nil {
+			t.Errorf("parseErrorStatusFilter(%q): expected an error", input)
+		}
+	}
+}
+
+func TestErrorStatusCondition(t *testing.T) {
+	cases := map[string]string{
+		"":    "status_code >= 400",
+		"all": "status_code >= 400",
+		"4xx": "status_code >= 400 AND status_code < 500",
+		"5xx": "status_code >= 500 AND status_code < 600",
+		"404": "status_code = 404",
+	}
+	for value, want := range cases {
+		if got := errorStatusCondition(value, "status_code"); got != want {
+			t.Errorf("errorStatusCondition(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
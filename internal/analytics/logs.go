@@ -52,6 +52,7 @@ type LogsStats struct {
 		PageSize int `json:"pageSize"`
 		Pages    int `json:"pages"`
 	} `json:"pagination"`
+	QueryMeta
 }
 
 type TimeRange struct {
@@ -78,8 +79,7 @@ func NewLogsStatsManager(userRepoPtr *store.Repository) *LogsStatsManager {
 
 // Query 实现 StatsManager 接口
 func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
-	result := LogsStats{}
-	const botDeviceLabel = "蜘蛛"
+	result := LogsStats{QueryMeta: newQueryMeta(query.Segment, QueryPathRawLogs)}
 	result.IPParsing = ingest.IsIPParsing()
 	result.IPParsingProgress = ingest.GetIPParsingProgress()
 	result.IPParsingEstimatedTotalSeconds = ingest.GetIPParsingEstimatedTotalSeconds()
@@ -104,14 +104,6 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	var timeRange string
 	var timeStart int64
 	var timeEnd int64
-	var statusCode int
-	var statusClass string
-	var excludeInternal bool
-	var excludeSpider bool
-	var excludeForeign bool
-	var ipFilter string
-	var locationFilter string
-	var urlFilter string
 	var pageviewOnly bool
 	var newVisitorFilter string
 	var includeNewVisitor bool
@@ -167,30 +159,6 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 		timeEnd = parsed
 	}
-	if statusCodeVal, ok := query.ExtraParam["statusCode"].(int); ok && statusCodeVal > 0 {
-		statusCode = statusCodeVal
-	}
-	if statusClassVal, ok := query.ExtraParam["statusClass"].(string); ok {
-		statusClass = statusClassVal
-	}
-	if excludeInternalVal, ok := query.ExtraParam["excludeInternal"].(bool); ok {
-		excludeInternal = excludeInternalVal
-	}
-	if excludeSpiderVal, ok := query.ExtraParam["excludeSpider"].(bool); ok {
-		excludeSpider = excludeSpiderVal
-	}
-	if excludeForeignVal, ok := query.ExtraParam["excludeForeign"].(bool); ok {
-		excludeForeign = excludeForeignVal
-	}
-	if ipFilterVal, ok := query.ExtraParam["ipFilter"].(string); ok {
-		ipFilter = strings.TrimSpace(ipFilterVal)
-	}
-	if locationFilterVal, ok := query.ExtraParam["locationFilter"].(string); ok {
-		locationFilter = strings.TrimSpace(locationFilterVal)
-	}
-	if urlFilterVal, ok := query.ExtraParam["urlFilter"].(string); ok {
-		urlFilter = strings.TrimSpace(urlFilterVal)
-	}
 	if pageviewOnlyVal, ok := query.ExtraParam["pageviewOnly"].(bool); ok {
 		pageviewOnly = pageviewOnlyVal
 	}
@@ -322,49 +290,9 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		conditions = append(conditions, fmt.Sprintf("%s <= ?", column("timestamp")))
 		args = append(args, timeEnd)
 	}
-	if ipFilter != "" {
-		conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column("ip")))
-		args = append(args, "%"+ipFilter+"%")
-	}
-	if locationFilter != "" {
-		conditions = append(conditions, fmt.Sprintf("(%s LIKE ? OR %s LIKE ?)",
-			column("domestic_location"), column("global_location")))
-		locationArg := "%" + locationFilter + "%"
-		args = append(args, locationArg, locationArg)
-	}
-	if urlFilter != "" {
-		conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column("url")))
-		args = append(args, "%"+urlFilter+"%")
-	}
-	if statusCode > 0 {
-		conditions = append(conditions, fmt.Sprintf("%s = ?", column("status_code")))
-		args = append(args, statusCode)
-	} else if statusClass != "" {
-		classLower := strings.ToLower(statusClass)
-		switch classLower {
-		case "2xx":
-			conditions = append(conditions, fmt.Sprintf("%s >= 200 AND %s < 300", column("status_code"), column("status_code")))
-		case "3xx":
-			conditions = append(conditions, fmt.Sprintf("%s >= 300 AND %s < 400", column("status_code"), column("status_code")))
-		case "4xx":
-			conditions = append(conditions, fmt.Sprintf("%s >= 400 AND %s < 500", column("status_code"), column("status_code")))
-		case "5xx":
-			conditions = append(conditions, fmt.Sprintf("%s >= 500 AND %s < 600", column("status_code"), column("status_code")))
-		}
-	}
-	if excludeInternal {
-		internalCondition, internalArgs := buildInternalIPCondition(column("ip"))
-		conditions = append(conditions, fmt.Sprintf("NOT %s", internalCondition))
-		args = append(args, internalArgs...)
-	}
-	if excludeSpider {
-		conditions = append(conditions, fmt.Sprintf("%s <> ?", column("user_device")))
-		args = append(args, botDeviceLabel)
-	}
-	if excludeForeign {
-		conditions = append(conditions, fmt.Sprintf("(%s = ? OR LOWER(%s) = ?)", column("global_location"), column("global_location")))
-		args = append(args, "中国", "china")
-	}
+	segmentConditions, segmentArgs := query.Segment.conditions(column)
+	conditions = append(conditions, segmentConditions...)
+	args = append(args, segmentArgs...)
 	if pageviewOnly {
 		conditions = append(conditions, fmt.Sprintf("%s = 1", column("pageview_flag")))
 	}
@@ -521,49 +449,8 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		countConditions = append(countConditions, fmt.Sprintf("%s <= ?", column("timestamp")))
 		countArgs = append(countArgs, timeEnd)
 	}
-	if ipFilter != "" {
-		countConditions = append(countConditions, fmt.Sprintf("%s LIKE ?", column("ip")))
-		countArgs = append(countArgs, "%"+ipFilter+"%")
-	}
-	if locationFilter != "" {
-		countConditions = append(countConditions, fmt.Sprintf("(%s LIKE ? OR %s LIKE ?)",
-			column("domestic_location"), column("global_location")))
-		locationArg := "%" + locationFilter + "%"
-		countArgs = append(countArgs, locationArg, locationArg)
-	}
-	if urlFilter != "" {
-		countConditions = append(countConditions, fmt.Sprintf("%s LIKE ?", column("url")))
-		countArgs = append(countArgs, "%"+urlFilter+"%")
-	}
-	if statusCode > 0 {
-		countConditions = append(countConditions, fmt.Sprintf("%s = ?", column("status_code")))
-		countArgs = append(countArgs, statusCode)
-	} else if statusClass != "" {
-		classLower := strings.ToLower(statusClass)
-		switch classLower {
-		case "2xx":
-			countConditions = append(countConditions, fmt.Sprintf("%s >= 200 AND %s < 300", column("status_code"), column("status_code")))
-		case "3xx":
-			countConditions = append(countConditions, fmt.Sprintf("%s >= 300 AND %s < 400", column("status_code"), column("status_code")))
-		case "4xx":
-			countConditions = append(countConditions, fmt.Sprintf("%s >= 400 AND %s < 500", column("status_code"), column("status_code")))
-		case "5xx":
-			countConditions = append(countConditions, fmt.Sprintf("%s >= 500 AND %s < 600", column("status_code"), column("status_code")))
-		}
-	}
-	if excludeInternal {
-		internalCondition, internalArgs := buildInternalIPCondition(column("ip"))
-		countConditions = append(countConditions, fmt.Sprintf("NOT %s", internalCondition))
-		countArgs = append(countArgs, internalArgs...)
-	}
-	if excludeSpider {
-		countConditions = append(countConditions, fmt.Sprintf("%s <> ?", column("user_device")))
-		countArgs = append(countArgs, botDeviceLabel)
-	}
-	if excludeForeign {
-		countConditions = append(countConditions, fmt.Sprintf("(%s = ? OR LOWER(%s) = ?)", column("global_location"), column("global_location")))
-		countArgs = append(countArgs, "中国", "china")
-	}
+	countConditions = append(countConditions, segmentConditions...)
+	countArgs = append(countArgs, segmentArgs...)
 	if pageviewOnly {
 		countConditions = append(countConditions, fmt.Sprintf("%s = 1", column("pageview_flag")))
 	}
@@ -27,6 +27,9 @@ type OverallStats struct {
 	Compare                   OverallCompare `json:"compare"`                   // 对比数据
 	StatusCodeHits            StatusCodeHits `json:"statusCodeHits"`            // HTTP 状态码命中次数
 	StatusCodeHitsPrevious    StatusCodeHits `json:"statusCodeHitsPrevious"`    // 上一期状态码命中次数
+	// Comparison 为自定义对比期的指标差异，仅在传入对比参数时返回
+	Comparison *OverallComparison `json:"comparison,omitempty"`
+	QueryMeta
 }
 
 type OverallSnapshot struct {
@@ -92,6 +95,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		},
 		StatusCodeHits:         StatusCodeHits{},
 		StatusCodeHitsPrevious: StatusCodeHits{},
+		QueryMeta:              newQueryMeta(query.Segment, query.Segment.queryPath()),
 	}
 
 	timeRange := query.ExtraParam["timeRange"].(string)
@@ -107,12 +111,14 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 	}
 
-	err = s.statsByTimeRangeForWebsite(query.WebsiteID, startTime, endTime, &result)
+	sites := query.Sites()
+	segment := query.Segment
+	err = s.statsByTimeRangeForSites(sites, segment, startTime, endTime, &result)
 	if err != nil {
 		return result, fmt.Errorf("获取总体统计失败: %v", err)
 	}
 
-	statusHits, err := s.statusCodeHitsByTimeRangeForWebsite(query.WebsiteID, startTime, endTime)
+	statusHits, err := s.statusCodeHitsByTimeRangeForSites(sites, segment, startTime, endTime)
 	if err != nil {
 		logrus.WithError(err).Warn("获取状态码统计失败")
 	} else {
@@ -120,7 +126,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	}
 
 	if !prevStart.IsZero() && !prevEnd.IsZero() {
-		prevStatusHits, err := s.statusCodeHitsByTimeRangeForWebsite(query.WebsiteID, prevStart, prevEnd)
+		prevStatusHits, err := s.statusCodeHitsByTimeRangeForSites(sites, segment, prevStart, prevEnd)
 		if err != nil {
 			logrus.WithError(err).Warn("获取上一期状态码统计失败")
 		} else {
@@ -128,7 +134,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 	}
 
-	metrics, err := collectSessionMetrics(s.repo, query.WebsiteID, startTime, endTime)
+	metrics, err := collectSessionMetricsForSites(s.repo, sites, segment, startTime, endTime)
 	if err != nil {
 		logrus.WithError(err).Warn("获取会话统计失败")
 	} else {
@@ -136,14 +142,14 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		result.EntryPages = buildEntryStats(metrics.EntryCounts, entryLimit)
 	}
 
-	activeCount, err := s.activeVisitorCount(query.WebsiteID)
+	activeCount, err := s.activeVisitorCount(sites, segment)
 	if err != nil {
 		logrus.WithError(err).Warn("获取活跃访客失败")
 	} else {
 		result.ActiveVisitorCount = activeCount
 	}
 
-	newCount, returningCount, err := s.newReturningCounts(query.WebsiteID, startTime, endTime)
+	newCount, returningCount, err := s.newReturningCountsForSites(sites, segment, startTime, endTime)
 	if err != nil {
 		logrus.WithError(err).Warn("获取新老访客失败")
 	} else {
@@ -152,7 +158,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	}
 
 	if !prevStart.IsZero() && !prevEnd.IsZero() {
-		prevNew, prevReturning, err := s.newReturningCounts(query.WebsiteID, prevStart, prevEnd)
+		prevNew, prevReturning, err := s.newReturningCountsForSites(sites, segment, prevStart, prevEnd)
 		if err != nil {
 			logrus.WithError(err).Warn("获取上期新老访客失败")
 		} else {
@@ -163,7 +169,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 	currentSnapshot := snapshotFromOverall(result)
 	prevSnapshot, prevSameSnapshot, forecastSnapshot := s.buildCompareSnapshots(
-		query.WebsiteID, timeRange, startTime, endTime, currentSnapshot,
+		sites, segment, timeRange, startTime, endTime, currentSnapshot,
 	)
 	result.Compare = OverallCompare{
 		Previous: prevSnapshot,
@@ -174,43 +180,67 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	return result, nil
 }
 
-// StatsByTimePoints 直接使用 db.Query() 方法查询数据库获取指定时间点的统计数据
-func (s *OverallStatsManager) statsByTimeRangeForWebsite(
-	websiteID string, startTime, endTime time.Time, overall *OverallStats) error {
+// statsByTimeRangeForSites 汇总指定站点在时间范围内的 PV、流量与 UV（跨站点时 UV 按 IP 去重）；
+// 设置了分群条件时改为扫描原始日志。
+func (s *OverallStatsManager) statsByTimeRangeForSites(
+	sites []string, segment Segment, startTime, endTime time.Time, overall *OverallStats) error {
 
 	// 初始化结果
 	overall.PV = 0
 	overall.UV = 0
 	overall.Traffic = 0
 
+	if !segment.IsEmpty() {
+		totals, err := queryRawLogTotals(s.repo, sites, segment, startTime.Unix(), endTime.Unix())
+		if err != nil {
+			return err
+		}
+		overall.PV = totals.PV
+		overall.UV = totals.UV
+		overall.Traffic = totals.Traffic
+		return nil
+	}
+
 	startDay := dayBucket(startTime)
 	endDay := dayBucket(endTime)
 
+	aggUnion, aggArgs := unionAllSites(sites, func(websiteID string) string {
+		return fmt.Sprintf(`SELECT pv, traffic FROM "%s_agg_daily" WHERE day >= ? AND day <= ?`, websiteID)
+	}, startDay, endDay)
 	aggQuery := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT 
             COALESCE(SUM(pv), 0) as pv,
             COALESCE(SUM(traffic), 0) as traffic
-        FROM "%s_agg_daily"
-        WHERE day >= ? AND day <= ?`,
-		websiteID))
+        FROM (
+        %s
+        ) t`,
+		aggUnion))
 
 	var pv int64
 	var traffic int64
-	row := s.repo.GetDB().QueryRow(aggQuery, startDay, endDay)
+	row := s.repo.GetDB().QueryRow(aggQuery, aggArgs...)
 	if err := row.Scan(&pv, &traffic); err != nil {
 		return fmt.Errorf("查询总体统计数据失败: %v", err)
 	}
 	overall.PV = int(pv)
 	overall.Traffic = traffic
 
+	uvUnion, uvArgs := unionAllSites(sites, func(websiteID string) string {
+		visitor, join := visitorColumn(sites, websiteID, "a")
+		return fmt.Sprintf(
+			`SELECT %s AS visitor FROM "%s_agg_daily_ip" a %s WHERE a.day >= ? AND a.day <= ?`,
+			visitor, websiteID, join,
+		)
+	}, startDay, endDay)
 	uvQuery := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
-        SELECT COUNT(DISTINCT ip_id) as uv
-        FROM "%s_agg_daily_ip"
-        WHERE day >= ? AND day <= ?`,
-		websiteID))
+        SELECT COUNT(DISTINCT visitor) as uv
+        FROM (
+        %s
+        ) t`,
+		uvUnion))
 
 	var uv int64
-	row = s.repo.GetDB().QueryRow(uvQuery, startDay, endDay)
+	row = s.repo.GetDB().QueryRow(uvQuery, uvArgs...)
 	if err := row.Scan(&uv); err != nil {
 		return fmt.Errorf("查询总体统计UV失败: %v", err)
 	}
@@ -219,13 +249,26 @@ func (s *OverallStatsManager) statsByTimeRangeForWebsite(
 	return nil
 }
 
-func (s *OverallStatsManager) statusCodeHitsByTimeRangeForWebsite(
-	websiteID string, startTime, endTime time.Time) (StatusCodeHits, error) {
+func (s *OverallStatsManager) statusCodeHitsByTimeRangeForSites(
+	sites []string, segment Segment, startTime, endTime time.Time) (StatusCodeHits, error) {
 
 	result := StatusCodeHits{}
+	if !segment.IsEmpty() {
+		totals, err := queryRawLogTotals(s.repo, sites, segment, startTime.Unix(), endTime.Unix())
+		if err != nil {
+			return result, err
+		}
+		return totals.Status, nil
+	}
 	startDay := dayBucket(startTime)
 	endDay := dayBucket(endTime)
 
+	union, args := unionAllSites(sites, func(websiteID string) string {
+		return fmt.Sprintf(
+			`SELECT s2xx, s3xx, s4xx, s5xx, other FROM "%s_agg_daily" WHERE day >= ? AND day <= ?`,
+			websiteID,
+		)
+	}, startDay, endDay)
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT
             COALESCE(SUM(s2xx), 0) AS s2xx,
@@ -233,11 +276,12 @@ func (s *OverallStatsManager) statusCodeHitsByTimeRangeForWebsite(
             COALESCE(SUM(s4xx), 0) AS s4xx,
             COALESCE(SUM(s5xx), 0) AS s5xx,
             COALESCE(SUM(other), 0) AS other
-        FROM "%s_agg_daily"
-        WHERE day >= ? AND day <= ?`,
-		websiteID))
+        FROM (
+        %s
+        ) t`,
+		union))
 
-	row := s.repo.GetDB().QueryRow(query, startDay, endDay)
+	row := s.repo.GetDB().QueryRow(query, args...)
 	if err := row.Scan(&result.S2xx, &result.S3xx, &result.S4xx, &result.S5xx, &result.Other); err != nil {
 		return result, fmt.Errorf("查询状态码统计失败: %v", err)
 	}
@@ -252,11 +296,41 @@ type sessionMetrics struct {
 
 const sessionGapSeconds = int64(1800)
 
+// collectSessionMetricsForSites 逐站点统计会话并合并；跨站点时入口页以站点前缀区分。
+func collectSessionMetricsForSites(
+	repo *store.Repository,
+	sites []string,
+	segment Segment,
+	startTime, endTime time.Time,
+) (sessionMetrics, error) {
+	if len(sites) == 1 {
+		return collectSessionMetrics(repo, sites[0], segment, startTime, endTime)
+	}
+	merged := sessionMetrics{EntryCounts: make(map[string]int)}
+	for _, websiteID := range sites {
+		metrics, err := collectSessionMetrics(repo, websiteID, segment, startTime, endTime)
+		if err != nil {
+			return merged, err
+		}
+		merged.SessionCount += metrics.SessionCount
+		label := siteLabel(websiteID)
+		for url, count := range metrics.EntryCounts {
+			merged.EntryCounts[label+url] += count
+		}
+	}
+	return merged, nil
+}
+
+// collectSessionMetrics 优先使用会话聚合表；分群条件只能在原始日志上还原会话。
 func collectSessionMetrics(
 	repo *store.Repository,
 	websiteID string,
+	segment Segment,
 	startTime, endTime time.Time,
 ) (sessionMetrics, error) {
+	if !segment.IsEmpty() {
+		return collectSessionMetricsFromLogs(repo, websiteID, segment, startTime, endTime)
+	}
 	sessionAggTable := fmt.Sprintf("%s_agg_session_daily", websiteID)
 	entryAggTable := fmt.Sprintf("%s_agg_entry_daily", websiteID)
 	hasSessionAgg, err := tableExists(repo.GetDB(), sessionAggTable)
@@ -277,7 +351,7 @@ func collectSessionMetrics(
 		return sessionMetrics{EntryCounts: make(map[string]int)}, err
 	}
 	if !exists {
-		return collectSessionMetricsFromLogs(repo, websiteID, startTime, endTime)
+		return collectSessionMetricsFromLogs(repo, websiteID, segment, startTime, endTime)
 	}
 	return collectSessionMetricsFromSessions(repo.GetDB(), websiteID, startTime, endTime)
 }
@@ -401,21 +475,24 @@ func collectSessionMetricsFromSessions(
 func collectSessionMetricsFromLogs(
 	repo *store.Repository,
 	websiteID string,
+	segment Segment,
 	startTime, endTime time.Time,
 ) (sessionMetrics, error) {
 	metrics := sessionMetrics{
 		EntryCounts: make(map[string]int),
 	}
 
+	segmentJoin, segmentCondition, segmentArgs := segment.filter(websiteID, "l")
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT l.timestamp, l.ip_id, l.ua_id, u.url
         FROM "%s_nginx_logs" l
-        JOIN "%s_dim_url" u ON u.id = l.url_id
-        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?
+        JOIN "%s_dim_url" u ON u.id = l.url_id%s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s
         ORDER BY l.ip_id, l.ua_id, l.timestamp`,
-		websiteID, websiteID))
+		websiteID, websiteID, segmentJoin, segmentCondition))
 
-	rows, err := repo.GetDB().Query(query, startTime.Unix(), endTime.Unix())
+	args := append([]interface{}{startTime.Unix(), endTime.Unix()}, segmentArgs...)
+	rows, err := repo.GetDB().Query(query, args...)
 	if err != nil {
 		return metrics, err
 	}
@@ -524,17 +601,26 @@ func buildEntryStats(counts map[string]int, limit int) ClientStats {
 	return result
 }
 
-func (s *OverallStatsManager) activeVisitorCount(websiteID string) (int, error) {
+func (s *OverallStatsManager) activeVisitorCount(sites []string, segment Segment) (int, error) {
 	now := time.Now()
 	start := now.Add(-15 * time.Minute)
 
+	union, args := unionAllSites(sites, func(websiteID string) string {
+		visitor, join := visitorColumn(sites, websiteID, "l")
+		segmentJoin, segmentCondition, _ := segment.filter(websiteID, "l")
+		return fmt.Sprintf(
+			`SELECT %s AS visitor FROM "%s_nginx_logs" l %s%s WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s`,
+			visitor, websiteID, join, segmentJoin, segmentCondition,
+		)
+	}, append([]interface{}{start.Unix(), now.Unix()}, segment.args()...)...)
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
-        SELECT COUNT(DISTINCT ip_id)
-        FROM "%s_nginx_logs"
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?`,
-		websiteID))
+        SELECT COUNT(DISTINCT visitor)
+        FROM (
+        %s
+        ) t`,
+		union))
 
-	row := s.repo.GetDB().QueryRow(query, start.Unix(), now.Unix())
+	row := s.repo.GetDB().QueryRow(query, args...)
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -542,31 +628,54 @@ func (s *OverallStatsManager) activeVisitorCount(websiteID string) (int, error)
 	return count, nil
 }
 
-func (s *OverallStatsManager) newReturningCounts(
-	websiteID string, startTime, endTime time.Time,
+// newReturningCountsForSites 逐站点统计新老访客后求和。
+// 首次访问时间按站点记录，跨站点时同一访客可能在多个站点分别计数。
+func (s *OverallStatsManager) newReturningCountsForSites(
+	sites []string, segment Segment, startTime, endTime time.Time,
 ) (int, int, error) {
-	startDay := dayBucket(startTime)
-	endDay := dayBucket(endTime)
+	totalNew, totalReturning := 0, 0
+	for _, websiteID := range sites {
+		newCount, returningCount, err := s.newReturningCounts(websiteID, segment, startTime, endTime)
+		if err != nil {
+			return 0, 0, err
+		}
+		totalNew += newCount
+		totalReturning += returningCount
+	}
+	return totalNew, totalReturning, nil
+}
 
-	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
-        WITH active_ips AS (
+func (s *OverallStatsManager) newReturningCounts(
+	websiteID string, segment Segment, startTime, endTime time.Time,
+) (int, int, error) {
+	// 活跃访客默认取自按天聚合的 IP 表，设置分群条件时从原始日志筛选
+	activeIPs := fmt.Sprintf(`
             SELECT DISTINCT ip_id
             FROM "%s_agg_daily_ip"
-            WHERE day >= ? AND day <= ?
+            WHERE day >= ? AND day <= ?`, websiteID)
+	args := []interface{}{dayBucket(startTime), dayBucket(endTime)}
+	if !segment.IsEmpty() {
+		segmentJoin, segmentCondition, segmentArgs := segment.filter(websiteID, "l")
+		activeIPs = fmt.Sprintf(`
+            SELECT DISTINCT l.ip_id
+            FROM "%s_nginx_logs" l%s
+            WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s`,
+			websiteID, segmentJoin, segmentCondition)
+		args = append([]interface{}{startTime.Unix(), endTime.Unix()}, segmentArgs...)
+	}
+
+	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        WITH active_ips AS (%s
         )
         SELECT
             COALESCE(SUM(CASE WHEN fs.first_ts >= ? AND fs.first_ts < ? THEN 1 ELSE 0 END), 0) AS new_uv,
             COALESCE(SUM(CASE WHEN fs.first_ts < ? THEN 1 ELSE 0 END), 0) AS returning_uv
         FROM active_ips a
         LEFT JOIN "%s_first_seen" fs ON fs.ip_id = a.ip_id`,
-		websiteID, websiteID))
+		activeIPs, websiteID))
 
-	row := s.repo.GetDB().QueryRow(
-		query,
-		startDay, endDay,
-		startTime.Unix(), endTime.Unix(),
-		startTime.Unix(),
-	)
+	args = append(args, startTime.Unix(), endTime.Unix(), startTime.Unix())
+	row := s.repo.GetDB().QueryRow(query, args...)
 
 	var newCount, returningCount int
 	if err := row.Scan(&newCount, &returningCount); err != nil {
@@ -586,6 +695,13 @@ func previousTimeRange(timeRange string) (time.Time, time.Time) {
 			return start, end
 		}
 	}
+	if rangeStart, rangeEnd, ok := timeutil.ParseDateRange(timeRange); ok {
+		// 自定义区间：取紧邻其前、天数相同的区间
+		days := int(rangeEnd.Sub(rangeStart).Hours()/24+0.5) + 1
+		prevEnd := rangeStart.AddDate(0, 0, -1)
+		prevStart := rangeStart.AddDate(0, 0, -days)
+		return prevStart, time.Date(prevEnd.Year(), prevEnd.Month(), prevEnd.Day(), 23, 59, 59, 0, prevEnd.Location())
+	}
 	switch timeRange {
 	case "today":
 		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
@@ -630,7 +746,8 @@ func snapshotFromOverall(overall OverallStats) OverallSnapshot {
 }
 
 func (s *OverallStatsManager) buildCompareSnapshots(
-	websiteID string,
+	sites []string,
+	segment Segment,
 	timeRange string,
 	startTime, endTime time.Time,
 	current OverallSnapshot,
@@ -640,7 +757,7 @@ func (s *OverallStatsManager) buildCompareSnapshots(
 		return OverallSnapshot{}, current, current
 	}
 
-	prevSnapshot, err := s.snapshotForRange(websiteID, prevStart, prevEnd)
+	prevSnapshot, err := s.snapshotForRange(sites, segment, prevStart, prevEnd)
 	if err != nil {
 		logrus.WithError(err).Warn("获取上一期统计失败")
 	}
@@ -667,7 +784,7 @@ func (s *OverallStatsManager) buildCompareSnapshots(
 	}
 
 	progressForecast := scaleSnapshot(current, progress)
-	forecast := s.forecastSnapshot(websiteID, startTime, endTime, currentEnd, progressForecast)
+	forecast := s.forecastSnapshot(sites, segment, startTime, endTime, currentEnd, progressForecast)
 
 	prevSameEnd := prevStart.Add(elapsed)
 	if prevSameEnd.After(prevEnd) {
@@ -675,7 +792,7 @@ func (s *OverallStatsManager) buildCompareSnapshots(
 	}
 	prevSameSnapshot := prevSnapshot
 	if prevSameEnd.After(prevStart) {
-		prevSameSnapshot, err = s.snapshotForRange(websiteID, prevStart, prevSameEnd)
+		prevSameSnapshot, err = s.snapshotForRange(sites, segment, prevStart, prevSameEnd)
 		if err != nil {
 			logrus.WithError(err).Warn("获取上一期同期失败")
 			prevSameSnapshot = prevSnapshot
@@ -702,14 +819,14 @@ func scaleSnapshot(current OverallSnapshot, progress float64) OverallSnapshot {
 }
 
 func (s *OverallStatsManager) snapshotForRange(
-	websiteID string, startTime, endTime time.Time,
+	sites []string, segment Segment, startTime, endTime time.Time,
 ) (OverallSnapshot, error) {
 	overall := OverallStats{}
-	if err := s.statsByTimeRangeForWebsite(websiteID, startTime, endTime, &overall); err != nil {
+	if err := s.statsByTimeRangeForSites(sites, segment, startTime, endTime, &overall); err != nil {
 		return OverallSnapshot{}, err
 	}
 
-	metrics, err := collectSessionMetrics(s.repo, websiteID, startTime, endTime)
+	metrics, err := collectSessionMetricsForSites(s.repo, sites, segment, startTime, endTime)
 	if err != nil {
 		return OverallSnapshot{}, err
 	}
@@ -724,7 +841,8 @@ func (s *OverallStatsManager) snapshotForRange(
 }
 
 func (s *OverallStatsManager) forecastSnapshot(
-	websiteID string,
+	sites []string,
+	segment Segment,
 	startTime, endTime, currentEnd time.Time,
 	progressForecast OverallSnapshot,
 ) OverallSnapshot {
@@ -757,7 +875,7 @@ func (s *OverallStatsManager) forecastSnapshot(
 		windowStart = startTime
 	}
 
-	windowSnapshot, err := s.snapshotForRange(websiteID, windowStart, currentEnd)
+	windowSnapshot, err := s.snapshotForRange(sites, segment, windowStart, currentEnd)
 	if err != nil {
 		logrus.WithError(err).Warn("获取预测窗口数据失败")
 		return progressForecast
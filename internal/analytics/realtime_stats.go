@@ -27,6 +27,7 @@ type RealtimeStats struct {
 	EntryPages      []RealtimeItem `json:"entryPages"`
 	Browsers        []RealtimeItem `json:"browsers"`
 	Locations       []RealtimeItem `json:"locations"`
+	QueryMeta
 }
 
 func (s RealtimeStats) GetType() string {
@@ -46,6 +47,7 @@ func NewRealtimeStatsManager(userRepoPtr *store.Repository) *RealtimeStatsManage
 func (m *RealtimeStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	result := RealtimeStats{
 		WindowMinutes: 30,
+		QueryMeta:     newQueryMeta(query.Segment, QueryPathRawLogs),
 	}
 
 	window := 30
@@ -61,41 +63,43 @@ func (m *RealtimeStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	startTime := endTime.Add(-time.Duration(window) * time.Minute)
 
 	tableName := fmt.Sprintf("%s_nginx_logs", query.WebsiteID)
+	segment := query.Segment
 
-	activeCount, err := m.activeVisitorCount(tableName, startTime, endTime)
+	activeCount, err := m.activeVisitorCount(tableName, segment, startTime, endTime)
 	if err != nil {
 		return result, err
 	}
 	result.ActiveCount = activeCount
 
-	series, err := m.activeSeries(tableName, startTime, endTime, window)
+	series, err := m.activeSeries(tableName, segment, startTime, endTime, window)
 	if err != nil {
 		return result, err
 	}
 	result.ActiveSeries = series
 
-	result.DeviceBreakdown = m.deviceBreakdown(tableName, startTime, endTime)
+	result.DeviceBreakdown = m.deviceBreakdown(tableName, segment, startTime, endTime)
 
 	refererExpr := buildRealtimeRefererExpr(query.WebsiteID, "r.referer")
 	refererJoin := fmt.Sprintf(`JOIN "%s_dim_referer" r ON r.id = l.referer_id`, query.WebsiteID)
-	referers, _ := m.queryTopItems(tableName, refererJoin, refererExpr, refererExpr, startTime, endTime, 10, true)
+	referers, _ := m.queryTopItems(tableName, segment, refererJoin, refererExpr, refererExpr, startTime, endTime, 10, true)
 	result.Referers = referers
 
 	urlJoin := fmt.Sprintf(`JOIN "%s_dim_url" u ON u.id = l.url_id`, query.WebsiteID)
-	pages, _ := m.queryTopItems(tableName, urlJoin, "u.url", "u.url", startTime, endTime, 10, false)
+	pages, _ := m.queryTopItems(tableName, segment, urlJoin, "u.url", "u.url", startTime, endTime, 10, false)
 	result.Pages = pages
 
-	entryCounts, _ := m.entryPages(tableName, startTime, endTime)
+	entryCounts, _ := m.entryPages(tableName, segment, startTime, endTime)
 	result.EntryPages = entryCounts
 
 	uaJoin := fmt.Sprintf(`JOIN "%s_dim_ua" ua ON ua.id = l.ua_id`, query.WebsiteID)
-	browsers, _ := m.queryTopItems(tableName, uaJoin, "ua.browser", "ua.browser", startTime, endTime, 10, true)
+	browsers, _ := m.queryTopItems(tableName, segment, uaJoin, "ua.browser", "ua.browser", startTime, endTime, 10, true)
 	result.Browsers = browsers
 
 	locationExpr := "CASE WHEN position('·' in loc.domestic) > 0 THEN substring(loc.domestic from position('·' in loc.domestic) + 1) ELSE loc.domestic END"
 	locationJoin := fmt.Sprintf(`JOIN "%s_dim_location" loc ON loc.id = l.location_id`, query.WebsiteID)
 	locations, _ := m.queryTopItems(
 		tableName,
+		segment,
 		locationJoin,
 		locationExpr,
 		locationExpr,
@@ -109,14 +113,16 @@ func (m *RealtimeStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	return result, nil
 }
 
-func (m *RealtimeStatsManager) activeVisitorCount(tableName string, startTime, endTime time.Time) (int, error) {
+func (m *RealtimeStatsManager) activeVisitorCount(
+	tableName string, segment Segment, startTime, endTime time.Time) (int, error) {
+	segmentJoin, segmentCondition, args := realtimeSegment(tableName, segment, startTime, endTime)
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
-        SELECT COUNT(DISTINCT ip_id)
-        FROM "%s"
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?`,
-		tableName))
+        SELECT COUNT(DISTINCT l.ip_id)
+        FROM "%s" l%s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s`,
+		tableName, segmentJoin, segmentCondition))
 
-	row := m.repo.GetDB().QueryRow(query, startTime.Unix(), endTime.Unix())
+	row := m.repo.GetDB().QueryRow(query, args...)
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -124,15 +130,17 @@ func (m *RealtimeStatsManager) activeVisitorCount(tableName string, startTime, e
 	return count, nil
 }
 
-func (m *RealtimeStatsManager) activeSeries(tableName string, startTime, endTime time.Time, window int) ([]int, error) {
+func (m *RealtimeStatsManager) activeSeries(
+	tableName string, segment Segment, startTime, endTime time.Time, window int) ([]int, error) {
+	segmentJoin, segmentCondition, args := realtimeSegment(tableName, segment, startTime, endTime)
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
-        SELECT (timestamp / 60) as bucket, COUNT(DISTINCT ip_id) as uv
-        FROM "%s"
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?
+        SELECT (l.timestamp / 60) as bucket, COUNT(DISTINCT l.ip_id) as uv
+        FROM "%s" l%s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s
         GROUP BY bucket`,
-		tableName))
+		tableName, segmentJoin, segmentCondition))
 
-	rows, err := m.repo.GetDB().Query(query, startTime.Unix(), endTime.Unix())
+	rows, err := m.repo.GetDB().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -159,16 +167,18 @@ func (m *RealtimeStatsManager) activeSeries(tableName string, startTime, endTime
 	return series, nil
 }
 
-func (m *RealtimeStatsManager) deviceBreakdown(tableName string, startTime, endTime time.Time) []RealtimeItem {
+func (m *RealtimeStatsManager) deviceBreakdown(
+	tableName string, segment Segment, startTime, endTime time.Time) []RealtimeItem {
+	segmentJoin, segmentCondition, args := realtimeSegment(tableName, segment, startTime, endTime)
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT ua.device, COUNT(DISTINCT l.ip_id) as uv
         FROM "%s" l
-        JOIN "%s_dim_ua" ua ON ua.id = l.ua_id
-        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?
+        JOIN "%s_dim_ua" ua ON ua.id = l.ua_id%s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s
         GROUP BY ua.device`,
-		tableName, strings.TrimSuffix(tableName, "_nginx_logs")))
+		tableName, strings.TrimSuffix(tableName, "_nginx_logs"), segmentJoin, segmentCondition))
 
-	rows, err := m.repo.GetDB().Query(query, startTime.Unix(), endTime.Unix())
+	rows, err := m.repo.GetDB().Query(query, args...)
 	if err != nil {
 		return []RealtimeItem{}
 	}
@@ -207,6 +217,7 @@ func (m *RealtimeStatsManager) deviceBreakdown(tableName string, startTime, endT
 
 func (m *RealtimeStatsManager) queryTopItems(
 	tableName string,
+	segment Segment,
 	joinClause string,
 	selectExpr string,
 	groupExpr string,
@@ -217,22 +228,23 @@ func (m *RealtimeStatsManager) queryTopItems(
 	if limit <= 0 {
 		limit = 10
 	}
-	countExpr := "COUNT(*)"
+	countExpr := "SUM(l.sample_weight)"
 	if distinctIP {
 		countExpr = "COUNT(DISTINCT l.ip_id)"
 	}
 
+	segmentJoin, segmentCondition, args := realtimeSegment(tableName, segment, startTime, endTime)
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT %[1]s as key, %[2]s as cnt
         FROM "%[3]s" l
-        %[4]s
-        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?
+        %[4]s%[6]s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%[7]s
         GROUP BY %[5]s
         ORDER BY cnt DESC
         LIMIT ?`,
-		selectExpr, countExpr, tableName, joinClause, groupExpr))
+		selectExpr, countExpr, tableName, joinClause, groupExpr, segmentJoin, segmentCondition))
 
-	rows, err := m.repo.GetDB().Query(query, startTime.Unix(), endTime.Unix(), limit)
+	rows, err := m.repo.GetDB().Query(query, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -274,17 +286,19 @@ func (m *RealtimeStatsManager) queryTopItems(
 
 func (m *RealtimeStatsManager) entryPages(
 	tableName string,
+	segment Segment,
 	startTime, endTime time.Time,
 ) ([]RealtimeItem, error) {
+	segmentJoin, segmentCondition, args := realtimeSegment(tableName, segment, startTime, endTime)
 	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         SELECT l.timestamp, l.ip_id, l.ua_id, u.url
         FROM "%s" l
-        JOIN "%s_dim_url" u ON u.id = l.url_id
-        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?
+        JOIN "%s_dim_url" u ON u.id = l.url_id%s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s
         ORDER BY l.ip_id, l.ua_id, l.timestamp`,
-		tableName, strings.TrimSuffix(tableName, "_nginx_logs")))
+		tableName, strings.TrimSuffix(tableName, "_nginx_logs"), segmentJoin, segmentCondition))
 
-	rows, err := m.repo.GetDB().Query(query, startTime.Unix(), endTime.Unix())
+	rows, err := m.repo.GetDB().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -352,6 +366,14 @@ func (m *RealtimeStatsManager) entryPages(
 	return result, nil
 }
 
+// realtimeSegment 返回分群的关联、条件以及包含时间窗口在内的完整参数
+func realtimeSegment(
+	tableName string, segment Segment, startTime, endTime time.Time) (string, string, []interface{}) {
+	segmentJoin, segmentCondition, segmentArgs := segment.filter(strings.TrimSuffix(tableName, "_nginx_logs"), "l")
+	args := append([]interface{}{startTime.Unix(), endTime.Unix()}, segmentArgs...)
+	return segmentJoin, segmentCondition, args
+}
+
 func buildRealtimeRefererExpr(websiteID string, refererColumn string) string {
 	internalCond := ""
 	if website, ok := config.GetWebsiteByID(websiteID); ok {
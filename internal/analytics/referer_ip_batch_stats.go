@@ -22,6 +22,7 @@ type RefererIPBatchStats struct {
 	Search   RefererIPGroupStats `json:"search"`
 	Direct   RefererIPGroupStats `json:"direct"`
 	External RefererIPGroupStats `json:"external"`
+	QueryMeta
 }
 
 func (s RefererIPBatchStats) GetType() string {
@@ -37,7 +38,7 @@ func NewRefererIPBatchStatsManager(repo *store.Repository) *RefererIPBatchStatsM
 }
 
 func (m *RefererIPBatchStatsManager) Query(query StatsQuery) (StatsResult, error) {
-	result := RefererIPBatchStats{}
+	result := RefererIPBatchStats{QueryMeta: newQueryMeta(query.Segment, QueryPathRawLogs)}
 	timeRange := query.ExtraParam["timeRange"].(string)
 	limit, _ := query.ExtraParam["limit"].(int)
 	if limit <= 0 {
@@ -49,19 +50,19 @@ func (m *RefererIPBatchStatsManager) Query(query StatsQuery) (StatsResult, error
 		return result, err
 	}
 
-	all, err := m.queryGroup(query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "all")
+	all, err := m.queryGroup(query.WebsiteID, query.Segment, startTime.Unix(), endTime.Unix(), limit, "all")
 	if err != nil {
 		return result, err
 	}
-	search, err := m.queryGroup(query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "search")
+	search, err := m.queryGroup(query.WebsiteID, query.Segment, startTime.Unix(), endTime.Unix(), limit, "search")
 	if err != nil {
 		return result, err
 	}
-	direct, err := m.queryGroup(query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "direct")
+	direct, err := m.queryGroup(query.WebsiteID, query.Segment, startTime.Unix(), endTime.Unix(), limit, "direct")
 	if err != nil {
 		return result, err
 	}
-	external, err := m.queryGroup(query.WebsiteID, startTime.Unix(), endTime.Unix(), limit, "external")
+	external, err := m.queryGroup(query.WebsiteID, query.Segment, startTime.Unix(), endTime.Unix(), limit, "external")
 	if err != nil {
 		return result, err
 	}
@@ -75,6 +76,7 @@ func (m *RefererIPBatchStatsManager) Query(query StatsQuery) (StatsResult, error
 
 func (m *RefererIPBatchStatsManager) queryGroup(
 	websiteID string,
+	segment Segment,
 	startUnix int64,
 	endUnix int64,
 	limit int,
@@ -93,28 +95,31 @@ func (m *RefererIPBatchStatsManager) queryGroup(
 	if sourceCondition != "" {
 		extraCondition = " AND " + sourceCondition
 	}
+	segmentJoin, segmentCondition, segmentArgs := segment.filter(websiteID, "l")
+	extraCondition += segmentCondition
+	args := append([]interface{}{startUnix, endUnix}, segmentArgs...)
 
 	totalQuery := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
-        SELECT COUNT(*)
+        SELECT COALESCE(SUM(l.sample_weight), 0)
         FROM "%[1]s_nginx_logs" l
-        JOIN "%[1]s_dim_referer" r ON r.id = l.referer_id
+        JOIN "%[1]s_dim_referer" r ON r.id = l.referer_id%[3]s
         WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%[2]s`,
-		websiteID, extraCondition))
+		websiteID, extraCondition, segmentJoin))
 
-	if err := m.repo.GetDB().QueryRow(totalQuery, startUnix, endUnix).Scan(&result.TotalUV); err != nil {
+	if err := m.repo.GetDB().QueryRow(totalQuery, args...).Scan(&result.TotalUV); err != nil {
 		return result, fmt.Errorf("查询来源IP总量失败: %v", err)
 	}
 
 	querySQL := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         WITH filtered AS (
-            SELECT l.ip_id, ip.ip, l.location_id
+            SELECT l.ip_id, ip.ip, l.location_id, l.sample_weight
             FROM "%[1]s_nginx_logs" l
             JOIN "%[1]s_dim_ip" ip ON ip.id = l.ip_id
-            JOIN "%[1]s_dim_referer" r ON r.id = l.referer_id
+            JOIN "%[1]s_dim_referer" r ON r.id = l.referer_id%[3]s
             WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%[2]s
         ),
         ip_counts AS (
-            SELECT ip_id, ip, COUNT(*) AS uv
+            SELECT ip_id, ip, SUM(sample_weight) AS uv
             FROM filtered
             GROUP BY ip_id, ip
         ),
@@ -147,9 +152,9 @@ func (m *RefererIPBatchStatsManager) queryGroup(
         FROM top_ips t
         LEFT JOIN location_rank lr ON lr.ip_id = t.ip_id AND lr.rn = 1
         ORDER BY t.uv DESC, t.ip ASC`,
-		websiteID, extraCondition))
+		websiteID, extraCondition, segmentJoin))
 
-	rows, err := m.repo.GetDB().Query(querySQL, startUnix, endUnix, limit)
+	rows, err := m.repo.GetDB().Query(querySQL, append(args, limit)...)
 	if err != nil {
 		return result, fmt.Errorf("查询来源IP排行失败: %v", err)
 	}
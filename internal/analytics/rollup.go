@@ -0,0 +1,79 @@
+package analytics
+
+import (
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+// 支持跨站点范围（all / group:<name>）的统计类型。
+var rollupStatsTypes = map[string]bool{
+	"overall":       true,
+	"timeseries":    true,
+	"url":           true,
+	"referer":       true,
+	"location":      true,
+	"sites_ranking": true,
+	"anomalies":     true,
+}
+
+// Sites 返回查询覆盖的站点列表：跨站点范围时为展开后的站点，否则为单个站点。
+func (q StatsQuery) Sites() []string {
+	if len(q.WebsiteIDs) > 0 {
+		return q.WebsiteIDs
+	}
+	return []string{q.WebsiteID}
+}
+
+// unionAllSites 为每个站点生成一条子查询并以 UNION ALL 拼接，args 按站点顺序重复。
+func unionAllSites(sites []string, branch func(websiteID string) string, args ...interface{}) (string, []interface{}) {
+	return unionAllSiteQueries(sites, func(websiteID string) (string, []interface{}) {
+		return branch(websiteID), args
+	})
+}
+
+// unionAllSiteQueries 与 unionAllSites 相同，但每条子查询自带参数；
+// 站点 ID、站点标签等随站点变化的值通过参数绑定传入，不拼接进 SQL。
+func unionAllSiteQueries(sites []string, branch func(websiteID string) (string, []interface{})) (string, []interface{}) {
+	parts := make([]string, 0, len(sites))
+	allArgs := make([]interface{}, 0)
+	for _, websiteID := range sites {
+		part, args := branch(websiteID)
+		parts = append(parts, part)
+		allArgs = append(allArgs, args...)
+	}
+	return strings.Join(parts, "\n        UNION ALL\n        "), allArgs
+}
+
+// visitorColumn 返回子查询中标识访客的列。
+// ip_id 只在单个站点的维表内唯一，跨站点时需要关联 dim_ip 以 IP 去重。
+func visitorColumn(sites []string, websiteID, alias string) (string, string) {
+	if len(sites) <= 1 {
+		return alias + ".ip_id", ""
+	}
+	return "vip.ip", `JOIN "` + websiteID + `_dim_ip" vip ON vip.id = ` + alias + ".ip_id"
+}
+
+// visitorCountExpr 返回按桶统计访客数的聚合表达式：单站点的 IP 聚合表在桶内已唯一，无需去重。
+func visitorCountExpr(sites []string) string {
+	if len(sites) <= 1 {
+		return "COUNT(*)"
+	}
+	return "COUNT(DISTINCT visitor)"
+}
+
+// siteLabel 返回跨站点结果中用于区分站点的前缀：优先使用第一个域名，否则使用站点名称。
+func siteLabel(websiteID string) string {
+	website, ok := config.GetWebsiteByID(websiteID)
+	if !ok {
+		return websiteID
+	}
+	label := website.Name
+	for _, domain := range website.Domains {
+		if normalized := normalizeDomain(domain); normalized != "" {
+			label = normalized
+			break
+		}
+	}
+	return label
+}
@@ -0,0 +1,28 @@
+package analytics
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnionAllSiteQueriesBindsSiteValues(t *testing.T) {
+	sites := []string{"ab12", "it's?"}
+	union, args := unionAllSiteQueries(sites, func(websiteID string) (string, []interface{}) {
+		return `SELECT ?::text AS site FROM "t" WHERE day >= ?`, []interface{}{websiteID, 1}
+	})
+	if strings.Contains(union, "ab12") || strings.Contains(union, "it's") {
+		t.Fatalf("site values must not be inlined:\n%s", union)
+	}
+	if strings.Count(union, "UNION ALL") != 1 || strings.Count(union, "?") != len(args) {
+		t.Fatalf("unexpected union:\n%s", union)
+	}
+	if want := []interface{}{"ab12", 1, "it's?", 1}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+
+	_, shared := unionAllSites(sites, func(websiteID string) string { return "SELECT ?" }, 7)
+	if !reflect.DeepEqual(shared, []interface{}{7, 7}) {
+		t.Fatalf("shared args = %v", shared)
+	}
+}
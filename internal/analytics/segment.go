@@ -0,0 +1,344 @@
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+const (
+	// QueryPathAggregate 表示结果来自预聚合表
+	QueryPathAggregate = "aggregate"
+	// QueryPathRawLogs 表示结果直接扫描原始日志表
+	QueryPathRawLogs = "raw_logs"
+
+	botDeviceLabel = "蜘蛛"
+)
+
+// 设备筛选支持的英文别名，对应 UA 解析器输出的设备类型。
+var segmentDeviceAliases = map[string]string{
+	"mobile":  "手机",
+	"phone":   "手机",
+	"tablet":  "平板",
+	"desktop": "桌面设备",
+	"bot":     botDeviceLabel,
+	"spider":  botDeviceLabel,
+	"other":   "其他设备",
+}
+
+// SegmentParamNames 为分群条件使用的请求参数名
+var SegmentParamNames = []string{
+	"statusCode", "statusClass", "excludeInternal", "excludeSpider", "excludeForeign",
+	"ipFilter", "locationFilter", "urlFilter", "deviceFilter", "browserFilter", "osFilter", "refererFilter",
+}
+
+// IsSegmentParam 判断请求参数是否属于分群条件
+func IsSegmentParam(name string) bool {
+	for _, item := range SegmentParamNames {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Segment 对所有统计类型通用的访客分群条件，在 BuildQueryFromRequest 中统一解析。
+// 例如「来自德国、排除蜘蛛、访问 /api/* 的手机访客」：
+// deviceFilter=mobile&locationFilter=德国&excludeSpider=true&urlFilter=/api/*
+type Segment struct {
+	StatusCode      int    `json:"statusCode,omitempty"`
+	StatusClass     string `json:"statusClass,omitempty"`
+	ExcludeInternal bool   `json:"excludeInternal,omitempty"`
+	ExcludeSpider   bool   `json:"excludeSpider,omitempty"`
+	ExcludeForeign  bool   `json:"excludeForeign,omitempty"`
+	IP              string `json:"ip,omitempty"`
+	Location        string `json:"location,omitempty"`
+	URL             string `json:"url,omitempty"`
+	Device          string `json:"device,omitempty"`
+	Browser         string `json:"browser,omitempty"`
+	OS              string `json:"os,omitempty"`
+	Referer         string `json:"referer,omitempty"`
+}
+
+// QueryMeta 随统计结果返回本次使用的分群条件与数据来源（aggregate / raw_logs）
+type QueryMeta struct {
+	Segment   *Segment `json:"segment,omitempty"`
+	QueryPath string   `json:"queryPath,omitempty"`
+}
+
+func newQueryMeta(segment Segment, queryPath string) QueryMeta {
+	meta := QueryMeta{QueryPath: queryPath}
+	if !segment.IsEmpty() {
+		seg := segment
+		meta.Segment = &seg
+	}
+	return meta
+}
+
+// IsEmpty 判断是否未设置任何分群条件
+func (s Segment) IsEmpty() bool {
+	return s == Segment{}
+}
+
+// queryPath 聚合表不含 IP / URL / UA / 地域等维度，设置了分群条件时只能回退到原始日志。
+func (s Segment) queryPath() string {
+	if s.IsEmpty() {
+		return QueryPathAggregate
+	}
+	return QueryPathRawLogs
+}
+
+// cacheKey 生成稳定的缓存键片段
+func (s Segment) cacheKey() string {
+	if s.IsEmpty() {
+		return ""
+	}
+	return fmt.Sprintf("%d|%s|%t|%t|%t|%s|%s|%s|%s|%s|%s|%s",
+		s.StatusCode, s.StatusClass, s.ExcludeInternal, s.ExcludeSpider, s.ExcludeForeign,
+		s.IP, s.Location, s.URL, s.Device, s.Browser, s.OS, s.Referer)
+}
+
+// parseSegment 从请求参数中解析分群条件
+func parseSegment(params map[string]string) (Segment, error) {
+	segment := Segment{}
+
+	if raw := strings.TrimSpace(params["statusCode"]); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return segment, fmt.Errorf("statusCode 参数格式错误")
+		}
+		if value > 0 {
+			segment.StatusCode = value
+		}
+	}
+	if raw := strings.TrimSpace(params["statusClass"]); raw != "" {
+		statusClass := strings.ToLower(raw)
+		switch statusClass {
+		case "2xx", "3xx", "4xx", "5xx":
+			segment.StatusClass = statusClass
+		default:
+			return segment, fmt.Errorf("statusClass 参数无效")
+		}
+	}
+
+	boolParams := []struct {
+		name   string
+		target *bool
+	}{
+		{"excludeInternal", &segment.ExcludeInternal},
+		{"excludeSpider", &segment.ExcludeSpider},
+		{"excludeForeign", &segment.ExcludeForeign},
+	}
+	for _, param := range boolParams {
+		raw, ok := params[param.name]
+		if !ok || raw == "" {
+			continue
+		}
+		switch strings.ToLower(raw) {
+		case "true", "1":
+			*param.target = true
+		case "false", "0":
+			*param.target = false
+		default:
+			return segment, fmt.Errorf("%s 参数无效", param.name)
+		}
+	}
+
+	segment.IP = strings.TrimSpace(params["ipFilter"])
+	segment.Location = strings.TrimSpace(params["locationFilter"])
+	segment.URL = strings.TrimSpace(params["urlFilter"])
+	segment.Browser = strings.TrimSpace(params["browserFilter"])
+	segment.OS = strings.TrimSpace(params["osFilter"])
+	segment.Referer = strings.TrimSpace(params["refererFilter"])
+	segment.Device = strings.TrimSpace(params["deviceFilter"])
+	if label, ok := segmentDeviceAliases[strings.ToLower(segment.Device)]; ok {
+		segment.Device = label
+	}
+
+	return segment, nil
+}
+
+// conditions 生成分群的 WHERE 条件，column 负责把逻辑列名映射为查询中的实际列。
+func (s Segment) conditions(column func(name string) string) ([]string, []interface{}) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if s.IP != "" {
+		conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column("ip")))
+		args = append(args, "%"+s.IP+"%")
+	}
+	if s.Location != "" {
+		conditions = append(conditions, fmt.Sprintf("(%s LIKE ? OR %s LIKE ?)",
+			column("domestic_location"), column("global_location")))
+		locationArg := "%" + s.Location + "%"
+		args = append(args, locationArg, locationArg)
+	}
+	if s.URL != "" {
+		conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column("url")))
+		args = append(args, urlFilterPattern(s.URL))
+	}
+	if s.StatusCode > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s = ?", column("status_code")))
+		args = append(args, s.StatusCode)
+	} else if s.StatusClass != "" {
+		low := int(s.StatusClass[0]-'0') * 100
+		conditions = append(conditions, fmt.Sprintf("%s >= %d AND %s < %d",
+			column("status_code"), low, column("status_code"), low+100))
+	}
+	if s.ExcludeInternal {
+		internalCondition, internalArgs := buildInternalIPCondition(column("ip"))
+		conditions = append(conditions, fmt.Sprintf("NOT %s", internalCondition))
+		args = append(args, internalArgs...)
+	}
+	if s.ExcludeSpider {
+		conditions = append(conditions, fmt.Sprintf("%s <> ?", column("user_device")))
+		args = append(args, botDeviceLabel)
+	}
+	if s.ExcludeForeign {
+		conditions = append(conditions, fmt.Sprintf("(%s = ? OR LOWER(%s) = ?)", column("global_location"), column("global_location")))
+		args = append(args, "中国", "china")
+	}
+	if s.Device != "" {
+		conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column("user_device")))
+		args = append(args, "%"+s.Device+"%")
+	}
+	if s.Browser != "" {
+		conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column("user_browser")))
+		args = append(args, "%"+s.Browser+"%")
+	}
+	if s.OS != "" {
+		conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column("user_os")))
+		args = append(args, "%"+s.OS+"%")
+	}
+	if s.Referer != "" {
+		conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column("referer")))
+		args = append(args, "%"+s.Referer+"%")
+	}
+
+	return conditions, args
+}
+
+// filter 为以 alias 为别名的日志表生成分群所需的维表关联与附加条件（以 " AND " 开头），
+// 维表使用 seg_ 前缀的别名，避免与调用方已有的关联冲突。
+func (s Segment) filter(websiteID, alias string) (string, string, []interface{}) {
+	if s.IsEmpty() {
+		return "", "", nil
+	}
+
+	dims := make(map[string]bool)
+	column := func(name string) string {
+		switch name {
+		case "ip":
+			dims["ip"] = true
+			return "seg_ip.ip"
+		case "url":
+			dims["url"] = true
+			return "seg_u.url"
+		case "referer":
+			dims["referer"] = true
+			return "seg_r.referer"
+		case "user_browser", "user_os", "user_device":
+			dims["ua"] = true
+			return "seg_ua." + strings.TrimPrefix(name, "user_")
+		case "domestic_location":
+			dims["location"] = true
+			return "seg_loc.domestic"
+		case "global_location":
+			dims["location"] = true
+			return "seg_loc.global"
+		default:
+			return fmt.Sprintf("%s.%s", alias, name)
+		}
+	}
+	conditions, args := s.conditions(column)
+
+	joinDefs := map[string]string{
+		"ip":       `JOIN "%s_dim_ip" seg_ip ON seg_ip.id = %s.ip_id`,
+		"url":      `JOIN "%s_dim_url" seg_u ON seg_u.id = %s.url_id`,
+		"referer":  `JOIN "%s_dim_referer" seg_r ON seg_r.id = %s.referer_id`,
+		"ua":       `JOIN "%s_dim_ua" seg_ua ON seg_ua.id = %s.ua_id`,
+		"location": `JOIN "%s_dim_location" seg_loc ON seg_loc.id = %s.location_id`,
+	}
+	names := make([]string, 0, len(dims))
+	for name := range dims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var joins strings.Builder
+	for _, name := range names {
+		joins.WriteString(" ")
+		joins.WriteString(fmt.Sprintf(joinDefs[name], websiteID, alias))
+	}
+	condition := ""
+	if len(conditions) > 0 {
+		condition = " AND " + strings.Join(conditions, " AND ")
+	}
+	return joins.String(), condition, args
+}
+
+// args 返回分群条件的参数，与站点无关，供 unionAllSites 按站点重复使用。
+func (s Segment) args() []interface{} {
+	_, args := s.conditions(func(name string) string { return name })
+	return args
+}
+
+// urlFilterPattern 含 * 时按通配符整体匹配（/api/* 只匹配 /api/ 开头的地址），否则按子串匹配。
+func urlFilterPattern(value string) string {
+	if !strings.Contains(value, "*") {
+		return "%" + value + "%"
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return strings.ReplaceAll(escaper.Replace(value), "*", "%")
+}
+
+type rawLogTotals struct {
+	PV      int
+	UV      int
+	Traffic int64
+	Status  StatusCodeHits
+}
+
+// queryRawLogTotals 直接扫描原始日志统计 PV、UV、流量与状态码，口径与聚合表一致：
+// PV、流量、UV 只计页面浏览，状态码计全部请求。
+func queryRawLogTotals(
+	repo *store.Repository, sites []string, segment Segment, startTs, endTs int64,
+) (rawLogTotals, error) {
+	totals := rawLogTotals{}
+	union, args := unionAllSites(sites, func(websiteID string) string {
+		visitor, visitorJoin := visitorColumn(sites, websiteID, "l")
+		segJoin, segCondition, _ := segment.filter(websiteID, "l")
+		return fmt.Sprintf(
+			`SELECT l.pageview_flag, l.bytes_sent, l.status_code, l.sample_weight, %s AS visitor FROM "%s_nginx_logs" l %s%s WHERE l.timestamp >= ? AND l.timestamp < ?%s`,
+			visitor, websiteID, visitorJoin, segJoin, segCondition,
+		)
+	}, append([]interface{}{startTs, endTs}, segment.args()...)...)
+
+	query := sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT
+            COALESCE(SUM(CASE WHEN pageview_flag = 1 THEN sample_weight ELSE 0 END), 0) AS pv,
+            COALESCE(SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_weight ELSE 0 END), 0) AS traffic,
+            COUNT(DISTINCT CASE WHEN pageview_flag = 1 THEN visitor END) AS uv,
+            COALESCE(SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_weight ELSE 0 END), 0) AS s2xx,
+            COALESCE(SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_weight ELSE 0 END), 0) AS s3xx,
+            COALESCE(SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_weight ELSE 0 END), 0) AS s4xx,
+            COALESCE(SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_weight ELSE 0 END), 0) AS s5xx,
+            COALESCE(SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_weight ELSE 0 END), 0) AS other
+        FROM (
+        %s
+        ) t`,
+		union))
+
+	row := repo.GetDB().QueryRow(query, args...)
+	if err := row.Scan(
+		&totals.PV, &totals.Traffic, &totals.UV,
+		&totals.Status.S2xx, &totals.Status.S3xx, &totals.Status.S4xx, &totals.Status.S5xx, &totals.Status.Other,
+	); err != nil {
+		return totals, fmt.Errorf("查询原始日志统计失败: %v", err)
+	}
+	return totals, nil
+}
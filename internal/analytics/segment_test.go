@@ -0,0 +1,112 @@
+package analytics
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSegment(t *testing.T) {
+	segment, err := parseSegment(map[string]string{
+		"statusClass":    "4XX",
+		"excludeSpider":  "1",
+		"excludeForeign": "false",
+		"deviceFilter":   " Mobile ",
+		"urlFilter":      " /api/* ",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Segment{StatusClass: "4xx", ExcludeSpider: true, Device: "手机", URL: "/api/*"}
+	if segment != want {
+		t.Fatalf("segment = %+v, want %+v", segment, want)
+	}
+
+	for name, params := range map[string]map[string]string{
+		"status code":  {"statusCode": "abc"},
+		"status class": {"statusClass": "6xx"},
+		"bool":         {"excludeInternal": "yes"},
+	} {
+		if _, err := parseSegment(params); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if segment, err := parseSegment(map[string]string{"statusCode": "0", "deviceFilter": "watch"}); err != nil || segment.StatusCode != 0 || segment.Device != "watch" {
+		t.Fatalf("unexpected segment %+v err=%v", segment, err)
+	}
+}
+
+func TestSegmentFilter(t *testing.T) {
+	if joins, condition, args := (Segment{}).filter("ab12", "l"); joins != "" || condition != "" || args != nil {
+		t.Fatalf("empty segment should not filter: %q %q %v", joins, condition, args)
+	}
+
+	segment := Segment{
+		StatusClass:   "5xx",
+		ExcludeSpider: true,
+		Location:      "德国",
+		URL:           "/api/*",
+		Device:        "手机",
+	}
+	joins, condition, args := segment.filter("ab12", "l")
+	wantJoins := ` JOIN "ab12_dim_location" seg_loc ON seg_loc.id = l.location_id` +
+		` JOIN "ab12_dim_ua" seg_ua ON seg_ua.id = l.ua_id` +
+		` JOIN "ab12_dim_url" seg_u ON seg_u.id = l.url_id`
+	if joins != wantJoins {
+		t.Fatalf("joins = %q", joins)
+	}
+	wantCondition := " AND (seg_loc.domestic LIKE ? OR seg_loc.global LIKE ?)" +
+		" AND seg_u.url LIKE ?" +
+		" AND l.status_code >= 500 AND l.status_code < 600" +
+		" AND seg_ua.device <> ?" +
+		" AND seg_ua.device LIKE ?"
+	if condition != wantCondition {
+		t.Fatalf("condition = %q", condition)
+	}
+	wantArgs := []interface{}{"%德国%", "%德国%", "/api/%", botDeviceLabel, "%手机%"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("args = %v", args)
+	}
+	if !reflect.DeepEqual(segment.args(), wantArgs) {
+		t.Fatalf("args() should match filter args, got %v", segment.args())
+	}
+	if strings.Count(condition, "?") != len(args) {
+		t.Fatalf("placeholder count %d does not match %d args", strings.Count(condition, "?"), len(args))
+	}
+
+	// 状态码优先于状态码区间，内网排除使用参数化条件
+	joins, condition, args = Segment{StatusCode: 404, StatusClass: "4xx", ExcludeInternal: true}.filter("ab12", "logs")
+	if !strings.Contains(joins, `"ab12_dim_ip" seg_ip ON seg_ip.id = logs.ip_id`) {
+		t.Fatalf("joins = %q", joins)
+	}
+	if !strings.HasPrefix(condition, " AND logs.status_code = ? AND NOT (seg_ip.ip LIKE ?") || strings.Contains(condition, ">=") {
+		t.Fatalf("condition = %q", condition)
+	}
+	if args[0] != 404 || strings.Count(condition, "?") != len(args) {
+		t.Fatalf("args = %v", args)
+	}
+}
+
+func TestURLFilterPattern(t *testing.T) {
+	cases := map[string]string{
+		"/api":     "%/api%",
+		"/api/*":   "/api/%",
+		"*/v1_*":   `%/v1\_%`,
+		`/a%b\c/*`: `/a\%b\\c/%`,
+		"*.php":    "%.php",
+	}
+	for input, want := range cases {
+		if got := urlFilterPattern(input); got != want {
+			t.Errorf("urlFilterPattern(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestAnomaliesRejectSegment(t *testing.T) {
+	manager := NewAnomaliesStatsManager(nil)
+	_, err := manager.Query(StatsQuery{Segment: Segment{ExcludeSpider: true}})
+	if !errors.Is(err, ErrAnomaliesSegment) {
+		t.Fatalf("expected ErrAnomaliesSegment, got %v", err)
+	}
+}
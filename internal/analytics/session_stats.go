@@ -37,6 +37,7 @@ type SessionsStats struct {
 		PageSize int `json:"pageSize"`
 		Pages    int `json:"pages"`
 	} `json:"pagination"`
+	QueryMeta
 }
 
 // GetType 实现 StatsResult 接口
@@ -58,17 +59,13 @@ func NewSessionsStatsManager(userRepoPtr *store.Repository) *SessionsStatsManage
 
 // Query 实现 StatsManager 接口
 func (m *SessionsStatsManager) Query(query StatsQuery) (StatsResult, error) {
-	result := SessionsStats{}
+	result := SessionsStats{QueryMeta: newQueryMeta(query.Segment, QueryPathRawLogs)}
 
 	page := 1
 	pageSize := 100
 	var timeRange string
 	var timeStart int64
 	var timeEnd int64
-	var ipFilter string
-	var deviceFilter string
-	var browserFilter string
-	var osFilter string
 
 	if pageVal, ok := query.ExtraParam["page"].(int); ok && pageVal > 0 {
 		page = pageVal
@@ -96,18 +93,7 @@ func (m *SessionsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 		timeEnd = parsed
 	}
-	if ipFilterVal, ok := query.ExtraParam["ipFilter"].(string); ok {
-		ipFilter = strings.TrimSpace(ipFilterVal)
-	}
-	if deviceFilterVal, ok := query.ExtraParam["deviceFilter"].(string); ok {
-		deviceFilter = strings.TrimSpace(deviceFilterVal)
-	}
-	if browserFilterVal, ok := query.ExtraParam["browserFilter"].(string); ok {
-		browserFilter = strings.TrimSpace(browserFilterVal)
-	}
-	if osFilterVal, ok := query.ExtraParam["osFilter"].(string); ok {
-		osFilter = strings.TrimSpace(osFilterVal)
-	}
+	segmentJoin, segmentCondition, segmentArgs := query.Segment.filter(query.WebsiteID, "l")
 
 	var queryBuilder strings.Builder
 	queryBuilder.WriteString(fmt.Sprintf(`
@@ -117,8 +103,8 @@ func (m *SessionsStatsManager) Query(query StatsQuery) (StatsResult, error) {
         JOIN "%s_dim_ip" ip ON ip.id = l.ip_id
         JOIN "%s_dim_ua" ua ON ua.id = l.ua_id
         JOIN "%s_dim_url" u ON u.id = l.url_id
-        JOIN "%s_dim_location" loc ON loc.id = l.location_id`,
-		query.WebsiteID, query.WebsiteID, query.WebsiteID, query.WebsiteID, query.WebsiteID))
+        JOIN "%s_dim_location" loc ON loc.id = l.location_id%s`,
+		query.WebsiteID, query.WebsiteID, query.WebsiteID, query.WebsiteID, query.WebsiteID, segmentJoin))
 
 	conditions := make([]string, 0, 4)
 	args := make([]interface{}, 0, 6)
@@ -140,23 +126,10 @@ func (m *SessionsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		conditions = append(conditions, "timestamp <= ?")
 		args = append(args, timeEnd)
 	}
-	if ipFilter != "" {
-		conditions = append(conditions, "ip.ip LIKE ?")
-		args = append(args, "%"+ipFilter+"%")
+	if segmentCondition != "" {
+		conditions = append(conditions, strings.TrimPrefix(segmentCondition, " AND "))
+		args = append(args, segmentArgs...)
 	}
-	if deviceFilter != "" {
-		conditions = append(conditions, "ua.device LIKE ?")
-		args = append(args, "%"+deviceFilter+"%")
-	}
-	if browserFilter != "" {
-		conditions = append(conditions, "ua.browser LIKE ?")
-		args = append(args, "%"+browserFilter+"%")
-	}
-	if osFilter != "" {
-		conditions = append(conditions, "ua.os LIKE ?")
-		args = append(args, "%"+osFilter+"%")
-	}
-
 	if len(conditions) > 0 {
 		queryBuilder.WriteString(" WHERE ")
 		queryBuilder.WriteString(strings.Join(conditions, " AND "))
@@ -13,6 +13,7 @@ type SessionSummary struct {
 	BounceCount        int     `json:"bounceCount"`
 	BounceRate         float64 `json:"bounceRate"`
 	AvgDurationSeconds int64   `json:"avgDurationSeconds"`
+	QueryMeta
 }
 
 func (s SessionSummary) GetType() string {
@@ -30,7 +31,7 @@ func NewSessionSummaryStatsManager(userRepoPtr *store.Repository) *SessionSummar
 }
 
 func (m *SessionSummaryStatsManager) Query(query StatsQuery) (StatsResult, error) {
-	result := SessionSummary{}
+	result := SessionSummary{QueryMeta: newQueryMeta(query.Segment, QueryPathRawLogs)}
 
 	timeRange, ok := query.ExtraParam["timeRange"].(string)
 	if !ok || timeRange == "" {
@@ -43,14 +44,15 @@ func (m *SessionSummaryStatsManager) Query(query StatsQuery) (StatsResult, error
 	}
 
 	tableName := fmt.Sprintf("%s_nginx_logs", query.WebsiteID)
+	segmentJoin, segmentCondition, segmentArgs := query.Segment.filter(query.WebsiteID, "l")
 	rows, err := m.repo.GetDB().Query(
 		sqlutil.ReplacePlaceholders(fmt.Sprintf(`
-        SELECT timestamp, ip_id, ua_id
-        FROM "%s"
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?
-        ORDER BY ip_id, ua_id, timestamp`,
-			tableName)),
-		startTime.Unix(), endTime.Unix(),
+        SELECT l.timestamp, l.ip_id, l.ua_id
+        FROM "%s" l%s
+        WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s
+        ORDER BY l.ip_id, l.ua_id, l.timestamp`,
+			tableName, segmentJoin, segmentCondition)),
+		append([]interface{}{startTime.Unix(), endTime.Unix()}, segmentArgs...)...,
 	)
 	if err != nil {
 		return result, fmt.Errorf("查询会话摘要失败: %v", err)
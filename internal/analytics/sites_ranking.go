@@ -0,0 +1,234 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+	"github.com/sirupsen/logrus"
+)
+
+type SiteRankingItem struct {
+	WebsiteID      string `json:"websiteId"`
+	Name           string `json:"name"`
+	PV             int    `json:"pv"`
+	UV             int    `json:"uv"`
+	Traffic        int64  `json:"traffic"`
+	S4xx           int    `json:"s4xx"`
+	S5xx           int    `json:"s5xx"`
+	PVPercent      int    `json:"pvPercent"`      // 占范围内总 PV 的百分比
+	TrafficPercent int    `json:"trafficPercent"` // 占范围内总流量的百分比
+	PrevPV         int    `json:"prevPv"`         // 上一期 PV
+	PrevUV         int    `json:"prevUv"`         // 上一期 UV
+}
+
+type SitesRankingTotal struct {
+	PV      int   `json:"pv"`
+	UV      int   `json:"uv"` // 跨站点按 IP 去重
+	Traffic int64 `json:"traffic"`
+}
+
+type SitesRankingStats struct {
+	Scope  string            `json:"scope"`
+	SortBy string            `json:"sortBy"`
+	Total  SitesRankingTotal `json:"total"`
+	Sites  []SiteRankingItem `json:"sites"`
+	// Comparison 为对比期的站点差异，仅在传入对比参数时返回
+	Comparison *SitesRankingComparison `json:"comparison,omitempty"`
+	QueryMeta
+}
+
+// SitesRankingStats 实现 StatsResult 接口
+func (s SitesRankingStats) GetType() string {
+	return "sites_ranking"
+}
+
+type SitesRankingStatsManager struct {
+	repo    *store.Repository
+	overall *OverallStatsManager
+}
+
+// NewSitesRankingStatsManager 创建站点排行统计管理器
+func NewSitesRankingStatsManager(userRepoPtr *store.Repository) *SitesRankingStatsManager {
+	return &SitesRankingStatsManager{
+		repo:    userRepoPtr,
+		overall: NewOverallStatsManager(userRepoPtr),
+	}
+}
+
+// 实现 StatsManager 接口
+func (s *SitesRankingStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	sortBy, _ := query.ExtraParam["sortBy"].(string)
+	if sortBy == "" {
+		sortBy = "pv"
+	}
+	result := SitesRankingStats{
+		Scope:  query.WebsiteID,
+		SortBy: sortBy,
+		Sites:  make([]SiteRankingItem, 0),
+	}
+	segment := query.Segment
+	result.QueryMeta = newQueryMeta(segment, segment.queryPath())
+
+	timeRange := query.ExtraParam["timeRange"].(string)
+	startTime, endTime, err := timeutil.TimePeriod(timeRange)
+	if err != nil {
+		return result, err
+	}
+	sites := query.Sites()
+
+	items, err := s.rankingItems(sites, segment, startTime, endTime)
+	if err != nil {
+		return result, fmt.Errorf("获取站点排行失败: %v", err)
+	}
+
+	prevStart, prevEnd := previousTimeRange(timeRange)
+	if !prevStart.IsZero() && !prevEnd.IsZero() {
+		prevItems, err := s.rankingItems(sites, segment, prevStart, prevEnd)
+		if err != nil {
+			logrus.WithError(err).Warn("获取上一期站点排行失败")
+		} else {
+			for websiteID, prev := range prevItems {
+				if item, ok := items[websiteID]; ok {
+					item.PrevPV = prev.PV
+					item.PrevUV = prev.UV
+				}
+			}
+		}
+	}
+
+	overall := OverallStats{}
+	if err := s.overall.statsByTimeRangeForSites(sites, segment, startTime, endTime, &overall); err != nil {
+		return result, fmt.Errorf("获取站点汇总失败: %v", err)
+	}
+	result.Total = SitesRankingTotal{
+		PV:      overall.PV,
+		UV:      overall.UV,
+		Traffic: overall.Traffic,
+	}
+
+	for _, websiteID := range sites {
+		item := items[websiteID]
+		if result.Total.PV > 0 {
+			item.PVPercent = int(math.Round(float64(item.PV) / float64(result.Total.PV) * 100))
+		}
+		if result.Total.Traffic > 0 {
+			item.TrafficPercent = int(math.Round(float64(item.Traffic) / float64(result.Total.Traffic) * 100))
+		}
+		result.Sites = append(result.Sites, *item)
+	}
+
+	sort.SliceStable(result.Sites, func(i, j int) bool {
+		a, b := result.Sites[i], result.Sites[j]
+		switch sortBy {
+		case "uv":
+			return a.UV > b.UV
+		case "traffic":
+			return a.Traffic > b.Traffic
+		default:
+			return a.PV > b.PV
+		}
+	})
+
+	return result, nil
+}
+
+// rankingItems 一次查询取出各站点的 PV/流量/错误数与 UV（站点内按 ip_id 去重）；
+// 设置了分群条件时逐站点扫描原始日志。
+func (s *SitesRankingStatsManager) rankingItems(
+	sites []string, segment Segment, startTime, endTime time.Time) (map[string]*SiteRankingItem, error) {
+
+	items := make(map[string]*SiteRankingItem, len(sites))
+	for _, websiteID := range sites {
+		name := websiteID
+		if website, ok := config.GetWebsiteByID(websiteID); ok {
+			name = website.Name
+		}
+		items[websiteID] = &SiteRankingItem{WebsiteID: websiteID, Name: name}
+	}
+
+	if !segment.IsEmpty() {
+		for _, websiteID := range sites {
+			totals, err := queryRawLogTotals(s.repo, []string{websiteID}, segment, startTime.Unix(), endTime.Unix())
+			if err != nil {
+				return items, err
+			}
+			item := items[websiteID]
+			item.PV = totals.PV
+			item.UV = totals.UV
+			item.Traffic = totals.Traffic
+			item.S4xx = totals.Status.S4xx
+			item.S5xx = totals.Status.S5xx
+		}
+		return items, nil
+	}
+
+	startDay := dayBucket(startTime)
+	endDay := dayBucket(endTime)
+
+	aggUnion, aggArgs := unionAllSiteQueries(sites, func(websiteID string) (string, []interface{}) {
+		return fmt.Sprintf(
+			`SELECT ?::text AS site, COALESCE(SUM(pv), 0) AS pv, COALESCE(SUM(traffic), 0) AS traffic,
+                COALESCE(SUM(s4xx), 0) AS s4xx, COALESCE(SUM(s5xx), 0) AS s5xx
+            FROM "%s_agg_daily" WHERE day >= ? AND day <= ?`,
+			websiteID,
+		), []interface{}{websiteID, startDay, endDay}
+	})
+	rows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(aggUnion), aggArgs...)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			websiteID  string
+			pv         int64
+			traffic    int64
+			s4xx, s5xx int64
+		)
+		if err := rows.Scan(&websiteID, &pv, &traffic, &s4xx, &s5xx); err != nil {
+			return items, err
+		}
+		if item, ok := items[websiteID]; ok {
+			item.PV = int(pv)
+			item.Traffic = traffic
+			item.S4xx = int(s4xx)
+			item.S5xx = int(s5xx)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return items, err
+	}
+
+	uvUnion, uvArgs := unionAllSiteQueries(sites, func(websiteID string) (string, []interface{}) {
+		return fmt.Sprintf(
+			`SELECT ?::text AS site, COUNT(DISTINCT ip_id) AS uv FROM "%s_agg_daily_ip" WHERE day >= ? AND day <= ?`,
+			websiteID,
+		), []interface{}{websiteID, startDay, endDay}
+	})
+	uvRows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(uvUnion), uvArgs...)
+	if err != nil {
+		return items, err
+	}
+	defer uvRows.Close()
+	for uvRows.Next() {
+		var websiteID string
+		var uv int
+		if err := uvRows.Scan(&websiteID, &uv); err != nil {
+			return items, err
+		}
+		if item, ok := items[websiteID]; ok {
+			item.UV = uv
+		}
+	}
+	if err := uvRows.Err(); err != nil {
+		return items, err
+	}
+
+	return items, nil
+}
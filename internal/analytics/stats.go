@@ -19,7 +19,13 @@ type StatsResult interface {
 
 // StatsQuery 统计查询的通用参数
 type StatsQuery struct {
-	WebsiteID  string
+	WebsiteID string
+	// WebsiteIDs 为跨站点范围（all / group:<name>）展开后的站点列表，单站点查询时为空
+	WebsiteIDs []string
+	// Segment 为所有统计类型共用的分群条件
+	Segment Segment
+	// Compare 为对比期区间，未传入对比参数时为 nil
+	Compare    *CompareRange
 	ExtraParam map[string]interface{}
 }
 
@@ -77,6 +83,12 @@ func (f *StatsFactory) registerDefaultManagers() {
 	f.managers["session"] = NewSessionsStatsManager(f.repo)
 	f.managers["session_summary"] = NewSessionSummaryStatsManager(f.repo)
 	f.managers["realtime"] = NewRealtimeStatsManager(f.repo)
+
+	f.managers["sites_ranking"] = NewSitesRankingStatsManager(f.repo)
+	f.managers["anomalies"] = NewAnomaliesStatsManager(f.repo)
+	f.managers["campaigns"] = NewCampaignStatsManager(f.repo)
+	f.managers["errors"] = NewErrorStatsManager(f.repo)
+	f.managers["bandwidth"] = NewBandwidthStatsManager(f.repo)
 }
 
 // GetManager 获取指定类型的统计管理器
@@ -105,6 +117,20 @@ func (f *StatsFactory) QueryStats(managerType string, query StatsQuery) (StatsRe
 		return nil, fmt.Errorf("未找到统计管理器: %s", managerType)
 	}
 
+	result, err := f.queryManager(manager, managerType, query)
+	if err != nil || query.Compare == nil {
+		return result, err
+	}
+
+	// 对比期按相同参数再查询一次，结果逐行合并
+	compareResult, err := f.queryManager(manager, managerType, compareQuery(managerType, query))
+	if err != nil {
+		return nil, err
+	}
+	return attachComparison(result, compareResult, *query.Compare)
+}
+
+func (f *StatsFactory) queryManager(manager StatsManager, managerType string, query StatsQuery) (StatsResult, error) {
 	if f.shouldCache(managerType) {
 		// 构建缓存键
 		cacheKey := f.buildCacheKey(managerType, query)
@@ -174,6 +200,9 @@ func (f *StatsFactory) buildCacheKey(managerType string, query StatsQuery) strin
 			}
 		}
 	}
+	if segmentKey := query.Segment.cacheKey(); segmentKey != "" {
+		key = fmt.Sprintf("%s-segment:%s", key, segmentKey)
+	}
 
 	return key
 }
@@ -203,6 +232,11 @@ func (f *StatsFactory) BuildQueryFromRequest(
 		"session":          {"id": "string", "page": "int", "pageSize": "int"},
 		"session_summary":  {"id": "string", "timeRange": "string"},
 		"realtime":         {"id": "string"},
+		"sites_ranking":    {"id": "string", "timeRange": "string"},
+		"anomalies":        {"id": "string", "timeRange": "string"},
+		"campaigns":        {"id": "string", "timeRange": "string", "limit": "int"},
+		"errors":           {"id": "string", "timeRange": "string", "limit": "int"},
+		"bandwidth":        {"id": "string", "timeRange": "string", "limit": "int"},
 	}
 
 	// 检查是否支持的统计类型
@@ -217,6 +251,18 @@ func (f *StatsFactory) BuildQueryFromRequest(
 		return query, err
 	}
 	query.WebsiteID = websiteID
+	if config.IsWebsiteScope(websiteID) {
+		if !rollupStatsTypes[statsType] {
+			return query, fmt.Errorf("统计类型 %s 不支持跨站点范围", statsType)
+		}
+		siteIDs, ok := config.ResolveWebsiteScope(websiteID)
+		if !ok {
+			return query, fmt.Errorf("站点范围不存在或不包含任何站点: %s", websiteID)
+		}
+		query.WebsiteIDs = siteIDs
+	} else if statsType == "sites_ranking" {
+		return query, fmt.Errorf("sites_ranking 需要 all 或 group:<name> 范围")
+	}
 
 	// 处理其他参数
 	for paramName, paramType := range paramDefs {
@@ -251,6 +297,19 @@ func (f *StatsFactory) BuildQueryFromRequest(
 		}
 	}
 
+	segment, err := parseSegment(params)
+	if err != nil {
+		return query, err
+	}
+	query.Segment = segment
+
+	timeRange, _ := query.ExtraParam["timeRange"].(string)
+	compare, err := parseCompareRange(statsType, params, timeRange)
+	if err != nil {
+		return query, err
+	}
+	query.Compare = compare
+
 	// 处理特殊可选参数
 	if statsType == "logs" {
 		if filter, ok := params["filter"]; ok && filter != "" {
@@ -265,64 +324,6 @@ func (f *StatsFactory) BuildQueryFromRequest(
 		if timeEnd, ok := params["timeEnd"]; ok && timeEnd != "" {
 			query.ExtraParam["timeEnd"] = timeEnd
 		}
-		if statusCodeRaw, ok := params["statusCode"]; ok && statusCodeRaw != "" {
-			value, err := strconv.Atoi(statusCodeRaw)
-			if err != nil {
-				return query, fmt.Errorf("statusCode 参数格式错误")
-			}
-			query.ExtraParam["statusCode"] = value
-		}
-		if statusClass, ok := params["statusClass"]; ok && statusClass != "" {
-			valid := map[string]bool{
-				"2xx": true,
-				"3xx": true,
-				"4xx": true,
-				"5xx": true,
-			}
-			if !valid[statusClass] {
-				return query, fmt.Errorf("statusClass 参数无效")
-			}
-			query.ExtraParam["statusClass"] = statusClass
-		}
-		if excludeInternalRaw, ok := params["excludeInternal"]; ok && excludeInternalRaw != "" {
-			switch strings.ToLower(excludeInternalRaw) {
-			case "true", "1":
-				query.ExtraParam["excludeInternal"] = true
-			case "false", "0":
-				query.ExtraParam["excludeInternal"] = false
-			default:
-				return query, fmt.Errorf("excludeInternal 参数无效")
-			}
-		}
-		if excludeSpiderRaw, ok := params["excludeSpider"]; ok && excludeSpiderRaw != "" {
-			switch strings.ToLower(excludeSpiderRaw) {
-			case "true", "1":
-				query.ExtraParam["excludeSpider"] = true
-			case "false", "0":
-				query.ExtraParam["excludeSpider"] = false
-			default:
-				return query, fmt.Errorf("excludeSpider 参数无效")
-			}
-		}
-		if excludeForeignRaw, ok := params["excludeForeign"]; ok && excludeForeignRaw != "" {
-			switch strings.ToLower(excludeForeignRaw) {
-			case "true", "1":
-				query.ExtraParam["excludeForeign"] = true
-			case "false", "0":
-				query.ExtraParam["excludeForeign"] = false
-			default:
-				return query, fmt.Errorf("excludeForeign 参数无效")
-			}
-		}
-		if ipFilter, ok := params["ipFilter"]; ok && ipFilter != "" {
-			query.ExtraParam["ipFilter"] = ipFilter
-		}
-		if locationFilter, ok := params["locationFilter"]; ok && locationFilter != "" {
-			query.ExtraParam["locationFilter"] = locationFilter
-		}
-		if urlFilter, ok := params["urlFilter"]; ok && urlFilter != "" {
-			query.ExtraParam["urlFilter"] = urlFilter
-		}
 		if pageviewOnlyRaw, ok := params["pageviewOnly"]; ok && pageviewOnlyRaw != "" {
 			switch strings.ToLower(pageviewOnlyRaw) {
 			case "true", "1":
@@ -365,18 +366,6 @@ func (f *StatsFactory) BuildQueryFromRequest(
 		if timeEnd, ok := params["timeEnd"]; ok && timeEnd != "" {
 			query.ExtraParam["timeEnd"] = timeEnd
 		}
-		if ipFilter, ok := params["ipFilter"]; ok && ipFilter != "" {
-			query.ExtraParam["ipFilter"] = ipFilter
-		}
-		if deviceFilter, ok := params["deviceFilter"]; ok && deviceFilter != "" {
-			query.ExtraParam["deviceFilter"] = deviceFilter
-		}
-		if browserFilter, ok := params["browserFilter"]; ok && browserFilter != "" {
-			query.ExtraParam["browserFilter"] = browserFilter
-		}
-		if osFilter, ok := params["osFilter"]; ok && osFilter != "" {
-			query.ExtraParam["osFilter"] = osFilter
-		}
 	}
 	if statsType == "overall" {
 		if entryLimit, ok := params["entryLimit"]; ok && entryLimit != "" {
@@ -396,6 +385,68 @@ func (f *StatsFactory) BuildQueryFromRequest(
 			query.ExtraParam["window"] = value
 		}
 	}
+	if statsType == "sites_ranking" {
+		if sortBy, ok := params["sortBy"]; ok && sortBy != "" {
+			value, err := getRequiredStringEnum(params, "sortBy", []string{"pv", "uv", "traffic"})
+			if err != nil {
+				return query, err
+			}
+			query.ExtraParam["sortBy"] = value
+		}
+	}
+	if statsType == "anomalies" {
+		if !query.Segment.IsEmpty() {
+			return query, ErrAnomaliesSegment
+		}
+		if severity, ok := params["severity"]; ok && severity != "" {
+			value, err := getRequiredStringEnum(params, "severity", config.AnomalySeverities)
+			if err != nil {
+				return query, err
+			}
+			query.ExtraParam["severity"] = value
+		}
+		if metric, ok := params["metric"]; ok && metric != "" {
+			value, err := getRequiredStringEnum(params, "metric", anomalyMetricNames)
+			if err != nil {
+				return query, err
+			}
+			query.ExtraParam["metric"] = value
+		}
+	}
+	if statsType == "campaigns" {
+		if groupBy, ok := params["groupBy"]; ok && groupBy != "" {
+			value, err := parseCampaignGroupBy(groupBy)
+			if err != nil {
+				return query, err
+			}
+			query.ExtraParam["groupBy"] = value
+		}
+	}
+	if statsType == "errors" {
+		if status, ok := params["status"]; ok && status != "" {
+			value, err := parseErrorStatusFilter(status)
+			if err != nil {
+				return query, err
+			}
+			query.ExtraParam["status"] = value
+		}
+	}
+	if statsType == "bandwidth" {
+		if class, ok := params["class"]; ok && class != "" {
+			value, err := getRequiredStringEnum(params, "class", bandwidthClasses)
+			if err != nil {
+				return query, err
+			}
+			query.ExtraParam["class"] = value
+		}
+		if sortBy, ok := params["sortBy"]; ok && sortBy != "" {
+			value, err := getRequiredStringEnum(params, "sortBy", bandwidthSortBys)
+			if err != nil {
+				return query, err
+			}
+			query.ExtraParam["sortBy"] = value
+		}
+	}
 	if statsType == "referer_ip" {
 		if sourceKind, ok := params["sourceKind"]; ok && sourceKind != "" {
 			valid := map[string]bool{
@@ -2,11 +2,14 @@ package analytics
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/likaia/nginxpulse/internal/sqlutil"
 	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/likaia/nginxpulse/internal/timeutil"
+	"github.com/sirupsen/logrus"
 )
 
 type StatPoint struct {
@@ -19,6 +22,11 @@ type TimeSeriesStats struct {
 	Visitors  []int    `json:"visitors"`
 	Pageviews []int    `json:"pageviews"`
 	PvMinusUv []int    `json:"pvMinusUv"` // PV - UV
+	// Annotations 为时间范围内检测到的流量异常，Index 对应 Labels 的下标
+	Annotations []TimeSeriesAnnotation `json:"annotations"`
+	// Comparison 为对比期叠加序列，仅在传入对比参数时返回
+	Comparison *TimeSeriesComparison `json:"comparison,omitempty"`
+	QueryMeta
 }
 
 // TimeSeriesStats 实现 StatsResult 接口
@@ -43,13 +51,15 @@ func (s *TimeSeriesStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	viewType := query.ExtraParam["viewType"].(string)
 	timePoints, labels := timeutil.TimePointsAndLabels(timeRange, viewType)
 	result := TimeSeriesStats{
-		Labels:    labels,
-		Visitors:  make([]int, len(timePoints)),
-		Pageviews: make([]int, len(timePoints)),
-		PvMinusUv: make([]int, len(timePoints)),
+		Labels:      labels,
+		Visitors:    make([]int, len(timePoints)),
+		Pageviews:   make([]int, len(timePoints)),
+		PvMinusUv:   make([]int, len(timePoints)),
+		Annotations: make([]TimeSeriesAnnotation, 0),
+		QueryMeta:   newQueryMeta(query.Segment, query.Segment.queryPath()),
 	}
 
-	statPoints, err := s.statsByTimePointsForWebsite(query.WebsiteID, timePoints, viewType)
+	statPoints, err := s.statsByTimePointsForSites(query.Sites(), query.Segment, timePoints, viewType)
 	if err != nil {
 		return result, fmt.Errorf("获取图表数据失败: %v", err)
 	}
@@ -59,12 +69,21 @@ func (s *TimeSeriesStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		result.PvMinusUv[i] = point.PV - point.UV
 	}
 
+	if len(timePoints) > 0 {
+		annotations, err := anomalyAnnotations(s.repo, query.Sites(), bucketBoundaries(timePoints, viewType))
+		if err != nil {
+			logrus.WithError(err).Warn("获取趋势图异常标注失败")
+		} else {
+			result.Annotations = annotations
+		}
+	}
+
 	return result, nil
 }
 
-// statsByTimePointsForWebsite 根据多个时间点批量查询统计数据
-func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
-	websiteID string, timePoints []time.Time, viewType string) ([]StatPoint, error) {
+// statsByTimePointsForSites 根据多个时间点批量查询统计数据，多个站点时 PV 求和、UV 按 IP 去重
+func (s *TimeSeriesStatsManager) statsByTimePointsForSites(
+	sites []string, segment Segment, timePoints []time.Time, viewType string) ([]StatPoint, error) {
 
 	timePointsSize := len(timePoints)
 	results := make([]StatPoint, timePointsSize)
@@ -73,15 +92,19 @@ func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
 		return results, nil
 	}
 
+	if !segment.IsEmpty() {
+		return s.statsFromLogs(sites, segment, bucketBoundaries(timePoints, viewType), results)
+	}
+
 	if viewType == "hourly" {
-		return s.statsByHourlyBuckets(websiteID, timePoints, results)
+		return s.statsByHourlyBuckets(sites, timePoints, results)
 	}
 
-	return s.statsByDailyBuckets(websiteID, timePoints, results)
+	return s.statsByDailyBuckets(sites, timePoints, results)
 }
 
 func (s *TimeSeriesStatsManager) statsByHourlyBuckets(
-	websiteID string, timePoints []time.Time, results []StatPoint) ([]StatPoint, error) {
+	sites []string, timePoints []time.Time, results []StatPoint) ([]StatPoint, error) {
 
 	bucketIndex := make(map[int64]int, len(timePoints))
 	startBucket := hourBucket(timePoints[0])
@@ -92,10 +115,13 @@ func (s *TimeSeriesStatsManager) statsByHourlyBuckets(
 		bucketIndex[bucket] = i
 	}
 
+	pvUnion, pvArgs := unionAllSites(sites, func(websiteID string) string {
+		return fmt.Sprintf(`SELECT bucket, pv FROM "%s_agg_hourly" WHERE bucket >= ? AND bucket <= ?`, websiteID)
+	}, startBucket, endBucket)
 	rows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
-		`SELECT bucket, pv FROM "%s_agg_hourly" WHERE bucket >= ? AND bucket <= ?`,
-		websiteID,
-	)), startBucket, endBucket)
+		`SELECT bucket, SUM(pv) FROM (%s) t GROUP BY bucket`,
+		pvUnion,
+	)), pvArgs...)
 	if err != nil {
 		return results, err
 	}
@@ -114,10 +140,17 @@ func (s *TimeSeriesStatsManager) statsByHourlyBuckets(
 		return results, err
 	}
 
+	uvUnion, uvArgs := unionAllSites(sites, func(websiteID string) string {
+		visitor, join := visitorColumn(sites, websiteID, "a")
+		return fmt.Sprintf(
+			`SELECT a.bucket, %s AS visitor FROM "%s_agg_hourly_ip" a %s WHERE a.bucket >= ? AND a.bucket <= ?`,
+			visitor, websiteID, join,
+		)
+	}, startBucket, endBucket)
 	uvRows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
-		`SELECT bucket, COUNT(*) FROM "%s_agg_hourly_ip" WHERE bucket >= ? AND bucket <= ? GROUP BY bucket`,
-		websiteID,
-	)), startBucket, endBucket)
+		`SELECT bucket, %s FROM (%s) t GROUP BY bucket`,
+		visitorCountExpr(sites), uvUnion,
+	)), uvArgs...)
 	if err != nil {
 		return results, err
 	}
@@ -140,7 +173,7 @@ func (s *TimeSeriesStatsManager) statsByHourlyBuckets(
 }
 
 func (s *TimeSeriesStatsManager) statsByDailyBuckets(
-	websiteID string, timePoints []time.Time, results []StatPoint) ([]StatPoint, error) {
+	sites []string, timePoints []time.Time, results []StatPoint) ([]StatPoint, error) {
 
 	dayIndex := make(map[string]int, len(timePoints))
 	startDay := dayBucket(timePoints[0])
@@ -151,10 +184,13 @@ func (s *TimeSeriesStatsManager) statsByDailyBuckets(
 		dayIndex[day] = i
 	}
 
+	pvUnion, pvArgs := unionAllSites(sites, func(websiteID string) string {
+		return fmt.Sprintf(`SELECT day, pv FROM "%s_agg_daily" WHERE day >= ? AND day <= ?`, websiteID)
+	}, startDay, endDay)
 	rows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
-		`SELECT day, pv FROM "%s_agg_daily" WHERE day >= ? AND day <= ?`,
-		websiteID,
-	)), startDay, endDay)
+		`SELECT day, SUM(pv) FROM (%s) t GROUP BY day`,
+		pvUnion,
+	)), pvArgs...)
 	if err != nil {
 		return results, err
 	}
@@ -174,10 +210,17 @@ func (s *TimeSeriesStatsManager) statsByDailyBuckets(
 		return results, err
 	}
 
+	uvUnion, uvArgs := unionAllSites(sites, func(websiteID string) string {
+		visitor, join := visitorColumn(sites, websiteID, "a")
+		return fmt.Sprintf(
+			`SELECT a.day, %s AS visitor FROM "%s_agg_daily_ip" a %s WHERE a.day >= ? AND a.day <= ?`,
+			visitor, websiteID, join,
+		)
+	}, startDay, endDay)
 	uvRows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
-		`SELECT day, COUNT(*) FROM "%s_agg_daily_ip" WHERE day >= ? AND day <= ? GROUP BY day`,
-		websiteID,
-	)), startDay, endDay)
+		`SELECT day, %s FROM (%s) t GROUP BY day`,
+		visitorCountExpr(sites), uvUnion,
+	)), uvArgs...)
 	if err != nil {
 		return results, err
 	}
@@ -200,6 +243,69 @@ func (s *TimeSeriesStatsManager) statsByDailyBuckets(
 	return results, nil
 }
 
+// statsFromLogs 在原始日志上按桶统计 PV/UV，boundaries 为各桶起点加上最后一个桶的终点，
+// 桶划分与聚合表一致（本地时区的整点 / 自然日）。
+func (s *TimeSeriesStatsManager) statsFromLogs(
+	sites []string, segment Segment, boundaries []int64, results []StatPoint) ([]StatPoint, error) {
+
+	thresholds := make([]string, 0, len(boundaries))
+	for _, boundary := range boundaries {
+		thresholds = append(thresholds, strconv.FormatInt(boundary, 10))
+	}
+	bucketExpr := fmt.Sprintf("width_bucket(l.timestamp, ARRAY[%s]::bigint[])", strings.Join(thresholds, ","))
+
+	union, args := unionAllSites(sites, func(websiteID string) string {
+		visitor, visitorJoin := visitorColumn(sites, websiteID, "l")
+		segmentJoin, segmentCondition, _ := segment.filter(websiteID, "l")
+		return fmt.Sprintf(
+			`SELECT %s AS idx, %s AS visitor, l.sample_weight AS weight FROM "%s_nginx_logs" l %s%s WHERE l.pageview_flag = 1 AND l.timestamp >= ? AND l.timestamp < ?%s`,
+			bucketExpr, visitor, websiteID, visitorJoin, segmentJoin, segmentCondition,
+		)
+	}, append([]interface{}{boundaries[0], boundaries[len(boundaries)-1]}, segment.args()...)...)
+
+	rows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT idx, SUM(weight), COUNT(DISTINCT visitor) FROM (%s) t GROUP BY idx`,
+		union,
+	)), args...)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var idx, pv, uv int
+		if err := rows.Scan(&idx, &pv, &uv); err != nil {
+			return results, err
+		}
+		// width_bucket 返回 1..n 对应第 idx-1 个时间点
+		if idx >= 1 && idx <= len(results) {
+			results[idx-1] = StatPoint{PV: pv, UV: uv}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return results, err
+	}
+
+	return results, nil
+}
+
+// bucketBoundaries 返回时间点对应桶的起止边界（Unix 秒）
+func bucketBoundaries(timePoints []time.Time, viewType string) []int64 {
+	boundaries := make([]int64, 0, len(timePoints)+1)
+	if viewType == "hourly" {
+		for _, point := range timePoints {
+			boundaries = append(boundaries, hourBucket(point))
+		}
+		return append(boundaries, boundaries[len(boundaries)-1]+3600)
+	}
+	for _, point := range timePoints {
+		local := point.In(time.Local)
+		boundaries = append(boundaries, time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local).Unix())
+	}
+	last := timePoints[len(timePoints)-1].In(time.Local)
+	next := time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, time.Local)
+	return append(boundaries, next.Unix())
+}
+
 func hourBucket(ts time.Time) int64 {
 	local := ts.In(time.Local)
 	start := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, local.Location())
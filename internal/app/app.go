@@ -46,7 +46,7 @@ func Run() error {
 	defer cancel()
 
 	if setupMode {
-		serverHandle, err := server.StartHTTPServer(nil, nil, cfg.Server.Port)
+		serverHandle, err := server.StartHTTPServer(nil, nil, cfg.Server)
 		if err != nil {
 			return err
 		}
@@ -66,8 +66,9 @@ func Run() error {
 
 	logParser := ingest.NewLogParser(repository)
 	statsFactory := analytics.NewStatsFactory(repository)
+	registerReloadHooks(repository, logParser, statsFactory)
 
-	serverHandle, err := server.StartHTTPServer(statsFactory, logParser, cfg.Server.Port)
+	serverHandle, err := server.StartHTTPServer(statsFactory, logParser, cfg.Server)
 	if err != nil {
 		return err
 	}
@@ -81,12 +82,14 @@ func Run() error {
 	}
 
 	go worker.RunScheduler(ctx, logParser, interval)
+	go worker.RunReportScheduler(ctx, worker.NewReporter(statsFactory))
+	go runConfigReloaders(ctx, repository)
 
 	return waitForShutdown(cancel, serverHandle)
 }
 
 func printStartupNotice(cfg *config.Config) {
-	accessAddr := formatAccessAddr(cfg.Server.Port)
+	accessAddr := formatAccessAddr(cfg.Server.Port, cfg.Server.TLSEnabled())
 	configPath := resolveConfigPath()
 	dataDir := resolveDataDir()
 	accessKeyStatus := "否"
@@ -102,10 +105,14 @@ func printStartupNotice(cfg *config.Config) {
 	fmt.Fprintln(os.Stdout, "================================")
 }
 
-func formatAccessAddr(addr string) string {
+func formatAccessAddr(addr string, useTLS bool) string {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
 	addr = strings.TrimSpace(addr)
 	if addr == "" {
-		return "http://localhost"
+		return scheme + "://localhost"
 	}
 
 	host := ""
@@ -134,25 +141,29 @@ func formatAccessAddr(addr string) string {
 	}
 
 	if port == "" {
-		return "http://" + host
+		return scheme + "://" + host
 	}
 	if strings.Contains(host, ":") {
 		host = "[" + host + "]"
 	}
-	return fmt.Sprintf("http://%s:%s", host, port)
+	return fmt.Sprintf("%s://%s:%s", scheme, host, port)
 }
 
 func resolveConfigPath() string {
-	if _, err := os.Stat(config.ConfigFile); err == nil {
-		if abs, err := filepath.Abs(config.ConfigFile); err == nil {
-			return abs
+	configFile := config.ResolveConfigFile()
+	if _, err := os.Stat(configFile); err == nil {
+		if abs, err := filepath.Abs(configFile); err == nil {
+			configFile = abs
+		}
+		if includes := len(config.IncludeFiles()); includes > 0 {
+			return fmt.Sprintf("%s（另有 conf.d 下 %d 个文件）", configFile, includes)
 		}
-		return config.ConfigFile
+		return configFile
 	}
 	if config.HasEnvConfigSource() {
 		return "CONFIG_JSON/WEBSITES (env)"
 	}
-	return config.ConfigFile
+	return configFile
 }
 
 func resolveDataDir() string {
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const configWatchInterval = 3 * time.Second
+
+// registerReloadHooks 把配置热加载接入各运行中的模块：新增站点先建表再替换配置，
+// 替换后刷新解析器规则并清空统计缓存。
+func registerReloadHooks(repository *store.Repository, logParser *ingest.LogParser, statsFactory *analytics.StatsFactory) {
+	config.OnReload(func(next *config.Config, diff *config.ReloadDiff) error {
+		for _, ref := range diff.WebsitesAdded {
+			if err := repository.EnsureWebsiteSchema(ref.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(cfg *config.Config, diff *config.ReloadDiff) {
+		logParser.ReloadConfig(cfg, diff)
+		statsFactory.ClearCache()
+	})
+}
+
+// runConfigReloaders 监听 SIGHUP 与配置文件变化（system.watchConfig），触发配置热加载。
+func runConfigReloaders(ctx context.Context, repository *store.Repository) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+	lastStat := statConfigFile()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			reloadConfig(repository, "SIGHUP")
+			lastStat = statConfigFile()
+		case <-ticker.C:
+			current := statConfigFile()
+			if current == lastStat {
+				continue
+			}
+			lastStat = current
+			if !config.ReadConfig().System.WatchConfig || current == "" {
+				continue
+			}
+			reloadConfig(repository, "文件变化")
+		}
+	}
+}
+
+// configFileStat 汇总主配置与 conf.d 下文件的路径、修改时间与大小，任一文件增删改都会变化
+type configFileStat string
+
+func statConfigFile() configFileStat {
+	var builder strings.Builder
+	for _, path := range config.ConfigFiles() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&builder, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+	}
+	return configFileStat(builder.String())
+}
+
+func reloadConfig(repository *store.Repository, trigger string) {
+	diff, err := config.ReloadConfig()
+	recordReloadAudit(repository, trigger, diff, err)
+	if err != nil {
+		logrus.WithField("trigger", trigger).WithError(err).Warn("配置热加载失败，继续使用当前配置")
+		return
+	}
+	if !diff.Changed() {
+		logrus.WithField("trigger", trigger).Debug("配置无变化")
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"trigger":         trigger,
+		"added":           len(diff.WebsitesAdded),
+		"removed":         len(diff.WebsitesRemoved),
+		"changed":         len(diff.WebsitesChanged),
+		"pvFilter":        diff.PVFilterChanged,
+		"websiteGroups":   diff.WebsiteGroupsChanged,
+		"reports":         diff.ReportsChanged,
+		"restartRequired": diff.RestartRequired,
+	}).Info("配置已热加载")
+}
+
+// recordReloadAudit 把非接口触发的热加载写入审计日志，操作者记为 system
+func recordReloadAudit(repository *store.Repository, trigger string, diff *config.ReloadDiff, err error) {
+	if err == nil && !diff.Changed() {
+		return
+	}
+	params := map[string]string{"trigger": trigger}
+	if err != nil {
+		params["error"] = err.Error()
+	}
+	entry := store.AuditEntry{
+		ActorKind: "system",
+		ActorName: "system",
+		Action:    "config.reload",
+		Success:   err == nil,
+	}
+	entry.Params, _ = json.Marshal(params)
+	if diff != nil {
+		entry.Diff, _ = json.Marshal(diff)
+	}
+	if insertErr := repository.InsertAuditEntry(entry); insertErr != nil {
+		logrus.WithError(insertErr).Warn("写入审计日志失败")
+	}
+}
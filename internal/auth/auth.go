@@ -0,0 +1,193 @@
+// Package auth 定义请求主体（用户会话或访问密钥）、角色与站点范围，以及密码与会话令牌的工具函数。
+// 身份解析由 server 包的中间件完成，web 包的接口据此做角色与站点校验。
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+type Role string
+
+const (
+	// RoleViewer 只读访问授权站点的统计与视图
+	RoleViewer Role = "viewer"
+	// RoleEditor 额外可以管理视图、报表、导出与重新解析日志
+	RoleEditor Role = "editor"
+	// RoleAdmin 拥有全部权限，包括配置、用户与重启服务
+	RoleAdmin Role = "admin"
+)
+
+var roleLevels = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// ParseRole 解析角色名称（不区分大小写）
+func ParseRole(value string) (Role, bool) {
+	role := Role(strings.ToLower(strings.TrimSpace(value)))
+	_, ok := roleLevels[role]
+	return role, ok
+}
+
+// Includes 判断当前角色是否不低于 required
+func (r Role) Includes(required Role) bool {
+	return roleLevels[r] >= roleLevels[required]
+}
+
+const (
+	PrincipalAnonymous = "anonymous"
+	PrincipalGuest     = "guest"
+	PrincipalAccessKey = "access_key"
+	PrincipalSession   = "session"
+	PrincipalAPIToken  = "api_token"
+)
+
+// Principal 为当前请求的主体。Websites 为空表示可访问全部站点；管理员始终不受站点限制。
+// StatsTypes 只用于 API 令牌，为空表示可查询全部统计类型。
+type Principal struct {
+	Kind       string   `json:"kind"`
+	UserID     int64    `json:"userId,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	Provider   string   `json:"provider,omitempty"`
+	Role       Role     `json:"role"`
+	Websites   []string `json:"websites"`
+	StatsTypes []string `json:"statsTypes,omitempty"`
+}
+
+// Unrestricted 报告主体是否可以访问全部站点
+func (p *Principal) Unrestricted() bool {
+	if p.Role == RoleAdmin {
+		return true
+	}
+	return roleLevels[p.Role] > 0 && len(p.Websites) == 0
+}
+
+// CanAccessWebsite 判断主体能否读取指定站点或虚拟范围（all / group:<name>），
+// 虚拟范围要求其中所有站点都在授权范围内。
+func (p *Principal) CanAccessWebsite(id string) bool {
+	if p.Unrestricted() {
+		return true
+	}
+	if config.IsWebsiteScope(id) {
+		if id == config.ScopeAllWebsites {
+			return false
+		}
+		ids, ok := config.ResolveWebsiteScope(id)
+		if !ok {
+			return false
+		}
+		for _, websiteID := range ids {
+			if !p.hasWebsite(websiteID) {
+				return false
+			}
+		}
+		return true
+	}
+	return p.hasWebsite(id)
+}
+
+func (p *Principal) hasWebsite(id string) bool {
+	for _, websiteID := range p.Websites {
+		if websiteID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// CanQueryStats 判断主体能否查询指定统计类型
+func (p *Principal) CanQueryStats(statsType string) bool {
+	if len(p.StatsTypes) == 0 {
+		return true
+	}
+	for _, allowed := range p.StatsTypes {
+		if allowed == statsType {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterWebsiteIDs 返回 ids 中主体可以访问的部分
+func (p *Principal) FilterWebsiteIDs(ids []string) []string {
+	if p.Unrestricted() {
+		return ids
+	}
+	allowed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if p.hasWebsite(id) {
+			allowed = append(allowed, id)
+		}
+	}
+	return allowed
+}
+
+var (
+	// anonymousAdmin 为未启用任何鉴权（无访问密钥、无用户）时的主体，保持原有的开放访问行为
+	anonymousAdmin = &Principal{Kind: PrincipalAnonymous, Role: RoleAdmin}
+	// guest 为未登录请求的主体（例如免鉴权的登录与分享接口），没有任何角色与站点权限
+	guest = &Principal{Kind: PrincipalGuest}
+)
+
+const principalContextKey = "nginxpulse.principal"
+
+// SetPrincipal 记录当前请求的主体
+func SetPrincipal(c *gin.Context, principal *Principal) {
+	c.Set(principalContextKey, principal)
+}
+
+// FromContext 返回当前请求的主体，未识别身份时返回没有任何权限的访客。
+func FromContext(c *gin.Context) *Principal {
+	if value, ok := c.Get(principalContextKey); ok {
+		if principal, ok := value.(*Principal); ok && principal != nil {
+			return principal
+		}
+	}
+	return guest
+}
+
+// Anonymous 返回未启用鉴权时使用的主体
+func Anonymous() *Principal {
+	return anonymousAdmin
+}
+
+// RequireRole 返回校验角色的中间件，角色不足时返回 403。
+func RequireRole(required Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !FromContext(c).Role.Includes(required) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "权限不足",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CheckWebsite 校验当前主体能否访问站点，无权限时写出 403 并返回 false。
+func CheckWebsite(c *gin.Context, websiteID string) bool {
+	if FromContext(c).CanAccessWebsite(websiteID) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error": "无权访问该站点",
+	})
+	return false
+}
+
+var userAccounts atomic.Bool
+
+// SetUserAccountsEnabled 记录是否存在启用的用户账号；存在时即使未配置访问密钥也要求登录。
+func SetUserAccountsEnabled(enabled bool) {
+	userAccounts.Store(enabled)
+}
+
+func UserAccountsEnabled() bool {
+	return userAccounts.Load()
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func runWithPrincipal(principal *Principal, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chain := []gin.HandlerFunc{func(c *gin.Context) {
+		if principal != nil {
+			SetPrincipal(c, principal)
+		}
+		c.Next()
+	}}
+	chain = append(chain, handlers...)
+	chain = append(chain, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/", chain...)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder
+}
+
+func TestRequireRoleMatrix(t *testing.T) {
+	principals := map[string]*Principal{
+		"none":   nil,
+		"viewer": {Kind: PrincipalSession, Role: RoleViewer},
+		"editor": {Kind: PrincipalSession, Role: RoleEditor},
+		"admin":  {Kind: PrincipalSession, Role: RoleAdmin},
+	}
+	allowed := map[Role]map[string]bool{
+		RoleViewer: {"viewer": true, "editor": true, "admin": true},
+		RoleEditor: {"editor": true, "admin": true},
+		RoleAdmin:  {"admin": true},
+	}
+	for required, expected := range allowed {
+		for name, principal := range principals {
+			recorder := runWithPrincipal(principal, RequireRole(required))
+			want := http.StatusForbidden
+			if expected[name] {
+				want = http.StatusNoContent
+			}
+			if recorder.Code != want {
+				t.Errorf("RequireRole(%s) for %s: got %d, want %d", required, name, recorder.Code, want)
+			}
+		}
+	}
+}
+
+func TestCheckWebsiteMatrix(t *testing.T) {
+	cases := []struct {
+		name      string
+		principal *Principal
+		website   string
+		want      bool
+	}{
+		{"guest", nil, "site-a", false},
+		{"admin scoped list is ignored", &Principal{Role: RoleAdmin, Websites: []string{"site-b"}}, "site-a", true},
+		{"viewer without scope", &Principal{Role: RoleViewer}, "site-a", true},
+		{"viewer in scope", &Principal{Role: RoleViewer, Websites: []string{"site-a"}}, "site-a", true},
+		{"viewer out of scope", &Principal{Role: RoleViewer, Websites: []string{"site-b"}}, "site-a", false},
+		{"scoped viewer cannot read all", &Principal{Role: RoleViewer, Websites: []string{"site-a"}}, "all", false},
+		{"editor out of scope", &Principal{Role: RoleEditor, Websites: []string{"site-b"}}, "site-a", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := runWithPrincipal(tc.principal, func(c *gin.Context) {
+				if !CheckWebsite(c, tc.website) {
+					return
+				}
+				c.Next()
+			})
+			got := recorder.Code == http.StatusNoContent
+			if got != tc.want {
+				t.Fatalf("CheckWebsite(%q): got status %d, want allowed=%v", tc.website, recorder.Code, tc.want)
+			}
+			if !tc.want && recorder.Code != http.StatusForbidden {
+				t.Fatalf("denied request should return 403, got %d", recorder.Code)
+			}
+		})
+	}
+}
+
+func TestFilterWebsiteIDs(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	scoped := &Principal{Role: RoleViewer, Websites: []string{"c", "a"}}
+	got := scoped.FilterWebsiteIDs(ids)
+	if len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("unexpected filtered ids %v", got)
+	}
+	if got := (&Principal{Role: RoleAdmin, Websites: []string{"a"}}).FilterWebsiteIDs(ids); len(got) != 3 {
+		t.Fatalf("admin should see every website, got %v", got)
+	}
+	if got := guest.FilterWebsiteIDs(ids); len(got) != 0 {
+		t.Fatalf("guest should see no website, got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// SessionCookieName 为登录会话 Cookie 名称
+	SessionCookieName = "nginxpulse_session"
+	// SessionTTL 为会话有效期，每次使用会顺延
+	SessionTTL = 7 * 24 * time.Hour
+
+	sessionTokenPrefix = "nps_"
+	apiTokenPrefix     = "npt_"
+	minPasswordLength  = 8
+	maxPasswordLength  = 72 // bcrypt 只使用前 72 字节
+)
+
+var (
+	ErrPasswordTooShort = errors.New("密码长度至少 8 位")
+	ErrPasswordTooLong  = errors.New("密码长度不能超过 72 字节")
+)
+
+// HashPassword 使用 bcrypt 生成密码哈希
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	if len(password) > maxPasswordLength {
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword 校验密码与哈希是否匹配
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+var (
+	// dummyPasswordHash 用于用户不存在时仍执行一次 bcrypt 比较，避免通过响应时间枚举用户名
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// BurnPasswordCheck 执行一次与真实校验耗时相当的比较，结果总是失败
+func BurnPasswordCheck(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("nginxpulse-dummy-password"), bcrypt.DefaultCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
+
+// NewSessionToken 生成会话令牌及其哈希，库中只保存哈希
+func NewSessionToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := sessionTokenPrefix + hex.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// NewAPIToken 生成 API 令牌，与会话令牌使用不同前缀以便区分
+func NewAPIToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := apiTokenPrefix + hex.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// IsAPIToken 判断 Bearer 令牌是否为 API 令牌
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
+
+// HashToken 计算令牌的 SHA-256 哈希
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SessionToken 读取请求携带的会话令牌：优先 Authorization: Bearer，其次会话 Cookie。
+func SessionToken(c *gin.Context) string {
+	if header := strings.TrimSpace(c.GetHeader("Authorization")); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	if cookie, err := c.Cookie(SessionCookieName); err == nil {
+		return strings.TrimSpace(cookie)
+	}
+	return ""
+}
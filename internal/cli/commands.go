@@ -1,8 +1,10 @@
 package cli
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strconv"
@@ -18,6 +20,8 @@ func ProcessCliCommands() bool {
 	// 命令行参数
 	cleanApp := flag.Bool("clean", false, "清理nginxpulse服务、释放端口和删除数据")
 	showVer := flag.Bool("v", false, "显示版本信息")
+	encryptSecret := flag.Bool("encrypt-secret", false, "从标准输入读取密钥，使用主密钥加密后输出 enc: 引用")
+	migrateConfig := flag.Bool("migrate-config", false, "把配置文件升级到当前版本，打印差异后写回")
 	flag.Parse()
 
 	// 显示版本信息
@@ -26,6 +30,18 @@ func ProcessCliCommands() bool {
 		return true
 	}
 
+	// 加密密钥
+	if *encryptSecret {
+		runEncryptSecret()
+		return true
+	}
+
+	// 迁移配置文件
+	if *migrateConfig {
+		runMigrateConfig()
+		return true
+	}
+
 	// 清理服务
 	if *cleanApp {
 		cleanService()
@@ -51,6 +67,61 @@ func ProcessCliCommands() bool {
 	return false
 }
 
+// runEncryptSecret 读取标准输入的第一行并加密，避免明文出现在命令行历史中
+func runEncryptSecret() {
+	reader := bufio.NewReader(os.Stdin)
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(os.Stderr, "读取密钥失败: %v\n", err)
+		os.Exit(1)
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		fmt.Fprintln(os.Stderr, "密钥不能为空")
+		os.Exit(1)
+	}
+	encrypted, err := config.EncryptSecret(secret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加密失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(encrypted)
+}
+
+// runMigrateConfig 迁移主配置与 conf.d 文件，打印差异后逐个原子写回
+func runMigrateConfig() {
+	if len(config.ConfigFiles()) == 0 {
+		fmt.Fprintf(os.Stderr, "未找到配置文件: %s\n", config.ResolveConfigFile())
+		os.Exit(1)
+	}
+	if config.HasEnvConfigSource() {
+		fmt.Fprintln(os.Stderr, "提示: 环境变量中的配置不会被迁移，只处理配置文件")
+	}
+	plan, err := config.PlanConfigMigration()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "迁移配置失败: %v\n", err)
+		os.Exit(1)
+	}
+	for _, file := range plan.Files {
+		fmt.Print(unifiedDiff(file.Path, file.Before, file.After))
+	}
+	if len(plan.Notes) > 0 {
+		fmt.Println("迁移说明:")
+		for _, item := range plan.Notes {
+			fmt.Printf(" - %s\n", item.String())
+		}
+	}
+	if len(plan.Files) == 0 {
+		fmt.Printf("配置文件已是当前版本 (version %d)，无需改写\n", config.CurrentConfigVersion)
+		return
+	}
+	if err := config.ApplyConfigMigration(plan); err != nil {
+		fmt.Fprintf(os.Stderr, "写入配置失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("已更新 %d 个配置文件，当前版本 %d\n", len(plan.Files), config.CurrentConfigVersion)
+}
+
 // showVersion 显示版本信息
 func showVersion() {
 	fmt.Printf("构建时间: %s\n", version.BuildTime)
@@ -58,7 +129,7 @@ func showVersion() {
 }
 
 func initConfig() bool {
-	if _, err := os.Stat(config.ConfigFile); err == nil {
+	if len(config.ConfigFiles()) > 0 {
 		return false
 	}
 
@@ -66,7 +137,7 @@ func initConfig() bool {
 		return false
 	}
 
-	fmt.Fprintf(os.Stderr, "未找到配置文件: %s\n", config.ConfigFile)
+	fmt.Fprintf(os.Stderr, "未找到配置文件: %s\n", config.ResolveConfigFile())
 	fmt.Fprintln(os.Stderr, "将进入初始化配置模式，可在页面完成配置")
 	return false
 }
@@ -101,6 +172,9 @@ func validateConfig() bool {
 	if config.NeedsSetup() {
 		return false
 	}
+	for _, item := range cfg.Deprecations() {
+		fmt.Fprintf(os.Stderr, "配置弃用提示: %s\n", item.String())
+	}
 	result := config.ValidateConfig(cfg, config.ValidateOptions{
 		CheckPaths: !cfg.System.DemoMode,
 	})
@@ -109,11 +183,7 @@ func validateConfig() bool {
 	}
 	fmt.Fprintln(os.Stderr, "配置文件错误:")
 	for _, item := range result.Errors {
-		if item.Field == "" {
-			fmt.Fprintf(os.Stderr, " - %s\n", item.Message)
-		} else {
-			fmt.Fprintf(os.Stderr, " - %s: %s\n", item.Field, item.Message)
-		}
+		fmt.Fprintf(os.Stderr, " - %s\n", item.String())
 	}
 	fmt.Fprintln(os.Stderr, "请修正配置问题后重新启动服务")
 	return true
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext 为每处改动前后保留的上下文行数
+const diffContext = 3
+
+type diffLine struct {
+	kind byte // ' '、'-'、'+'
+	text string
+	// oldLine / newLine 为该行之前已经过的旧、新文件行数
+	oldLine int
+	newLine int
+}
+
+// unifiedDiff 按行比较两个文件内容，输出 unified 格式的差异；内容相同时返回空字符串。
+func unifiedDiff(name string, before, after []byte) string {
+	lines := diffLines(splitLines(string(before)), splitLines(string(after)))
+	changed := make([]int, 0)
+	for i, line := range lines {
+		if line.kind != ' ' {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "--- %s\n+++ %s (migrated)\n", name, name)
+	for i := 0; i < len(changed); {
+		start := max(changed[i]-diffContext, 0)
+		last := changed[i]
+		// 相邻改动之间的上下文会重叠时合并为一个 hunk
+		for i+1 < len(changed) && changed[i+1]-last <= 2*diffContext {
+			i++
+			last = changed[i]
+		}
+		end := min(last+diffContext+1, len(lines))
+		i++
+
+		oldCount, newCount := 0, 0
+		for _, line := range lines[start:end] {
+			if line.kind != '+' {
+				oldCount++
+			}
+			if line.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&builder, "@@ -%s +%s @@\n",
+			hunkRange(lines[start].oldLine, oldCount), hunkRange(lines[start].newLine, newCount))
+		for _, line := range lines[start:end] {
+			builder.WriteByte(line.kind)
+			builder.WriteString(line.text)
+			builder.WriteByte('\n')
+		}
+	}
+	return builder.String()
+}
+
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines 基于最长公共子序列生成逐行差异，配置文件行数不多，直接使用 O(n*m) 的表
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, n+m)
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			lines = append(lines, diffLine{kind: ' ', text: a[i], oldLine: i, newLine: j})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{kind: '-', text: a[i], oldLine: i, newLine: j})
+			i++
+		default:
+			lines = append(lines, diffLine{kind: '+', text: b[j], oldLine: i, newLine: j})
+			j++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,63 @@
+package config
+
+import "strings"
+
+const (
+	defaultAnomalyThreshold = 3.5
+	defaultAnomalyWeeks     = 4
+	maxAnomalyWeeks         = 12
+)
+
+// AnomalySeverities 按从低到高排列的异常等级。
+var AnomalySeverities = []string{"low", "medium", "high"}
+
+// AnomalyDetectionConfig 控制基于小时聚合的流量异常检测。
+type AnomalyDetectionConfig struct {
+	Enabled bool `json:"enabled"`
+	// Notify 为 true 时，达到 NotifySeverity 的异常会写入系统通知。
+	Notify         bool   `json:"notify"`
+	NotifySeverity string `json:"notifySeverity,omitempty"`
+	// Threshold 为稳健 z 分数阈值（|x-中位数| / (1.4826*MAD)），默认 3.5。
+	Threshold float64 `json:"threshold,omitempty"`
+	// Weeks 为季节基线回看的周数（取相同星期几、相同小时），默认 4。
+	Weeks int `json:"weeks,omitempty"`
+}
+
+// GetAnomalyDetectionConfig 返回补齐默认值后的异常检测配置。
+func GetAnomalyDetectionConfig() AnomalyDetectionConfig {
+	result := AnomalyDetectionConfig{
+		Enabled:        true,
+		NotifySeverity: "medium",
+		Threshold:      defaultAnomalyThreshold,
+		Weeks:          defaultAnomalyWeeks,
+	}
+	cfg := ReadConfig().System.AnomalyDetection
+	if cfg == nil {
+		return result
+	}
+	result.Enabled = cfg.Enabled
+	result.Notify = cfg.Notify
+	if severity := strings.ToLower(strings.TrimSpace(cfg.NotifySeverity)); AnomalySeverityRank(severity) >= 0 {
+		result.NotifySeverity = severity
+	}
+	if cfg.Threshold > 0 {
+		result.Threshold = cfg.Threshold
+	}
+	if cfg.Weeks > 0 {
+		result.Weeks = cfg.Weeks
+		if result.Weeks > maxAnomalyWeeks {
+			result.Weeks = maxAnomalyWeeks
+		}
+	}
+	return result
+}
+
+// AnomalySeverityRank 返回异常等级的序号，未知等级返回 -1。
+func AnomalySeverityRank(severity string) int {
+	for i, item := range AnomalySeverities {
+		if item == severity {
+			return i
+		}
+	}
+	return -1
+}
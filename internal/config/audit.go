@@ -0,0 +1,12 @@
+package config
+
+// defaultAuditRetentionDays 为审计日志默认保留天数
+const defaultAuditRetentionDays = 180
+
+// GetAuditRetentionDays 返回审计日志保留天数
+func GetAuditRetentionDays() int {
+	if days := ReadConfig().System.AuditRetentionDays; days > 0 {
+		return days
+	}
+	return defaultAuditRetentionDays
+}
@@ -0,0 +1,56 @@
+package config
+
+import "strings"
+
+// maxCampaignCustomParams 限制自定义投放参数的数量，避免维表组合过多。
+const maxCampaignCustomParams = 10
+
+// CampaignConfig 控制入库时的投放参数解析与 URL 归一化。
+type CampaignConfig struct {
+	// CustomParams 额外解析为投放维度的查询参数，例如 ref、aff_id。
+	CustomParams []string `json:"customParams,omitempty"`
+	// StripTrackingParams 为 true 时，入库的 URL 去除 utm_*、gclid、fbclid、msclkid 与 CustomParams。
+	StripTrackingParams bool `json:"stripTrackingParams"`
+	// StripParams 为额外需要去除的查询参数，仅在 StripTrackingParams 为 true 时生效。
+	StripParams []string `json:"stripParams,omitempty"`
+}
+
+// GetCampaignConfig 返回归一化后的投放参数配置，参数名统一转为小写并去重。
+func GetCampaignConfig() CampaignConfig {
+	cfg := ReadConfig().System.Campaigns
+	if cfg == nil {
+		return CampaignConfig{}
+	}
+	customParams := normalizeParamNames(cfg.CustomParams)
+	if len(customParams) > maxCampaignCustomParams {
+		customParams = customParams[:maxCampaignCustomParams]
+	}
+	return CampaignConfig{
+		CustomParams:        customParams,
+		StripTrackingParams: cfg.StripTrackingParams,
+		StripParams:         normalizeParamNames(cfg.StripParams),
+	}
+}
+
+func normalizeParamNames(values []string) []string {
+	result := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		name := strings.ToLower(strings.TrimSpace(value))
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		result = append(result, name)
+	}
+	return result
+}
+
+// validCampaignParamName 判断查询参数名是否可用于配置（不能包含分隔符或空白）。
+func validCampaignParamName(name string) bool {
+	name = strings.TrimSpace(name)
+	return name != "" && !strings.ContainsAny(name, "=&?# \t")
+}
@@ -11,8 +11,10 @@ import (
 )
 
 var (
+	// configMu 保护 globalConfig 与 websiteIDMap，热加载时两者一起替换
+	configMu     sync.RWMutex
 	globalConfig *Config
-	websiteIDMap sync.Map
+	websiteIDMap map[string]WebsiteConfig
 )
 
 const (
@@ -22,11 +24,22 @@ const (
 )
 
 type Config struct {
+	// Version 为配置格式版本，旧版本在加载时按迁移链升级，见 migrate.go。
+	Version  int             `json:"version,omitempty"`
 	System   SystemConfig    `json:"system"`
 	Server   ServerConfig    `json:"server"`
 	Database DatabaseConfig  `json:"database"`
 	Websites []WebsiteConfig `json:"websites"`
-	PVFilter PVFilterConfig  `json:"pvFilter"`
+	// WebsiteGroups 站点分组，用于跨站点汇总统计（scope 为 group:<name>）。
+	WebsiteGroups []WebsiteGroupConfig `json:"websiteGroups,omitempty"`
+	// Reports 定时摘要报表（邮件 / webhook）。
+	Reports  []ReportConfig `json:"reports,omitempty"`
+	PVFilter PVFilterConfig `json:"pvFilter"`
+
+	// locations 记录从文件加载的字段位置（文件与行号），用于校验错误定位
+	locations map[string]FieldLocation
+	// deprecations 为加载时迁移或发现的弃用字段
+	deprecations []FieldError
 }
 
 type WebsiteConfig struct {
@@ -39,6 +52,8 @@ type WebsiteConfig struct {
 	TimeLayout string           `json:"timeLayout,omitempty"`
 	Sources    []SourceConfig   `json:"sources,omitempty"`
 	Whitelist  *WhitelistConfig `json:"whitelist,omitempty"`
+	// Public 开启后站点统计可通过 /api/public/ 匿名访问（仅聚合数据）。
+	Public *PublicDashboardConfig `json:"public,omitempty"`
 }
 
 type SourceConfig struct {
@@ -93,22 +108,52 @@ type WhitelistConfig struct {
 }
 
 type SystemConfig struct {
-	LogDestination    string   `json:"logDestination"`
-	TaskInterval      string   `json:"taskInterval"` // "5m" "25s"
-	HTTPSourceTimeout string   `json:"httpSourceTimeout,omitempty"`
-	LogRetentionDays  int      `json:"logRetentionDays"`
-	ParseBatchSize    int      `json:"parseBatchSize"`
-	IPGeoCacheLimit   int      `json:"ipGeoCacheLimit"`
-	IPGeoAPIURL       string   `json:"ipGeoApiUrl"`
-	DemoMode          bool     `json:"demoMode"`
-	AccessKeys        []string `json:"accessKeys"`
-	Language          string   `json:"language"`
-	WebBasePath       string   `json:"webBasePath,omitempty"`
-	MobilePWAEnabled  bool     `json:"mobilePwaEnabled"`
+	LogDestination    string             `json:"logDestination"`
+	TaskInterval      string             `json:"taskInterval"` // "5m" "25s"
+	HTTPSourceTimeout string             `json:"httpSourceTimeout,omitempty"`
+	LogRetentionDays  int                `json:"logRetentionDays"`
+	ParseBatchSize    int                `json:"parseBatchSize"`
+	IPGeoCacheLimit   int                `json:"ipGeoCacheLimit"`
+	IPGeoAPIURL       string             `json:"ipGeoApiUrl"`
+	DemoMode          bool               `json:"demoMode"`
+	AccessKeys        []string           `json:"accessKeys"`
+	AgentTokens       []AgentTokenConfig `json:"agentTokens,omitempty"`
+	Language          string             `json:"language"`
+	WebBasePath       string             `json:"webBasePath,omitempty"`
+	MobilePWAEnabled  bool               `json:"mobilePwaEnabled"`
+	// WatchConfig 开启后定期检查配置文件，发生变化时自动热加载。
+	WatchConfig bool `json:"watchConfig,omitempty"`
+	// AuditRetentionDays 为审计日志保留天数，0 表示使用默认的 180 天。
+	AuditRetentionDays int `json:"auditRetentionDays,omitempty"`
+	// AnomalyDetection 流量异常检测配置，未配置时按默认参数启用检测、不发通知。
+	AnomalyDetection *AnomalyDetectionConfig `json:"anomalyDetection,omitempty"`
+	// SMTP 邮件发送配置，定时报表的邮件收件人依赖此配置。
+	SMTP *SMTPConfig `json:"smtp,omitempty"`
+	// Campaigns 投放参数（utm_* 等）解析与 URL 归一化配置。
+	Campaigns *CampaignConfig `json:"campaigns,omitempty"`
+	// QueryAPI /api/query 表达式查询的行数与超时限制。
+	QueryAPI *QueryAPIConfig `json:"queryApi,omitempty"`
+	// OIDC 单点登录配置。
+	OIDC *OIDCConfig `json:"oidc,omitempty"`
+	// RateLimit 按客户端 IP 与 API 令牌限流。
+	RateLimit *RateLimitConfig `json:"rateLimit,omitempty"`
+}
+
+// AgentTokenConfig 是绑定到单个站点的 agent 推送令牌，仅可用于 v2 推送接口。
+// 配置 HMACSecret 后，该令牌的请求必须携带签名。
+type AgentTokenConfig struct {
+	Token      string `json:"token"`
+	WebsiteID  string `json:"websiteId"`
+	AgentID    string `json:"agentId,omitempty"`
+	HMACSecret string `json:"hmacSecret,omitempty"`
 }
 
 type ServerConfig struct {
-	Port string `json:"Port"`
+	Port string     `json:"Port"`
+	TLS  *TLSConfig `json:"tls,omitempty"`
+	// TrustedProxies 为可信反向代理的 IP 或 CIDR，只有来自这些地址的请求才按
+	// X-Forwarded-For / X-Real-IP 识别客户端；为空时不信任任何代理。
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
 }
 
 type DatabaseConfig struct {
@@ -119,19 +164,34 @@ type DatabaseConfig struct {
 	ConnMaxLifetime string `json:"connMaxLifetime"`
 }
 
+type WebsiteGroupConfig struct {
+	Name string `json:"name"`
+	// Websites 为组内站点名称（也可以直接填写站点 ID）。
+	Websites []string `json:"websites"`
+}
+
 type PVFilterConfig struct {
 	StatusCodeInclude []int    `json:"statusCodeInclude"`
 	ExcludePatterns   []string `json:"excludePatterns"`
 	ExcludeIPs        []string `json:"excludeIPs"`
 }
 
-// ReadRawConfig 读取配置（支持环境变量覆盖与默认值）但不初始化全局变量
+// ReadRawConfig 读取配置（支持环境变量覆盖与默认值）但不初始化全局变量，密钥引用不做解析
 func ReadRawConfig() (*Config, error) {
-	return loadConfig()
+	return loadRawConfig()
 }
 
 // ReadConfig 读取配置文件并返回配置，同时初始化 ID 映射
 func ReadConfig() *Config {
+	configMu.RLock()
+	cfg := globalConfig
+	configMu.RUnlock()
+	if cfg != nil {
+		return cfg
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
 	if globalConfig != nil {
 		return globalConfig
 	}
@@ -141,32 +201,36 @@ func ReadConfig() *Config {
 		panic(err)
 	}
 
-	// 初始化 ID 映射
-	for _, website := range cfg.Websites {
-		id := generateID(website.Name)
-		websiteIDMap.Store(id, website)
-	}
-
 	globalConfig = cfg
+	websiteIDMap = buildWebsiteIDMap(cfg)
 	return globalConfig
 }
 
+// buildWebsiteIDMap 生成站点 ID 到站点配置的映射
+func buildWebsiteIDMap(cfg *Config) map[string]WebsiteConfig {
+	websites := make(map[string]WebsiteConfig, len(cfg.Websites))
+	for _, website := range cfg.Websites {
+		websites[generateID(website.Name)] = website
+	}
+	return websites
+}
+
 // GetWebsiteByID 根据 ID 获取对应的 WebsiteConfig
 func GetWebsiteByID(id string) (WebsiteConfig, bool) {
-	value, ok := websiteIDMap.Load(id)
-	if ok {
-		return value.(WebsiteConfig), true
-	}
-	return WebsiteConfig{}, false
+	configMu.RLock()
+	defer configMu.RUnlock()
+	website, ok := websiteIDMap[id]
+	return website, ok
 }
 
 // GetAllWebsiteIDs 获取所有网站的 ID 列表
 func GetAllWebsiteIDs() []string {
-	var ids []string
-	websiteIDMap.Range(func(key, value interface{}) bool {
-		ids = append(ids, key.(string))
-		return true
-	})
+	configMu.RLock()
+	defer configMu.RUnlock()
+	ids := make([]string, 0, len(websiteIDMap))
+	for id := range websiteIDMap {
+		ids = append(ids, id)
+	}
 	return ids
 }
 
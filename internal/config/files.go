@@ -0,0 +1,613 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configIncludeDir 为主配置同目录下的 include 目录，其中每个 YAML 文件描述一个站点（或 websites 列表）
+const configIncludeDir = "conf.d"
+
+// yamlConfigFiles 为 YAML 格式的主配置，ConfigFile 不存在时依次查找
+var yamlConfigFiles = []string{
+	"./configs/nginxpulse_config.yaml",
+	"./configs/nginxpulse_config.yml",
+}
+
+// FieldLocation 为配置字段所在的文件与行号，行号未知时为 0。
+type FieldLocation struct {
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+// includeFile 为 conf.d 下的一个站点文件
+type includeFile struct {
+	path string
+	// list 表示文件使用 websites: 列表，否则整个文件是一个站点
+	list         bool
+	websites     []WebsiteConfig
+	node         *yaml.Node
+	deprecations []FieldError
+}
+
+// ResolveConfigFile 返回主配置文件路径：优先 JSON，其次同目录下的 YAML；都不存在时返回默认的 JSON 路径。
+func ResolveConfigFile() string {
+	for _, path := range append([]string{ConfigFile}, yamlConfigFiles...) {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ConfigFile
+}
+
+// IncludeFiles 返回 conf.d 下按文件名排序的 YAML 文件
+func IncludeFiles() []string {
+	dir := filepath.Join(filepath.Dir(ResolveConfigFile()), configIncludeDir)
+	files := make([]string, 0)
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// ConfigFiles 返回当前存在的主配置与 include 文件，供配置文件监听使用
+func ConfigFiles() []string {
+	files := make([]string, 0)
+	if main := ResolveConfigFile(); fileExists(main) {
+		files = append(files, main)
+	}
+	return append(files, IncludeFiles()...)
+}
+
+func hasConfigFiles() bool {
+	return len(ConfigFiles()) > 0
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// readConfigFiles 读取主配置与 conf.d 下的站点文件，include 中的站点追加在主配置站点之后。
+// 所有文件都先按迁移链升级到 CurrentConfigVersion 再解码。返回值 loaded 表示至少读取到一个文件。
+func readConfigFiles(cfg *Config) (bool, error) {
+	locations := make(map[string]FieldLocation)
+	deprecations := make([]FieldError, 0)
+	loaded := false
+
+	mainFile := filepath.Clean(ResolveConfigFile())
+	data, err := os.ReadFile(mainFile)
+	if err == nil {
+		migrated, node, notes, err := migrateMainConfig(mainFile, data)
+		if err != nil {
+			return false, err
+		}
+		if node != nil {
+			recordLocations(node, "", mainFile, locations)
+		}
+		if err := decodeMainConfig(mainFile, migrated, node, cfg); err != nil {
+			return false, err
+		}
+		locations[""] = FieldLocation{File: mainFile}
+		deprecations = append(deprecations, notes...)
+		loaded = true
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	includes, err := readIncludeFiles(len(cfg.Websites))
+	if err != nil {
+		return false, err
+	}
+	for _, include := range includes {
+		offset := len(cfg.Websites)
+		include.recordLocations(offset, locations)
+		cfg.Websites = append(cfg.Websites, include.websites...)
+		deprecations = append(deprecations, include.deprecations...)
+		loaded = true
+	}
+
+	cfg.locations = locations
+	cfg.deprecations = deprecations
+	return loaded, nil
+}
+
+func decodeMainConfig(path string, data []byte, node *yaml.Node, cfg *Config) error {
+	if isYAMLFile(path) {
+		if node == nil {
+			var probe yaml.Node
+			if err := yaml.Unmarshal(data, &probe); err != nil {
+				return fmt.Errorf("解析 %s 失败: %w", path, err)
+			}
+			node = &probe
+		}
+		converted, err := yamlNodeToJSON(node)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", path, err)
+		}
+		data = converted
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return describeDecodeError(path, data, node, err)
+	}
+	return nil
+}
+
+// readIncludeFiles 读取 conf.d 文件，offset 为第一个 include 站点在合并后 websites 中的序号（用于弃用提示的字段路径）
+func readIncludeFiles(offset int) ([]includeFile, error) {
+	paths := IncludeFiles()
+	includes := make([]includeFile, 0, len(paths))
+	for _, path := range paths {
+		include, err := readIncludeFile(path, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += len(include.websites)
+		includes = append(includes, include)
+	}
+	return includes, nil
+}
+
+func readIncludeFile(path string, offset int) (includeFile, error) {
+	include := includeFile{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return include, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return include, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	migrator := configMigrator{file: path}
+	migrator.migrateIncludeDocument(&node, offset)
+	include.deprecations = migrator.notes
+	include.node = &node
+	root := documentRoot(&node)
+	if root == nil {
+		// 空文件
+		return include, nil
+	}
+	if root.Kind != yaml.MappingNode {
+		return include, fmt.Errorf("%s:%d: include 文件应为站点对象或包含 websites 列表", path, root.Line)
+	}
+	converted, err := yamlNodeToJSON(root)
+	if err != nil {
+		return include, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	if mappingValue(root, "websites") != nil {
+		include.list = true
+		var payload struct {
+			Websites []WebsiteConfig `json:"websites"`
+		}
+		if err := json.Unmarshal(converted, &payload); err != nil {
+			return include, describeDecodeError(path, converted, &node, err)
+		}
+		include.websites = payload.Websites
+		return include, nil
+	}
+	var website WebsiteConfig
+	if err := json.Unmarshal(converted, &website); err != nil {
+		return include, describeDecodeError(path, converted, &node, err)
+	}
+	include.websites = []WebsiteConfig{website}
+	return include, nil
+}
+
+// recordLocations 把 include 文件中的字段位置换算为合并后配置的 websites[i] 路径
+func (f includeFile) recordLocations(offset int, locations map[string]FieldLocation) {
+	root := documentRoot(f.node)
+	if root == nil {
+		return
+	}
+	if !f.list {
+		recordLocations(root, fmt.Sprintf("websites[%d]", offset), f.path, locations)
+		return
+	}
+	items := mappingValue(root, "websites")
+	if items == nil || items.Kind != yaml.SequenceNode {
+		return
+	}
+	for i, item := range items.Content {
+		recordLocations(item, fmt.Sprintf("websites[%d]", offset+i), f.path, locations)
+	}
+}
+
+// parseYAMLNode 解析配置文件以获取字段行号；JSON 也按 YAML 解析，失败时返回 nil（只是没有行号）。
+func parseYAMLNode(data []byte) *yaml.Node {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil
+	}
+	return &node
+}
+
+func documentRoot(node *yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return node.Content[0]
+	}
+	return node
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// recordLocations 按校验错误使用的字段路径（如 websites[0].sources[1].path）记录行号
+func recordLocations(node *yaml.Node, path, file string, locations map[string]FieldLocation) {
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			recordLocations(child, path, file, locations)
+		}
+		return
+	}
+	if _, ok := locations[path]; !ok && path != "" {
+		locations[path] = FieldLocation{File: file, Line: node.Line}
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			child := key.Value
+			if path != "" {
+				child = path + "." + key.Value
+			}
+			locations[child] = FieldLocation{File: file, Line: key.Line}
+			recordLocations(node.Content[i+1], child, file, locations)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			recordLocations(item, fmt.Sprintf("%s[%d]", path, i), file, locations)
+		}
+	}
+}
+
+// locateField 查找字段位置，字段本身没有出现在文件中时逐级退回到上层字段
+func (c *Config) locateField(field string) (FieldLocation, bool) {
+	if c == nil || c.locations == nil {
+		return FieldLocation{}, false
+	}
+	path := field
+	for {
+		if location, ok := c.locations[path]; ok {
+			return location, true
+		}
+		if path == "" {
+			return FieldLocation{}, false
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			path = ""
+		} else {
+			path = path[:cut]
+		}
+	}
+}
+
+// dropWebsiteLocations 在 WEBSITES 环境变量覆盖站点时清除文件中站点字段的位置与弃用提示
+func (c *Config) dropWebsiteLocations() {
+	for path := range c.locations {
+		if isWebsiteField(path) {
+			delete(c.locations, path)
+		}
+	}
+	kept := c.deprecations[:0]
+	for _, item := range c.deprecations {
+		if !isWebsiteField(item.Field) {
+			kept = append(kept, item)
+		}
+	}
+	c.deprecations = kept
+}
+
+func isWebsiteField(path string) bool {
+	return path == "websites" || strings.HasPrefix(path, "websites[") || strings.HasPrefix(path, "websites.")
+}
+
+// describeDecodeError 为 JSON 解码错误补充文件与行号
+func describeDecodeError(path string, data []byte, node *yaml.Node, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) && !isYAMLFile(path) {
+		line := 1 + bytes.Count(data[:min(int(syntaxErr.Offset), len(data))], []byte("\n"))
+		return fmt.Errorf("解析 %s 失败（第 %d 行）: %w", path, line, err)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && node != nil && typeErr.Field != "" {
+		locations := make(map[string]FieldLocation)
+		recordLocations(node, "", path, locations)
+		field := jsonFieldPath(typeErr.Field)
+		if location, ok := locations[field]; ok {
+			return fmt.Errorf("%s:%d: 字段 %s 类型错误，应为 %s", path, location.Line, field, typeErr.Type.String())
+		}
+	}
+	return fmt.Errorf("解析 %s 失败: %w", path, err)
+}
+
+// jsonFieldPath 把 encoding/json 的字段路径（sources.0.id）转换为校验使用的格式（sources[0].id）
+func jsonFieldPath(field string) string {
+	var builder strings.Builder
+	for i, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil && i > 0 {
+			builder.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			builder.WriteString(".")
+		}
+		builder.WriteString(part)
+	}
+	return builder.String()
+}
+
+// yamlNodeToJSON 把 YAML 转换为 JSON，复用配置结构体的 json 标签解码
+func yamlNodeToJSON(node *yaml.Node) ([]byte, error) {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(value)
+}
+
+// marshalYAML 按结构体的 json 字段顺序输出 YAML
+func marshalYAML(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	node, err := jsonToYAMLNode(decoder)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func jsonToYAMLNode(decoder *json.Decoder) (*yaml.Node, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '{' {
+			node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ := keyToken.(string)
+				child, err := jsonToYAMLNode(decoder)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+			}
+			_, err := decoder.Token()
+			return node, err
+		}
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for decoder.More() {
+			child, err := jsonToYAMLNode(decoder)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		_, err := decoder.Token()
+		return node, err
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(value.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(value)}, nil
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+}
+
+// WriteConfigFile 保存配置：来自 conf.d 的站点（按站点 ID 或 sources 匹配）写回原文件，其余写入主配置文件。
+// 内容未变化的 include 文件保持原样；站点全部被删除的 include 文件重命名为 .removed。
+// 每个文件都先写临时文件再原子替换。
+func WriteConfigFile(cfg *Config) error {
+	if cfg == nil {
+		return nil
+	}
+
+	includes, err := readIncludeFiles(0)
+	if err != nil {
+		return err
+	}
+	matched, assigned := matchIncludeWebsites(includes, cfg.Websites)
+	for n, include := range includes {
+		websites := make([]WebsiteConfig, 0, len(include.websites))
+		for _, idx := range matched[n] {
+			if idx >= 0 {
+				websites = append(websites, cfg.Websites[idx])
+			}
+		}
+		if sameWebsites(include.websites, websites) {
+			continue
+		}
+		if len(websites) == 0 {
+			if err := os.Rename(include.path, include.path+".removed"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeIncludeFile(include, websites); err != nil {
+			return err
+		}
+	}
+
+	mainCfg := *cfg
+	mainCfg.Version = CurrentConfigVersion
+	mainCfg.Websites = make([]WebsiteConfig, 0, len(cfg.Websites)-len(assigned))
+	for i, site := range cfg.Websites {
+		if !assigned[i] {
+			mainCfg.Websites = append(mainCfg.Websites, site)
+		}
+	}
+	mainFile := ResolveConfigFile()
+	var payload []byte
+	if isYAMLFile(mainFile) {
+		payload, err = marshalYAML(&mainCfg)
+	} else {
+		payload, err = json.MarshalIndent(&mainCfg, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(mainFile, payload)
+}
+
+// matchIncludeWebsites 为 conf.d 中的每个旧站点在提交的站点中找到对应项（-1 表示已删除）。
+// 先按站点 ID 匹配（与运行时一致，由名称生成）；站点改名后 ID 随之变化，
+// 再按 sources 的 ID 列表匹配剩余站点，保证改名的站点仍写回原文件。assigned 为已归入 conf.d 的提交站点。
+func matchIncludeWebsites(includes []includeFile, websites []WebsiteConfig) (matched [][]int, assigned map[int]bool) {
+	assigned = make(map[int]bool)
+	indexByID := make(map[string]int, len(websites))
+	for i, site := range websites {
+		id := generateID(site.Name)
+		if _, ok := indexByID[id]; !ok {
+			indexByID[id] = i
+		}
+	}
+	matched = make([][]int, len(includes))
+	for n, include := range includes {
+		matched[n] = make([]int, len(include.websites))
+		for j, old := range include.websites {
+			matched[n][j] = -1
+			if idx, ok := indexByID[generateID(old.Name)]; ok && !assigned[idx] {
+				assigned[idx] = true
+				matched[n][j] = idx
+			}
+		}
+	}
+	for n, include := range includes {
+		for j, old := range include.websites {
+			if matched[n][j] >= 0 {
+				continue
+			}
+			key := sourceIDsKey(old)
+			if key == "" {
+				continue
+			}
+			candidate := -1
+			for i, site := range websites {
+				if assigned[i] || sourceIDsKey(site) != key {
+					continue
+				}
+				if candidate >= 0 {
+					// 多个站点使用相同的 source ID 时无法确定对应关系
+					candidate = -1
+					break
+				}
+				candidate = i
+			}
+			if candidate >= 0 {
+				assigned[candidate] = true
+				matched[n][j] = candidate
+			}
+		}
+	}
+	return matched, assigned
+}
+
+func sourceIDsKey(site WebsiteConfig) string {
+	ids := make([]string, 0, len(site.Sources))
+	for _, src := range site.Sources {
+		if id := strings.TrimSpace(src.ID); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return strings.Join(ids, "\x00")
+}
+
+func sameWebsites(a, b []WebsiteConfig) bool {
+	left, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(left, right)
+}
+
+func writeIncludeFile(include includeFile, websites []WebsiteConfig) error {
+	var value interface{} = websites[0]
+	if include.list || len(websites) > 1 {
+		value = struct {
+			Websites []WebsiteConfig `json:"websites"`
+		}{Websites: websites}
+	}
+	payload, err := marshalYAML(value)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(include.path, payload)
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免写入中途读到不完整的配置
+func writeFileAtomic(path string, payload []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, payload, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// setupConfigDir 在临时目录中准备主配置与 conf.d 文件，并切换工作目录
+func setupConfigDir(t *testing.T, files map[string]string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv(envConfigJSON, "")
+	t.Setenv(envWebsites, "")
+	for path, content := range files {
+		writeTestFile(t, path, content)
+	}
+}
+
+const testMainConfig = `{
+  "version": 2,
+  "websites": [
+    {"name": "main", "sources": [{"id": "main", "type": "local", "path": "/var/log/main.log"}]}
+  ]
+}
+`
+
+func TestIncludeFileErrorsCarryFileAndLine(t *testing.T) {
+	blog := filepath.Join("configs", "conf.d", "blog.yaml")
+	setupConfigDir(t, map[string]string{
+		ConfigFile: testMainConfig,
+		blog: `# 博客
+name: blog
+sources:
+  - id: blog
+    type: local
+`,
+	})
+	cfg, err := loadRawConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := ValidateConfig(cfg, ValidateOptions{})
+	var found *FieldError
+	for i, item := range result.Errors {
+		if item.Field == "websites[1].sources[0]" {
+			found = &result.Errors[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("missing source error, got %v", result.Errors)
+	}
+	if found.File != blog || found.Line != 4 {
+		t.Fatalf("error should point at %s:4, got %s:%d", blog, found.File, found.Line)
+	}
+
+	writeTestFile(t, blog, "name: blog\nsources:\n  - id: blog\n    type: [local]\n")
+	_, err = loadRawConfig()
+	if err == nil || !strings.Contains(err.Error(), blog) || !strings.Contains(err.Error(), "4") {
+		t.Fatalf("decode error should name the include file and line, got %v", err)
+	}
+}
+
+func TestWriteConfigFileKeepsIncludeFiles(t *testing.T) {
+	blog := filepath.Join("configs", "conf.d", "blog.yaml")
+	docs := filepath.Join("configs", "conf.d", "docs.yml")
+	docsContent := `# 文档站点，保存时不应被改写
+websites:
+  - name: docs # 主文档
+    sources:
+      - {id: docs, type: local, path: /var/log/docs.log}
+  - name: wiki
+    sources:
+      - id: wiki
+        type: local
+        path: /var/log/wiki.log
+`
+	setupConfigDir(t, map[string]string{
+		ConfigFile: testMainConfig,
+		blog: `name: blog
+sources:
+  - id: blog
+    type: local
+    path: /var/log/blog.log
+`,
+		docs: docsContent,
+	})
+	cfg, err := loadRawConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Websites) != 4 || cfg.Websites[1].Name != "blog" {
+		t.Fatalf("unexpected websites %+v", cfg.Websites)
+	}
+
+	// 改名并修改 conf.d 中的站点：仍按 sources 写回原文件，其他 include 文件不变
+	cfg.Websites[1].Name = "blog-new"
+	cfg.Websites[1].Domains = []string{"blog.example.com"}
+	if err := WriteConfigFile(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, docs); got != docsContent {
+		t.Fatalf("untouched include file was rewritten:\n%s", got)
+	}
+	if got := readTestFile(t, blog); !strings.Contains(got, "name: blog-new") || !strings.Contains(got, "blog.example.com") {
+		t.Fatalf("renamed site should stay in its include file:\n%s", got)
+	}
+	if _, err := os.Stat(blog + ".removed"); !os.IsNotExist(err) {
+		t.Fatal("renamed site must not remove its include file")
+	}
+	mainContent := readTestFile(t, ConfigFile)
+	if strings.Contains(mainContent, "blog") || !strings.Contains(mainContent, `"version": 2`) {
+		t.Fatalf("unexpected main config:\n%s", mainContent)
+	}
+
+	// 再次保存相同的配置时所有文件保持字节一致
+	blogContent := readTestFile(t, blog)
+	reloaded, err := loadRawConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteConfigFile(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{ConfigFile: mainContent, blog: blogContent, docs: docsContent} {
+		if got := readTestFile(t, path); got != want {
+			t.Fatalf("%s changed on an unchanged save:\n%s", path, got)
+		}
+	}
+}
@@ -26,6 +26,8 @@ const (
 	envLanguage          = "APP_LANGUAGE"
 	envWebBasePath       = "WEB_BASE_PATH"
 	envMobilePWAEnabled  = "MOBILE_PWA_ENABLED"
+	envWatchConfig       = "WATCH_CONFIG"
+	envAuditRetention    = "AUDIT_RETENTION_DAYS"
 	envIPGeoCacheLimit   = "IP_GEO_CACHE_LIMIT"
 	envIPGeoAPIURL       = "IP_GEO_API_URL"
 	envDBDriver          = "DB_DRIVER"
@@ -33,6 +35,11 @@ const (
 	envDBMaxOpenConns    = "DB_MAX_OPEN_CONNS"
 	envDBMaxIdleConns    = "DB_MAX_IDLE_CONNS"
 	envDBConnMaxLifetime = "DB_CONN_MAX_LIFETIME"
+	envMasterKey         = "MASTER_KEY"
+	envMasterKeyFile     = "MASTER_KEY_FILE"
+	envTLSCertFile       = "TLS_CERT_FILE"
+	envTLSKeyFile        = "TLS_KEY_FILE"
+	envTLSClientCAFile   = "TLS_CLIENT_CA_FILE"
 )
 
 var (
@@ -93,7 +100,20 @@ func DefaultConfig() Config {
 	}
 }
 
+// loadConfig 读取配置并解析其中的密钥引用，供运行时使用
 func loadConfig() (*Config, error) {
+	cfg, err := loadRawConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(cfg); err != nil {
+		return nil, fmt.Errorf("解析密钥引用失败: %w", err)
+	}
+	return cfg, nil
+}
+
+// loadRawConfig 读取配置（含环境变量覆盖与默认值），密钥引用保持原样
+func loadRawConfig() (*Config, error) {
 	cfg := DefaultConfig()
 	cfgPtr := &cfg
 	loaded := false
@@ -101,29 +121,30 @@ func loadConfig() (*Config, error) {
 	if ForceEmptyConfigEnabled() {
 		loaded = false
 	} else if raw, key := getEnvValue(envConfigJSON); raw != "" {
-		if err := json.Unmarshal([]byte(raw), cfgPtr); err != nil {
+		migrated, _, notes, err := migrateMainConfig(key, []byte(raw))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(migrated, cfgPtr); err != nil {
 			return nil, fmt.Errorf("解析 %s 失败: %w", key, err)
 		}
+		cfgPtr.deprecations = notes
 		loaded = true
 	} else {
-		bytes, err := os.ReadFile(ConfigFile)
-		if err == nil {
-			if err := json.Unmarshal(bytes, cfgPtr); err != nil {
-				return nil, err
-			}
-			loaded = true
-		} else if !os.IsNotExist(err) {
+		// 配置文件不存在且未注入环境变量时进入初始化模式
+		fileLoaded, err := readConfigFiles(cfgPtr)
+		if err != nil {
 			return nil, err
-		} else if !HasEnvConfigSource() {
-			// 配置不存在且未注入环境变量，进入初始化模式
-			loaded = false
 		}
+		loaded = fileLoaded
 	}
 
 	if err := applyEnvOverrides(cfgPtr); err != nil {
 		return nil, err
 	}
 	applyDefaults(cfgPtr)
+	// conf.d 与 WEBSITES 中的站点没有版本号，每次加载都执行完整迁移链，内存中的配置总是当前版本
+	cfgPtr.Version = CurrentConfigVersion
 
 	if !loaded && len(cfgPtr.Websites) == 0 && !NeedsSetup() {
 		return nil, fmt.Errorf("未提供网站配置")
@@ -139,11 +160,18 @@ func HasEnvConfigSource() bool {
 
 func applyEnvOverrides(cfg *Config) error {
 	if raw, key := getEnvValue(envWebsites); raw != "" {
+		migrated, notes, err := migrateWebsitesEnv(key, []byte(raw))
+		if err != nil {
+			return err
+		}
 		websites := []WebsiteConfig{}
-		if err := json.Unmarshal([]byte(raw), &websites); err != nil {
+		if err := json.Unmarshal(migrated, &websites); err != nil {
 			return fmt.Errorf("解析 %s 失败: %w", key, err)
 		}
 		cfg.Websites = websites
+		// 文件中的站点被整体覆盖，其位置与弃用提示不再适用
+		cfg.dropWebsiteLocations()
+		cfg.deprecations = append(cfg.deprecations, notes...)
 	}
 
 	if raw, _ := getEnvValue(envLogDestination); raw != "" {
@@ -228,6 +256,23 @@ func applyEnvOverrides(cfg *Config) error {
 		}
 		cfg.System.MobilePWAEnabled = parsed
 	}
+	if raw, key := getEnvValue(envWatchConfig); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		cfg.System.WatchConfig = parsed
+	}
+	if raw, key := getEnvValue(envAuditRetention); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		if parsed <= 0 {
+			return fmt.Errorf("%s 必须大于0", key)
+		}
+		cfg.System.AuditRetentionDays = parsed
+	}
 
 	if raw, _ := getEnvValue(envServerPort); raw != "" {
 		if !strings.Contains(raw, ":") {
@@ -236,6 +281,28 @@ func applyEnvOverrides(cfg *Config) error {
 		cfg.Server.Port = raw
 	}
 
+	// 同时设置证书与私钥即启用 TLS
+	certFile, _ := getEnvValue(envTLSCertFile)
+	keyFile, _ := getEnvValue(envTLSKeyFile)
+	clientCAFile, _ := getEnvValue(envTLSClientCAFile)
+	if certFile != "" || keyFile != "" || clientCAFile != "" {
+		if cfg.Server.TLS == nil {
+			cfg.Server.TLS = &TLSConfig{}
+		}
+		if certFile != "" {
+			cfg.Server.TLS.CertFile = certFile
+		}
+		if keyFile != "" {
+			cfg.Server.TLS.KeyFile = keyFile
+		}
+		if clientCAFile != "" {
+			cfg.Server.TLS.ClientCAFile = clientCAFile
+		}
+		if certFile != "" && keyFile != "" {
+			cfg.Server.TLS.Enabled = true
+		}
+	}
+
 	if raw, _ := getEnvValue(envDBDriver); raw != "" {
 		cfg.Database.Driver = raw
 	}
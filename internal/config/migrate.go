@@ -0,0 +1,429 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentConfigVersion 为当前的配置格式版本。没有 version 字段的配置视为版本 1，
+// 加载时按迁移链逐级升级（只在内存中生效），nginxpulse -migrate-config 把结果写回文件。
+const CurrentConfigVersion = 2
+
+const legacyConfigVersion = 1
+
+// configMigration 把配置从 from 版本升级到 from+1。
+// 迁移直接修改 YAML 节点（JSON 也按 YAML 解析），未涉及的字段与顺序保持不变。
+// conf.d 文件没有自己的版本号，每次都会执行完整的迁移链，因此 website 对已升级的站点不能产生改动。
+type configMigration struct {
+	from    int
+	website func(m *configMigrator, site *yaml.Node, field string)
+}
+
+var configMigrations = []configMigration{
+	{from: 1, website: migrateSiteParseToSources},
+}
+
+// legacyParseFields 为站点级的解析字段，配置了 sources 时由各 source 的 parse 覆盖
+var legacyParseFields = []string{"logType", "logFormat", "logRegex", "timeLayout"}
+
+// configMigrator 记录一个文件的迁移结果：是否有改动，以及弃用提示
+type configMigrator struct {
+	file    string
+	changed bool
+	notes   []FieldError
+}
+
+func (m *configMigrator) deprecate(node *yaml.Node, field, msg string) {
+	item := FieldError{Field: field, Message: msg, File: m.file}
+	if node != nil {
+		item.Line = node.Line
+	}
+	m.notes = append(m.notes, item)
+}
+
+// checkDeprecatedSite 检查无法自动迁移的弃用字段，每次加载都会执行。
+// 只配置 logPath 的站点不自动改写：改为 source 后解析进度按新的键记录，会导致日志重新解析。
+func (m *configMigrator) checkDeprecatedSite(site *yaml.Node, field string) {
+	if site == nil || site.Kind != yaml.MappingNode {
+		return
+	}
+	if sources := mappingValue(site, "sources"); sources != nil && sources.Kind == yaml.SequenceNode && len(sources.Content) > 0 {
+		return
+	}
+	if logPath := mappingValue(site, "logPath"); logPath != nil && strings.TrimSpace(logPath.Value) != "" {
+		m.deprecate(logPath, field+".logPath", "logPath 已弃用，建议改为 sources（type: local）；改写后日志会重新解析，因此不会自动迁移")
+	}
+}
+
+// migrateSiteParseToSources（1 -> 2）把站点级的 logType / logFormat / logRegex / timeLayout
+// 移到每个 source 的 parse 中，source 已有的设置优先；配置了 sources 时 logPath 不生效，直接删除。
+func migrateSiteParseToSources(m *configMigrator, site *yaml.Node, field string) {
+	if site == nil || site.Kind != yaml.MappingNode {
+		return
+	}
+	sources := mappingValue(site, "sources")
+	if sources == nil || sources.Kind != yaml.SequenceNode || len(sources.Content) == 0 {
+		return
+	}
+
+	for _, key := range legacyParseFields {
+		value := mappingValue(site, key)
+		if value == nil || value.Kind != yaml.ScalarNode || strings.TrimSpace(value.Value) == "" {
+			continue
+		}
+		for _, src := range sources.Content {
+			if src.Kind != yaml.MappingNode {
+				continue
+			}
+			parse := mappingValue(src, "parse")
+			if parse == nil || parse.Kind != yaml.MappingNode {
+				parse = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				setMappingValue(src, "parse", parse)
+			}
+			if existing := mappingValue(parse, key); existing != nil && strings.TrimSpace(existing.Value) != "" {
+				continue
+			}
+			copied := *value
+			setMappingValue(parse, key, &copied)
+		}
+		keyNode := removeMappingKey(site, key)
+		m.changed = true
+		m.deprecate(keyNode, field+"."+key, fmt.Sprintf("站点级 %s 已弃用，已迁移到各 source 的 parse.%s", key, key))
+	}
+
+	if logPath := mappingValue(site, "logPath"); logPath != nil {
+		keyNode := removeMappingKey(site, "logPath")
+		m.changed = true
+		if strings.TrimSpace(logPath.Value) != "" {
+			m.deprecate(keyNode, field+".logPath", "配置了 sources 时 logPath 不生效，已删除")
+		}
+	}
+}
+
+// migrateMainDocument 把主配置从其 version 升级到 CurrentConfigVersion
+func (m *configMigrator) migrateMainDocument(node *yaml.Node) error {
+	root := documentRoot(node)
+	if root == nil || root.Kind != yaml.MappingNode {
+		return nil
+	}
+	from := legacyConfigVersion
+	if versionNode := mappingValue(root, "version"); versionNode != nil {
+		parsed, err := strconv.Atoi(strings.TrimSpace(versionNode.Value))
+		if err != nil || parsed < legacyConfigVersion {
+			return fmt.Errorf("%s:%d: version 无效: %s", m.file, versionNode.Line, versionNode.Value)
+		}
+		if parsed > CurrentConfigVersion {
+			return fmt.Errorf("%s:%d: 配置版本 %d 高于当前程序支持的版本 %d，请升级 nginxpulse",
+				m.file, versionNode.Line, parsed, CurrentConfigVersion)
+		}
+		from = parsed
+	}
+
+	var sites []*yaml.Node
+	if websites := mappingValue(root, "websites"); websites != nil && websites.Kind == yaml.SequenceNode {
+		sites = websites.Content
+	}
+	if from < CurrentConfigVersion {
+		for _, migration := range configMigrations {
+			if migration.from < from {
+				continue
+			}
+			for i, site := range sites {
+				migration.website(m, site, fmt.Sprintf("websites[%d]", i))
+			}
+		}
+		m.setVersion(root, from)
+	}
+	for i, site := range sites {
+		m.checkDeprecatedSite(site, fmt.Sprintf("websites[%d]", i))
+	}
+	return nil
+}
+
+// setVersion 把主配置的 version 改为 CurrentConfigVersion，没有该字段时插入到最前面
+func (m *configMigrator) setVersion(root *yaml.Node, from int) {
+	versionValue := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(CurrentConfigVersion)}
+	if mappingValue(root, "version") != nil {
+		setMappingValue(root, "version", versionValue)
+	} else {
+		versionKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+		// 文件开头的注释挂在原来的第一个字段上，移到新插入的 version 上以保持在文件顶部
+		if len(root.Content) > 0 {
+			versionKey.HeadComment = root.Content[0].HeadComment
+			root.Content[0].HeadComment = ""
+		}
+		root.Content = append([]*yaml.Node{versionKey, versionValue}, root.Content...)
+	}
+	m.changed = true
+	m.deprecate(nil, "version", fmt.Sprintf("配置版本 %d 已在加载时升级到 %d，运行 nginxpulse -migrate-config 写回配置文件", from, CurrentConfigVersion))
+}
+
+// migrateIncludeDocument 迁移 conf.d 文件中的站点，offset 为第一个站点在合并后 websites 中的序号。
+// 返回文件中的站点数量。
+func (m *configMigrator) migrateIncludeDocument(node *yaml.Node, offset int) int {
+	root := documentRoot(node)
+	if root == nil || root.Kind != yaml.MappingNode {
+		return 0
+	}
+	sites := []*yaml.Node{root}
+	if websites := mappingValue(root, "websites"); websites != nil {
+		sites = nil
+		if websites.Kind == yaml.SequenceNode {
+			sites = websites.Content
+		}
+	}
+	m.migrateSites(sites, offset)
+	return len(sites)
+}
+
+// migrateSites 对没有版本号的站点列表（conf.d、WEBSITES 环境变量）执行完整的迁移链与弃用检查
+func (m *configMigrator) migrateSites(sites []*yaml.Node, offset int) {
+	for _, migration := range configMigrations {
+		for i, site := range sites {
+			migration.website(m, site, fmt.Sprintf("websites[%d]", offset+i))
+		}
+	}
+	for i, site := range sites {
+		m.checkDeprecatedSite(site, fmt.Sprintf("websites[%d]", offset+i))
+	}
+}
+
+// migrateWebsitesEnv 迁移 WEBSITES 环境变量中的站点数组。有改动时返回迁移后的 JSON，否则原样返回 data。
+func migrateWebsitesEnv(key string, data []byte) ([]byte, []FieldError, error) {
+	root := documentRoot(parseYAMLNode(data))
+	if root == nil || root.Kind != yaml.SequenceNode {
+		return data, nil, nil
+	}
+	migrator := configMigrator{file: key}
+	migrator.migrateSites(root.Content, 0)
+	if !migrator.changed {
+		return data, migrator.notes, nil
+	}
+	converted, err := yamlNodeToJSON(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析 %s 失败: %w", key, err)
+	}
+	return converted, migrator.notes, nil
+}
+
+// migrateMainConfig 迁移主配置内容。有改动时返回由迁移后节点转换的 JSON 供解码，否则原样返回 data。
+// 内容无法按 YAML 解析时不迁移，由后续解码报告错误。
+func migrateMainConfig(path string, data []byte) ([]byte, *yaml.Node, []FieldError, error) {
+	node := parseYAMLNode(data)
+	if node == nil {
+		return data, nil, nil, nil
+	}
+	migrator := configMigrator{file: path}
+	if err := migrator.migrateMainDocument(node); err != nil {
+		return nil, nil, nil, err
+	}
+	if !migrator.changed {
+		return data, node, migrator.notes, nil
+	}
+	converted, err := yamlNodeToJSON(node)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	return converted, node, migrator.notes, nil
+}
+
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// removeMappingKey 删除字段，返回被删除的键节点（用于行号）
+func removeMappingKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			keyNode := node.Content[i]
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return keyNode
+		}
+	}
+	return nil
+}
+
+// Deprecations 返回加载配置时迁移或发现的弃用字段
+func (c *Config) Deprecations() []FieldError {
+	if c == nil || len(c.deprecations) == 0 {
+		return []FieldError{}
+	}
+	return append([]FieldError(nil), c.deprecations...)
+}
+
+// ConfigFileMigration 为单个配置文件的迁移结果
+type ConfigFileMigration struct {
+	Path   string
+	Before []byte
+	After  []byte
+}
+
+// ConfigMigrationPlan 为 -migrate-config 的迁移计划：Files 只包含内容有变化的文件，
+// Notes 包含全部弃用提示（包括需要手动处理、未改写文件的字段）。
+type ConfigMigrationPlan struct {
+	Files []ConfigFileMigration
+	Notes []FieldError
+}
+
+// PlanConfigMigration 读取主配置与 conf.d 文件并执行迁移，不写入磁盘。
+func PlanConfigMigration() (ConfigMigrationPlan, error) {
+	plan := ConfigMigrationPlan{}
+	offset := 0
+
+	mainFile := filepath.Clean(ResolveConfigFile())
+	data, err := os.ReadFile(mainFile)
+	if err == nil {
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return plan, fmt.Errorf("解析 %s 失败: %w", mainFile, err)
+		}
+		migrator := configMigrator{file: mainFile}
+		if err := migrator.migrateMainDocument(&node); err != nil {
+			return plan, err
+		}
+		if err := plan.add(&migrator, &node, data); err != nil {
+			return plan, err
+		}
+		if websites := mappingValue(documentRoot(&node), "websites"); websites != nil && websites.Kind == yaml.SequenceNode {
+			offset = len(websites.Content)
+		}
+	} else if !os.IsNotExist(err) {
+		return plan, err
+	}
+
+	for _, path := range IncludeFiles() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return plan, err
+		}
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return plan, fmt.Errorf("解析 %s 失败: %w", path, err)
+		}
+		migrator := configMigrator{file: path}
+		offset += migrator.migrateIncludeDocument(&node, offset)
+		if err := plan.add(&migrator, &node, data); err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+func (p *ConfigMigrationPlan) add(m *configMigrator, node *yaml.Node, before []byte) error {
+	p.Notes = append(p.Notes, m.notes...)
+	if !m.changed {
+		return nil
+	}
+	after, err := encodeConfigNode(m.file, node)
+	if err != nil {
+		return fmt.Errorf("生成 %s 失败: %w", m.file, err)
+	}
+	if !bytes.Equal(before, after) {
+		p.Files = append(p.Files, ConfigFileMigration{Path: m.file, Before: before, After: after})
+	}
+	return nil
+}
+
+// ApplyConfigMigration 把迁移结果逐个原子写入
+func ApplyConfigMigration(plan ConfigMigrationPlan) error {
+	for _, file := range plan.Files {
+		if err := writeFileAtomic(file.Path, file.After); err != nil {
+			return fmt.Errorf("写入 %s 失败: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// encodeConfigNode 按文件原格式输出节点：YAML 保留注释，JSON 保留字段顺序并使用两个空格缩进
+func encodeConfigNode(path string, node *yaml.Node) ([]byte, error) {
+	if isYAMLFile(path) {
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(node); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	var compact bytes.Buffer
+	if err := writeNodeJSON(&compact, node); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, compact.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// writeNodeJSON 按节点中的字段顺序输出 JSON（yamlNodeToJSON 经过 map 转换，不保留顺序）
+func writeNodeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		return writeNodeJSON(buf, node.Content[0])
+	case yaml.AliasNode:
+		return writeNodeJSON(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeNodeJSON(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeNodeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	default:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(encoded)
+		return nil
+	}
+}
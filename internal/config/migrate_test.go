@@ -0,0 +1,224 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// migrateForTest 迁移一份主配置，返回按原格式输出的结果
+func migrateForTest(t *testing.T, path, input string) (string, *configMigrator) {
+	t.Helper()
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	migrator := &configMigrator{file: path}
+	if err := migrator.migrateMainDocument(&node); err != nil {
+		t.Fatal(err)
+	}
+	out, err := encodeConfigNode(path, &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), migrator
+}
+
+func noteFields(notes []FieldError) []string {
+	fields := make([]string, 0, len(notes))
+	for _, note := range notes {
+		fields = append(fields, note.Field)
+	}
+	return fields
+}
+
+func TestMigrateV1ToV2(t *testing.T) {
+	cases := []struct {
+		name    string
+		path    string
+		input   string
+		want    string
+		notes   []string
+		changed bool
+	}{
+		{
+			name: "site parse fields move into sources",
+			path: "config.json",
+			input: `{"websites":[{"name":"blog","logType":"nginx","logPath":"/var/log/a.log","timeLayout":"02/Jan/2006",
+				"sources":[{"id":"a","type":"local"},{"id":"b","type":"local","parse":{"logType":"caddy"}}]}]}`,
+			want: `{
+  "version": 2,
+  "websites": [
+    {
+      "name": "blog",
+      "sources": [
+        {
+          "id": "a",
+          "type": "local",
+          "parse": {
+            "logType": "nginx",
+            "timeLayout": "02/Jan/2006"
+          }
+        },
+        {
+          "id": "b",
+          "type": "local",
+          "parse": {
+            "logType": "caddy",
+            "timeLayout": "02/Jan/2006"
+          }
+        }
+      ]
+    }
+  ]
+}
+`,
+			notes:   []string{"websites[0].logType", "websites[0].timeLayout", "websites[0].logPath", "version"},
+			changed: true,
+		},
+		{
+			name:    "logPath only site is reported but not rewritten",
+			path:    "config.json",
+			input:   `{"websites":[{"name":"blog","logPath":"/var/log/a.log"}]}`,
+			want:    "{\n  \"version\": 2,\n  \"websites\": [\n    {\n      \"name\": \"blog\",\n      \"logPath\": \"/var/log/a.log\"\n    }\n  ]\n}\n",
+			notes:   []string{"version", "websites[0].logPath"},
+			changed: true,
+		},
+		{
+			name:    "current version is left alone",
+			path:    "config.json",
+			input:   `{"version":2,"websites":[{"name":"blog","logType":"nginx","sources":[{"id":"a"}]}]}`,
+			want:    "{\n  \"version\": 2,\n  \"websites\": [\n    {\n      \"name\": \"blog\",\n      \"logType\": \"nginx\",\n      \"sources\": [\n        {\n          \"id\": \"a\"\n        }\n      ]\n    }\n  ]\n}\n",
+			notes:   []string{},
+			changed: false,
+		},
+		{
+			name: "yaml keeps comments",
+			path: "config.yaml",
+			input: `# 主配置
+websites:
+  - name: blog # 博客
+    logFormat: '$remote_addr'
+    sources:
+      - id: a
+`,
+			want: `# 主配置
+version: 2
+websites:
+  - name: blog # 博客
+    sources:
+      - id: a
+        parse:
+          logFormat: '$remote_addr'
+`,
+			notes:   []string{"websites[0].logFormat", "version"},
+			changed: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, migrator := migrateForTest(t, tc.path, tc.input)
+			if got != tc.want {
+				t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, tc.want)
+			}
+			if migrator.changed != tc.changed {
+				t.Fatalf("changed = %v, want %v", migrator.changed, tc.changed)
+			}
+			if fields := noteFields(migrator.notes); strings.Join(fields, ",") != strings.Join(tc.notes, ",") {
+				t.Fatalf("notes = %v, want %v", fields, tc.notes)
+			}
+
+			// 迁移结果再次迁移时不应有任何改动
+			again, second := migrateForTest(t, tc.path, got)
+			if second.changed || again != got {
+				t.Fatalf("migration is not idempotent:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestMigrateRejectsInvalidVersion(t *testing.T) {
+	cases := map[string]string{
+		"too new": `{"version": 3}`,
+		"invalid": `{"version": "two"}`,
+		"zero":    `{"version": 0}`,
+	}
+	for name, input := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, _, _, err := migrateMainConfig("config.json", []byte(input))
+			if err == nil || !strings.Contains(err.Error(), "config.json:1") {
+				t.Fatalf("expected a located version error, got %v", err)
+			}
+		})
+	}
+	if _, _, _, err := migrateMainConfig("config.json", []byte(`{"version": 3}`)); !strings.Contains(err.Error(), "请升级") {
+		t.Fatalf("too new version should ask for an upgrade, got %v", err)
+	}
+}
+
+func TestEncodeConfigNodeKeepsKeyOrder(t *testing.T) {
+	input := `{"websites":[{"sources":[{"type":"local","id":"a"}],"name":"blog"}],"server":{"Port":":8089"},"system":{"taskInterval":"1m","accessKeys":["k"]}}`
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &node); err != nil {
+		t.Fatal(err)
+	}
+	out, err := encodeConfigNode("config.json", &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, out); err != nil {
+		t.Fatal(err)
+	}
+	if compact.String() != input {
+		t.Fatalf("key order changed:\n%s\nwant:\n%s", compact.String(), input)
+	}
+}
+
+func TestMigrateWebsitesEnv(t *testing.T) {
+	raw := `[{"name":"blog","logRegex":"^(?P<ip>\\S+)","sources":[{"id":"a"}]},{"name":"docs","logPath":"/var/log/docs.log"}]`
+	migrated, notes, err := migrateWebsitesEnv(envWebsites, []byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var websites []WebsiteConfig
+	if err := json.Unmarshal(migrated, &websites); err != nil {
+		t.Fatal(err)
+	}
+	if websites[0].LogRegex != "" || websites[0].Sources[0].Parse == nil || websites[0].Sources[0].Parse.LogRegex != `^(?P<ip>\S+)` {
+		t.Fatalf("site regex not moved into the source: %+v", websites[0])
+	}
+	if fields := noteFields(notes); strings.Join(fields, ",") != "websites[0].logRegex,websites[1].logPath" {
+		t.Fatalf("unexpected notes %v", fields)
+	}
+	if notes[0].File != envWebsites {
+		t.Fatalf("notes should point at the env var, got %q", notes[0].File)
+	}
+
+	unchanged := `[{"name":"docs","sources":[{"id":"a"}]}]`
+	if migrated, notes, _ := migrateWebsitesEnv(envWebsites, []byte(unchanged)); string(migrated) != unchanged || len(notes) != 0 {
+		t.Fatalf("current sites must be returned as is, got %s %v", migrated, notes)
+	}
+}
+
+func TestLoadRawConfigMigratesWebsitesEnv(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv(envWebsites, `[{"name":"blog","logType":"nginx","sources":[{"id":"a","type":"local","path":"/var/log/a.log"}]}]`)
+	cfg, err := loadRawConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != CurrentConfigVersion {
+		t.Fatalf("version = %d", cfg.Version)
+	}
+	site := cfg.Websites[0]
+	if site.LogType != "" || site.Sources[0].Parse == nil || site.Sources[0].Parse.LogType != "nginx" {
+		t.Fatalf("WEBSITES sites must be migrated: %+v", site)
+	}
+	if fields := noteFields(cfg.Deprecations()); strings.Join(fields, ",") != "websites[0].logType" {
+		t.Fatalf("unexpected deprecations %v", fields)
+	}
+}
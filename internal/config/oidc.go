@@ -0,0 +1,110 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	defaultOIDCUsernameClaim = "preferred_username"
+	defaultOIDCGroupsClaim   = "groups"
+)
+
+var defaultOIDCScopes = []string{"openid", "profile", "email"}
+
+// oidcRoles 为映射中允许的角色，与 auth 包的角色保持一致。
+var oidcRoles = map[string]bool{"admin": true, "editor": true, "viewer": true}
+
+// OIDCConfig 为 OIDC 单点登录配置，使用授权码 + PKCE 流程。
+type OIDCConfig struct {
+	Enabled bool `json:"enabled"`
+	// Issuer 为 IdP 地址，首次登录时读取 <issuer>/.well-known/openid-configuration。
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// RedirectURL 为回调地址，默认按请求地址与 webBasePath 推导为 .../api/auth/oidc/callback。
+	RedirectURL string `json:"redirectUrl,omitempty"`
+	// PostLogoutRedirectURL 为 IdP 注销后的返回地址，默认回到首页。
+	PostLogoutRedirectURL string   `json:"postLogoutRedirectUrl,omitempty"`
+	Scopes                []string `json:"scopes,omitempty"`
+	// UsernameClaim 为用户名使用的声明，默认 preferred_username，缺失时依次使用 email、sub。
+	UsernameClaim string `json:"usernameClaim,omitempty"`
+	// GroupsClaim 为映射默认匹配的声明，默认 groups。
+	GroupsClaim  string            `json:"groupsClaim,omitempty"`
+	RoleMappings []OIDCRoleMapping `json:"roleMappings,omitempty"`
+	// DefaultRole 为没有匹配任何映射时的角色，为空表示拒绝登录。
+	DefaultRole     string   `json:"defaultRole,omitempty"`
+	DefaultWebsites []string `json:"defaultWebsites,omitempty"`
+	// ButtonLabel 为登录页单点登录按钮的文字。
+	ButtonLabel string `json:"buttonLabel,omitempty"`
+}
+
+// OIDCRoleMapping 把声明值映射为角色与站点范围。
+// Websites 可填写站点名称、站点 ID 或 group:<name>，为空表示全部站点。
+type OIDCRoleMapping struct {
+	Claim    string   `json:"claim,omitempty"`
+	Value    string   `json:"value"`
+	Role     string   `json:"role"`
+	Websites []string `json:"websites,omitempty"`
+}
+
+// GetOIDCConfig 返回补齐默认值的 OIDC 配置，未启用时返回 nil。
+func GetOIDCConfig() *OIDCConfig {
+	cfg := ReadConfig().System.OIDC
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	result := *cfg
+	result.Issuer = strings.TrimRight(strings.TrimSpace(result.Issuer), "/")
+	result.ClientID = strings.TrimSpace(result.ClientID)
+	if len(result.Scopes) == 0 {
+		result.Scopes = append([]string(nil), defaultOIDCScopes...)
+	} else if !containsString(result.Scopes, "openid") {
+		result.Scopes = append([]string{"openid"}, result.Scopes...)
+	}
+	if strings.TrimSpace(result.UsernameClaim) == "" {
+		result.UsernameClaim = defaultOIDCUsernameClaim
+	}
+	if strings.TrimSpace(result.GroupsClaim) == "" {
+		result.GroupsClaim = defaultOIDCGroupsClaim
+	}
+	result.RoleMappings = make([]OIDCRoleMapping, 0, len(cfg.RoleMappings))
+	for _, mapping := range cfg.RoleMappings {
+		if strings.TrimSpace(mapping.Claim) == "" {
+			mapping.Claim = result.GroupsClaim
+		}
+		mapping.Role = strings.ToLower(strings.TrimSpace(mapping.Role))
+		result.RoleMappings = append(result.RoleMappings, mapping)
+	}
+	result.DefaultRole = strings.ToLower(strings.TrimSpace(result.DefaultRole))
+	return &result
+}
+
+// ResolveWebsiteRefs 把站点名称、站点 ID 与 group:<name> 展开为去重排序后的站点 ID，
+// 无法匹配的条目会被忽略。
+func ResolveWebsiteRefs(values []string) []string {
+	websites := ReadConfig().Websites
+	seen := make(map[string]bool)
+	ids := make([]string, 0, len(values))
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, ScopeGroupPrefix) {
+			members, _ := ResolveWebsiteScope(value)
+			for _, id := range members {
+				add(id)
+			}
+			continue
+		}
+		if id, ok := resolveGroupMember(websites, value); ok {
+			add(id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
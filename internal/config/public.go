@@ -0,0 +1,23 @@
+package config
+
+const defaultPublicRoundTo = 10
+
+// PublicDashboardConfig 控制站点的公开统计页，开启后无需登录即可查看总览、趋势、热门页面、来源与国家。
+type PublicDashboardConfig struct {
+	Enabled bool `json:"enabled"`
+	// RoundTo 为计数的取整粒度，默认 10；低于该值的明细行不公开。
+	RoundTo int `json:"roundTo,omitempty"`
+}
+
+// GetPublicDashboard 返回站点的公开统计配置，站点不存在或未开启时返回 false。
+func GetPublicDashboard(websiteID string) (PublicDashboardConfig, bool) {
+	website, ok := GetWebsiteByID(websiteID)
+	if !ok || website.Public == nil || !website.Public.Enabled {
+		return PublicDashboardConfig{}, false
+	}
+	result := *website.Public
+	if result.RoundTo <= 0 {
+		result.RoundTo = defaultPublicRoundTo
+	}
+	return result, true
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryAPIMaxRows = 1000
+	maxQueryAPIMaxRows     = 10000
+	defaultQueryAPITimeout = 10 * time.Second
+	maxQueryAPITimeout     = 2 * time.Minute
+)
+
+// QueryAPIConfig 控制 /api/query 表达式查询的行数与耗时上限。
+type QueryAPIConfig struct {
+	// Disabled 为 true 时关闭 /api/query。
+	Disabled bool `json:"disabled"`
+	// MaxRows 为单次查询返回的最大行数，默认 1000，最大 10000。
+	MaxRows int `json:"maxRows,omitempty"`
+	// Timeout 为单次查询的超时时间（Go duration），默认 10s，最大 2m。
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// QueryAPILimits 为补齐默认值后的查询限制。
+type QueryAPILimits struct {
+	Enabled bool
+	MaxRows int
+	Timeout time.Duration
+}
+
+// GetQueryAPILimits 返回 /api/query 的有效限制。
+func GetQueryAPILimits() QueryAPILimits {
+	result := QueryAPILimits{
+		Enabled: true,
+		MaxRows: defaultQueryAPIMaxRows,
+		Timeout: defaultQueryAPITimeout,
+	}
+	cfg := ReadConfig().System.QueryAPI
+	if cfg == nil {
+		return result
+	}
+	result.Enabled = !cfg.Disabled
+	if cfg.MaxRows > 0 {
+		result.MaxRows = cfg.MaxRows
+		if result.MaxRows > maxQueryAPIMaxRows {
+			result.MaxRows = maxQueryAPIMaxRows
+		}
+	}
+	if timeout := strings.TrimSpace(cfg.Timeout); timeout != "" {
+		if parsed, err := time.ParseDuration(timeout); err == nil && parsed > 0 {
+			result.Timeout = parsed
+			if result.Timeout > maxQueryAPITimeout {
+				result.Timeout = maxQueryAPITimeout
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,28 @@
+package config
+
+const defaultTokenRequestsPerMinute = 120
+
+// RateLimitConfig 控制 HTTP API 的限流，超出时返回 429 与 Retry-After。
+type RateLimitConfig struct {
+	// IPPerMinute 为每个客户端 IP 每分钟的请求数，0 表示不限制（agent 推送接口不计入）。
+	IPPerMinute int `json:"ipPerMinute,omitempty"`
+	// TokenPerMinute 为每个 API 令牌每分钟的默认请求数，默认 120；令牌可单独设置。
+	TokenPerMinute int `json:"tokenPerMinute,omitempty"`
+	// Burst 为允许的突发请求数，0 表示与每分钟请求数相同。
+	Burst int `json:"burst,omitempty"`
+}
+
+// GetRateLimitConfig 返回补齐默认值后的限流配置。
+func GetRateLimitConfig() RateLimitConfig {
+	result := RateLimitConfig{TokenPerMinute: defaultTokenRequestsPerMinute}
+	cfg := ReadConfig().System.RateLimit
+	if cfg == nil {
+		return result
+	}
+	result.IPPerMinute = cfg.IPPerMinute
+	result.Burst = cfg.Burst
+	if cfg.TokenPerMinute > 0 {
+		result.TokenPerMinute = cfg.TokenPerMinute
+	}
+	return result
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrReloadInProgress 表示已有一次配置热加载正在进行
+var ErrReloadInProgress = errors.New("配置正在重新加载，请稍后重试")
+
+// ReloadValidationError 表示新配置未通过校验，运行中的配置保持不变
+type ReloadValidationError struct {
+	Result ValidationResult
+}
+
+func (e *ReloadValidationError) Error() string {
+	if len(e.Result.Errors) == 0 {
+		return "配置校验失败"
+	}
+	return "配置校验失败: " + e.Result.Errors[0].String()
+}
+
+// ReloadDiff 为一次热加载前后的配置差异，站点以 ID 标识、同时给出名称。
+type ReloadDiff struct {
+	WebsitesAdded    []WebsiteRef `json:"websitesAdded"`
+	WebsitesRemoved  []WebsiteRef `json:"websitesRemoved"`
+	WebsitesChanged  []WebsiteRef `json:"websitesChanged"`
+	SourcesChanged   []WebsiteRef `json:"sourcesChanged"`
+	WhitelistChanged []WebsiteRef `json:"whitelistChanged"`
+	ParseChanged     []WebsiteRef `json:"parseChanged"`
+	PVFilterChanged  bool         `json:"pvFilterChanged"`
+	// WebsiteGroupsChanged / ReportsChanged 表示顶层 websiteGroups、reports 有变化，已即时生效
+	WebsiteGroupsChanged bool    `json:"websiteGroupsChanged"`
+	ReportsChanged       bool    `json:"reportsChanged"`
+	AccessKeys           KeyDiff `json:"accessKeys"`
+	AgentTokens          KeyDiff `json:"agentTokens"`
+	// SystemChanged 为发生变化的 system 字段（JSON 名称），已即时生效
+	SystemChanged []string `json:"systemChanged"`
+	// RestartRequired 为发生变化但只在启动时读取的字段，需要重启服务才能生效
+	RestartRequired []string `json:"restartRequired"`
+}
+
+type WebsiteRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// KeyDiff 只记录数量，不返回密钥本身
+type KeyDiff struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// Changed 报告两份配置之间是否存在差异
+func (d *ReloadDiff) Changed() bool {
+	return len(d.WebsitesAdded) > 0 || len(d.WebsitesRemoved) > 0 || len(d.WebsitesChanged) > 0 ||
+		d.PVFilterChanged || d.WebsiteGroupsChanged || d.ReportsChanged || d.AccessKeys.Added > 0 || d.AccessKeys.Removed > 0 ||
+		d.AgentTokens.Added > 0 || d.AgentTokens.Removed > 0 ||
+		len(d.SystemChanged) > 0 || len(d.RestartRequired) > 0
+}
+
+type reloadHook struct {
+	before func(next *Config, diff *ReloadDiff) error
+	after  func(cfg *Config, diff *ReloadDiff)
+}
+
+var (
+	reloadMu    sync.Mutex
+	reloadHooks []reloadHook
+)
+
+// OnReload 注册配置热加载回调。before 在替换配置前执行（例如为新增站点建表），
+// 返回错误时放弃本次加载；after 在替换配置后执行，用于刷新各模块缓存的规则。两者均可为 nil。
+func OnReload(before func(next *Config, diff *ReloadDiff) error, after func(cfg *Config, diff *ReloadDiff)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, reloadHook{before: before, after: after})
+}
+
+// ReloadConfig 重新读取配置文件（含环境变量覆盖），校验通过后原子替换当前配置与站点映射，
+// 并依次执行 OnReload 注册的回调。读取或校验失败时运行中的配置保持不变。
+func ReloadConfig() (*ReloadDiff, error) {
+	if !reloadMu.TryLock() {
+		return nil, ErrReloadInProgress
+	}
+	defer reloadMu.Unlock()
+
+	next, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("读取配置失败: %w", err)
+	}
+	result := ValidateConfig(next, ValidateOptions{})
+	if len(result.Errors) > 0 {
+		return nil, &ReloadValidationError{Result: result}
+	}
+
+	current := ReadConfig()
+	diff := DiffConfig(current, next)
+	for _, hook := range reloadHooks {
+		if hook.before == nil {
+			continue
+		}
+		if err := hook.before(next, diff); err != nil {
+			return nil, err
+		}
+	}
+
+	websites := buildWebsiteIDMap(next)
+	configMu.Lock()
+	globalConfig = next
+	websiteIDMap = websites
+	configMu.Unlock()
+
+	for _, hook := range reloadHooks {
+		if hook.after != nil {
+			hook.after(next, diff)
+		}
+	}
+	return diff, nil
+}
+
+// DiffConfig 比较两份配置，nil 视为空配置
+func DiffConfig(prev, next *Config) *ReloadDiff {
+	if prev == nil {
+		prev = &Config{}
+	}
+	if next == nil {
+		next = &Config{}
+	}
+	diff := &ReloadDiff{
+		WebsitesAdded:    []WebsiteRef{},
+		WebsitesRemoved:  []WebsiteRef{},
+		WebsitesChanged:  []WebsiteRef{},
+		SourcesChanged:   []WebsiteRef{},
+		WhitelistChanged: []WebsiteRef{},
+		ParseChanged:     []WebsiteRef{},
+		SystemChanged:    []string{},
+		RestartRequired:  []string{},
+	}
+
+	prevSites := buildWebsiteIDMap(prev)
+	nextSites := buildWebsiteIDMap(next)
+	for _, id := range sortedWebsiteIDs(nextSites) {
+		site := nextSites[id]
+		ref := WebsiteRef{ID: id, Name: site.Name}
+		old, ok := prevSites[id]
+		if !ok {
+			diff.WebsitesAdded = append(diff.WebsitesAdded, ref)
+			continue
+		}
+		if reflect.DeepEqual(old, site) {
+			continue
+		}
+		diff.WebsitesChanged = append(diff.WebsitesChanged, ref)
+		if old.LogPath != site.LogPath || !reflect.DeepEqual(old.Sources, site.Sources) {
+			diff.SourcesChanged = append(diff.SourcesChanged, ref)
+		}
+		if !reflect.DeepEqual(old.Whitelist, site.Whitelist) {
+			diff.WhitelistChanged = append(diff.WhitelistChanged, ref)
+		}
+		if old.LogType != site.LogType || old.LogFormat != site.LogFormat ||
+			old.LogRegex != site.LogRegex || old.TimeLayout != site.TimeLayout {
+			diff.ParseChanged = append(diff.ParseChanged, ref)
+		}
+	}
+	for _, id := range sortedWebsiteIDs(prevSites) {
+		if _, ok := nextSites[id]; !ok {
+			diff.WebsitesRemoved = append(diff.WebsitesRemoved, WebsiteRef{ID: id, Name: prevSites[id].Name})
+		}
+	}
+
+	diff.PVFilterChanged = !reflect.DeepEqual(prev.PVFilter, next.PVFilter)
+	diff.WebsiteGroupsChanged = !reflect.DeepEqual(prev.WebsiteGroups, next.WebsiteGroups)
+	diff.ReportsChanged = !reflect.DeepEqual(prev.Reports, next.Reports)
+	diff.AccessKeys = diffKeys(prev.System.AccessKeys, next.System.AccessKeys)
+	diff.AgentTokens = diffKeys(agentTokenKeys(prev.System.AgentTokens), agentTokenKeys(next.System.AgentTokens))
+
+	restartOnly := map[string]bool{
+		"logDestination": true,
+		"taskInterval":   true,
+		"demoMode":       true,
+		"webBasePath":    true,
+	}
+	for _, field := range diffJSONFields(prev.System, next.System) {
+		switch {
+		case field == "accessKeys" || field == "agentTokens":
+			// 已在 AccessKeys / AgentTokens 中单独统计
+		case restartOnly[field]:
+			diff.RestartRequired = append(diff.RestartRequired, "system."+field)
+		default:
+			diff.SystemChanged = append(diff.SystemChanged, field)
+		}
+	}
+	if strings.TrimSpace(prev.Server.Port) != strings.TrimSpace(next.Server.Port) {
+		diff.RestartRequired = append(diff.RestartRequired, "server.Port")
+	}
+	if !reflect.DeepEqual(prev.Server.TLS, next.Server.TLS) {
+		diff.RestartRequired = append(diff.RestartRequired, "server.tls")
+	}
+	if !reflect.DeepEqual(prev.Server.TrustedProxies, next.Server.TrustedProxies) {
+		diff.RestartRequired = append(diff.RestartRequired, "server.trustedProxies")
+	}
+	if !reflect.DeepEqual(prev.Database, next.Database) {
+		diff.RestartRequired = append(diff.RestartRequired, "database")
+	}
+	return diff
+}
+
+func sortedWebsiteIDs(websites map[string]WebsiteConfig) []string {
+	ids := make([]string, 0, len(websites))
+	for id := range websites {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func agentTokenKeys(tokens []AgentTokenConfig) []string {
+	keys := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		// 令牌相同但绑定站点或签名密钥变化时也视为替换
+		keys = append(keys, token.Token+"\x00"+token.WebsiteID+"\x00"+token.AgentID+"\x00"+token.HMACSecret)
+	}
+	return keys
+}
+
+func diffKeys(prev, next []string) KeyDiff {
+	prevSet := make(map[string]bool, len(prev))
+	for _, key := range prev {
+		if key = strings.TrimSpace(key); key != "" {
+			prevSet[key] = true
+		}
+	}
+	nextSet := make(map[string]bool, len(next))
+	for _, key := range next {
+		if key = strings.TrimSpace(key); key != "" {
+			nextSet[key] = true
+		}
+	}
+	var diff KeyDiff
+	for key := range nextSet {
+		if !prevSet[key] {
+			diff.Added++
+		}
+	}
+	for key := range prevSet {
+		if !nextSet[key] {
+			diff.Removed++
+		}
+	}
+	return diff
+}
+
+// diffJSONFields 按 JSON 字段名比较两个结构体，返回值不同的字段
+func diffJSONFields(prev, next interface{}) []string {
+	prevFields := jsonFields(prev)
+	nextFields := jsonFields(next)
+	names := make([]string, 0, len(nextFields))
+	for name, value := range nextFields {
+		if string(prevFields[name]) != string(value) {
+			names = append(names, name)
+		}
+	}
+	for name := range prevFields {
+		if _, ok := nextFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func jsonFields(value interface{}) map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	_ = json.Unmarshal(payload, &fields)
+	return fields
+}
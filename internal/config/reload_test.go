@@ -0,0 +1,50 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func cloneConfig(t *testing.T, cfg *Config) *Config {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copied Config
+	if err := json.Unmarshal(data, &copied); err != nil {
+		t.Fatal(err)
+	}
+	return &copied
+}
+
+func TestDiffConfigTopLevelSections(t *testing.T) {
+	base := &Config{
+		Websites:      []WebsiteConfig{{Name: "blog", LogPath: "/var/log/blog.log"}},
+		WebsiteGroups: []WebsiteGroupConfig{{Name: "all-blogs", Websites: []string{"blog"}}},
+		Reports:       []ReportConfig{{Name: "daily", Website: "blog", Schedule: "@daily"}},
+	}
+	if diff := DiffConfig(base, cloneConfig(t, base)); diff.Changed() {
+		t.Fatalf("identical configs reported as changed: %+v", diff)
+	}
+
+	groups := cloneConfig(t, base)
+	groups.WebsiteGroups[0].Websites = append(groups.WebsiteGroups[0].Websites, "docs")
+	diff := DiffConfig(base, groups)
+	if !diff.WebsiteGroupsChanged || diff.ReportsChanged || !diff.Changed() {
+		t.Fatalf("website group change not detected: %+v", diff)
+	}
+
+	reports := cloneConfig(t, base)
+	reports.Reports[0].Schedule = "@weekly"
+	diff = DiffConfig(base, reports)
+	if !diff.ReportsChanged || diff.WebsiteGroupsChanged || !diff.Changed() {
+		t.Fatalf("report change not detected: %+v", diff)
+	}
+
+	removed := cloneConfig(t, base)
+	removed.Reports = nil
+	if diff := DiffConfig(base, removed); !diff.ReportsChanged {
+		t.Fatal("removing all reports must be reported")
+	}
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	defaultReportTimeRange = "yesterday"
+	defaultReportTopN      = 10
+	maxReportTopN          = 50
+)
+
+// ReportTimeRanges 为报表支持的统计时间范围，与统计接口的 timeRange 取值一致。
+var ReportTimeRanges = []string{"today", "yesterday", "week", "last7days", "month", "last30days"}
+
+// ReportWebhookFormats 为 webhook 支持的消息格式。
+var ReportWebhookFormats = []string{"json", "markdown"}
+
+// ReportConfig 是一份定时摘要报表。
+type ReportConfig struct {
+	Name string `json:"name"`
+	// Website 为站点名称、站点 ID、all 或 group:<name>。
+	Website string `json:"website"`
+	// Schedule 为 5 段 cron 表达式（分 时 日 月 周），也支持 @daily、@weekly 等别名。
+	Schedule string `json:"schedule"`
+	// Timezone 为 schedule 使用的 IANA 时区，默认服务器本地时区。
+	Timezone  string                `json:"timezone,omitempty"`
+	TimeRange string                `json:"timeRange,omitempty"`
+	TopN      int                   `json:"topN,omitempty"`
+	Email     []string              `json:"email,omitempty"`
+	Webhooks  []ReportWebhookConfig `json:"webhooks,omitempty"`
+	Disabled  bool                  `json:"disabled,omitempty"`
+}
+
+type ReportWebhookConfig struct {
+	URL string `json:"url"`
+	// Format 为 json（完整摘要）或 markdown（{"title","text"}，适用于聊天机器人）。
+	Format  string            `json:"format,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// SMTPConfig 是发送邮件使用的 SMTP 服务。
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from"`
+	// Security 为 starttls（默认）、tls 或 none。
+	Security string `json:"security,omitempty"`
+}
+
+// GetReports 返回补齐默认值后的报表配置。
+func GetReports() []ReportConfig {
+	cfg := ReadConfig()
+	reports := make([]ReportConfig, 0, len(cfg.Reports))
+	for _, item := range cfg.Reports {
+		reports = append(reports, normalizeReport(item))
+	}
+	return reports
+}
+
+// GetReport 按名称查找报表。
+func GetReport(name string) (ReportConfig, bool) {
+	name = strings.TrimSpace(name)
+	for _, item := range GetReports() {
+		if item.Name == name {
+			return item, true
+		}
+	}
+	return ReportConfig{}, false
+}
+
+func normalizeReport(item ReportConfig) ReportConfig {
+	item.Name = strings.TrimSpace(item.Name)
+	item.Website = strings.TrimSpace(item.Website)
+	item.Schedule = strings.TrimSpace(item.Schedule)
+	item.Timezone = strings.TrimSpace(item.Timezone)
+	if item.TimeRange == "" {
+		item.TimeRange = defaultReportTimeRange
+	}
+	if item.TopN <= 0 {
+		item.TopN = defaultReportTopN
+	} else if item.TopN > maxReportTopN {
+		item.TopN = maxReportTopN
+	}
+	webhooks := make([]ReportWebhookConfig, 0, len(item.Webhooks))
+	for _, hook := range item.Webhooks {
+		hook.Format = strings.ToLower(strings.TrimSpace(hook.Format))
+		if hook.Format == "" {
+			hook.Format = "json"
+		}
+		webhooks = append(webhooks, hook)
+	}
+	item.Webhooks = webhooks
+	return item
+}
+
+// ReportLocation 返回报表 schedule 使用的时区。
+func ReportLocation(report ReportConfig) (*time.Location, error) {
+	if report.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(report.Timezone)
+}
+
+// ResolveReportWebsites 将报表的 website 解析为统计接口使用的 id 与其覆盖的站点 ID。
+func ResolveReportWebsites(website string) (string, []string, bool) {
+	website = strings.TrimSpace(website)
+	if IsWebsiteScope(website) {
+		ids, ok := ResolveWebsiteScope(website)
+		return website, ids, ok
+	}
+	id, ok := resolveGroupMember(ReadConfig().Websites, website)
+	if !ok {
+		return "", nil, false
+	}
+	return id, []string{id}, true
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// SecretPlaceholder 为 /api/config 返回的脱敏占位符，保存时原样提交表示沿用原值。
+	SecretPlaceholder = "******"
+
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+	secretEncPrefix  = "enc:"
+)
+
+var errMasterKeyMissing = errors.New("未配置主密钥（" + envMasterKey + " 或 " + envMasterKeyFile + "）")
+
+// secretFingerprintKey 为占位符指纹的进程内随机密钥，指纹无法用来离线猜测原值；
+// 服务重启后旧页面提交的指纹占位符不再匹配，由校验提示重新填写。
+var secretFingerprintKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// secretField 为配置中的一个敏感字段。path 与校验错误的字段路径一致；
+// key 按站点名称与来源 ID 定位，调整站点或来源顺序后保存仍能找回原值。
+// group 非空的字段没有可用的名称（访问密钥、未限定 agentId 的 agent 令牌），
+// 占位符附带原值的指纹，保存时在同一 group 内按指纹找回原值，删除或调整顺序都不会错位。
+type secretField struct {
+	path  string
+	key   string
+	group string
+	get   func() string
+	set   func(string)
+}
+
+func stringSecret(path, key string, value *string) secretField {
+	return secretField{
+		path: path,
+		key:  key,
+		get:  func() string { return *value },
+		set:  func(next string) { *value = next },
+	}
+}
+
+func headerSecrets(path, key string, headers map[string]string) []secretField {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]secretField, 0, len(names))
+	for _, name := range names {
+		name := name
+		fields = append(fields, secretField{
+			path: path + "." + name,
+			key:  key + "." + strings.ToLower(name),
+			get:  func() string { return headers[name] },
+			set:  func(next string) { headers[name] = next },
+		})
+	}
+	return fields
+}
+
+// secretFields 列出配置中所有敏感字段：访问密钥、agent 令牌与签名密钥、来源凭据与请求头、
+// 数据库 DSN、SMTP 密码、OIDC 客户端密钥
+func secretFields(cfg *Config) []secretField {
+	fields := []secretField{
+		stringSecret("database.dsn", "database.dsn", &cfg.Database.DSN),
+	}
+	// 访问密钥没有名称，按占位符中的指纹找回原值
+	for i := range cfg.System.AccessKeys {
+		field := stringSecret(fmt.Sprintf("system.accessKeys[%d]", i), "", &cfg.System.AccessKeys[i])
+		field.group = "system.accessKeys"
+		fields = append(fields, field)
+	}
+	// agent 令牌按站点与 agentId 定位，未配置 agentId 时按指纹找回原值
+	for i := range cfg.System.AgentTokens {
+		token := &cfg.System.AgentTokens[i]
+		path := fmt.Sprintf("system.agentTokens[%d]", i)
+		tokenField := stringSecret(path+".token", "", &token.Token)
+		hmacField := stringSecret(path+".hmacSecret", "", &token.HMACSecret)
+		if agentID := strings.TrimSpace(token.AgentID); agentID != "" {
+			key := fmt.Sprintf("system.agentTokens[%s|%s]", strings.TrimSpace(token.WebsiteID), agentID)
+			tokenField.key = key + ".token"
+			hmacField.key = key + ".hmacSecret"
+		} else {
+			tokenField.group = "system.agentTokens.token"
+			hmacField.group = "system.agentTokens.hmacSecret"
+		}
+		fields = append(fields, tokenField, hmacField)
+	}
+	if cfg.System.SMTP != nil {
+		fields = append(fields, stringSecret("system.smtp.password", "system.smtp.password", &cfg.System.SMTP.Password))
+	}
+	if cfg.System.OIDC != nil {
+		fields = append(fields, stringSecret("system.oidc.clientSecret", "system.oidc.clientSecret", &cfg.System.OIDC.ClientSecret))
+	}
+	for i := range cfg.Websites {
+		site := &cfg.Websites[i]
+		for j := range site.Sources {
+			src := &site.Sources[j]
+			path := fmt.Sprintf("websites[%d].sources[%d]", i, j)
+			sourceKey := strings.TrimSpace(src.ID)
+			if sourceKey == "" {
+				sourceKey = fmt.Sprintf("#%d", j)
+			}
+			key := fmt.Sprintf("websites[%s].sources[%s]", strings.TrimSpace(site.Name), sourceKey)
+			if src.Auth != nil {
+				fields = append(fields, stringSecret(path+".auth.password", key+".auth.password", &src.Auth.Password))
+			}
+			fields = append(fields,
+				stringSecret(path+".accessKey", key+".accessKey", &src.AccessKey),
+				stringSecret(path+".secretKey", key+".secretKey", &src.SecretKey),
+			)
+			fields = append(fields, headerSecrets(path+".headers", key+".headers", src.Headers)...)
+			if src.Index != nil {
+				fields = append(fields, headerSecrets(path+".index.headers", key+".index.headers", src.Index.Headers)...)
+			}
+		}
+	}
+	return fields
+}
+
+// placeholder 返回字段脱敏后的占位符，group 非空时附带原值的指纹
+func (f secretField) placeholder(value string) string {
+	if f.group == "" {
+		return SecretPlaceholder
+	}
+	mac := hmac.New(sha256.New, secretFingerprintKey)
+	mac.Write([]byte(f.group))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return SecretPlaceholder + "#" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// IsSecretPlaceholder 判断取值是否为脱敏占位符（含带指纹的占位符）
+func IsSecretPlaceholder(value string) bool {
+	return value == SecretPlaceholder || strings.HasPrefix(value, SecretPlaceholder+"#")
+}
+
+// IsSecretReference 判断取值是否为密钥引用（env:、file:、enc:）
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretEnvPrefix) ||
+		strings.HasPrefix(value, secretFilePrefix) ||
+		strings.HasPrefix(value, secretEncPrefix)
+}
+
+// ResolveSecret 解析密钥引用：env:NAME 读取环境变量，file:/path 读取文件内容（去掉首尾空白），
+// enc:... 使用主密钥解密；其他取值原样返回。
+func ResolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimSpace(strings.TrimPrefix(value, secretEnvPrefix))
+		if name == "" {
+			return "", errors.New("env: 引用缺少变量名")
+		}
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("环境变量 %s 未设置", name)
+		}
+		return resolved, nil
+	case strings.HasPrefix(value, secretFilePrefix):
+		path := strings.TrimSpace(strings.TrimPrefix(value, secretFilePrefix))
+		if path == "" {
+			return "", errors.New("file: 引用缺少文件路径")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("读取密钥文件失败: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(value, secretEncPrefix):
+		return decryptSecret(strings.TrimPrefix(value, secretEncPrefix))
+	default:
+		return value, nil
+	}
+}
+
+// resolveSecrets 在加载配置时把所有密钥引用替换为实际取值
+func resolveSecrets(cfg *Config) error {
+	for _, field := range secretFields(cfg) {
+		value := field.get()
+		if !IsSecretReference(value) {
+			continue
+		}
+		resolved, err := ResolveSecret(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.path, err)
+		}
+		field.set(resolved)
+	}
+	return nil
+}
+
+// RedactSecrets 把明文密钥替换为占位符；密钥引用本身不含密钥，保留原样便于在界面查看。
+func RedactSecrets(cfg *Config) {
+	if cfg == nil {
+		return
+	}
+	for _, field := range secretFields(cfg) {
+		value := field.get()
+		if value != "" && !IsSecretReference(value) {
+			field.set(field.placeholder(value))
+		}
+	}
+}
+
+// RestoreRedactedSecrets 把提交配置中仍为占位符的字段恢复为 previous 中的原值（引用或明文）。
+// 有名称的字段按 key 匹配，其余字段按占位符中的指纹匹配；找不到原值的占位符保持不变，由校验报告。
+func RestoreRedactedSecrets(cfg, previous *Config) {
+	if cfg == nil || previous == nil {
+		return
+	}
+	originals := make(map[string]string)
+	for _, field := range secretFields(previous) {
+		value := field.get()
+		if IsSecretPlaceholder(value) {
+			continue
+		}
+		if field.group != "" {
+			originals[field.group+"|"+field.placeholder(value)] = value
+			continue
+		}
+		originals[field.key] = value
+	}
+	for _, field := range secretFields(cfg) {
+		value := field.get()
+		if !IsSecretPlaceholder(value) {
+			continue
+		}
+		lookup := field.key
+		if field.group != "" {
+			lookup = field.group + "|" + value
+		}
+		if original, ok := originals[lookup]; ok {
+			field.set(original)
+		}
+	}
+}
+
+// validateSecrets 校验未恢复的占位符与无法解析的密钥引用
+func validateSecrets(cfg *Config, addError func(field, msg string)) {
+	for _, field := range secretFields(cfg) {
+		value := field.get()
+		if IsSecretPlaceholder(value) {
+			addError(field.path, "未找到原有密钥，请重新填写")
+			continue
+		}
+		if !IsSecretReference(value) {
+			continue
+		}
+		if _, err := ResolveSecret(value); err != nil {
+			addError(field.path, fmt.Sprintf("无法解析密钥引用: %v", err))
+		}
+	}
+}
+
+// masterKey 从环境变量或文件读取主密钥，按 SHA-256 派生 AES-256 密钥
+func masterKey() ([]byte, error) {
+	raw, _ := getEnvValue(envMasterKey)
+	if raw == "" {
+		if path, _ := getEnvValue(envMasterKeyFile); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("读取主密钥文件失败: %w", err)
+			}
+			raw = strings.TrimSpace(string(data))
+		}
+	}
+	if raw == "" {
+		return nil, errMasterKeyMissing
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:], nil
+}
+
+func newSecretAEAD() (cipher.AEAD, error) {
+	key, err := masterKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret 使用主密钥加密明文，返回可直接写入配置的 enc: 引用
+func EncryptSecret(plaintext string) (string, error) {
+	aead, err := newSecretAEAD()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretEncPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(encoded string) (string, error) {
+	aead, err := newSecretAEAD()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("enc: 引用格式错误")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("解密失败，主密钥不匹配或密文已损坏")
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSecretsResolveRedactRestoreRoundTrip(t *testing.T) {
+	t.Setenv(envMasterKey, "test-master-key")
+	t.Setenv("NP_TEST_SMTP_PASSWORD", "smtp-secret")
+	secretFile := filepath.Join(t.TempDir(), "hmac")
+	if err := os.WriteFile(secretFile, []byte("  hmac-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	encryptedDSN, err := EncryptSecret("postgres://user:pass@db/np")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encryptedDSN, secretEncPrefix) {
+		t.Fatalf("unexpected encrypted value %q", encryptedDSN)
+	}
+
+	raw := &Config{
+		Database: DatabaseConfig{DSN: encryptedDSN},
+		System: SystemConfig{
+			AccessKeys: []string{"plain-key", "env:NP_TEST_SMTP_PASSWORD"},
+			AgentTokens: []AgentTokenConfig{
+				{Token: "agent-token", WebsiteID: "site", AgentID: "edge-1", HMACSecret: "file:" + secretFile},
+			},
+			SMTP: &SMTPConfig{Password: "env:NP_TEST_SMTP_PASSWORD"},
+		},
+		Websites: []WebsiteConfig{{
+			Name: "blog",
+			Sources: []SourceConfig{{
+				ID:        "s3",
+				Type:      "s3",
+				SecretKey: "s3-secret",
+				Headers:   map[string]string{"Authorization": "Bearer x"},
+			}},
+		}},
+	}
+
+	resolved := cloneConfig(t, raw)
+	if err := resolveSecrets(resolved); err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Database.DSN != "postgres://user:pass@db/np" {
+		t.Fatalf("enc: not resolved, got %q", resolved.Database.DSN)
+	}
+	if resolved.System.SMTP.Password != "smtp-secret" || resolved.System.AccessKeys[1] != "smtp-secret" {
+		t.Fatal("env: references not resolved")
+	}
+	if resolved.System.AgentTokens[0].HMACSecret != "hmac-secret" {
+		t.Fatalf("file: reference not resolved, got %q", resolved.System.AgentTokens[0].HMACSecret)
+	}
+
+	// /api/config 返回的是未解析的原始配置
+	redacted := cloneConfig(t, raw)
+	RedactSecrets(redacted)
+	if !IsSecretPlaceholder(redacted.System.AccessKeys[0]) ||
+		redacted.System.AgentTokens[0].Token != SecretPlaceholder ||
+		redacted.Websites[0].Sources[0].SecretKey != SecretPlaceholder ||
+		redacted.Websites[0].Sources[0].Headers["Authorization"] != SecretPlaceholder {
+		t.Fatalf("plaintext secrets must be redacted: %+v", redacted.System)
+	}
+	if redacted.Database.DSN != encryptedDSN || redacted.System.AgentTokens[0].HMACSecret != "file:"+secretFile {
+		t.Fatal("references must be kept as written")
+	}
+	data, _ := json.Marshal(redacted)
+	for _, leaked := range []string{"plain-key", "agent-token", "s3-secret", "Bearer x"} {
+		if strings.Contains(string(data), leaked) {
+			t.Fatalf("redacted config leaks %q", leaked)
+		}
+	}
+
+	// 保存时提交的占位符恢复为原值
+	RestoreRedactedSecrets(redacted, raw)
+	if !reflect.DeepEqual(redacted, raw) {
+		t.Fatalf("restore mismatch:\n got  %+v\n want %+v", redacted, raw)
+	}
+}
+
+func TestRestoreRedactedSecretsMatchesByName(t *testing.T) {
+	previous := &Config{Websites: []WebsiteConfig{
+		{Name: "a", Sources: []SourceConfig{{ID: "x", SecretKey: "secret-a"}}},
+		{Name: "b", Sources: []SourceConfig{{ID: "x", SecretKey: "secret-b"}}},
+	}}
+	submitted := &Config{Websites: []WebsiteConfig{
+		{Name: "b", Sources: []SourceConfig{{ID: "x", SecretKey: SecretPlaceholder}}},
+		{Name: "c", Sources: []SourceConfig{{ID: "x", SecretKey: SecretPlaceholder}}},
+	}}
+	RestoreRedactedSecrets(submitted, previous)
+	if submitted.Websites[0].Sources[0].SecretKey != "secret-b" {
+		t.Fatalf("reordered site should keep its secret, got %q", submitted.Websites[0].Sources[0].SecretKey)
+	}
+	if submitted.Websites[1].Sources[0].SecretKey != SecretPlaceholder {
+		t.Fatal("unknown site must keep the placeholder")
+	}
+	var errs []string
+	validateSecrets(submitted, func(field, msg string) { errs = append(errs, field) })
+	if len(errs) != 1 || errs[0] != "websites[1].sources[0].secretKey" {
+		t.Fatalf("unexpected validation errors %v", errs)
+	}
+}
+
+func TestRestoreRedactedSecretsAfterDeletingUnnamedSecrets(t *testing.T) {
+	previous := &Config{System: SystemConfig{
+		AccessKeys: []string{"compromised-key", "kept-key", "kept-key"},
+		AgentTokens: []AgentTokenConfig{
+			{Token: "old-token", WebsiteID: "site", HMACSecret: "old-hmac"},
+			{Token: "kept-token", WebsiteID: "site", HMACSecret: "kept-hmac"},
+		},
+	}}
+	redacted := cloneConfig(t, previous)
+	RedactSecrets(redacted)
+	if redacted.System.AccessKeys[0] == redacted.System.AccessKeys[1] || redacted.System.AccessKeys[1] != redacted.System.AccessKeys[2] {
+		t.Fatalf("placeholders should identify the original value: %v", redacted.System.AccessKeys)
+	}
+
+	// 在界面上删除第一项后保存：剩下的占位符前移，仍应恢复为各自的原值
+	submitted := cloneConfig(t, redacted)
+	submitted.System.AccessKeys = submitted.System.AccessKeys[1:2]
+	submitted.System.AgentTokens = submitted.System.AgentTokens[1:]
+	RestoreRedactedSecrets(submitted, previous)
+	if !reflect.DeepEqual(submitted.System.AccessKeys, []string{"kept-key"}) {
+		t.Fatalf("deleted access key came back: %v", submitted.System.AccessKeys)
+	}
+	if token := submitted.System.AgentTokens[0]; token.Token != "kept-token" || token.HMACSecret != "kept-hmac" {
+		t.Fatalf("deleted agent token came back: %+v", token)
+	}
+
+	// 占位符不能跨字段使用，不带指纹的占位符也无法按位置找回原值
+	crossed := &Config{System: SystemConfig{
+		AccessKeys:  []string{redacted.System.AgentTokens[0].Token, SecretPlaceholder},
+		AgentTokens: []AgentTokenConfig{{Token: redacted.System.AccessKeys[0], WebsiteID: "site"}},
+	}}
+	RestoreRedactedSecrets(crossed, previous)
+	var errs []string
+	validateSecrets(crossed, func(field, msg string) { errs = append(errs, field) })
+	want := []string{"system.accessKeys[0]", "system.accessKeys[1]", "system.agentTokens[0].token"}
+	if !reflect.DeepEqual(errs, want) {
+		t.Fatalf("unexpected validation errors %v", errs)
+	}
+}
+
+func TestResolveSecretWrongMasterKey(t *testing.T) {
+	t.Setenv(envMasterKey, "key-one")
+	encrypted, err := EncryptSecret("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := ResolveSecret(encrypted); err != nil || plain != "hello" {
+		t.Fatalf("decrypt with the same key: %q, %v", plain, err)
+	}
+
+	t.Setenv(envMasterKey, "key-two")
+	if _, err := ResolveSecret(encrypted); err == nil {
+		t.Fatal("decrypting with a different master key must fail")
+	}
+
+	t.Setenv(envMasterKey, "")
+	t.Setenv(envMasterKeyFile, "")
+	if _, err := ResolveSecret(encrypted); err == nil {
+		t.Fatal("decrypting without a master key must fail")
+	}
+
+	cfg := &Config{Database: DatabaseConfig{DSN: encrypted}}
+	if err := resolveSecrets(cfg); err == nil || !strings.Contains(err.Error(), "database.dsn") {
+		t.Fatalf("resolve error should name the field, got %v", err)
+	}
+}
@@ -28,7 +28,7 @@ func ConfigSourceType() ConfigSource {
 	if HasEnvConfigSource() {
 		return ConfigSourceEnv
 	}
-	if _, err := os.Stat(ConfigFile); err == nil {
+	if hasConfigFiles() {
 		return ConfigSourceFile
 	}
 	return ConfigSourceNone
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/tlsutil"
+)
+
+// TLSConfig 为内置 HTTPS 服务配置，证书文件更新后自动重新加载，无需重启。
+type TLSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// ClientCAFile 配置后，agent 推送接口要求客户端证书且必须由该 CA 签发（mTLS），其他接口不受影响。
+	ClientCAFile string `json:"clientCaFile,omitempty"`
+	// MinVersion 为最低 TLS 版本，可选 1.2（默认）、1.3。
+	MinVersion string `json:"minVersion,omitempty"`
+	// DisableHTTP2 关闭 HTTP/2，默认通过 ALPN 协商启用。
+	DisableHTTP2 bool `json:"disableHttp2,omitempty"`
+}
+
+// TLSEnabled 判断是否启用 HTTPS。
+func (s ServerConfig) TLSEnabled() bool {
+	return s.TLS != nil && s.TLS.Enabled
+}
+
+// TLSMinVersion 把 minVersion 转换为 crypto/tls 常量，无法识别时返回 false。
+func TLSMinVersion(value string) (uint16, bool) {
+	switch strings.TrimSpace(value) {
+	case "", "1.2":
+		return tls.VersionTLS12, true
+	case "1.3":
+		return tls.VersionTLS13, true
+	default:
+		return 0, false
+	}
+}
+
+func validateTLS(cfg *TLSConfig, opts ValidateOptions, addError func(field, msg string)) {
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+	certFile := strings.TrimSpace(cfg.CertFile)
+	keyFile := strings.TrimSpace(cfg.KeyFile)
+	if certFile == "" {
+		addError("server.tls.certFile", "启用 TLS 时证书文件不能为空")
+	}
+	if keyFile == "" {
+		addError("server.tls.keyFile", "启用 TLS 时私钥文件不能为空")
+	}
+	if _, ok := TLSMinVersion(cfg.MinVersion); !ok {
+		addError("server.tls.minVersion", "minVersion 仅支持 1.2、1.3")
+	}
+	if !opts.CheckPaths {
+		return
+	}
+	if certFile != "" && keyFile != "" {
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			addError("server.tls.certFile", "无法加载证书: "+err.Error())
+		}
+	}
+	if caFile := strings.TrimSpace(cfg.ClientCAFile); caFile != "" {
+		if _, err := tlsutil.LoadCertPool(caFile); err != nil {
+			addError("server.tls.clientCaFile", "无法加载 CA 证书: "+err.Error())
+		}
+	}
+}
@@ -4,16 +4,39 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"net/mail"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/likaia/nginxpulse/internal/timeutil"
 )
 
 type FieldError struct {
 	Field   string `json:"field"`
 	Message string `json:"message"`
+	// File / Line 为字段所在的配置文件与行号，仅对从文件加载的配置给出
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+// String 返回带位置的错误描述，例如 configs/conf.d/blog.yaml:12 websites[3].logPath: 日志路径不能为空
+func (e FieldError) String() string {
+	text := e.Message
+	if e.Field != "" {
+		text = e.Field + ": " + text
+	}
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d %s", e.File, e.Line, text)
+	case e.File != "":
+		return e.File + " " + text
+	default:
+		return text
+	}
 }
 
 type ValidateOptions struct {
@@ -28,11 +51,19 @@ type ValidationResult struct {
 
 func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 	result := ValidationResult{}
+	newFieldError := func(field, msg string) FieldError {
+		item := FieldError{Field: field, Message: msg}
+		if location, ok := cfg.locateField(field); ok {
+			item.File = location.File
+			item.Line = location.Line
+		}
+		return item
+	}
 	addError := func(field, msg string) {
-		result.Errors = append(result.Errors, FieldError{Field: field, Message: msg})
+		result.Errors = append(result.Errors, newFieldError(field, msg))
 	}
 	addWarning := func(field, msg string) {
-		result.Warnings = append(result.Warnings, FieldError{Field: field, Message: msg})
+		result.Warnings = append(result.Warnings, newFieldError(field, msg))
 	}
 
 	if cfg == nil {
@@ -49,6 +80,9 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 		if strings.TrimSpace(site.Name) == "" {
 			addError(sitePrefix+".name", "站点名称不能为空")
 		}
+		if site.Public != nil && site.Public.RoundTo < 0 {
+			addError(sitePrefix+".public.roundTo", "roundTo 不能为负数")
+		}
 
 		if len(site.Sources) == 0 {
 			if strings.TrimSpace(site.LogPath) == "" {
@@ -147,6 +181,13 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 		}
 	}
 
+	validateTLS(cfg.Server.TLS, opts, addError)
+	for i, raw := range cfg.Server.TrustedProxies {
+		if !validTrustedProxy(raw) {
+			addError(fmt.Sprintf("server.trustedProxies[%d]", i), fmt.Sprintf("可信代理 IP/IP 段格式不正确: %s", raw))
+		}
+	}
+
 	if strings.TrimSpace(cfg.Database.Driver) == "" {
 		addError("database.driver", "数据库驱动不能为空")
 	} else if strings.TrimSpace(cfg.Database.Driver) != "postgres" {
@@ -155,9 +196,13 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 	if strings.TrimSpace(cfg.Database.DSN) == "" {
 		addError("database.dsn", "数据库 DSN 不能为空")
 	}
+	validateSecrets(cfg, addError)
 	if cfg.System.LogRetentionDays <= 0 {
 		addError("system.logRetentionDays", "logRetentionDays 必须大于 0")
 	}
+	if cfg.System.AuditRetentionDays < 0 {
+		addError("system.auditRetentionDays", "auditRetentionDays 不能为负数")
+	}
 	if cfg.System.ParseBatchSize <= 0 {
 		addError("system.parseBatchSize", "parseBatchSize 必须大于 0")
 	}
@@ -194,16 +239,283 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 		}
 	}
 
+	if len(cfg.System.AgentTokens) > 0 {
+		siteIDs := make(map[string]struct{}, len(cfg.Websites))
+		for _, site := range cfg.Websites {
+			siteIDs[generateID(site.Name)] = struct{}{}
+		}
+		seenTokens := map[string]struct{}{}
+		for i, item := range cfg.System.AgentTokens {
+			prefix := fmt.Sprintf("system.agentTokens[%d]", i)
+			token := strings.TrimSpace(item.Token)
+			if token == "" {
+				addError(prefix+".token", "token 不能为空")
+			} else if _, ok := seenTokens[token]; ok {
+				addError(prefix+".token", "token 重复")
+			} else {
+				seenTokens[token] = struct{}{}
+			}
+			websiteID := strings.TrimSpace(item.WebsiteID)
+			if websiteID == "" {
+				addError(prefix+".websiteId", "websiteId 不能为空")
+			} else if _, ok := siteIDs[websiteID]; !ok {
+				addWarning(prefix+".websiteId", "websiteId 未匹配到任何站点")
+			}
+		}
+	}
+
+	if anomaly := cfg.System.AnomalyDetection; anomaly != nil {
+		if severity := strings.TrimSpace(anomaly.NotifySeverity); severity != "" && AnomalySeverityRank(strings.ToLower(severity)) < 0 {
+			addError("system.anomalyDetection.notifySeverity", "notifySeverity 仅支持 low、medium、high")
+		}
+		if anomaly.Threshold < 0 {
+			addError("system.anomalyDetection.threshold", "threshold 不能为负数")
+		} else if anomaly.Threshold > 0 && anomaly.Threshold < 2 {
+			addWarning("system.anomalyDetection.threshold", "threshold 过低会产生大量误报，建议不低于 3")
+		}
+		if anomaly.Weeks < 0 {
+			addError("system.anomalyDetection.weeks", "weeks 不能为负数")
+		} else if anomaly.Weeks > maxAnomalyWeeks {
+			addWarning("system.anomalyDetection.weeks", fmt.Sprintf("weeks 最大为 %d，超出部分将被忽略", maxAnomalyWeeks))
+		}
+	}
+
+	if campaigns := cfg.System.Campaigns; campaigns != nil {
+		for i, name := range campaigns.CustomParams {
+			if !validCampaignParamName(name) {
+				addError(fmt.Sprintf("system.campaigns.customParams[%d]", i), "参数名不能为空，且不能包含 = & ? # 或空白")
+			}
+		}
+		if len(campaigns.CustomParams) > maxCampaignCustomParams {
+			addWarning("system.campaigns.customParams", fmt.Sprintf("customParams 最多 %d 个，超出部分将被忽略", maxCampaignCustomParams))
+		}
+		for i, name := range campaigns.StripParams {
+			if !validCampaignParamName(name) {
+				addError(fmt.Sprintf("system.campaigns.stripParams[%d]", i), "参数名不能为空，且不能包含 = & ? # 或空白")
+			}
+		}
+		if len(campaigns.StripParams) > 0 && !campaigns.StripTrackingParams {
+			addWarning("system.campaigns.stripParams", "stripTrackingParams 未开启，stripParams 不会生效")
+		}
+	}
+
+	if rateLimit := cfg.System.RateLimit; rateLimit != nil {
+		if rateLimit.IPPerMinute < 0 {
+			addError("system.rateLimit.ipPerMinute", "ipPerMinute 不能为负数")
+		}
+		if rateLimit.TokenPerMinute < 0 {
+			addError("system.rateLimit.tokenPerMinute", "tokenPerMinute 不能为负数")
+		}
+		if rateLimit.Burst < 0 {
+			addError("system.rateLimit.burst", "burst 不能为负数")
+		}
+	}
+	if queryAPI := cfg.System.QueryAPI; queryAPI != nil {
+		if queryAPI.MaxRows < 0 {
+			addError("system.queryApi.maxRows", "maxRows 不能为负数")
+		} else if queryAPI.MaxRows > maxQueryAPIMaxRows {
+			addWarning("system.queryApi.maxRows", fmt.Sprintf("maxRows 最大为 %d，超出部分将被忽略", maxQueryAPIMaxRows))
+		}
+		if timeout := strings.TrimSpace(queryAPI.Timeout); timeout != "" {
+			if parsed, err := time.ParseDuration(timeout); err != nil || parsed <= 0 {
+				addError("system.queryApi.timeout", "timeout 格式无效，示例：10s、1m")
+			} else if parsed > maxQueryAPITimeout {
+				addWarning("system.queryApi.timeout", fmt.Sprintf("timeout 最大为 %s，超出部分将被忽略", maxQueryAPITimeout))
+			}
+		}
+	}
+
+	if oidc := cfg.System.OIDC; oidc != nil && oidc.Enabled {
+		issuer, err := url.Parse(strings.TrimSpace(oidc.Issuer))
+		if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+			addError("system.oidc.issuer", "issuer 必须是 http(s) 地址")
+		} else if issuer.Scheme == "http" {
+			addWarning("system.oidc.issuer", "issuer 未使用 HTTPS，仅建议用于本地测试")
+		}
+		if strings.TrimSpace(oidc.ClientID) == "" {
+			addError("system.oidc.clientId", "clientId 不能为空")
+		}
+		for _, field := range []struct{ name, value string }{
+			{"redirectUrl", oidc.RedirectURL},
+			{"postLogoutRedirectUrl", oidc.PostLogoutRedirectURL},
+		} {
+			if value := strings.TrimSpace(field.value); value != "" {
+				if parsed, err := url.Parse(value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+					addError("system.oidc."+field.name, field.name+" 必须是完整的 URL")
+				}
+			}
+		}
+		if role := strings.ToLower(strings.TrimSpace(oidc.DefaultRole)); role != "" && !oidcRoles[role] {
+			addError("system.oidc.defaultRole", "defaultRole 仅支持 admin、editor、viewer")
+		}
+		if len(oidc.RoleMappings) == 0 && strings.TrimSpace(oidc.DefaultRole) == "" {
+			addWarning("system.oidc.roleMappings", "未配置 roleMappings 与 defaultRole，所有单点登录用户都会被拒绝")
+		}
+		oidcGroups := make(map[string]bool, len(cfg.WebsiteGroups))
+		for _, group := range cfg.WebsiteGroups {
+			oidcGroups[strings.TrimSpace(group.Name)] = true
+		}
+		validateRefs := func(prefix string, values []string) {
+			for i, value := range values {
+				value = strings.TrimSpace(value)
+				if strings.HasPrefix(value, ScopeGroupPrefix) {
+					if !oidcGroups[strings.TrimPrefix(value, ScopeGroupPrefix)] {
+						addError(fmt.Sprintf("%s[%d]", prefix, i), "未匹配到站点分组")
+					}
+					continue
+				}
+				if _, ok := resolveGroupMember(cfg.Websites, value); !ok {
+					addError(fmt.Sprintf("%s[%d]", prefix, i), "未匹配到站点名称或 ID")
+				}
+			}
+		}
+		validateRefs("system.oidc.defaultWebsites", oidc.DefaultWebsites)
+		for i, mapping := range oidc.RoleMappings {
+			prefix := fmt.Sprintf("system.oidc.roleMappings[%d]", i)
+			if strings.TrimSpace(mapping.Value) == "" {
+				addError(prefix+".value", "value 不能为空")
+			}
+			if !oidcRoles[strings.ToLower(strings.TrimSpace(mapping.Role))] {
+				addError(prefix+".role", "role 仅支持 admin、editor、viewer")
+			}
+			validateRefs(prefix+".websites", mapping.Websites)
+		}
+	}
+
+	if len(cfg.WebsiteGroups) > 0 {
+		seenGroups := map[string]struct{}{}
+		for i, group := range cfg.WebsiteGroups {
+			prefix := fmt.Sprintf("websiteGroups[%d]", i)
+			name := strings.TrimSpace(group.Name)
+			if name == "" {
+				addError(prefix+".name", "分组名称不能为空")
+			} else if _, ok := seenGroups[name]; ok {
+				addError(prefix+".name", "分组名称重复")
+			} else {
+				seenGroups[name] = struct{}{}
+			}
+			if len(group.Websites) == 0 {
+				addError(prefix+".websites", "分组至少需要包含一个站点")
+			}
+			for widx, member := range group.Websites {
+				if _, ok := resolveGroupMember(cfg.Websites, member); !ok {
+					addError(fmt.Sprintf("%s.websites[%d]", prefix, widx), "未匹配到站点名称或 ID")
+				}
+			}
+		}
+	}
+
+	if smtp := cfg.System.SMTP; smtp != nil {
+		if strings.TrimSpace(smtp.Host) == "" {
+			addError("system.smtp.host", "smtp.host 不能为空")
+		}
+		if _, err := mail.ParseAddress(strings.TrimSpace(smtp.From)); err != nil {
+			addError("system.smtp.from", "smtp.from 不是有效的邮箱地址")
+		}
+		if smtp.Port < 0 || smtp.Port > 65535 {
+			addError("system.smtp.port", "smtp.port 超出范围")
+		}
+		if security := strings.ToLower(strings.TrimSpace(smtp.Security)); security != "" && !containsString([]string{"starttls", "tls", "none"}, security) {
+			addError("system.smtp.security", "smtp.security 仅支持 starttls、tls、none")
+		}
+	}
+
+	if len(cfg.Reports) > 0 {
+		groupNames := make(map[string]struct{}, len(cfg.WebsiteGroups))
+		for _, group := range cfg.WebsiteGroups {
+			groupNames[strings.TrimSpace(group.Name)] = struct{}{}
+		}
+		seenReports := map[string]struct{}{}
+		for i, report := range cfg.Reports {
+			prefix := fmt.Sprintf("reports[%d]", i)
+			name := strings.TrimSpace(report.Name)
+			if name == "" {
+				addError(prefix+".name", "报表名称不能为空")
+			} else if _, ok := seenReports[name]; ok {
+				addError(prefix+".name", "报表名称重复")
+			} else {
+				seenReports[name] = struct{}{}
+			}
+
+			website := strings.TrimSpace(report.Website)
+			switch {
+			case website == "":
+				addError(prefix+".website", "website 不能为空")
+			case website == ScopeAllWebsites:
+			case strings.HasPrefix(website, ScopeGroupPrefix):
+				if _, ok := groupNames[strings.TrimPrefix(website, ScopeGroupPrefix)]; !ok {
+					addError(prefix+".website", "未匹配到站点分组")
+				}
+			default:
+				if _, ok := resolveGroupMember(cfg.Websites, website); !ok {
+					addError(prefix+".website", "未匹配到站点名称或 ID")
+				}
+			}
+
+			if _, err := timeutil.ParseCron(report.Schedule); err != nil {
+				addError(prefix+".schedule", err.Error())
+			}
+			if tz := strings.TrimSpace(report.Timezone); tz != "" {
+				if _, err := time.LoadLocation(tz); err != nil {
+					addError(prefix+".timezone", "timezone 不是有效的 IANA 时区，示例：Asia/Shanghai")
+				}
+			}
+			if report.TimeRange != "" && !containsString(ReportTimeRanges, report.TimeRange) {
+				addError(prefix+".timeRange", fmt.Sprintf("timeRange 仅支持 %s", strings.Join(ReportTimeRanges, "、")))
+			}
+			if report.TopN < 0 {
+				addError(prefix+".topN", "topN 不能为负数")
+			}
+
+			for eidx, address := range report.Email {
+				if _, err := mail.ParseAddress(strings.TrimSpace(address)); err != nil {
+					addError(fmt.Sprintf("%s.email[%d]", prefix, eidx), "不是有效的邮箱地址")
+				}
+			}
+			if len(report.Email) > 0 && cfg.System.SMTP == nil {
+				addError(prefix+".email", "配置邮件收件人需要先配置 system.smtp")
+			}
+			for widx, hook := range report.Webhooks {
+				hookPrefix := fmt.Sprintf("%s.webhooks[%d]", prefix, widx)
+				target := strings.TrimSpace(hook.URL)
+				if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+					addError(hookPrefix+".url", "webhook url 需以 http:// 或 https:// 开头")
+				}
+				if format := strings.ToLower(strings.TrimSpace(hook.Format)); format != "" && !containsString(ReportWebhookFormats, format) {
+					addError(hookPrefix+".format", "format 仅支持 json、markdown")
+				}
+			}
+			if len(report.Email) == 0 && len(report.Webhooks) == 0 && !report.Disabled {
+				addWarning(prefix, "报表未配置任何收件人或 webhook")
+			}
+		}
+	}
+
 	if len(cfg.PVFilter.StatusCodeInclude) == 0 {
 		addError("pvFilter.statusCodeInclude", "statusCodeInclude 不能为空")
 	}
 	if len(cfg.PVFilter.ExcludePatterns) == 0 {
 		addError("pvFilter.excludePatterns", "excludePatterns 不能为空")
 	}
+	for i, pattern := range cfg.PVFilter.ExcludePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			addError(fmt.Sprintf("pvFilter.excludePatterns[%d]", i), fmt.Sprintf("正则表达式无效: %v", err))
+		}
+	}
 
 	return result
 }
 
+// validTrustedProxy 判断可信代理是否为单个 IP 或 CIDR（不支持 IP 段）
+func validTrustedProxy(value string) bool {
+	trimmed := strings.TrimSpace(value)
+	if strings.Contains(trimmed, "/") {
+		_, _, err := net.ParseCIDR(trimmed)
+		return err == nil
+	}
+	return net.ParseIP(trimmed) != nil
+}
+
 func validateWhitelistIP(value string) error {
 	trimmed := strings.TrimSpace(value)
 	if trimmed == "" {
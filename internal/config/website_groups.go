@@ -0,0 +1,91 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// ScopeAllWebsites 是覆盖全部站点的虚拟统计范围。
+	ScopeAllWebsites = "all"
+	// ScopeGroupPrefix 是站点分组范围的前缀，例如 group:blogs。
+	ScopeGroupPrefix = "group:"
+)
+
+// WebsiteGroup 是解析后的站点分组。
+type WebsiteGroup struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	WebsiteIDs []string `json:"websiteIds"`
+}
+
+// IsWebsiteScope 判断 id 是否为跨站点的虚拟范围（all 或 group:<name>）。
+func IsWebsiteScope(id string) bool {
+	return id == ScopeAllWebsites || strings.HasPrefix(id, ScopeGroupPrefix)
+}
+
+// ResolveWebsiteScope 将虚拟范围展开为站点 ID 列表（按 ID 排序）。
+func ResolveWebsiteScope(id string) ([]string, bool) {
+	if id == ScopeAllWebsites {
+		ids := GetAllWebsiteIDs()
+		sort.Strings(ids)
+		return ids, len(ids) > 0
+	}
+	if !strings.HasPrefix(id, ScopeGroupPrefix) {
+		return nil, false
+	}
+	name := strings.TrimPrefix(id, ScopeGroupPrefix)
+	for _, group := range GetWebsiteGroups() {
+		if group.Name == name {
+			return group.WebsiteIDs, len(group.WebsiteIDs) > 0
+		}
+	}
+	return nil, false
+}
+
+// GetWebsiteGroups 返回配置中的站点分组，成员已解析为站点 ID，未匹配的成员会被忽略。
+func GetWebsiteGroups() []WebsiteGroup {
+	cfg := ReadConfig()
+	groups := make([]WebsiteGroup, 0, len(cfg.WebsiteGroups))
+	for _, item := range cfg.WebsiteGroups {
+		name := strings.TrimSpace(item.Name)
+		if name == "" {
+			continue
+		}
+		seen := make(map[string]struct{}, len(item.Websites))
+		ids := make([]string, 0, len(item.Websites))
+		for _, member := range item.Websites {
+			id, ok := resolveGroupMember(cfg.Websites, member)
+			if !ok {
+				continue
+			}
+			if _, dup := seen[id]; dup {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		groups = append(groups, WebsiteGroup{
+			ID:         ScopeGroupPrefix + name,
+			Name:       name,
+			WebsiteIDs: ids,
+		})
+	}
+	return groups
+}
+
+// resolveGroupMember 按站点名称或站点 ID 匹配分组成员。
+func resolveGroupMember(websites []WebsiteConfig, member string) (string, bool) {
+	member = strings.TrimSpace(member)
+	if member == "" {
+		return "", false
+	}
+	for _, site := range websites {
+		id := generateID(site.Name)
+		if site.Name == member || id == member {
+			return id, true
+		}
+	}
+	return "", false
+}
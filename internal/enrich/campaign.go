@@ -0,0 +1,136 @@
+package enrich
+
+import (
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+// 广告点击 ID 与对应平台；未携带 utm_source 时按平台补齐来源与媒介。
+var clickIDSources = map[string]struct {
+	platform string
+	source   string
+	medium   string
+}{
+	"gclid":   {platform: "google", source: "google", medium: "cpc"},
+	"msclkid": {platform: "microsoft", source: "bing", medium: "cpc"},
+	"fbclid":  {platform: "facebook", source: "facebook", medium: "social"},
+}
+
+// campaignRules 为投放参数解析规则，热加载时整体替换
+type campaignRules struct {
+	customParams map[string]bool
+	stripParams  map[string]bool
+	strip        bool
+}
+
+var campaignRulesPtr atomic.Pointer[campaignRules]
+
+// InitCampaignParams 根据 system.campaigns 初始化投放参数解析规则，可重复调用以刷新规则
+func InitCampaignParams() {
+	cfg := config.GetCampaignConfig()
+	rules := &campaignRules{
+		customParams: make(map[string]bool, len(cfg.CustomParams)),
+		stripParams:  make(map[string]bool, len(cfg.StripParams)),
+		strip:        cfg.StripTrackingParams,
+	}
+	for _, name := range cfg.CustomParams {
+		rules.customParams[name] = true
+	}
+	for _, name := range cfg.StripParams {
+		rules.stripParams[name] = true
+	}
+	campaignRulesPtr.Store(rules)
+}
+
+// ExtractCampaign 解析 URL 查询串中的 utm_*、点击 ID 与自定义参数。
+// 开启 stripTrackingParams 时返回去除跟踪参数后的 URL，其余参数保持原有顺序与编码。
+func ExtractCampaign(rawURL string) (string, store.Campaign) {
+	var campaign store.Campaign
+	idx := strings.IndexByte(rawURL, '?')
+	if idx < 0 {
+		return rawURL, campaign
+	}
+	path, query := rawURL[:idx], rawURL[idx+1:]
+	rules := campaignRulesPtr.Load()
+	if rules == nil {
+		rules = &campaignRules{}
+	}
+
+	kept := make([]string, 0, 4)
+	clickID := ""
+	stripped := false
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key := strings.ToLower(strings.TrimSpace(unescapeQueryPart(rawKey)))
+		value := strings.TrimSpace(unescapeQueryPart(rawValue))
+
+		tracking := true
+		switch {
+		case key == "utm_source":
+			campaign.Source = strings.ToLower(value)
+		case key == "utm_medium":
+			campaign.Medium = strings.ToLower(value)
+		case key == "utm_campaign":
+			campaign.Name = value
+		case key == "utm_term":
+			campaign.Term = value
+		case key == "utm_content":
+			campaign.Content = value
+		case strings.HasPrefix(key, "utm_"):
+			// utm_id 等其他 utm 参数不单独成维，只参与去除
+		case clickIDSources[key].platform != "":
+			if clickID == "" && value != "" {
+				clickID = key
+			}
+		case rules.customParams[key]:
+			if value != "" {
+				if campaign.Custom == nil {
+					campaign.Custom = make(map[string]string)
+				}
+				campaign.Custom[key] = value
+			}
+		case rules.stripParams[key]:
+		default:
+			tracking = false
+		}
+
+		if tracking && rules.strip {
+			stripped = true
+			continue
+		}
+		kept = append(kept, pair)
+	}
+
+	if clickID != "" {
+		click := clickIDSources[clickID]
+		campaign.ClickSource = click.platform
+		if campaign.Source == "" {
+			campaign.Source = click.source
+			if campaign.Medium == "" {
+				campaign.Medium = click.medium
+			}
+		}
+	}
+
+	if !stripped {
+		return rawURL, campaign
+	}
+	if len(kept) == 0 {
+		return path, campaign
+	}
+	return path + "?" + strings.Join(kept, "&"), campaign
+}
+
+func unescapeQueryPart(value string) string {
+	if decoded, err := url.QueryUnescape(value); err == nil {
+		return decoded
+	}
+	return value
+}
@@ -0,0 +1,98 @@
+package enrich
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+func setCampaignRules(t *testing.T, rules *campaignRules) {
+	t.Helper()
+	previous := campaignRulesPtr.Load()
+	campaignRulesPtr.Store(rules)
+	t.Cleanup(func() { campaignRulesPtr.Store(previous) })
+}
+
+func TestExtractCampaign(t *testing.T) {
+	setCampaignRules(t, &campaignRules{customParams: map[string]bool{"ref": true}})
+
+	tests := []struct {
+		name string
+		url  string
+		want store.Campaign
+	}{
+		{
+			name: "no query",
+			url:  "/pricing",
+		},
+		{
+			name: "utm params",
+			url:  "/pricing?UTM_Source=Newsletter&utm_medium=EMAIL&utm_campaign=Spring%20Sale&utm_term=shoes&utm_content=hero",
+			want: store.Campaign{Source: "newsletter", Medium: "email", Name: "Spring Sale", Term: "shoes", Content: "hero"},
+		},
+		{
+			name: "click id fills source and medium",
+			url:  "/?gclid=abc&fbclid=def",
+			want: store.Campaign{Source: "google", Medium: "cpc", ClickSource: "google"},
+		},
+		{
+			name: "utm source wins over click id",
+			url:  "/?fbclid=abc&utm_source=partner",
+			want: store.Campaign{Source: "partner", ClickSource: "facebook"},
+		},
+		{
+			name: "empty click id is ignored",
+			url:  "/?msclkid=&msclkid=x",
+			want: store.Campaign{Source: "bing", Medium: "cpc", ClickSource: "microsoft"},
+		},
+		{
+			name: "custom params",
+			url:  "/?ref=twitter&page=2&ref2=x",
+			want: store.Campaign{Custom: map[string]string{"ref": "twitter"}},
+		},
+		{
+			name: "bad escape keeps raw value",
+			url:  "/?utm_campaign=100%ZZ",
+			want: store.Campaign{Name: "100%ZZ"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, campaign := ExtractCampaign(tt.url)
+			if normalized != tt.url {
+				t.Fatalf("url must be kept when stripping is off, got %q", normalized)
+			}
+			if !reflect.DeepEqual(campaign, tt.want) {
+				t.Fatalf("campaign = %+v, want %+v", campaign, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractCampaignStripsTrackingParams(t *testing.T) {
+	setCampaignRules(t, &campaignRules{
+		customParams: map[string]bool{"ref": true},
+		stripParams:  map[string]bool{"spm": true},
+		strip:        true,
+	})
+
+	tests := map[string]string{
+		"/a?utm_source=x&id=1&gclid=y&q=a%20b":     "/a?id=1&q=a%20b",
+		"/a?utm_id=7&spm=1.2&ref=tw&fbclid=z":      "/a",
+		"/a?b=2&&a=1":                              "/a?b=2&&a=1",
+		"/a?page=2&UTM_MEDIUM=cpc&msclkid=1&sort=": "/a?page=2&sort=",
+		"/a": "/a",
+	}
+	for input, want := range tests {
+		if got, _ := ExtractCampaign(input); got != want {
+			t.Errorf("ExtractCampaign(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	// 未配置时不去除任何参数
+	setCampaignRules(t, nil)
+	if got, campaign := ExtractCampaign("/a?utm_source=x&spm=1"); got != "/a?utm_source=x&spm=1" || campaign.Source != "x" {
+		t.Fatalf("default rules: url=%q campaign=%+v", got, campaign)
+	}
+}
@@ -4,48 +4,60 @@ import (
 	"net"
 	"regexp"
 	"strings"
+	"sync/atomic"
 
 	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/sirupsen/logrus"
 )
 
-var (
-	// 全局过滤规则编译后的正则表达式
+// pvFilterRules 为编译后的 PV 过滤规则，热加载时整体替换
+type pvFilterRules struct {
 	excludePatterns []*regexp.Regexp
 	excludeIPs      map[string]bool
 	statusCodes     map[int]bool
 	excludePrivate  bool
-)
+}
 
-// InitPVFilters 初始化PV过滤规则
+var pvRules atomic.Pointer[pvFilterRules]
+
+// InitPVFilters 根据当前配置初始化 PV 过滤规则，可在运行中重复调用以刷新规则。
+// 无效的正则会被跳过并记录日志（配置校验阶段已拦截）。
 func InitPVFilters() {
 	cfg := config.ReadConfig()
+	rules := &pvFilterRules{}
 
 	// 初始化状态码过滤
-	statusCodes = make(map[int]bool)
+	rules.statusCodes = make(map[int]bool)
 	for _, code := range cfg.PVFilter.StatusCodeInclude {
-		statusCodes[code] = true
+		rules.statusCodes[code] = true
 	}
 
 	// 初始化正则表达式过滤
-	excludePatterns = make([]*regexp.Regexp, len(cfg.PVFilter.ExcludePatterns))
-	for i, pattern := range cfg.PVFilter.ExcludePatterns {
-		excludePatterns[i] = regexp.MustCompile(pattern)
+	rules.excludePatterns = make([]*regexp.Regexp, 0, len(cfg.PVFilter.ExcludePatterns))
+	for _, pattern := range cfg.PVFilter.ExcludePatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			logrus.WithError(err).Warnf("PV 过滤正则无效，已跳过: %s", pattern)
+			continue
+		}
+		rules.excludePatterns = append(rules.excludePatterns, compiled)
 	}
 
 	// 初始化IP过滤
-	excludeIPs = make(map[string]bool)
+	rules.excludeIPs = make(map[string]bool)
 	for _, ip := range cfg.PVFilter.ExcludeIPs {
 		normalized := normalizeIP(ip)
 		if normalized == "" {
 			continue
 		}
-		excludeIPs[normalized] = true
+		rules.excludeIPs[normalized] = true
 	}
 
-	excludePrivate = true
+	rules.excludePrivate = true
 	if cfg.PVFilter.ExcludeIPs != nil && len(cfg.PVFilter.ExcludeIPs) == 0 {
-		excludePrivate = false
+		rules.excludePrivate = false
 	}
+	pvRules.Store(rules)
 }
 
 // normalizeIP extracts a usable IP string from log tokens
@@ -100,25 +112,30 @@ func normalizeIP(raw string) string {
 
 // ShouldCountAsPageView 判断是否符合 PV 过滤条件
 func ShouldCountAsPageView(statusCode int, path string, ip string) int {
+	rules := pvRules.Load()
+	if rules == nil {
+		return 0
+	}
+
 	// 检查状态码
-	if !statusCodes[statusCode] {
+	if !rules.statusCodes[statusCode] {
 		return 0
 	}
 
 	normalizedIP := normalizeIP(ip)
 
 	// 过滤内网/保留地址
-	if excludePrivate && isPrivateIP(net.ParseIP(normalizedIP)) {
+	if rules.excludePrivate && isPrivateIP(net.ParseIP(normalizedIP)) {
 		return 0
 	}
 
 	// 检查排除 IP 列表
-	if normalizedIP != "" && excludeIPs[normalizedIP] {
+	if normalizedIP != "" && rules.excludeIPs[normalizedIP] {
 		return 0
 	}
 
 	// 检查是否匹配全局排除模式
-	for _, pattern := range excludePatterns {
+	for _, pattern := range rules.excludePatterns {
 		if pattern.MatchString(path) {
 			return 0
 		}
@@ -0,0 +1,86 @@
+package ingest
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+// AgentBatch 是 v2 推送协议中的一批日志。
+// Seq 在同一 agent/站点/来源/StreamID 内单调递增；Seq 为 0 表示不做幂等校验（仅按内容去重）。
+// SampleRates 为空表示未采样，否则与 Lines 一一对应。
+type AgentBatch struct {
+	AgentID     string
+	WebsiteID   string
+	SourceID    string
+	StreamID    string
+	Seq         int64
+	Lines       []string
+	SampleRates []float64
+}
+
+type AgentBatchResult struct {
+	Accepted  int   `json:"accepted"`
+	Deduped   int   `json:"deduped"`
+	Duplicate bool  `json:"duplicate"`
+	AckSeq    int64 `json:"ack_seq"`
+}
+
+var agentBatchLocks sync.Map // key: agentID/websiteID/sourceID -> *sync.Mutex
+
+func agentBatchLock(key string) *sync.Mutex {
+	value, _ := agentBatchLocks.LoadOrStore(key, &sync.Mutex{})
+	return value.(*sync.Mutex)
+}
+
+// IngestAgentBatch 幂等地写入一批 agent 日志：序号不大于已确认序号的批次直接确认，不会重复计数。
+// 同一 agent/站点/来源的批次串行处理，避免并发重试同时通过序号校验。
+func (p *LogParser) IngestAgentBatch(batch AgentBatch) (AgentBatchResult, error) {
+	result := AgentBatchResult{AckSeq: batch.Seq}
+	if batch.WebsiteID == "" {
+		return result, errors.New("websiteID 不能为空")
+	}
+	agentID := strings.TrimSpace(batch.AgentID)
+	if batch.Seq <= 0 || agentID == "" || p.repo == nil {
+		accepted, deduped, err := p.IngestSampledLines(batch.WebsiteID, batch.SourceID, batch.Lines, batch.SampleRates)
+		result.Accepted = accepted
+		result.Deduped = deduped
+		return result, err
+	}
+
+	lock := agentBatchLock(agentID + "/" + batch.WebsiteID + "/" + batch.SourceID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	cursor, found, err := p.repo.GetAgentIngestCursor(agentID, batch.WebsiteID, batch.SourceID)
+	if err != nil {
+		return result, err
+	}
+	if found && cursor.StreamID == batch.StreamID && batch.Seq <= cursor.LastSeq {
+		result.Duplicate = true
+		result.AckSeq = cursor.LastSeq
+		return result, nil
+	}
+
+	accepted, deduped, err := p.IngestSampledLines(batch.WebsiteID, batch.SourceID, batch.Lines, batch.SampleRates)
+	result.Accepted = accepted
+	result.Deduped = deduped
+	if err != nil {
+		return result, err
+	}
+
+	// 先落库日志、再推进游标：若两步之间进程退出，agent 重试时会再次写入，
+	// 此时由内容去重缓存兜底，不会出现“已确认但未入库”的丢数据情况。
+	if err := p.repo.SaveAgentIngestCursor(store.AgentIngestCursor{
+		AgentID:   agentID,
+		WebsiteID: batch.WebsiteID,
+		SourceID:  batch.SourceID,
+		StreamID:  batch.StreamID,
+		LastSeq:   batch.Seq,
+	}); err != nil {
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/agentproto"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 心跳超时 = 上报间隔 * agentHeartbeatMissFactor，且不小于 agentHeartbeatMinTimeout。
+	agentHeartbeatMissFactor  = 3
+	agentHeartbeatMinTimeout  = 2 * time.Minute
+	defaultHeartbeatIntervalS = 30
+)
+
+// AgentStatus 是 /api/agents 返回的单个 agent 视图。
+type AgentStatus struct {
+	store.AgentRecord
+	Online bool `json:"online"`
+	// TimeoutSeconds 是判定心跳中断的阈值。
+	TimeoutSeconds int64 `json:"timeout_seconds"`
+}
+
+func agentHeartbeatTimeout(intervalSeconds int) time.Duration {
+	if intervalSeconds <= 0 {
+		intervalSeconds = defaultHeartbeatIntervalS
+	}
+	timeout := time.Duration(intervalSeconds*agentHeartbeatMissFactor) * time.Second
+	if timeout < agentHeartbeatMinTimeout {
+		timeout = agentHeartbeatMinTimeout
+	}
+	return timeout
+}
+
+// RecordAgentHeartbeat 写入 agent 注册表并返回服务端时间与时钟偏差。
+// 之前因心跳中断发出过通知的 agent 恢复时会再发一条恢复通知；时钟偏差过大时发出告警（签名会因此校验失败）。
+func (p *LogParser) RecordAgentHeartbeat(hb agentproto.Heartbeat, remoteAddr string, receivedAt time.Time) (agentproto.HeartbeatAck, error) {
+	ack := agentproto.HeartbeatAck{ServerTime: receivedAt.UnixMilli()}
+	agentID := strings.TrimSpace(hb.AgentID)
+	if agentID == "" {
+		return ack, errors.New("agentID 不能为空")
+	}
+	if p == nil || p.repo == nil {
+		return ack, errors.New("数据库未初始化")
+	}
+	if hb.SentAt > 0 {
+		ack.ClockSkewMs = hb.SentAt - ack.ServerTime
+	}
+
+	files, err := json.Marshal(hb.Files)
+	if err != nil {
+		return ack, err
+	}
+	backlog, err := json.Marshal(hb.Backlog)
+	if err != nil {
+		return ack, err
+	}
+	record := store.AgentRecord{
+		AgentID:         agentID,
+		Hostname:        strings.TrimSpace(hb.Hostname),
+		Version:         strings.TrimSpace(hb.Version),
+		Protocol:        strings.TrimSpace(hb.Protocol),
+		StreamID:        strings.TrimSpace(hb.StreamID),
+		RemoteAddr:      remoteAddr,
+		IntervalSeconds: hb.IntervalSeconds,
+		Files:           files,
+		Backlog:         backlog,
+		LastError:       strings.TrimSpace(hb.LastError),
+		ClockSkewMs:     ack.ClockSkewMs,
+	}
+	if hb.LastErrorAt > 0 {
+		at := time.UnixMilli(hb.LastErrorAt)
+		record.LastErrorAt = &at
+	}
+
+	wasMissed, err := p.repo.UpsertAgentHeartbeat(record)
+	if err != nil {
+		return ack, err
+	}
+	if wasMissed {
+		p.notifySystem("info", "agent", "Agent 心跳已恢复",
+			fmt.Sprintf("Agent %s（%s）已恢复心跳", agentID, record.Hostname),
+			"agent_heartbeat_recovered:"+agentID,
+			map[string]interface{}{
+				"agent_id": agentID,
+				"hostname": record.Hostname,
+			})
+	}
+	skew := time.Duration(ack.ClockSkewMs) * time.Millisecond
+	if skew > agentproto.MaxClockSkew || skew < -agentproto.MaxClockSkew {
+		p.notifySystem("warning", "agent", "Agent 时钟偏差过大",
+			fmt.Sprintf("Agent %s（%s）与服务端时钟相差 %s，签名校验与日志时间可能异常", agentID, record.Hostname, skew.Round(time.Second)),
+			"agent_clock_skew:"+agentID,
+			map[string]interface{}{
+				"agent_id":      agentID,
+				"hostname":      record.Hostname,
+				"clock_skew_ms": ack.ClockSkewMs,
+			})
+	}
+	return ack, nil
+}
+
+// ListAgentStatuses 返回所有已知 agent 及其在线状态。
+func (p *LogParser) ListAgentStatuses(now time.Time) ([]AgentStatus, error) {
+	if p == nil || p.repo == nil {
+		return nil, errors.New("数据库未初始化")
+	}
+	records, err := p.repo.ListAgents()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]AgentStatus, 0, len(records))
+	for _, record := range records {
+		timeout := agentHeartbeatTimeout(record.IntervalSeconds)
+		statuses = append(statuses, AgentStatus{
+			AgentRecord:    record,
+			Online:         now.Sub(record.LastSeenAt) <= timeout,
+			TimeoutSeconds: int64(timeout / time.Second),
+		})
+	}
+	return statuses, nil
+}
+
+// DeleteAgent 从注册表移除已下线的 agent（再次上报心跳会重新登记）。
+func (p *LogParser) DeleteAgent(agentID string) error {
+	if p == nil || p.repo == nil {
+		return errors.New("数据库未初始化")
+	}
+	return p.repo.DeleteAgent(agentID)
+}
+
+// CheckAgentHeartbeats 为心跳超时的 agent 发出系统通知（每次中断只通知一次）。
+func (p *LogParser) CheckAgentHeartbeats() {
+	if p == nil || p.repo == nil {
+		return
+	}
+	now := time.Now()
+	statuses, err := p.ListAgentStatuses(now)
+	if err != nil {
+		logrus.WithError(err).Warn("读取 agent 注册表失败")
+		return
+	}
+	for _, status := range statuses {
+		if status.Online || status.MissedNotified {
+			continue
+		}
+		marked, err := p.repo.MarkAgentMissedNotified(status.AgentID, status.LastSeenAt)
+		if err != nil {
+			logrus.WithError(err).Warnf("更新 agent 心跳状态失败: %s", status.AgentID)
+			continue
+		}
+		if !marked {
+			continue
+		}
+		silence := now.Sub(status.LastSeenAt).Round(time.Second)
+		p.notifySystem("warning", "agent", "Agent 心跳中断",
+			fmt.Sprintf("Agent %s（%s）已 %s 未上报心跳", status.AgentID, status.Hostname, silence),
+			"agent_heartbeat_missed:"+status.AgentID,
+			map[string]interface{}{
+				"agent_id":     status.AgentID,
+				"hostname":     status.Hostname,
+				"last_seen_at": status.LastSeenAt,
+				"remote_addr":  status.RemoteAddr,
+			})
+	}
+}
@@ -0,0 +1,35 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/agentproto"
+)
+
+func TestAgentHeartbeatTimeout(t *testing.T) {
+	cases := map[int]time.Duration{
+		0:   agentHeartbeatMinTimeout,
+		-5:  agentHeartbeatMinTimeout,
+		10:  agentHeartbeatMinTimeout,
+		60:  3 * time.Minute,
+		300: 15 * time.Minute,
+	}
+	for interval, want := range cases {
+		if got := agentHeartbeatTimeout(interval); got != want {
+			t.Errorf("agentHeartbeatTimeout(%d) = %v, want %v", interval, got, want)
+		}
+	}
+}
+
+func TestRecordAgentHeartbeatRequiresAgentID(t *testing.T) {
+	parser := &LogParser{}
+	if _, err := parser.RecordAgentHeartbeat(agentproto.Heartbeat{AgentID: "  "}, "127.0.0.1", time.Now()); err == nil {
+		t.Fatal("expected an error for an empty agent ID")
+	}
+	receivedAt := time.UnixMilli(1_700_000_000_000)
+	ack, err := parser.RecordAgentHeartbeat(agentproto.Heartbeat{AgentID: "a", SentAt: receivedAt.UnixMilli() + 1500}, "127.0.0.1", receivedAt)
+	if err == nil || ack.ServerTime != receivedAt.UnixMilli() {
+		t.Fatalf("heartbeat without a repository should fail, got ack=%+v err=%v", ack, err)
+	}
+}
@@ -0,0 +1,261 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// anomalyLookbackHours 每轮检测最近若干个已完整结束的小时，容忍解析延迟。
+	anomalyLookbackHours = 6
+	// anomalyGracePeriod 小时结束后等待的时间，避免对仍在写入的小时误报。
+	anomalyGracePeriod = 15 * time.Minute
+	// anomalyMinBaselinePoints 季节基线至少需要的历史样本数。
+	anomalyMinBaselinePoints = 3
+	// madScale 将 MAD 换算为正态分布下的标准差估计。
+	madScale = 1.4826
+)
+
+// anomalyMetric 描述一个参与检测的指标。
+type anomalyMetric struct {
+	Name  string
+	Label string
+	// MinDelta 为判定异常所需的最小绝对偏差，过滤低流量站点的噪声。
+	MinDelta float64
+	// SpikeOnly 为 true 时只检测上涨（错误数下降不算异常）。
+	SpikeOnly bool
+}
+
+var anomalyMetrics = []anomalyMetric{
+	{Name: "pv", Label: "PV", MinDelta: 20},
+	{Name: "uv", Label: "UV", MinDelta: 10},
+	{Name: "s4xx", Label: "4xx 错误", MinDelta: 10, SpikeOnly: true},
+	{Name: "s5xx", Label: "5xx 错误", MinDelta: 5, SpikeOnly: true},
+	{Name: "traffic", Label: "带宽", MinDelta: 10 * 1024 * 1024},
+}
+
+// DetectTrafficAnomalies 以“相同星期几、相同小时”的历史中位数/MAD 为季节基线，
+// 检测各站点最近几个小时的 PV、UV、4xx、5xx 与带宽异常并写入异常表。
+func (p *LogParser) DetectTrafficAnomalies() {
+	if p == nil || p.repo == nil {
+		return
+	}
+	settings := config.GetAnomalyDetectionConfig()
+	if !settings.Enabled {
+		return
+	}
+	now := time.Now()
+	for _, websiteID := range config.GetAllWebsiteIDs() {
+		if err := p.detectWebsiteAnomalies(websiteID, settings, now); err != nil {
+			logrus.WithError(err).Warnf("网站 %s 流量异常检测失败", websiteID)
+		}
+	}
+}
+
+func (p *LogParser) detectWebsiteAnomalies(websiteID string, settings config.AnomalyDetectionConfig, now time.Time) error {
+	latest, ok, err := p.repo.LatestHourlyBucket(websiteID)
+	if err != nil || !ok {
+		return err
+	}
+
+	targets := completedHourBuckets(now, anomalyLookbackHours)
+	if len(targets) == 0 {
+		return nil
+	}
+	baselineBuckets := make(map[int64][]int64, len(targets))
+	needed := make([]int64, 0, len(targets)*(settings.Weeks+1))
+	for _, bucket := range targets {
+		history := seasonalBuckets(bucket, settings.Weeks)
+		baselineBuckets[bucket] = history
+		needed = append(needed, bucket)
+		needed = append(needed, history...)
+	}
+	metrics, err := p.repo.LoadHourlyMetrics(websiteID, needed)
+	if err != nil {
+		return err
+	}
+	earliest := latest
+	for bucket := range metrics {
+		if bucket < earliest {
+			earliest = bucket
+		}
+	}
+
+	for _, bucket := range targets {
+		current, ok := metrics[bucket]
+		if !ok && latest <= bucket {
+			// 该小时及之后都没有数据，可能只是日志尚未解析，暂不判断
+			continue
+		}
+		history := make([]store.HourlyMetrics, 0, len(baselineBuckets[bucket]))
+		for _, past := range baselineBuckets[bucket] {
+			if past < earliest {
+				continue
+			}
+			// 站点已有数据之后的空小时按 0 计入基线
+			history = append(history, metrics[past])
+		}
+		if len(history) < anomalyMinBaselinePoints {
+			continue
+		}
+		for _, metric := range anomalyMetrics {
+			anomaly, found := evaluateAnomaly(metric, current, history, settings.Threshold)
+			if !found {
+				continue
+			}
+			anomaly.WebsiteID = websiteID
+			anomaly.Bucket = bucket
+			inserted, err := p.repo.InsertTrafficAnomaly(anomaly)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				p.notifyTrafficAnomaly(anomaly, metric, settings)
+			}
+		}
+	}
+	return nil
+}
+
+// completedHourBuckets 返回 now 之前已结束（并过了宽限期）的最近 count 个小时桶，按时间升序。
+func completedHourBuckets(now time.Time, count int) []int64 {
+	local := now.Add(-anomalyGracePeriod).In(time.Local)
+	current := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, local.Location())
+	buckets := make([]int64, 0, count)
+	for i := count; i >= 1; i-- {
+		buckets = append(buckets, current.Add(-time.Duration(i)*time.Hour).Unix())
+	}
+	return buckets
+}
+
+// seasonalBuckets 返回前 weeks 周相同星期几、相同小时的桶（按本地时间计算，兼容夏令时）。
+func seasonalBuckets(bucket int64, weeks int) []int64 {
+	base := time.Unix(bucket, 0).In(time.Local)
+	buckets := make([]int64, 0, weeks)
+	for k := 1; k <= weeks; k++ {
+		past := base.AddDate(0, 0, -7*k)
+		past = time.Date(past.Year(), past.Month(), past.Day(), base.Hour(), 0, 0, 0, past.Location())
+		buckets = append(buckets, past.Unix())
+	}
+	return buckets
+}
+
+// evaluateAnomaly 计算稳健 z 分数，超过阈值且偏差足够大时返回异常记录。
+func evaluateAnomaly(
+	metric anomalyMetric,
+	current store.HourlyMetrics,
+	history []store.HourlyMetrics,
+	threshold float64,
+) (store.TrafficAnomaly, bool) {
+	value, ok := current.Value(metric.Name)
+	if !ok {
+		return store.TrafficAnomaly{}, false
+	}
+	samples := make([]float64, 0, len(history))
+	for _, item := range history {
+		v, _ := item.Value(metric.Name)
+		samples = append(samples, v)
+	}
+	median := medianOf(samples)
+	deviations := make([]float64, len(samples))
+	for i, v := range samples {
+		deviations[i] = math.Abs(v - median)
+	}
+	mad := medianOf(deviations)
+
+	delta := value - median
+	if math.Abs(delta) < metric.MinDelta {
+		return store.TrafficAnomaly{}, false
+	}
+	if metric.SpikeOnly && delta < 0 {
+		return store.TrafficAnomaly{}, false
+	}
+	// 历史数据完全一致时 MAD 为 0，按中位数的 5% 兜底，避免分数无穷大
+	spread := math.Max(madScale*mad, math.Max(median*0.05, 1))
+	score := delta / spread
+	severity := anomalySeverity(math.Abs(score), threshold)
+	if severity == "" {
+		return store.TrafficAnomaly{}, false
+	}
+	direction := "spike"
+	if delta < 0 {
+		direction = "drop"
+	}
+	return store.TrafficAnomaly{
+		Metric:    metric.Name,
+		Value:     value,
+		Baseline:  median,
+		MAD:       mad,
+		Score:     math.Round(score*100) / 100,
+		Severity:  severity,
+		Direction: direction,
+	}, true
+}
+
+func anomalySeverity(score, threshold float64) string {
+	switch {
+	case score >= threshold*2.5:
+		return "high"
+	case score >= threshold*1.5:
+		return "medium"
+	case score >= threshold:
+		return "low"
+	default:
+		return ""
+	}
+}
+
+func medianOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+func (p *LogParser) notifyTrafficAnomaly(anomaly store.TrafficAnomaly, metric anomalyMetric, settings config.AnomalyDetectionConfig) {
+	if !settings.Notify {
+		return
+	}
+	if config.AnomalySeverityRank(anomaly.Severity) < config.AnomalySeverityRank(settings.NotifySeverity) {
+		return
+	}
+	siteName := anomaly.WebsiteID
+	if site, ok := config.GetWebsiteByID(anomaly.WebsiteID); ok {
+		siteName = site.Name
+	}
+	hour := time.Unix(anomaly.Bucket, 0).In(time.Local).Format("2006-01-02 15:04")
+	trend := "突增"
+	if anomaly.Direction == "drop" {
+		trend = "骤降"
+	}
+	level := "warning"
+	if anomaly.Severity == "high" {
+		level = "error"
+	}
+	p.notifySystem(level, "traffic_anomaly", fmt.Sprintf("%s %s异常", siteName, metric.Label),
+		fmt.Sprintf("%s 时段 %s %s：当前 %.0f，基线 %.0f（z=%.2f）", hour, metric.Label, trend, anomaly.Value, anomaly.Baseline, anomaly.Score),
+		fmt.Sprintf("traffic_anomaly:%s:%s:%d", anomaly.WebsiteID, anomaly.Metric, anomaly.Bucket),
+		map[string]interface{}{
+			"website_id":   anomaly.WebsiteID,
+			"website_name": siteName,
+			"bucket":       anomaly.Bucket,
+			"metric":       anomaly.Metric,
+			"value":        anomaly.Value,
+			"baseline":     anomaly.Baseline,
+			"score":        anomaly.Score,
+			"severity":     anomaly.Severity,
+			"direction":    anomaly.Direction,
+		})
+}
@@ -0,0 +1,121 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+func pvHistory(values ...float64) []store.HourlyMetrics {
+	history := make([]store.HourlyMetrics, 0, len(values))
+	for _, value := range values {
+		history = append(history, store.HourlyMetrics{PV: value, S5xx: value})
+	}
+	return history
+}
+
+func TestEvaluateAnomaly(t *testing.T) {
+	pv := anomalyMetrics[0]
+	s5xx := anomalyMetrics[3]
+	tests := []struct {
+		name      string
+		metric    anomalyMetric
+		current   store.HourlyMetrics
+		history   []store.HourlyMetrics
+		want      bool
+		severity  string
+		direction string
+		score     float64
+	}{
+		{
+			name:    "spike",
+			metric:  pv,
+			current: store.HourlyMetrics{PV: 200},
+			history: pvHistory(100, 110, 90, 105, 95),
+			want:    true, severity: "high", direction: "spike", score: 13.49,
+		},
+		{
+			name:    "within min delta",
+			metric:  pv,
+			current: store.HourlyMetrics{PV: 115},
+			history: pvHistory(100, 100, 100),
+		},
+		{
+			name:    "flat history uses median fallback",
+			metric:  pv,
+			current: store.HourlyMetrics{PV: 130},
+			history: pvHistory(100, 100, 100),
+			want:    true, severity: "medium", direction: "spike", score: 6,
+		},
+		{
+			name:    "drop",
+			metric:  pv,
+			current: store.HourlyMetrics{PV: 40},
+			history: pvHistory(100, 100, 100),
+			want:    true, severity: "high", direction: "drop", score: -12,
+		},
+		{
+			name:    "error drop is not an anomaly",
+			metric:  s5xx,
+			current: store.HourlyMetrics{S5xx: 0},
+			history: pvHistory(50, 50, 50),
+		},
+		{
+			name:    "unknown metric",
+			metric:  anomalyMetric{Name: "latency"},
+			current: store.HourlyMetrics{PV: 1000},
+			history: pvHistory(1, 1, 1),
+		},
+	}
+	for _, tt := range tests {
+		anomaly, ok := evaluateAnomaly(tt.metric, tt.current, tt.history, 3)
+		if ok != tt.want {
+			t.Fatalf("%s: detected = %v, want %v (%+v)", tt.name, ok, tt.want, anomaly)
+		}
+		if !ok {
+			continue
+		}
+		if anomaly.Severity != tt.severity || anomaly.Direction != tt.direction || anomaly.Score != tt.score {
+			t.Fatalf("%s: unexpected anomaly %+v", tt.name, anomaly)
+		}
+	}
+}
+
+func TestAnomalySeverityAndMedian(t *testing.T) {
+	for score, want := range map[float64]string{2.9: "", 3: "low", 4.5: "medium", 7.5: "high"} {
+		if got := anomalySeverity(score, 3); got != want {
+			t.Errorf("anomalySeverity(%v) = %q, want %q", score, got, want)
+		}
+	}
+	if medianOf(nil) != 0 || medianOf([]float64{3, 1, 2}) != 2 || medianOf([]float64{4, 1, 3, 2}) != 2.5 {
+		t.Fatal("unexpected median")
+	}
+}
+
+func TestAnomalyBuckets(t *testing.T) {
+	now := time.Date(2024, 3, 12, 10, 10, 0, 0, time.Local)
+	buckets := completedHourBuckets(now, 3)
+	// 10:10 仍在上一小时的宽限期内，最近一个完整的小时为 08:00
+	want := []int64{
+		time.Date(2024, 3, 12, 6, 0, 0, 0, time.Local).Unix(),
+		time.Date(2024, 3, 12, 7, 0, 0, 0, time.Local).Unix(),
+		time.Date(2024, 3, 12, 8, 0, 0, 0, time.Local).Unix(),
+	}
+	for i := range want {
+		if buckets[i] != want[i] {
+			t.Fatalf("completedHourBuckets = %v, want %v", buckets, want)
+		}
+	}
+
+	bucket := time.Date(2024, 3, 12, 9, 0, 0, 0, time.Local)
+	for k, value := range seasonalBuckets(bucket.Unix(), 4) {
+		past := time.Unix(value, 0).In(time.Local)
+		if past.Weekday() != bucket.Weekday() || past.Hour() != 9 || past.Minute() != 0 {
+			t.Fatalf("seasonal bucket %d is %v", k, past)
+		}
+		if days := bucket.Sub(past).Hours() / 24; days < float64(7*(k+1))-0.1 || days > float64(7*(k+1))+0.1 {
+			t.Fatalf("seasonal bucket %d is %.2f days back", k, days)
+		}
+	}
+}
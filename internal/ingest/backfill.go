@@ -151,7 +151,8 @@ func (p *LogParser) backfillPlainFile(
 	}
 	window := parseWindow{maxTs: cutoffTs}
 
-	batch := make([]store.NginxLogRecord, 0, p.parseBatchSize)
+	batchSize := p.batchSize()
+	batch := make([]store.NginxLogRecord, 0, batchSize)
 	processBatch := func() {
 		if len(batch) == 0 {
 			return
@@ -222,7 +223,7 @@ func (p *LogParser) backfillPlainFile(
 		}
 		entryCount++
 
-		if len(batch) >= p.parseBatchSize {
+		if len(batch) >= batchSize {
 			processBatch()
 		}
 
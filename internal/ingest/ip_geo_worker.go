@@ -164,8 +164,8 @@ func (p *LogParser) ProcessPendingIPGeo(limit int) int {
 			if err := p.repo.UpsertIPGeoCache(entries); err != nil {
 				logrus.WithError(err).Warn("写入 IP 归属地缓存失败")
 			}
-			if p.ipGeoCacheLimit > 0 {
-				if err := p.repo.TrimIPGeoCache(p.ipGeoCacheLimit); err != nil {
+			if limit := p.geoCacheLimit(); limit > 0 {
+				if err := p.repo.TrimIPGeoCache(limit); err != nil {
 					logrus.WithError(err).Warn("清理 IP 归属地缓存失败")
 				}
 			}
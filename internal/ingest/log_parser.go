@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/url"
 	"os"
@@ -165,46 +166,27 @@ type LogParser struct {
 	lineParsers       map[string]*logLineParser // key: websiteID or websiteID:sourceID
 	dedup             *dedup.Cache
 	whitelistMatchers map[string]*enrich.WhitelistMatcher
+	// settingsMu 保护可热加载的字段：retentionDays、parseBatchSize、ipGeoCacheLimit、lineParsers、whitelistMatchers
+	settingsMu sync.RWMutex
 }
 
 // NewLogParser 创建新的日志解析器
 func NewLogParser(userRepoPtr *store.Repository) *LogParser {
 	statePath := filepath.Join(config.DataDir, "nginx_scan_state.json")
 	cfg := config.ReadConfig()
-	retentionDays := cfg.System.LogRetentionDays
-	if retentionDays <= 0 {
-		retentionDays = 30
-	}
-	parseBatchSize := cfg.System.ParseBatchSize
-	if parseBatchSize <= 0 {
-		parseBatchSize = defaultParseBatchSize
-	}
-	ipGeoCacheLimit := cfg.System.IPGeoCacheLimit
-	if ipGeoCacheLimit <= 0 {
-		ipGeoCacheLimit = 1000000
-	}
 	parser := &LogParser{
-		repo:              userRepoPtr,
-		statePath:         statePath,
-		states:            make(map[string]LogScanState),
-		demoMode:          cfg.System.DemoMode,
-		retentionDays:     retentionDays,
-		parseBatchSize:    parseBatchSize,
-		ipGeoCacheLimit:   ipGeoCacheLimit,
-		lineParsers:       make(map[string]*logLineParser),
-		dedup:             dedup.NewCache(100000, 10*time.Minute),
-		whitelistMatchers: make(map[string]*enrich.WhitelistMatcher),
-	}
-	for _, websiteID := range config.GetAllWebsiteIDs() {
-		if site, ok := config.GetWebsiteByID(websiteID); ok {
-			if matcher := enrich.NewWhitelistMatcher(site.Whitelist); matcher != nil {
-				parser.whitelistMatchers[websiteID] = matcher
-			}
-		}
-	}
+		repo:        userRepoPtr,
+		statePath:   statePath,
+		states:      make(map[string]LogScanState),
+		demoMode:    cfg.System.DemoMode,
+		lineParsers: make(map[string]*logLineParser),
+		dedup:       dedup.NewCache(100000, 10*time.Minute),
+	}
+	parser.applySettings(cfg)
 	parser.loadState()
 	parser.resetStateIfEmptyDB()
 	enrich.InitPVFilters()
+	enrich.InitCampaignParams()
 	return parser
 }
 
@@ -1232,7 +1214,8 @@ func (p *LogParser) parseLogLines(
 	var batchWhitelistHits map[string]*whitelistHit
 
 	// 批量插入相关
-	batch := make([]store.NginxLogRecord, 0, p.parseBatchSize)
+	batchSize := p.batchSize()
+	batch := make([]store.NginxLogRecord, 0, batchSize)
 
 	// 处理一批数据
 	processBatch := func() {
@@ -1277,7 +1260,7 @@ func (p *LogParser) parseLogLines(
 		if !window.allows(ts) {
 			continue
 		}
-		if matcher := p.whitelistMatchers[websiteID]; matcher != nil && matcher.Enabled() {
+		if matcher := p.whitelistMatcher(websiteID); matcher != nil && matcher.Enabled() {
 			if match, ok := matcher.Match(entry.IP); ok {
 				batchWhitelistHits = p.recordWhitelistHit(websiteID, *entry, match, batchWhitelistHits)
 			}
@@ -1294,7 +1277,7 @@ func (p *LogParser) parseLogLines(
 		entriesCount++
 		parserResult.TotalEntries++ // 累加到总结果中，而非赋值
 
-		if len(batch) >= p.parseBatchSize {
+		if len(batch) >= batchSize {
 			processBatch()
 		}
 	}
@@ -1316,6 +1299,12 @@ func (p *LogParser) parseLogLines(
 
 // IngestLines parses and inserts streamed log lines for a website/source.
 func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int, int, error) {
+	return p.IngestSampledLines(websiteID, sourceID, lines, nil)
+}
+
+// IngestSampledLines 与 IngestLines 相同，但 sampleRates[i] 为 agent 对第 i 行的采样率（0,1]。
+// 采样行按 1/采样率 放大预聚合计数（PV、流量、状态码），原始日志仍只保存一条。
+func (p *LogParser) IngestSampledLines(websiteID, sourceID string, lines []string, sampleRates []float64) (int, int, error) {
 	if websiteID == "" {
 		return 0, 0, errors.New("websiteID 不能为空")
 	}
@@ -1326,7 +1315,8 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 		return 0, 0, err
 	}
 
-	batch := make([]store.NginxLogRecord, 0, p.parseBatchSize)
+	batchSize := p.batchSize()
+	batch := make([]store.NginxLogRecord, 0, batchSize)
 	accepted := 0
 	deduped := 0
 	var minTs int64
@@ -1352,17 +1342,20 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 		return nil
 	}
 
-	for _, line := range lines {
+	for i, line := range lines {
 		entry, err := p.parseLogLine(websiteID, sourceID, line)
 		if err != nil {
 			continue
 		}
+		if i < len(sampleRates) {
+			entry.SampleWeight = sampleWeight(sampleRates[i])
+		}
 		key := buildDedupKey(websiteID, sourceID, line)
 		if p.dedup != nil && p.dedup.Seen(key) {
 			deduped++
 			continue
 		}
-		if matcher := p.whitelistMatchers[websiteID]; matcher != nil && matcher.Enabled() {
+		if matcher := p.whitelistMatcher(websiteID); matcher != nil && matcher.Enabled() {
 			if match, ok := matcher.Match(entry.IP); ok {
 				batchWhitelistHits = p.recordWhitelistHit(websiteID, *entry, match, batchWhitelistHits)
 			}
@@ -1379,7 +1372,7 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 			maxTs = ts
 		}
 
-		if len(batch) >= p.parseBatchSize {
+		if len(batch) >= batchSize {
 			if err := processBatch(); err != nil {
 				return accepted, deduped, err
 			}
@@ -1408,6 +1401,14 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 	return accepted, deduped, nil
 }
 
+// sampleWeight 将采样率换算为还原倍数；非法或 >=1 的采样率视为未采样。
+func sampleWeight(rate float64) int {
+	if rate <= 0 || rate >= 1 || math.IsNaN(rate) {
+		return 1
+	}
+	return int(math.Round(1 / rate))
+}
+
 func buildDedupKey(websiteID, sourceID, line string) string {
 	hash := sha1.Sum([]byte(line))
 	if sourceID == "" {
@@ -1519,7 +1520,10 @@ func (p *LogParser) getLineParserForSource(websiteID, sourceID string) (*logLine
 	if sourceID != "" {
 		key = websiteID + ":" + sourceID
 	}
-	if parser, ok := p.lineParsers[key]; ok {
+	p.settingsMu.RLock()
+	parser, ok := p.lineParsers[key]
+	p.settingsMu.RUnlock()
+	if ok {
 		return parser, nil
 	}
 
@@ -1543,7 +1547,12 @@ func (p *LogParser) getLineParserForSource(websiteID, sourceID string) (*logLine
 		return nil, err
 	}
 
+	p.settingsMu.Lock()
+	if p.lineParsers == nil {
+		p.lineParsers = make(map[string]*logLineParser)
+	}
 	p.lineParsers[key] = parser
+	p.settingsMu.Unlock()
 	return parser, nil
 }
 
@@ -1973,11 +1982,12 @@ func (p *LogParser) buildLogRecord(
 		return nil, errors.New("日志缺少状态码")
 	}
 
-	cutoffTime := time.Now().AddDate(0, 0, -p.retentionDays)
+	cutoffTime := time.Now().AddDate(0, 0, -p.retention())
 	if timestamp.Before(cutoffTime) {
 		return nil, errors.New("日志超过保留天数")
 	}
 
+	urlValue, campaign := enrich.ExtractCampaign(urlValue)
 	decodedPath, err := url.QueryUnescape(urlValue)
 	if err != nil {
 		decodedPath = urlValue
@@ -2012,6 +2022,7 @@ func (p *LogParser) buildLogRecord(
 		UserDevice:       device,
 		DomesticLocation: "",
 		GlobalLocation:   "",
+		Campaign:         campaign,
 	}, nil
 }
 
@@ -0,0 +1,100 @@
+package ingest
+
+import (
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/enrich"
+	"github.com/sirupsen/logrus"
+)
+
+// applySettings 根据配置重建可热加载的解析参数与站点白名单，整体替换后对新批次生效。
+func (p *LogParser) applySettings(cfg *config.Config) {
+	retentionDays := cfg.System.LogRetentionDays
+	if retentionDays <= 0 {
+		retentionDays = 30
+	}
+	parseBatchSize := cfg.System.ParseBatchSize
+	if parseBatchSize <= 0 {
+		parseBatchSize = defaultParseBatchSize
+	}
+	ipGeoCacheLimit := cfg.System.IPGeoCacheLimit
+	if ipGeoCacheLimit <= 0 {
+		ipGeoCacheLimit = 1000000
+	}
+	whitelistMatchers := make(map[string]*enrich.WhitelistMatcher)
+	for _, websiteID := range config.GetAllWebsiteIDs() {
+		if site, ok := config.GetWebsiteByID(websiteID); ok {
+			if matcher := enrich.NewWhitelistMatcher(site.Whitelist); matcher != nil {
+				whitelistMatchers[websiteID] = matcher
+			}
+		}
+	}
+
+	p.settingsMu.Lock()
+	defer p.settingsMu.Unlock()
+	p.retentionDays = retentionDays
+	p.parseBatchSize = parseBatchSize
+	p.ipGeoCacheLimit = ipGeoCacheLimit
+	p.whitelistMatchers = whitelistMatchers
+}
+
+// ReloadConfig 在配置热加载后刷新解析器：更新解析参数、白名单与 PV / 投放参数规则，
+// 并丢弃发生变化或已移除站点的行解析器缓存。新增站点在下一轮扫描时开始解析，
+// 已移除站点停止解析，但其数据与扫描状态会保留，重新加入后继续增量解析。
+func (p *LogParser) ReloadConfig(cfg *config.Config, diff *config.ReloadDiff) {
+	p.applySettings(cfg)
+	enrich.InitPVFilters()
+	enrich.InitCampaignParams()
+
+	stale := make(map[string]bool)
+	for _, ref := range diff.WebsitesChanged {
+		stale[ref.ID] = true
+	}
+	for _, ref := range diff.WebsitesRemoved {
+		stale[ref.ID] = true
+	}
+	if len(stale) > 0 {
+		p.settingsMu.Lock()
+		for key := range p.lineParsers {
+			websiteID, _, _ := strings.Cut(key, ":")
+			if stale[websiteID] {
+				delete(p.lineParsers, key)
+			}
+		}
+		p.settingsMu.Unlock()
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"added":   len(diff.WebsitesAdded),
+		"removed": len(diff.WebsitesRemoved),
+		"changed": len(diff.WebsitesChanged),
+	}).Info("日志解析器已应用新配置")
+}
+
+func (p *LogParser) batchSize() int {
+	p.settingsMu.RLock()
+	defer p.settingsMu.RUnlock()
+	if p.parseBatchSize <= 0 {
+		return defaultParseBatchSize
+	}
+	return p.parseBatchSize
+}
+
+func (p *LogParser) retention() int {
+	p.settingsMu.RLock()
+	defer p.settingsMu.RUnlock()
+	return p.retentionDays
+}
+
+func (p *LogParser) geoCacheLimit() int {
+	p.settingsMu.RLock()
+	defer p.settingsMu.RUnlock()
+	return p.ipGeoCacheLimit
+}
+
+func (p *LogParser) whitelistMatcher(websiteID string) *enrich.WhitelistMatcher {
+	p.settingsMu.RLock()
+	defer p.settingsMu.RUnlock()
+	return p.whitelistMatchers[websiteID]
+}
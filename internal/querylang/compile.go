@@ -0,0 +1,446 @@
+package querylang
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindTime
+)
+
+type fieldDef struct {
+	expr string
+	join string
+	kind fieldKind
+	// distinct 为 uniq() 使用的去重表达式，为空时使用 expr
+	distinct string
+	// noGroup 为 true 时不能用于 by
+	noGroup bool
+	// uniqOnly 为 true 时只能用于 uniq()
+	uniqOnly bool
+}
+
+// fields 为可在语句中引用的字段白名单
+var fields = map[string]fieldDef{
+	"ip":       {expr: "d_ip.ip", join: "ip", distinct: "l.ip_id"},
+	"url":      {expr: "d_url.url", join: "url", distinct: "l.url_id"},
+	"referer":  {expr: "d_ref.referer", join: "referer", distinct: "l.referer_id"},
+	"browser":  {expr: "d_ua.browser", join: "ua"},
+	"os":       {expr: "d_ua.os", join: "ua"},
+	"device":   {expr: "d_ua.device", join: "ua"},
+	"location": {expr: "d_loc.domestic", join: "location"},
+	"country":  {expr: "d_loc.global", join: "location"},
+	"source":   {expr: "COALESCE(d_cmp.source, '')", join: "campaign"},
+	"medium":   {expr: "COALESCE(d_cmp.medium, '')", join: "campaign"},
+	"campaign": {expr: "COALESCE(d_cmp.campaign, '')", join: "campaign"},
+	"method":   {expr: "l.method"},
+	"status":   {expr: "l.status_code", kind: kindNumber},
+	"pageview": {expr: "l.pageview_flag", kind: kindNumber},
+	"bytes":    {expr: "l.bytes_sent", kind: kindNumber, noGroup: true},
+	"visitor":  {distinct: "(l.ip_id, l.ua_id)", uniqOnly: true},
+	"hour":     {expr: "to_char(to_timestamp(l.timestamp), 'YYYY-MM-DD HH24:00')", kind: kindTime},
+	"day":      {expr: "to_char(to_timestamp(l.timestamp), 'YYYY-MM-DD')", kind: kindTime},
+	"week":     {expr: "to_char(date_trunc('week', to_timestamp(l.timestamp)), 'YYYY-MM-DD')", kind: kindTime},
+	"month":    {expr: "to_char(to_timestamp(l.timestamp), 'YYYY-MM')", kind: kindTime},
+}
+
+var joinDefs = map[string]string{
+	"ip":       `JOIN "%[1]s_dim_ip" d_ip ON d_ip.id = l.ip_id`,
+	"url":      `JOIN "%[1]s_dim_url" d_url ON d_url.id = l.url_id`,
+	"referer":  `JOIN "%[1]s_dim_referer" d_ref ON d_ref.id = l.referer_id`,
+	"ua":       `JOIN "%[1]s_dim_ua" d_ua ON d_ua.id = l.ua_id`,
+	"location": `JOIN "%[1]s_dim_location" d_loc ON d_loc.id = l.location_id`,
+	"campaign": `LEFT JOIN "%[1]s_dim_campaign" d_cmp ON d_cmp.id = l.campaign_id`,
+}
+
+// 聚合函数白名单，count 不接受字段，uniq 接受任意非时间字段，其余只接受数值字段。
+// count 与 sum 按 sample_weight 还原 agent 采样前的量，其余函数按实际落库的行计算。
+var numericFuncs = map[string]string{
+	"sum": "SUM(%s * l.sample_weight)::bigint",
+	"avg": "AVG(%s)::double precision",
+	"min": "MIN(%s)",
+	"max": "MAX(%s)",
+}
+
+// FieldNames 返回可用字段名，供错误提示与文档使用
+func FieldNames() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options 为编译时的站点、时间范围与行数限制
+type Options struct {
+	WebsiteID string
+	StartTs   int64
+	EndTs     int64
+	// DefaultLimit 为语句未指定 limit 时的行数，MaxRows 为行数上限
+	DefaultLimit int
+	MaxRows      int
+}
+
+// Compiled 为编译后的 SQL，SQL 已转换为 PostgreSQL 占位符；
+// 实际 LIMIT 为 Limit+1，多取一行用于判断结果是否被截断。
+type Compiled struct {
+	SQL     string
+	Args    []interface{}
+	Columns []string
+	Limit   int
+}
+
+type compiler struct {
+	opts  Options
+	args  []interface{}
+	joins map[string]bool
+}
+
+// Compile 将查询语句编译为针对单个站点日志表的 SQL
+func Compile(query *Query, opts Options) (*Compiled, error) {
+	if query == nil || len(query.Metrics) == 0 {
+		return nil, errorf(-1, "至少需要一个指标")
+	}
+	if opts.WebsiteID == "" {
+		return nil, errorf(-1, "站点 ID 不能为空")
+	}
+	c := &compiler{opts: opts, joins: make(map[string]bool)}
+
+	selects := make([]string, 0, len(query.GroupBy)+len(query.Metrics))
+	columns := make([]string, 0, len(query.GroupBy)+len(query.Metrics))
+	seen := make(map[string]bool)
+	addColumn := func(name, expr string) error {
+		if seen[name] {
+			return errorf(-1, "列名 %s 重复，请使用 as 指定别名", name)
+		}
+		seen[name] = true
+		columns = append(columns, name)
+		selects = append(selects, fmt.Sprintf(`%s AS "%s"`, expr, name))
+		return nil
+	}
+
+	for _, name := range query.GroupBy {
+		def, ok := fields[name]
+		if !ok {
+			return nil, unknownField(name)
+		}
+		if def.noGroup || def.uniqOnly {
+			return nil, errorf(-1, "字段 %s 不能用于 by", name)
+		}
+		c.use(def)
+		if err := addColumn(name, def.expr); err != nil {
+			return nil, err
+		}
+	}
+	for _, metric := range query.Metrics {
+		expr, alias, err := c.metric(metric)
+		if err != nil {
+			return nil, err
+		}
+		if err := addColumn(alias, expr); err != nil {
+			return nil, err
+		}
+	}
+
+	conditions := []string{"l.timestamp >= ?", "l.timestamp < ?"}
+	c.args = append(c.args, opts.StartTs, opts.EndTs)
+	if query.Where != nil {
+		where, err := c.expr(query.Where)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, where)
+	}
+
+	orderBy, err := orderClause(query, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = opts.DefaultLimit
+	}
+	if opts.MaxRows > 0 && (limit <= 0 || limit > opts.MaxRows) {
+		limit = opts.MaxRows
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var sqlText strings.Builder
+	sqlText.WriteString("SELECT ")
+	sqlText.WriteString(strings.Join(selects, ", "))
+	sqlText.WriteString(fmt.Sprintf("\nFROM \"%s_nginx_logs\" l", opts.WebsiteID))
+	for _, name := range sortedKeys(c.joins) {
+		sqlText.WriteString("\n")
+		sqlText.WriteString(fmt.Sprintf(joinDefs[name], opts.WebsiteID))
+	}
+	sqlText.WriteString("\nWHERE ")
+	sqlText.WriteString(strings.Join(conditions, " AND "))
+	if len(query.GroupBy) > 0 {
+		positions := make([]string, len(query.GroupBy))
+		for i := range query.GroupBy {
+			positions[i] = strconv.Itoa(i + 1)
+		}
+		sqlText.WriteString("\nGROUP BY ")
+		sqlText.WriteString(strings.Join(positions, ", "))
+		sqlText.WriteString("\nORDER BY ")
+		sqlText.WriteString(orderBy)
+	}
+	sqlText.WriteString("\nLIMIT ?")
+	c.args = append(c.args, limit+1)
+
+	return &Compiled{
+		SQL:     sqlutil.ReplacePlaceholders(sqlText.String()),
+		Args:    c.args,
+		Columns: columns,
+		Limit:   limit,
+	}, nil
+}
+
+func (c *compiler) use(def fieldDef) {
+	if def.join != "" {
+		c.joins[def.join] = true
+	}
+}
+
+func (c *compiler) metric(metric Metric) (string, string, error) {
+	alias := metric.Alias
+	switch metric.Func {
+	case "count":
+		if metric.Field != "" {
+			return "", "", errorf(-1, "count() 不接受字段，去重计数请使用 uniq(%s)", metric.Field)
+		}
+		if alias == "" {
+			alias = "count"
+		}
+		return "SUM(l.sample_weight)", alias, nil
+	case "uniq":
+		def, ok := fields[metric.Field]
+		if !ok {
+			return "", "", unknownField(metric.Field)
+		}
+		if def.kind == kindTime {
+			return "", "", errorf(-1, "uniq() 不支持时间维度 %s", metric.Field)
+		}
+		distinct := def.distinct
+		if distinct == "" {
+			c.use(def)
+			distinct = def.expr
+		}
+		if alias == "" {
+			alias = "uniq_" + metric.Field
+		}
+		return fmt.Sprintf("COUNT(DISTINCT %s)", distinct), alias, nil
+	}
+
+	if metric.Field == "" {
+		return "", "", errorf(-1, "%s() 需要指定数值字段", metric.Func)
+	}
+	def, ok := fields[metric.Field]
+	if !ok {
+		return "", "", unknownField(metric.Field)
+	}
+	if def.kind != kindNumber {
+		return "", "", errorf(-1, "%s() 只支持数值字段（bytes、status、pageview），%s 不是数值字段", metric.Func, metric.Field)
+	}
+	if metric.Func == "percentile" {
+		if alias == "" {
+			alias = "p" + strconv.FormatFloat(metric.Arg, 'f', -1, 64) + "_" + metric.Field
+			alias = strings.ReplaceAll(alias, ".", "_")
+		}
+		fraction := strconv.FormatFloat(metric.Arg/100, 'f', -1, 64)
+		return fmt.Sprintf("percentile_cont(%s) WITHIN GROUP (ORDER BY %s)", fraction, def.expr), alias, nil
+	}
+	format, ok := numericFuncs[metric.Func]
+	if !ok {
+		return "", "", errorf(-1, "不支持的指标函数 %s，可用：count、uniq、sum、avg、min、max、percentile、pNN", metric.Func)
+	}
+	if alias == "" {
+		alias = metric.Func + "_" + metric.Field
+	}
+	return fmt.Sprintf(format, def.expr), alias, nil
+}
+
+func (c *compiler) expr(expr Expr) (string, error) {
+	switch node := expr.(type) {
+	case *Logical:
+		left, err := c.expr(node.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := c.expr(node.Right)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(node.Op), right), nil
+	case *Not:
+		inner, err := c.expr(node.X)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("NOT %s", inner), nil
+	case *Comparison:
+		return c.comparison(node)
+	}
+	return "", errorf(-1, "无法识别的条件")
+}
+
+func (c *compiler) comparison(cmp *Comparison) (string, error) {
+	def, ok := fields[cmp.Field]
+	if !ok {
+		return "", unknownField(cmp.Field)
+	}
+	if def.kind == kindTime || def.uniqOnly {
+		return "", errorf(cmp.pos, "字段 %s 不能用于 where，时间范围请使用 timeRange 参数", cmp.Field)
+	}
+	c.use(def)
+
+	if def.kind == kindNumber {
+		return c.numberComparison(def, cmp)
+	}
+
+	switch cmp.Op {
+	case "=", "!=":
+		c.args = append(c.args, cmp.Values[0].Str)
+		return fmt.Sprintf("%s %s ?", def.expr, cmp.Op), nil
+	case "~", "!~":
+		c.args = append(c.args, likePattern(cmp.Values[0].Str))
+		op := "LIKE"
+		if cmp.Op == "!~" {
+			op = "NOT LIKE"
+		}
+		return fmt.Sprintf("%s %s ?", def.expr, op), nil
+	case "in":
+		placeholders := make([]string, len(cmp.Values))
+		for i, value := range cmp.Values {
+			placeholders[i] = "?"
+			c.args = append(c.args, value.Str)
+		}
+		return fmt.Sprintf("%s IN (%s)", def.expr, strings.Join(placeholders, ", ")), nil
+	}
+	return "", errorf(cmp.pos, "字段 %s 不支持运算符 %s，可用：= != ~ !~ in", cmp.Field, cmp.Op)
+}
+
+// numberComparison 处理数值字段，status 额外支持 '4xx' 这样的状态码段。
+func (c *compiler) numberComparison(def fieldDef, cmp *Comparison) (string, error) {
+	if cmp.Op == "in" {
+		placeholders := make([]string, len(cmp.Values))
+		for i, value := range cmp.Values {
+			if !value.IsNumber {
+				return "", errorf(cmp.pos, "字段 %s 的 in 列表只能包含数字", cmp.Field)
+			}
+			placeholders[i] = "?"
+			c.args = append(c.args, numberArg(value.Number))
+		}
+		return fmt.Sprintf("%s IN (%s)", def.expr, strings.Join(placeholders, ", ")), nil
+	}
+
+	value := cmp.Values[0]
+	if !value.IsNumber {
+		if cmp.Field == "status" && (cmp.Op == "=" || cmp.Op == "!=") {
+			if low, ok := statusClass(value.Str); ok {
+				c.args = append(c.args, low, low+100)
+				condition := fmt.Sprintf("(%s >= ? AND %s < ?)", def.expr, def.expr)
+				if cmp.Op == "!=" {
+					condition = "NOT " + condition
+				}
+				return condition, nil
+			}
+		}
+		return "", errorf(cmp.pos, "字段 %s 为数值字段，比较值必须为数字", cmp.Field)
+	}
+	switch cmp.Op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		c.args = append(c.args, numberArg(value.Number))
+		return fmt.Sprintf("%s %s ?", def.expr, cmp.Op), nil
+	}
+	return "", errorf(cmp.pos, "字段 %s 不支持运算符 %s，可用：= != < <= > >= in", cmp.Field, cmp.Op)
+}
+
+// orderClause 生成 ORDER BY；未指定时首个分组为时间维度则按时间升序，否则按首个指标降序。
+func orderClause(query *Query, columns []string) (string, error) {
+	known := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		known[column] = true
+	}
+	items := query.OrderBy
+	if len(items) == 0 {
+		metricColumn := columns[len(query.GroupBy)]
+		if len(query.GroupBy) > 0 && fields[query.GroupBy[0]].kind == kindTime {
+			items = []OrderItem{{Name: query.GroupBy[0]}}
+		} else {
+			items = []OrderItem{{Name: metricColumn, Desc: true}}
+		}
+	}
+	parts := make([]string, 0, len(items)+len(query.GroupBy))
+	used := make(map[string]bool)
+	for _, item := range items {
+		if !known[item.Name] {
+			return "", errorf(-1, "排序列 %s 不在查询结果中，可用：%s", item.Name, strings.Join(columns, "、"))
+		}
+		direction := "ASC"
+		if item.Desc {
+			direction = "DESC"
+		}
+		parts = append(parts, fmt.Sprintf(`"%s" %s`, item.Name, direction))
+		used[item.Name] = true
+	}
+	// 补充分组列作为次级排序，保证结果顺序稳定
+	for _, name := range query.GroupBy {
+		if !used[name] {
+			parts = append(parts, fmt.Sprintf(`"%s" ASC`, name))
+		}
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+// likePattern 与分群参数的 URL 匹配一致：含 * 时按通配符整体匹配，否则按子串匹配。
+func likePattern(value string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	escaped := escaper.Replace(value)
+	if !strings.Contains(value, "*") {
+		return "%" + escaped + "%"
+	}
+	return strings.ReplaceAll(escaped, "*", "%")
+}
+
+func statusClass(value string) (int, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) != 3 || value[1:] != "xx" || value[0] < '1' || value[0] > '5' {
+		return 0, false
+	}
+	return int(value[0]-'0') * 100, true
+}
+
+func numberArg(value float64) interface{} {
+	if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+		return int64(value)
+	}
+	return value
+}
+
+func unknownField(name string) *Error {
+	return errorf(-1, "未知字段 %s，可用字段：%s", name, strings.Join(FieldNames(), "、"))
+}
+
+func sortedKeys(values map[string]bool) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,152 @@
+package querylang
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "结尾"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// isKeyword 判断标识符是否为指定关键字（不区分大小写）
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+// Error 为查询语句的解析或校验错误，Pos 为出错位置（从 0 开始的字节偏移），未知时为 -1。
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if e.Pos < 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("位置 %d: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+const maxQueryLength = 4096
+
+func tokenize(input string) ([]token, error) {
+	if len(input) > maxQueryLength {
+		return nil, errorf(-1, "查询语句不能超过 %d 字节", maxQueryLength)
+	}
+	tokens := make([]token, 0, 32)
+	i := 0
+	for i < len(input) {
+		ch := rune(input[i])
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case ch == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case ch == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case ch == '\'' || ch == '"':
+			value, next, err := readString(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value, pos: i})
+			i = next
+		case ch >= '0' && ch <= '9':
+			start := i
+			for i < len(input) && (input[i] >= '0' && input[i] <= '9' || input[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: input[start:i], pos: start})
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			start := i
+			for i < len(input) && (input[i] == '_' || isASCIIAlnum(input[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[start:i], pos: start})
+		case strings.ContainsRune("=!<>~", ch):
+			start := i
+			op := string(ch)
+			if i+1 < len(input) {
+				two := input[i : i+2]
+				switch two {
+				case "==", "!=", "<>", "<=", ">=", "!~":
+					op = two
+				}
+			}
+			if op == "!" {
+				return nil, errorf(start, "无法识别的运算符 %q", op)
+			}
+			i += len(op)
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+		default:
+			return nil, errorf(i, "无法识别的字符 %q", input[i])
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(input)})
+	return tokens, nil
+}
+
+// readString 读取单引号或双引号字符串，支持反斜杠转义与连续两个引号转义。
+func readString(input string, start int) (string, int, error) {
+	quote := input[start]
+	var builder strings.Builder
+	i := start + 1
+	for i < len(input) {
+		ch := input[i]
+		switch {
+		case ch == '\\' && i+1 < len(input):
+			builder.WriteByte(input[i+1])
+			i += 2
+		case ch == quote && i+1 < len(input) && input[i+1] == quote:
+			builder.WriteByte(quote)
+			i += 2
+		case ch == quote:
+			return builder.String(), i + 1, nil
+		default:
+			builder.WriteByte(ch)
+			i++
+		}
+	}
+	return "", 0, errorf(start, "字符串缺少结束引号")
+}
+
+func isASCIIAlnum(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
@@ -0,0 +1,423 @@
+// Package querylang 实现 /api/query 使用的小型表达式查询语言：
+//
+//	[select] <指标>[, ...] [by <维度>[, ...]] [where <条件>] [order by <列> [asc|desc][, ...]] [limit <n>]
+//
+// 例如：count(), uniq(ip) by day, url where status >= 400 and url ~ '/api/*' order by count desc limit 20
+//
+// 语句只能引用白名单中的字段与函数，字面量全部作为绑定参数传入，编译结果可以直接交给数据库执行。
+package querylang
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Query 为解析后的查询语句
+type Query struct {
+	Metrics []Metric
+	GroupBy []string
+	Where   Expr
+	OrderBy []OrderItem
+	// Limit 为语句中的 limit，未指定时为 0
+	Limit int
+}
+
+// Metric 为单个聚合指标，Field 对 count 为空，Arg 仅 percentile 使用（0-100）。
+type Metric struct {
+	Func  string
+	Field string
+	Arg   float64
+	Alias string
+}
+
+type OrderItem struct {
+	Name string
+	Desc bool
+}
+
+// Expr 为 where 条件表达式：*Logical、*Not 或 *Comparison。
+type Expr interface {
+	exprNode()
+}
+
+// Logical 为 and / or 组合
+type Logical struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+type Not struct {
+	X Expr
+}
+
+// Comparison 为字段与字面量的比较，Op 为 = != < <= > >= ~ !~ in。
+type Comparison struct {
+	Field  string
+	Op     string
+	Values []Value
+	pos    int
+}
+
+// Value 为字面量，IsNumber 为 true 时使用 Number，否则使用 Str。
+type Value struct {
+	Str      string
+	Number   float64
+	IsNumber bool
+}
+
+func (*Logical) exprNode()    {}
+func (*Not) exprNode()        {}
+func (*Comparison) exprNode() {}
+
+const (
+	maxMetrics   = 10
+	maxGroupBy   = 4
+	maxInValues  = 100
+	maxExprDepth = 32
+)
+
+// 子句关键字不能作为别名或字段名使用
+var reservedWords = map[string]bool{
+	"select": true, "by": true, "where": true, "and": true, "or": true, "not": true, "in": true,
+	"order": true, "asc": true, "desc": true, "limit": true, "as": true,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// Parse 解析查询语句，字段与函数是否存在在 Compile 阶段校验。
+func Parse(input string) (*Query, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, errorf(-1, "查询语句不能为空")
+	}
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parseQuery()
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.peek().isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, errorf(tok.pos, "此处应为%s，实际为 %s", what, tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) expectIdent(what string) (string, int, error) {
+	tok, err := p.expect(tokenIdent, what)
+	if err != nil {
+		return "", tok.pos, err
+	}
+	name := strings.ToLower(tok.text)
+	if reservedWords[name] {
+		return "", tok.pos, errorf(tok.pos, "此处应为%s，实际为关键字 %s", what, tok)
+	}
+	return name, tok.pos, nil
+}
+
+func (p *parser) parseQuery() (*Query, error) {
+	query := &Query{}
+	p.acceptKeyword("select")
+
+	for {
+		metric, err := p.parseMetric()
+		if err != nil {
+			return nil, err
+		}
+		query.Metrics = append(query.Metrics, metric)
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+	if len(query.Metrics) > maxMetrics {
+		return nil, errorf(-1, "指标最多 %d 个", maxMetrics)
+	}
+
+	if p.acceptKeyword("by") {
+		for {
+			name, _, err := p.expectIdent("维度名")
+			if err != nil {
+				return nil, err
+			}
+			query.GroupBy = append(query.GroupBy, name)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+		if len(query.GroupBy) > maxGroupBy {
+			return nil, errorf(-1, "分组维度最多 %d 个", maxGroupBy)
+		}
+	}
+
+	if p.acceptKeyword("where") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		query.Where = expr
+	}
+
+	if p.acceptKeyword("order") {
+		if !p.acceptKeyword("by") {
+			tok := p.peek()
+			return nil, errorf(tok.pos, "order 后应为 by，实际为 %s", tok)
+		}
+		for {
+			name, _, err := p.expectIdent("排序列")
+			if err != nil {
+				return nil, err
+			}
+			item := OrderItem{Name: name}
+			if p.acceptKeyword("desc") {
+				item.Desc = true
+			} else {
+				p.acceptKeyword("asc")
+			}
+			query.OrderBy = append(query.OrderBy, item)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.acceptKeyword("limit") {
+		tok, err := p.expect(tokenNumber, "行数")
+		if err != nil {
+			return nil, err
+		}
+		limit, convErr := strconv.Atoi(tok.text)
+		if convErr != nil || limit <= 0 {
+			return nil, errorf(tok.pos, "limit 必须为正整数")
+		}
+		query.Limit = limit
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, errorf(tok.pos, "无法识别的内容 %s", tok)
+	}
+	return query, nil
+}
+
+// parseMetric 解析 count()、uniq(ip)、sum(bytes)、percentile(bytes, 95) 或 p95(bytes)，可带 as 别名。
+func (p *parser) parseMetric() (Metric, error) {
+	name, pos, err := p.expectIdent("指标函数")
+	if err != nil {
+		return Metric{}, err
+	}
+	metric := Metric{Func: name}
+	if percentile, ok := shorthandPercentile(name); ok {
+		metric.Func = "percentile"
+		metric.Arg = percentile
+	}
+	if _, err := p.expect(tokenLParen, " ("); err != nil {
+		return Metric{}, err
+	}
+	if p.peek().kind != tokenRParen {
+		field, _, err := p.expectIdent("字段名")
+		if err != nil {
+			return Metric{}, err
+		}
+		metric.Field = field
+		if metric.Func == "percentile" && metric.Arg == 0 {
+			if _, err := p.expect(tokenComma, " ,"); err != nil {
+				return Metric{}, err
+			}
+			tok, err := p.expect(tokenNumber, "百分位（0-100）")
+			if err != nil {
+				return Metric{}, err
+			}
+			value, convErr := strconv.ParseFloat(tok.text, 64)
+			if convErr != nil || value <= 0 || value > 100 {
+				return Metric{}, errorf(tok.pos, "百分位必须在 0-100 之间")
+			}
+			metric.Arg = value
+		}
+	}
+	if _, err := p.expect(tokenRParen, " )"); err != nil {
+		return Metric{}, err
+	}
+	if metric.Func == "percentile" && metric.Arg == 0 {
+		return Metric{}, errorf(pos, "percentile 需要指定百分位，例如 percentile(bytes, 95)")
+	}
+	if p.acceptKeyword("as") {
+		alias, _, err := p.expectIdent("别名")
+		if err != nil {
+			return Metric{}, err
+		}
+		metric.Alias = alias
+	}
+	return metric, nil
+}
+
+// shorthandPercentile 识别 p50、p95、p99 这类百分位简写
+func shorthandPercentile(name string) (float64, bool) {
+	if len(name) < 2 || name[0] != 'p' {
+		return 0, false
+	}
+	value, err := strconv.Atoi(name[1:])
+	if err != nil || value <= 0 || value > 100 {
+		return 0, false
+	}
+	return float64(value), true
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExprDepth {
+		return nil, errorf(p.peek().pos, "条件嵌套过深")
+	}
+	if p.acceptKeyword("not") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: inner}, nil
+	}
+	if p.peek().kind == tokenLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, " )"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison 解析 field op value、field in (...) 与 field not in (...)
+func (p *parser) parseComparison() (Expr, error) {
+	field, pos, err := p.expectIdent("字段名")
+	if err != nil {
+		return nil, err
+	}
+	negate := false
+	if p.peek().isKeyword("not") {
+		p.next()
+		if tok := p.peek(); !tok.isKeyword("in") {
+			return nil, errorf(tok.pos, "not 后应为 in，实际为 %s", tok)
+		}
+		negate = true
+	}
+	if p.acceptKeyword("in") {
+		if _, err := p.expect(tokenLParen, " ("); err != nil {
+			return nil, err
+		}
+		cmp := &Comparison{Field: field, Op: "in", pos: pos}
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			cmp.Values = append(cmp.Values, value)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokenRParen, " )"); err != nil {
+			return nil, err
+		}
+		if len(cmp.Values) > maxInValues {
+			return nil, errorf(pos, "in 列表最多 %d 项", maxInValues)
+		}
+		if negate {
+			return &Not{X: cmp}, nil
+		}
+		return cmp, nil
+	}
+	opTok, err := p.expect(tokenOperator, "比较运算符")
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &Comparison{Field: field, Op: opTok.text, Values: []Value{value}, pos: pos}, nil
+}
+
+func (p *parser) parseValue() (Value, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return Value{Str: tok.text}, nil
+	case tokenNumber:
+		number, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return Value{}, errorf(tok.pos, "数字格式无效: %s", tok.text)
+		}
+		return Value{Number: number, IsNumber: true, Str: tok.text}, nil
+	case tokenIdent:
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return Value{Number: 1, IsNumber: true, Str: "1"}, nil
+		case "false":
+			return Value{Number: 0, IsNumber: true, Str: "0"}, nil
+		}
+	}
+	return Value{}, errorf(tok.pos, "此处应为字符串或数字，实际为 %s", tok)
+}
@@ -0,0 +1,285 @@
+package querylang
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  *Query
+	}{
+		{
+			name:  "count only",
+			input: "count()",
+			want:  &Query{Metrics: []Metric{{Func: "count"}}},
+		},
+		{
+			name:  "select keyword and aliases",
+			input: "SELECT count() AS hits, uniq(ip) as visitors BY url",
+			want: &Query{
+				Metrics: []Metric{{Func: "count", Alias: "hits"}, {Func: "uniq", Field: "ip", Alias: "visitors"}},
+				GroupBy: []string{"url"},
+			},
+		},
+		{
+			name:  "percentile forms",
+			input: "percentile(bytes, 99.5), p95(bytes)",
+			want: &Query{Metrics: []Metric{
+				{Func: "percentile", Field: "bytes", Arg: 99.5},
+				{Func: "percentile", Field: "bytes", Arg: 95},
+			}},
+		},
+		{
+			name:  "where precedence and order limit",
+			input: "count() by day, url where status >= 400 and url ~ '/api/*' or not method = \"GET\" order by count desc, url limit 20",
+			want: &Query{
+				Metrics: []Metric{{Func: "count"}},
+				GroupBy: []string{"day", "url"},
+				Where: &Logical{
+					Op: "or",
+					Left: &Logical{
+						Op:    "and",
+						Left:  &Comparison{Field: "status", Op: ">=", Values: []Value{{Str: "400", Number: 400, IsNumber: true}}},
+						Right: &Comparison{Field: "url", Op: "~", Values: []Value{{Str: "/api/*"}}},
+					},
+					Right: &Not{X: &Comparison{Field: "method", Op: "=", Values: []Value{{Str: "GET"}}}},
+				},
+				OrderBy: []OrderItem{{Name: "count", Desc: true}, {Name: "url"}},
+				Limit:   20,
+			},
+		},
+		{
+			name:  "in and not in with escapes",
+			input: "count() where status in (404, 410) and country not in ('中国', 'it''s')",
+			want: &Query{
+				Metrics: []Metric{{Func: "count"}},
+				Where: &Logical{
+					Op: "and",
+					Left: &Comparison{Field: "status", Op: "in", Values: []Value{
+						{Str: "404", Number: 404, IsNumber: true},
+						{Str: "410", Number: 410, IsNumber: true},
+					}},
+					Right: &Not{X: &Comparison{Field: "country", Op: "in", Values: []Value{
+						{Str: "中国"}, {Str: "it's"},
+					}}},
+				},
+			},
+		},
+		{
+			name:  "parentheses and operator aliases",
+			input: "count() where (status == 500 or status <> 200) and pageview = true",
+			want: &Query{
+				Metrics: []Metric{{Func: "count"}},
+				Where: &Logical{
+					Op: "and",
+					Left: &Logical{
+						Op:    "or",
+						Left:  &Comparison{Field: "status", Op: "=", Values: []Value{{Str: "500", Number: 500, IsNumber: true}}},
+						Right: &Comparison{Field: "status", Op: "!=", Values: []Value{{Str: "200", Number: 200, IsNumber: true}}},
+					},
+					Right: &Comparison{Field: "pageview", Op: "=", Values: []Value{{Str: "1", Number: 1, IsNumber: true}}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.input, err)
+			}
+			clearPositions(got.Where)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Parse(%q)\n got: %#v\nwant: %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// clearPositions 清除比较节点的位置信息，只比较语法结构
+func clearPositions(expr Expr) {
+	switch node := expr.(type) {
+	case *Logical:
+		clearPositions(node.Left)
+		clearPositions(node.Right)
+	case *Not:
+		clearPositions(node.X)
+	case *Comparison:
+		node.pos = 0
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "empty", input: "  ", wantErr: "查询语句不能为空"},
+		{name: "missing paren", input: "count(", wantErr: "位置 6"},
+		{name: "unterminated string", input: "count() where url = 'abc", wantErr: "字符串缺少结束引号"},
+		{name: "unknown char", input: "count(); drop table x", wantErr: "无法识别的字符"},
+		{name: "keyword as field", input: "count() by where", wantErr: "关键字"},
+		{name: "bad limit", input: "count() limit 0", wantErr: "limit 必须为正整数"},
+		{name: "percentile out of range", input: "percentile(bytes, 120)", wantErr: "百分位必须在 0-100 之间"},
+		{name: "percentile without arg", input: "percentile()", wantErr: "需要指定百分位"},
+		{name: "trailing tokens", input: "count() by url url", wantErr: "无法识别的内容"},
+		{name: "not without in", input: "count() where url not = 'a'", wantErr: "not 后应为 in"},
+		{name: "order without by", input: "count() order count", wantErr: "order 后应为 by"},
+		{name: "too long", input: "count() where url = '" + strings.Repeat("a", maxQueryLength) + "'", wantErr: "不能超过"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Parse(tt.input)
+			if err == nil {
+				t.Fatalf("Parse(%q) expected error", tt.input)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Parse(%q) error = %q, want contains %q", tt.input, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{WebsiteID: "site", StartTs: 100, EndTs: 200, DefaultLimit: 100, MaxRows: 1000}
+	tests := []struct {
+		name        string
+		input       string
+		wantSQL     string
+		wantArgs    []interface{}
+		wantColumns []string
+	}{
+		{
+			name:  "total without group",
+			input: "count(), uniq(visitor), sum(bytes)",
+			wantSQL: `SELECT SUM(l.sample_weight) AS "count", COUNT(DISTINCT (l.ip_id, l.ua_id)) AS "uniq_visitor", SUM(l.bytes_sent * l.sample_weight)::bigint AS "sum_bytes"
+FROM "site_nginx_logs" l
+WHERE l.timestamp >= $1 AND l.timestamp < $2
+LIMIT $3`,
+			wantArgs:    []interface{}{int64(100), int64(200), 101},
+			wantColumns: []string{"count", "uniq_visitor", "sum_bytes"},
+		},
+		{
+			name:  "group by dims with filters",
+			input: "count() as hits, uniq(ip) by url, browser where status = '4xx' and url ~ '/api/*' and country in ('中国') limit 20",
+			wantSQL: `SELECT d_url.url AS "url", d_ua.browser AS "browser", SUM(l.sample_weight) AS "hits", COUNT(DISTINCT l.ip_id) AS "uniq_ip"
+FROM "site_nginx_logs" l
+JOIN "site_dim_location" d_loc ON d_loc.id = l.location_id
+JOIN "site_dim_ua" d_ua ON d_ua.id = l.ua_id
+JOIN "site_dim_url" d_url ON d_url.id = l.url_id
+WHERE l.timestamp >= $1 AND l.timestamp < $2 AND (((l.status_code >= $3 AND l.status_code < $4) AND d_url.url LIKE $5) AND d_loc.global IN ($6))
+GROUP BY 1, 2
+ORDER BY "hits" DESC, "url" ASC, "browser" ASC
+LIMIT $7`,
+			wantArgs:    []interface{}{int64(100), int64(200), 400, 500, "/api/%", "中国", 21},
+			wantColumns: []string{"url", "browser", "hits", "uniq_ip"},
+		},
+		{
+			name:  "time bucket ordered ascending by default",
+			input: "p95(bytes), avg(bytes) by day where not referer ~ '50%_off' and source != ''",
+			wantSQL: `SELECT to_char(to_timestamp(l.timestamp), 'YYYY-MM-DD') AS "day", percentile_cont(0.95) WITHIN GROUP (ORDER BY l.bytes_sent) AS "p95_bytes", AVG(l.bytes_sent)::double precision AS "avg_bytes"
+FROM "site_nginx_logs" l
+LEFT JOIN "site_dim_campaign" d_cmp ON d_cmp.id = l.campaign_id
+JOIN "site_dim_referer" d_ref ON d_ref.id = l.referer_id
+WHERE l.timestamp >= $1 AND l.timestamp < $2 AND (NOT d_ref.referer LIKE $3 AND COALESCE(d_cmp.source, '') != $4)
+GROUP BY 1
+ORDER BY "day" ASC
+LIMIT $5`,
+			wantArgs:    []interface{}{int64(100), int64(200), `%50\%\_off%`, "", 101},
+			wantColumns: []string{"day", "p95_bytes", "avg_bytes"},
+		},
+		{
+			name:  "explicit order and limit capped by max rows",
+			input: "uniq(url) by hour where bytes > 1.5 order by uniq_url limit 5000",
+			wantSQL: `SELECT to_char(to_timestamp(l.timestamp), 'YYYY-MM-DD HH24:00') AS "hour", COUNT(DISTINCT l.url_id) AS "uniq_url"
+FROM "site_nginx_logs" l
+WHERE l.timestamp >= $1 AND l.timestamp < $2 AND l.bytes_sent > $3
+GROUP BY 1
+ORDER BY "uniq_url" ASC, "hour" ASC
+LIMIT $4`,
+			wantArgs:    []interface{}{int64(100), int64(200), 1.5, 1001},
+			wantColumns: []string{"hour", "uniq_url"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			query, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.input, err)
+			}
+			compiled, err := Compile(query, opts)
+			if err != nil {
+				t.Fatalf("Compile(%q) error: %v", tt.input, err)
+			}
+			if compiled.SQL != tt.wantSQL {
+				t.Fatalf("Compile(%q) SQL\n got: %s\nwant: %s", tt.input, compiled.SQL, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(compiled.Args, tt.wantArgs) {
+				t.Fatalf("Compile(%q) args = %#v, want %#v", tt.input, compiled.Args, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(compiled.Columns, tt.wantColumns) {
+				t.Fatalf("Compile(%q) columns = %v, want %v", tt.input, compiled.Columns, tt.wantColumns)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{WebsiteID: "site", StartTs: 100, EndTs: 200, DefaultLimit: 100, MaxRows: 1000}
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "unknown field", input: "count() by password", wantErr: "未知字段 password"},
+		{name: "unknown function", input: "median(bytes)", wantErr: "不支持的指标函数"},
+		{name: "sum of string", input: "sum(url)", wantErr: "不是数值字段"},
+		{name: "count with field", input: "count(ip)", wantErr: "uniq(ip)"},
+		{name: "group by bytes", input: "count() by bytes", wantErr: "不能用于 by"},
+		{name: "time in where", input: "count() where day = '2024-01-01'", wantErr: "不能用于 where"},
+		{name: "like on number", input: "count() where status ~ '4'", wantErr: "比较值必须为数字"},
+		{name: "range on string", input: "count() where url > 'a'", wantErr: "不支持运算符 >"},
+		{name: "duplicate column", input: "count(), count()", wantErr: "列名 count 重复"},
+		{name: "unknown order column", input: "count() by url order by hits", wantErr: "排序列 hits"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			query, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.input, err)
+			}
+			_, err = Compile(query, opts)
+			if err == nil {
+				t.Fatalf("Compile(%q) expected error", tt.input)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Compile(%q) error = %q, want contains %q", tt.input, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,17 +1,37 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/agentproto"
+	"github.com/likaia/nginxpulse/internal/auth"
 	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/web"
+	"github.com/sirupsen/logrus"
 )
 
 const accessKeyHeader = "X-NginxPulse-Key"
 
-func accessKeyMiddleware() gin.HandlerFunc {
+// accessKeySet 缓存由某一份配置生成的访问密钥集合，配置热加载后按新配置重建
+type accessKeySet struct {
+	mu   sync.Mutex
+	cfg  *config.Config
+	keys map[string]struct{}
+}
+
+func (s *accessKeySet) current() map[string]struct{} {
 	cfg := config.ReadConfig()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg == cfg && s.keys != nil {
+		return s.keys
+	}
 	keys := make(map[string]struct{})
 	for _, key := range cfg.System.AccessKeys {
 		key = strings.TrimSpace(key)
@@ -20,36 +40,215 @@ func accessKeyMiddleware() gin.HandlerFunc {
 		}
 		keys[key] = struct{}{}
 	}
+	s.cfg = cfg
+	s.keys = keys
+	return keys
+}
 
-	if len(keys) == 0 {
-		return func(c *gin.Context) {
-			c.Next()
-		}
-	}
+// accessKeyMiddleware 解析请求主体：访问密钥（视为管理员）、登录会话（Cookie 或 Bearer 令牌）
+// 或 API 令牌（Bearer npt_...，只读）。未配置访问密钥且没有启用的用户时保持开放访问；
+// 站点范围由各接口自行校验。
+func accessKeyMiddleware(repo *store.Repository, limiter *rateLimiter) gin.HandlerFunc {
+	keySet := &accessKeySet{}
+	tokens := &apiTokenAuth{limiter: limiter, usage: make(map[int64]*apiTokenUsage)}
 
 	return func(c *gin.Context) {
 		if !strings.HasPrefix(c.Request.URL.Path, "/api/") {
 			c.Next()
 			return
 		}
+		keys := keySet.current()
+		if len(keys) == 0 && !auth.UserAccountsEnabled() {
+			auth.SetPrincipal(c, auth.Anonymous())
+			c.Next()
+			return
+		}
 		if c.Request.Method == http.MethodOptions {
 			c.Next()
 			return
 		}
 
 		value := strings.TrimSpace(c.GetHeader(accessKeyHeader))
-		if value == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "需要访问密钥",
-			})
+		// agent 推送接口允许使用站点级 agent 令牌代替访问密钥，由接口自身完成鉴权。
+		if value == "" && web.IsAgentIngestPath(c.Request.URL.Path) &&
+			strings.TrimSpace(c.GetHeader(agentproto.HeaderAgentToken)) != "" {
+			c.Next()
+			return
+		}
+		// 视图分享接口使用分享令牌鉴权，不需要访问密钥。
+		if c.Request.Method == http.MethodGet && web.IsSharedViewPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		// 公开统计接口只返回已开启公开的站点的聚合数据，由接口自身校验。
+		if c.Request.Method == http.MethodGet && web.IsPublicDashboardPath(c.Request.URL.Path) {
+			c.Next()
 			return
 		}
-		if _, ok := keys[value]; !ok {
+		// 登录接口自身校验用户名与密码或单点登录回调。
+		if web.IsPublicAuthPath(c.Request.URL.Path) || isOIDCPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		var principal *auth.Principal
+		if value != "" {
+			if _, ok := keys[value]; !ok {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": "访问密钥无效",
+				})
+				return
+			}
+			principal = &auth.Principal{Kind: auth.PrincipalAccessKey, Role: auth.RoleAdmin}
+		} else if token := auth.SessionToken(c); token != "" && repo != nil && auth.IsAPIToken(token) {
+			principal = tokens.resolve(c, repo, token)
+			if principal == nil {
+				return
+			}
+		} else if token != "" && repo != nil {
+			principal = resolveSession(c, repo, token)
+			if principal == nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": "登录已失效，请重新登录",
+				})
+				return
+			}
+		} else {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "访问密钥无效",
+				"error": "需要登录或访问密钥",
 			})
 			return
 		}
+
+		auth.SetPrincipal(c, principal)
 		c.Next()
 	}
 }
+
+// sessionTouchInterval 为顺延会话有效期的最小间隔，避免每个请求都写库
+const sessionTouchInterval = time.Minute
+
+func resolveSession(c *gin.Context, repo *store.Repository, token string) *auth.Principal {
+	tokenHash := auth.HashToken(token)
+	user, session, err := repo.GetSessionUser(tokenHash)
+	if err != nil {
+		if !errors.Is(err, store.ErrUserNotFound) {
+			logrus.WithError(err).Warn("读取登录会话失败")
+		}
+		return nil
+	}
+	role, ok := auth.ParseRole(user.Role)
+	if !ok {
+		return nil
+	}
+	if time.Since(session.LastSeenAt) > sessionTouchInterval {
+		if err := repo.TouchUserSession(tokenHash, time.Now().Add(auth.SessionTTL)); err != nil {
+			logrus.WithError(err).Warn("更新登录会话失败")
+		}
+	}
+	return &auth.Principal{
+		Kind:     auth.PrincipalSession,
+		UserID:   user.ID,
+		Username: user.Username,
+		Provider: user.Provider,
+		Role:     role,
+		Websites: user.Websites,
+	}
+}
+
+// apiTokenUsageInterval 为写回 API 令牌使用记录的最小间隔，期间的使用次数在内存中累计
+const apiTokenUsageInterval = time.Minute
+
+// apiTokenUsage 是某个令牌尚未写回数据库的使用记录
+type apiTokenUsage struct {
+	pending   int64
+	ip        string
+	flushedAt time.Time
+}
+
+// apiTokenAuth 校验 API 令牌并按令牌限流。令牌先查库校验，只有有效令牌才会分配限流桶，
+// 避免随机令牌撑大限流表；使用次数与最近使用时间按 apiTokenUsageInterval 批量写回。
+type apiTokenAuth struct {
+	limiter *rateLimiter
+
+	mu    sync.Mutex
+	usage map[int64]*apiTokenUsage
+}
+
+// recordUse 记录一次使用；距上次写回超过 apiTokenUsageInterval 时返回需要写回的次数
+func (a *apiTokenAuth) recordUse(id int64, ip string, now time.Time) (int64, string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	usage, ok := a.usage[id]
+	if !ok {
+		usage = &apiTokenUsage{}
+		a.usage[id] = usage
+	}
+	usage.pending++
+	usage.ip = ip
+	if now.Sub(usage.flushedAt) < apiTokenUsageInterval {
+		return 0, "", false
+	}
+	uses := usage.pending
+	usage.pending = 0
+	usage.flushedAt = now
+	return uses, usage.ip, true
+}
+
+// restoreUse 写回失败时把次数加回去，下次写回时一并提交
+func (a *apiTokenAuth) restoreUse(id, uses int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if usage, ok := a.usage[id]; ok {
+		usage.pending += uses
+	}
+}
+
+// resolve 返回令牌对应的只读主体；失败时已写出响应并返回 nil
+func (a *apiTokenAuth) resolve(c *gin.Context, repo *store.Repository, token string) *auth.Principal {
+	// 只读：令牌只能调用统计查询等 GET 接口，拒绝的请求不计入使用次数
+	if !web.IsAPITokenRequest(c.Request.Method, c.Request.URL.Path) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "API 令牌只能调用只读统计接口",
+		})
+		return nil
+	}
+
+	tokenHash := auth.HashToken(token)
+	apiToken, err := repo.GetActiveAPIToken(tokenHash)
+	if err != nil {
+		if !errors.Is(err, store.ErrAPITokenNotFound) {
+			logrus.WithError(err).Warn("读取 API 令牌失败")
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "API 令牌无效或已过期",
+		})
+		return nil
+	}
+
+	limits := config.GetRateLimitConfig()
+	perMinute := limits.TokenPerMinute
+	if apiToken.RateLimit > 0 {
+		perMinute = apiToken.RateLimit
+	}
+	now := time.Now()
+	if ok, wait := a.limiter.allow("token:"+tokenHash, perMinute, limits.Burst, now); !ok {
+		abortRateLimited(c, wait)
+		return nil
+	}
+
+	if uses, ip, flush := a.recordUse(apiToken.ID, c.ClientIP(), now); flush {
+		if err := repo.AddAPITokenUsage(apiToken.ID, uses, ip, now); err != nil {
+			logrus.WithError(err).Warn("更新 API 令牌使用记录失败")
+			a.restoreUse(apiToken.ID, uses)
+		}
+	}
+	return &auth.Principal{
+		Kind:       auth.PrincipalAPIToken,
+		UserID:     apiToken.ID,
+		Username:   apiToken.Name,
+		Role:       auth.RoleViewer,
+		Websites:   apiToken.Websites,
+		StatsTypes: apiToken.StatsTypes,
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"net"
 	"net/http"
 	"strings"
@@ -9,17 +10,40 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/likaia/nginxpulse/internal/web"
 	"github.com/sirupsen/logrus"
 )
 
 // StartHTTPServer configures and starts the HTTP server in a goroutine.
-func StartHTTPServer(statsFactory *analytics.StatsFactory, logParser *ingest.LogParser, addr string) (*http.Server, error) {
-	router := buildRouter(statsFactory, logParser)
+// server.tls 启用时提供 HTTPS，并默认通过 ALPN 启用 HTTP/2。
+func StartHTTPServer(statsFactory *analytics.StatsFactory, logParser *ingest.LogParser, serverCfg config.ServerConfig) (*http.Server, error) {
+	addr := serverCfg.Port
+	var tlsConfig *tls.Config
+	if serverCfg.TLSEnabled() {
+		var err error
+		tlsConfig, err = buildTLSConfig(serverCfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	requireClientCert := tlsConfig != nil && strings.TrimSpace(serverCfg.TLS.ClientCAFile) != ""
+
+	router, err := buildRouter(statsFactory, logParser, requireClientCert, serverCfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
 	server := &http.Server{
-		Addr:    addr,
-		Handler: router,
+		Addr:      addr,
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig != nil && serverCfg.TLS.DisableHTTP2 {
+		// 非 nil 的空表会阻止 net/http 自动启用 HTTP/2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 	}
 
 	listener, err := net.Listen("tcp", addr)
@@ -28,36 +52,83 @@ func StartHTTPServer(statsFactory *analytics.StatsFactory, logParser *ingest.Log
 	}
 
 	go func() {
-		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logrus.WithError(err).Error("HTTP 服务器运行失败")
 		}
 	}()
 
-	logrus.Infof("服务器已启动，监听地址: %s", addr)
+	if tlsConfig != nil {
+		logrus.WithFields(logrus.Fields{
+			"http2": !serverCfg.TLS.DisableHTTP2,
+			"mtls":  requireClientCert,
+		}).Infof("服务器已启动（HTTPS），监听地址: %s", addr)
+	} else {
+		logrus.Infof("服务器已启动，监听地址: %s", addr)
+	}
 	return server, nil
 }
 
-func buildRouter(statsFactory *analytics.StatsFactory, logParser *ingest.LogParser) *gin.Engine {
+func buildRouter(statsFactory *analytics.StatsFactory, logParser *ingest.LogParser, requireClientCert bool, trustedProxies []string) (*gin.Engine, error) {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
+	if err := configureTrustedProxies(router, trustedProxies); err != nil {
+		return nil, err
+	}
 
 	router.Use(gin.Recovery())
 	router.Use(requestLogger())
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", accessKeyHeader},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
 	router.Use(basePathMiddleware(router))
-	router.Use(accessKeyMiddleware())
+	var repo *store.Repository
+	if statsFactory != nil {
+		repo = statsFactory.Repo()
+		if count, err := repo.CountActiveUsers(""); err != nil {
+			logrus.WithError(err).Warn("读取用户数量失败")
+		} else {
+			auth.SetUserAccountsEnabled(count > 0)
+		}
+	}
+	if requireClientCert {
+		router.Use(requireClientCertMiddleware())
+	}
+	limiter := newRateLimiter()
+	router.Use(ipRateLimitMiddleware(limiter))
+	router.Use(accessKeyMiddleware(repo, limiter))
 
 	web.SetupRoutes(router, statsFactory, logParser)
+	setupOIDCRoutes(router, repo)
 	attachAppConfig(router)
 	attachWebUI(router)
 
-	return router
+	return router, nil
+}
+
+// configureTrustedProxies 设置可信反向代理（server.trustedProxies）。gin 默认信任所有代理，
+// 任何客户端都能通过 X-Forwarded-For 伪造 ClientIP；未配置时不信任任何代理，
+// ClientIP 即连接的对端地址，限流与审计日志都依赖这一点。
+func configureTrustedProxies(router *gin.Engine, proxies []string) error {
+	trusted := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trusted = append(trusted, proxy)
+		}
+	}
+	if len(trusted) == 0 {
+		return router.SetTrustedProxies(nil)
+	}
+	return router.SetTrustedProxies(trusted)
 }
 
 func requestLogger() gin.HandlerFunc {
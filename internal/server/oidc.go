@@ -0,0 +1,530 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+const (
+	oidcHTTPTimeout = 10 * time.Second
+	// oidcClockSkew 为校验 exp / iat 时允许的时钟偏差
+	oidcClockSkew = time.Minute
+	// oidcJWKSMinRefresh 为遇到未知 kid 时重新拉取 JWKS 的最小间隔
+	oidcJWKSMinRefresh = time.Minute
+	oidcMaxBodySize    = 1 << 20
+)
+
+var (
+	errOIDCDisabled = errors.New("未启用单点登录")
+	errOIDCNoRole   = errors.New("没有匹配的角色映射，拒绝登录")
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// oidcProvider 为一个 IdP 的客户端：发现文档、授权地址、换取令牌与校验 ID Token。
+type oidcProvider struct {
+	cfg       config.OIDCConfig
+	client    *http.Client
+	discovery oidcDiscovery
+
+	keysMu        sync.Mutex
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+type oidcTokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// newOIDCProvider 读取 issuer 的发现文档，issuer 与文档声明不一致时拒绝使用。
+func newOIDCProvider(ctx context.Context, cfg *config.OIDCConfig, client *http.Client) (*oidcProvider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: oidcHTTPTimeout}
+	}
+	provider := &oidcProvider{cfg: *cfg, client: client}
+	if err := provider.getJSON(ctx, cfg.Issuer+"/.well-known/openid-configuration", "", &provider.discovery); err != nil {
+		return nil, fmt.Errorf("读取 OIDC 发现文档失败: %w", err)
+	}
+	if strings.TrimRight(provider.discovery.Issuer, "/") != cfg.Issuer {
+		return nil, fmt.Errorf("发现文档中的 issuer 不匹配: %s", provider.discovery.Issuer)
+	}
+	if provider.discovery.AuthorizationEndpoint == "" || provider.discovery.TokenEndpoint == "" || provider.discovery.JWKSURI == "" {
+		return nil, errors.New("发现文档缺少 authorization_endpoint、token_endpoint 或 jwks_uri")
+	}
+	return provider, nil
+}
+
+// authCodeURL 生成授权码 + PKCE（S256）登录地址
+func (p *oidcProvider) authCodeURL(state, nonce, verifier, redirectURL string) string {
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.cfg.ClientID)
+	values.Set("redirect_uri", redirectURL)
+	values.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", pkceChallenge(verifier))
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + separator + values.Encode()
+}
+
+// endSessionURL 返回 IdP 的注销地址，IdP 不支持 RP 发起注销时返回空字符串
+func (p *oidcProvider) endSessionURL(postLogoutRedirect string) string {
+	if p.discovery.EndSessionEndpoint == "" {
+		return ""
+	}
+	values := url.Values{}
+	values.Set("client_id", p.cfg.ClientID)
+	if postLogoutRedirect != "" {
+		values.Set("post_logout_redirect_uri", postLogoutRedirect)
+	}
+	separator := "?"
+	if strings.Contains(p.discovery.EndSessionEndpoint, "?") {
+		separator = "&"
+	}
+	return p.discovery.EndSessionEndpoint + separator + values.Encode()
+}
+
+// exchange 用授权码与 PKCE verifier 换取令牌
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier, redirectURL string) (oidcTokens, error) {
+	var tokens oidcTokens
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokens, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return tokens, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxBodySize))
+	if err != nil {
+		return tokens, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &failure)
+		if failure.Error != "" {
+			return tokens, fmt.Errorf("换取令牌失败: %s %s", failure.Error, failure.Description)
+		}
+		return tokens, fmt.Errorf("换取令牌失败: HTTP %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return tokens, fmt.Errorf("解析令牌响应失败: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return tokens, errors.New("令牌响应缺少 id_token")
+	}
+	return tokens, nil
+}
+
+// verifyIDToken 校验 ID Token 的签名、issuer、audience、有效期与 nonce，返回其中的声明
+func (p *oidcProvider) verifyIDToken(ctx context.Context, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token 格式无效")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("id_token 头部无效: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("id_token 签名格式无效")
+	}
+	key, err := p.keyFor(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]any)
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("id_token 内容无效: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != p.discovery.Issuer {
+		return nil, errors.New("id_token issuer 不匹配")
+	}
+	audiences := claimStrings(claims["aud"])
+	if !containsValue(audiences, p.cfg.ClientID) {
+		return nil, errors.New("id_token audience 不匹配")
+	}
+	if azp, ok := claims["azp"].(string); ok && len(audiences) > 1 && azp != p.cfg.ClientID {
+		return nil, errors.New("id_token azp 不匹配")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("id_token 已过期")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("id_token 签发时间无效")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("id_token nonce 不匹配")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id_token 缺少 sub")
+	}
+	return claims, nil
+}
+
+// userinfo 读取 userinfo 接口的声明，IdP 未提供该接口时返回 nil
+func (p *oidcProvider) userinfo(ctx context.Context, accessToken string) (map[string]any, error) {
+	if p.discovery.UserinfoEndpoint == "" || accessToken == "" {
+		return nil, nil
+	}
+	claims := make(map[string]any)
+	if err := p.getJSON(ctx, p.discovery.UserinfoEndpoint, accessToken, &claims); err != nil {
+		return nil, fmt.Errorf("读取 userinfo 失败: %w", err)
+	}
+	return claims, nil
+}
+
+// keyFor 返回 kid 对应的公钥，缓存中没有时重新拉取 JWKS（支持 IdP 轮换密钥）
+func (p *oidcProvider) keyFor(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.keysMu.Lock()
+	defer p.keysMu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < oidcJWKSMinRefresh {
+		return nil, errors.New("未找到 id_token 的签名密钥")
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.discovery.JWKSURI, "", &set); err != nil {
+		return nil, fmt.Errorf("读取 JWKS 失败: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, errors.New("未找到 id_token 的签名密钥")
+}
+
+// lookupKey 按 kid 查找公钥；令牌未声明 kid 且 JWKS 只有一个密钥时使用该密钥
+func (p *oidcProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, endpoint, bearer string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxBodySize)).Decode(target)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 {
+			return nil, errors.New("RSA 指数无效")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的曲线: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC 公钥无效")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+}
+
+// verifyJWTSignature 校验 RS*/PS*/ES* 签名，拒绝 none 与对称算法。
+// 算法必须与密钥类型一致；ES 系列还要求曲线与算法对应（RFC 7518 3.4），PS 系列的盐长等于摘要长度。
+func verifyJWTSignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("不支持的签名算法: %s", alg)
+	}
+	var hashFunc crypto.Hash
+	var hasher func() hash.Hash
+	var curve elliptic.Curve
+	switch alg[2:] {
+	case "256":
+		hashFunc, hasher, curve = crypto.SHA256, sha256.New, elliptic.P256()
+	case "384":
+		hashFunc, hasher, curve = crypto.SHA384, sha512.New384, elliptic.P384()
+	case "512":
+		hashFunc, hasher, curve = crypto.SHA512, sha512.New, elliptic.P521()
+	default:
+		return fmt.Errorf("不支持的签名算法: %s", alg)
+	}
+	h := hasher()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	invalid := errors.New("id_token 签名无效")
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return invalid
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(rsaKey, hashFunc, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hashFunc, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return invalid
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != curve {
+			return invalid
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return invalid
+		}
+		return nil
+	}
+	return fmt.Errorf("不支持的签名算法: %s", alg)
+}
+
+func decodeJWTSegment(segment string, target any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, target)
+}
+
+// oidcIdentity 为由声明映射得到的 NginxPulse 身份，Websites 为空表示全部站点
+type oidcIdentity struct {
+	Subject  string
+	Username string
+	Role     auth.Role
+	Websites []string
+}
+
+// mapOIDCIdentity 按 roleMappings 把声明映射为角色与站点范围：取匹配映射中最高的角色，
+// 站点取并集，任一匹配映射未限定站点时不限制站点。没有匹配时使用 defaultRole。
+func mapOIDCIdentity(cfg *config.OIDCConfig, claims map[string]any) (oidcIdentity, error) {
+	identity := oidcIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	for _, claim := range []string{cfg.UsernameClaim, "email", "sub"} {
+		if value, ok := claims[claim].(string); ok && strings.TrimSpace(value) != "" {
+			identity.Username = strings.TrimSpace(value)
+			break
+		}
+	}
+
+	matched := false
+	unrestricted := false
+	var refs []string
+	for _, mapping := range cfg.RoleMappings {
+		if !containsValue(claimStrings(claims[mapping.Claim]), mapping.Value) {
+			continue
+		}
+		role, ok := auth.ParseRole(mapping.Role)
+		if !ok {
+			continue
+		}
+		matched = true
+		if !identity.Role.Includes(role) {
+			identity.Role = role
+		}
+		if len(mapping.Websites) == 0 {
+			unrestricted = true
+		}
+		refs = append(refs, mapping.Websites...)
+	}
+	if !matched {
+		role, ok := auth.ParseRole(cfg.DefaultRole)
+		if !ok {
+			return identity, errOIDCNoRole
+		}
+		identity.Role = role
+		refs = cfg.DefaultWebsites
+		unrestricted = len(refs) == 0
+	}
+	if unrestricted || identity.Role == auth.RoleAdmin {
+		identity.Websites = []string{}
+		return identity, nil
+	}
+	identity.Websites = config.ResolveWebsiteRefs(refs)
+	if len(identity.Websites) == 0 {
+		return identity, errors.New("映射的站点均不存在，拒绝登录")
+	}
+	return identity, nil
+}
+
+// claimStrings 把字符串、字符串数组或以空格 / 逗号分隔的声明值统一为字符串列表
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' })
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case bool:
+		return []string{fmt.Sprint(v)}
+	}
+	return nil
+}
+
+func containsValue(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func randomURLToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/web"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	oidcPathPrefix   = "/api/auth/oidc/"
+	oidcLoginPath    = oidcPathPrefix + "login"
+	oidcCallbackPath = oidcPathPrefix + "callback"
+	oidcLogoutPath   = oidcPathPrefix + "logout"
+
+	oidcStateCookie = "nginxpulse_oidc_state"
+	// oidcStateTTL 为从跳转 IdP 到回调完成的最长时间
+	oidcStateTTL = 10 * time.Minute
+	// oidcMaxPending 限制未完成登录的数量，避免被刷满内存
+	oidcMaxPending = 10000
+)
+
+// isOIDCPath 判断是否为单点登录接口，这些接口自行完成鉴权，不经过访问密钥校验。
+func isOIDCPath(path string) bool {
+	return strings.HasPrefix(path, oidcPathPrefix)
+}
+
+// oidcPending 为一次未完成的登录，回调时凭 state 取回 PKCE verifier 与 nonce
+type oidcPending struct {
+	verifier    string
+	nonce       string
+	redirectURL string
+	returnTo    string
+	expiresAt   time.Time
+}
+
+// oidcService 按当前配置缓存 IdP 客户端（配置热加载后重建），并保存未完成的登录状态。
+type oidcService struct {
+	repo   *store.Repository
+	client *http.Client
+
+	mu       sync.Mutex
+	cfgRoot  *config.Config
+	provider *oidcProvider
+	pending  map[string]oidcPending
+}
+
+func newOIDCService(repo *store.Repository) *oidcService {
+	return &oidcService{
+		repo:    repo,
+		client:  &http.Client{Timeout: oidcHTTPTimeout},
+		pending: make(map[string]oidcPending),
+	}
+}
+
+// currentProvider 返回当前配置对应的 IdP 客户端，发现文档读取失败时下次请求重试
+func (s *oidcService) currentProvider(ctx context.Context) (*oidcProvider, error) {
+	root := config.ReadConfig()
+	s.mu.Lock()
+	if s.cfgRoot == root && s.provider != nil {
+		provider := s.provider
+		s.mu.Unlock()
+		return provider, nil
+	}
+	s.mu.Unlock()
+
+	cfg := config.GetOIDCConfig()
+	if cfg == nil {
+		return nil, errOIDCDisabled
+	}
+	provider, err := newOIDCProvider(ctx, cfg, s.client)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.cfgRoot = root
+	s.provider = provider
+	s.mu.Unlock()
+	return provider, nil
+}
+
+func (s *oidcService) savePending(state string, pending oidcPending) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, item := range s.pending {
+		if now.After(item.expiresAt) {
+			delete(s.pending, key)
+		}
+	}
+	if len(s.pending) >= oidcMaxPending {
+		return false
+	}
+	s.pending[state] = pending
+	return true
+}
+
+// takePending 取出并删除登录状态，每个 state 只能使用一次
+func (s *oidcService) takePending(state string) (oidcPending, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, ok := s.pending[state]
+	if !ok {
+		return pending, false
+	}
+	delete(s.pending, state)
+	if time.Now().After(pending.expiresAt) {
+		return pending, false
+	}
+	return pending, true
+}
+
+// providerOrAbort 返回 IdP 客户端，未启用或不可用时写出响应
+func (s *oidcService) providerOrAbort(c *gin.Context) (*oidcProvider, bool) {
+	provider, err := s.currentProvider(c.Request.Context())
+	if errors.Is(err, errOIDCDisabled) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return nil, false
+	}
+	if err != nil {
+		logrus.WithError(err).Error("连接 OIDC 身份提供方失败")
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "连接身份提供方失败",
+		})
+		return nil, false
+	}
+	return provider, true
+}
+
+func setupOIDCRoutes(router *gin.Engine, repo *store.Repository) {
+	service := newOIDCService(repo)
+
+	// 跳转到 IdP 登录，redirect 为登录完成后返回的站内路径
+	router.GET(oidcLoginPath, func(c *gin.Context) {
+		if repo == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持单点登录",
+			})
+			return
+		}
+		provider, ok := service.providerOrAbort(c)
+		if !ok {
+			return
+		}
+		state, err1 := randomURLToken()
+		nonce, err2 := randomURLToken()
+		verifier, err3 := randomURLToken()
+		if err := errors.Join(err1, err2, err3); err != nil {
+			logrus.WithError(err).Error("生成登录状态失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "生成登录状态失败",
+			})
+			return
+		}
+		redirectURL := oidcCallbackURL(c, &provider.cfg)
+		if !service.savePending(state, oidcPending{
+			verifier:    verifier,
+			nonce:       nonce,
+			redirectURL: redirectURL,
+			returnTo:    sanitizeReturnPath(c.Query("redirect")),
+			expiresAt:   time.Now().Add(oidcStateTTL),
+		}) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "登录请求过多，请稍后重试",
+			})
+			return
+		}
+		// state 同时写入 Cookie，回调时校验，防止把他人的登录结果注入当前浏览器
+		setOIDCStateCookie(c, state, int(oidcStateTTL.Seconds()))
+		c.Redirect(http.StatusFound, provider.authCodeURL(state, nonce, verifier, redirectURL))
+	})
+
+	router.GET(oidcCallbackPath, func(c *gin.Context) {
+		if repo == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持单点登录",
+			})
+			return
+		}
+		if idpError := c.Query("error"); idpError != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "身份提供方拒绝登录: " + strings.TrimSpace(idpError+" "+c.Query("error_description")),
+			})
+			return
+		}
+		state := c.Query("state")
+		cookieState, _ := c.Cookie(oidcStateCookie)
+		setOIDCStateCookie(c, "", -1)
+		pending, ok := service.takePending(state)
+		if state == "" || cookieState != state || !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "登录状态无效或已过期，请重新登录",
+			})
+			return
+		}
+		provider, ok := service.providerOrAbort(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+		tokens, err := provider.exchange(ctx, c.Query("code"), pending.verifier, pending.redirectURL)
+		if err != nil {
+			logrus.WithError(err).Warn("OIDC 换取令牌失败")
+			recordOIDCLogin(c, repo, nil, err)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "单点登录失败: " + err.Error(),
+			})
+			return
+		}
+		claims, err := provider.verifyIDToken(ctx, tokens.IDToken, pending.nonce)
+		if err != nil {
+			logrus.WithError(err).Warn("OIDC id_token 校验失败")
+			recordOIDCLogin(c, repo, nil, err)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "单点登录失败: " + err.Error(),
+			})
+			return
+		}
+		mergeUserinfoClaims(ctx, provider, tokens.AccessToken, claims)
+
+		identity, err := mapOIDCIdentity(&provider.cfg, claims)
+		if err != nil {
+			logrus.WithField("sub", identity.Subject).WithError(err).Warn("OIDC 用户没有可用的角色")
+			recordOIDCLogin(c, repo, &auth.Principal{Kind: auth.PrincipalGuest, Username: identity.Username}, err)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		user, err := repo.UpsertExternalUser(store.User{
+			Username:   identity.Username,
+			Provider:   store.UserProviderOIDC,
+			ExternalID: identity.Subject,
+			Role:       string(identity.Role),
+			Websites:   identity.Websites,
+		})
+		if errors.Is(err, store.ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "用户名已被其他账号占用: " + identity.Username,
+			})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("保存单点登录用户失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "登录失败",
+			})
+			return
+		}
+		if user.Disabled {
+			recordOIDCLogin(c, repo, &auth.Principal{Kind: auth.PrincipalGuest, UserID: user.ID, Username: user.Username}, errors.New("账号已被禁用"))
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "账号已被禁用",
+			})
+			return
+		}
+		auth.SetUserAccountsEnabled(true)
+		if _, _, err := web.StartUserSession(c, repo, user); err != nil {
+			logrus.WithError(err).Error("创建登录会话失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "登录失败",
+			})
+			return
+		}
+		recordOIDCLogin(c, repo, &auth.Principal{Kind: auth.PrincipalSession, UserID: user.ID, Username: user.Username}, nil)
+		c.Redirect(http.StatusFound, pending.returnTo)
+	})
+
+	// 注销本地会话，IdP 支持时继续跳转到 IdP 注销
+	router.GET(oidcLogoutPath, func(c *gin.Context) {
+		web.EndUserSession(c, repo)
+		home := config.WebBasePathPrefix() + "/"
+		target := home
+		if provider, err := service.currentProvider(c.Request.Context()); err == nil {
+			postLogout := provider.cfg.PostLogoutRedirectURL
+			if postLogout == "" {
+				postLogout = externalBaseURL(c) + home
+			}
+			if endSession := provider.endSessionURL(postLogout); endSession != "" {
+				target = endSession
+			}
+		}
+		c.Redirect(http.StatusFound, target)
+	})
+}
+
+// recordOIDCLogin 记录单点登录结果，actor 为 nil 表示尚未识别出用户
+func recordOIDCLogin(c *gin.Context, repo *store.Repository, actor *auth.Principal, err error) {
+	if actor == nil {
+		actor = &auth.Principal{Kind: auth.PrincipalGuest}
+	}
+	params := gin.H{"provider": store.UserProviderOIDC}
+	if err != nil {
+		params["error"] = err.Error()
+	}
+	web.RecordAudit(c, repo, web.AuditEvent{
+		Action:  "auth.login",
+		Params:  params,
+		Success: err == nil,
+		Actor:   actor,
+	})
+}
+
+// mergeUserinfoClaims 在 id_token 缺少用户名或分组声明时用 userinfo 补齐（sub 必须一致）
+func mergeUserinfoClaims(ctx context.Context, provider *oidcProvider, accessToken string, claims map[string]any) {
+	_, hasGroups := claims[provider.cfg.GroupsClaim]
+	_, hasUsername := claims[provider.cfg.UsernameClaim]
+	if hasGroups && hasUsername {
+		return
+	}
+	extra, err := provider.userinfo(ctx, accessToken)
+	if err != nil {
+		logrus.WithError(err).Warn("读取 OIDC userinfo 失败")
+		return
+	}
+	if extra == nil || extra["sub"] != claims["sub"] {
+		return
+	}
+	for key, value := range extra {
+		if _, exists := claims[key]; !exists {
+			claims[key] = value
+		}
+	}
+}
+
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	// 回调来自 IdP 的顶层跳转，Lax 可以携带该 Cookie
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, web.SessionCookiePath(), "", web.IsSecureRequest(c), true)
+}
+
+// oidcCallbackURL 返回回调地址：优先使用配置，否则按请求地址与 webBasePath 推导
+func oidcCallbackURL(c *gin.Context, cfg *config.OIDCConfig) string {
+	if redirect := strings.TrimSpace(cfg.RedirectURL); redirect != "" {
+		return redirect
+	}
+	return externalBaseURL(c) + config.WebBasePathPrefix() + oidcCallbackPath
+}
+
+// externalBaseURL 按请求（含反向代理的 X-Forwarded-* 头）推导对外访问的 scheme://host
+func externalBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if web.IsSecureRequest(c) {
+		scheme = "https"
+	}
+	host := strings.TrimSpace(c.GetHeader("X-Forwarded-Host"))
+	if host == "" {
+		host = c.Request.Host
+	}
+	if comma := strings.Index(host, ","); comma >= 0 {
+		host = strings.TrimSpace(host[:comma])
+	}
+	return scheme + "://" + host
+}
+
+// sanitizeReturnPath 只接受站内路径，避免登录后被重定向到外部站点
+func sanitizeReturnPath(raw string) string {
+	home := config.WebBasePathPrefix() + "/"
+	raw = strings.TrimSpace(raw)
+	if raw == "" || !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") || strings.ContainsAny(raw, "\\\r\n") {
+		return home
+	}
+	return raw
+}
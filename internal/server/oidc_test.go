@@ -0,0 +1,428 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+// mockOIDCProvider 是本地的 OIDC 身份提供方：发现文档、JWKS、授权码换取令牌（校验 PKCE）与 userinfo。
+type mockOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]mockAuthorization
+	claims map[string]any
+	// userinfo 为 userinfo 接口额外返回的声明
+	userinfo map[string]any
+}
+
+type mockAuthorization struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	mock := &mockOIDCProvider{t: t, key: key, codes: make(map[string]mockAuthorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		base := mock.server.URL
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                 base,
+			"authorization_endpoint": base + "/authorize",
+			"token_endpoint":         base + "/token",
+			"userinfo_endpoint":      base + "/userinfo",
+			"jwks_uri":               base + "/jwks",
+			"end_session_endpoint":   base + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"keys": []map[string]any{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", mock.handleToken)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mock.mu.Lock()
+		defer mock.mu.Unlock()
+		writeJSON(w, http.StatusOK, mock.userinfo)
+	})
+	mock.server = httptest.NewServer(mux)
+	t.Cleanup(mock.server.Close)
+	return mock
+}
+
+// authorize 模拟用户在 IdP 完成登录：解析授权地址并签发授权码
+func (m *mockOIDCProvider) authorize(authURL string, claims map[string]any) string {
+	m.t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatalf("parse auth url: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("response_type") != "code" {
+		m.t.Fatalf("unexpected auth request: %s", authURL)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := "code-" + query.Get("state")
+	m.codes[code] = mockAuthorization{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	m.claims = claims
+	return code
+}
+
+func (m *mockOIDCProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || id != "nginxpulse" || secret != "s3cret" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	m.mu.Lock()
+	authz, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	claims := m.claims
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || authz.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != authz.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	payload := map[string]any{
+		"iss":   m.server.URL,
+		"aud":   "nginxpulse",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": authz.nonce,
+	}
+	for key, value := range claims {
+		payload[key] = value
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"id_token":     m.sign(payload),
+	})
+}
+
+func (m *mockOIDCProvider) sign(payload map[string]any) string {
+	m.t.Helper()
+	return m.signWithHeader(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"}, payload)
+}
+
+func (m *mockOIDCProvider) signWithHeader(headerFields map[string]string, payload map[string]any) string {
+	m.t.Helper()
+	header, _ := json.Marshal(headerFields)
+	body, _ := json.Marshal(payload)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatalf("sign: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func testOIDCConfig(issuer string) *config.OIDCConfig {
+	return &config.OIDCConfig{
+		Enabled:       true,
+		Issuer:        issuer,
+		ClientID:      "nginxpulse",
+		ClientSecret:  "s3cret",
+		Scopes:        []string{"openid", "profile", "groups"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		RoleMappings: []config.OIDCRoleMapping{
+			{Claim: "groups", Value: "ops", Role: "admin"},
+			{Claim: "groups", Value: "analysts", Role: "viewer"},
+			{Claim: "department", Value: "marketing", Role: "editor"},
+		},
+	}
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	ctx := context.Background()
+	provider, err := newOIDCProvider(ctx, testOIDCConfig(mock.server.URL), mock.server.Client())
+	if err != nil {
+		t.Fatalf("newOIDCProvider: %v", err)
+	}
+
+	const redirectURL = "https://pulse.example.com/base/api/auth/oidc/callback"
+	verifier, _ := randomURLToken()
+	authURL := provider.authCodeURL("state-1", "nonce-1", verifier, redirectURL)
+	if !strings.HasPrefix(authURL, mock.server.URL+"/authorize?") || !strings.Contains(authURL, "scope=openid+profile+groups") {
+		t.Fatalf("unexpected auth url: %s", authURL)
+	}
+	code := mock.authorize(authURL, map[string]any{
+		"sub":                "user-1",
+		"preferred_username": "alice",
+		"groups":             []any{"analysts", "ops"},
+	})
+
+	tokens, err := provider.exchange(ctx, code, verifier, redirectURL)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	claims, err := provider.verifyIDToken(ctx, tokens.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("verifyIDToken: %v", err)
+	}
+	identity, err := mapOIDCIdentity(&provider.cfg, claims)
+	if err != nil {
+		t.Fatalf("mapOIDCIdentity: %v", err)
+	}
+	if identity.Subject != "user-1" || identity.Username != "alice" || identity.Role != auth.RoleAdmin || len(identity.Websites) != 0 {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+
+	// 授权码只能使用一次
+	if _, err := provider.exchange(ctx, code, verifier, redirectURL); err == nil {
+		t.Fatal("expected reused code to fail")
+	}
+	if logout := provider.endSessionURL("https://pulse.example.com/base/"); !strings.HasPrefix(logout, mock.server.URL+"/logout?") ||
+		!strings.Contains(logout, "post_logout_redirect_uri=https%3A%2F%2Fpulse.example.com%2Fbase%2F") {
+		t.Fatalf("unexpected logout url: %s", logout)
+	}
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	ctx := context.Background()
+	provider, err := newOIDCProvider(ctx, testOIDCConfig(mock.server.URL), mock.server.Client())
+	if err != nil {
+		t.Fatalf("newOIDCProvider: %v", err)
+	}
+	const redirectURL = "http://localhost/api/auth/oidc/callback"
+
+	verifier, _ := randomURLToken()
+	code := mock.authorize(provider.authCodeURL("state-2", "nonce-2", verifier, redirectURL), map[string]any{"sub": "user-2"})
+	if _, err := provider.exchange(ctx, code, verifier+"x", redirectURL); err == nil {
+		t.Fatal("expected PKCE verifier mismatch to fail")
+	}
+
+	code = mock.authorize(provider.authCodeURL("state-3", "nonce-3", verifier, redirectURL), map[string]any{"sub": "user-2"})
+	tokens, err := provider.exchange(ctx, code, verifier, redirectURL)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if _, err := provider.verifyIDToken(ctx, tokens.IDToken, "other-nonce"); err == nil {
+		t.Fatal("expected nonce mismatch to fail")
+	}
+	parts := strings.Split(tokens.IDToken, ".")
+	forged, _ := json.Marshal(map[string]any{"iss": mock.server.URL, "aud": "nginxpulse", "sub": "admin", "nonce": "nonce-3", "exp": time.Now().Add(time.Hour).Unix()})
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2]
+	if _, err := provider.verifyIDToken(ctx, tampered, "nonce-3"); err == nil {
+		t.Fatal("expected tampered token to fail")
+	}
+
+	expired := mock.sign(map[string]any{"iss": mock.server.URL, "aud": "nginxpulse", "sub": "user-2", "nonce": "n", "exp": time.Now().Add(-time.Hour).Unix()})
+	if _, err := provider.verifyIDToken(ctx, expired, "n"); err == nil {
+		t.Fatal("expected expired token to fail")
+	}
+	otherAudience := mock.sign(map[string]any{"iss": mock.server.URL, "aud": "someone-else", "sub": "user-2", "nonce": "n", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := provider.verifyIDToken(ctx, otherAudience, "n"); err == nil {
+		t.Fatal("expected audience mismatch to fail")
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	if _, err := provider.verifyIDToken(ctx, unsigned, "nonce-3"); err == nil {
+		t.Fatal("expected alg none to fail")
+	}
+	valid := map[string]any{"iss": mock.server.URL, "aud": "nginxpulse", "sub": "user-2", "nonce": "n", "exp": time.Now().Add(time.Hour).Unix()}
+	unknownKid := mock.signWithHeader(map[string]string{"alg": "RS256", "kid": "rotated-away"}, valid)
+	if _, err := provider.verifyIDToken(ctx, unknownKid, "n"); err == nil {
+		t.Fatal("expected unknown kid to fail")
+	}
+	// 签名本身有效，但头部声明的算法与 RSA 密钥不符
+	wrongAlg := mock.signWithHeader(map[string]string{"alg": "ES256", "kid": "test-key"}, valid)
+	if _, err := provider.verifyIDToken(ctx, wrongAlg, "n"); err == nil {
+		t.Fatal("expected alg/key type mismatch to fail")
+	}
+}
+
+func TestVerifyJWTSignatureRejectsMismatches(t *testing.T) {
+	const input = "header.payload"
+	digest256 := sha256.Sum256([]byte(input))
+	digest384 := sha512.Sum384([]byte(input))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest256[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	psSig, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esSig := func(key *ecdsa.PrivateKey, digest []byte) []byte {
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig
+	}
+	es256 := esSig(p256, digest256[:])
+	derSig, err := ecdsa.SignASN1(rand.Reader, p256, digest256[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		alg       string
+		key       crypto.PublicKey
+		signature []byte
+		ok        bool
+	}{
+		"RS256":                 {"RS256", &rsaKey.PublicKey, rsSig, true},
+		"PS256":                 {"PS256", &rsaKey.PublicKey, psSig, true},
+		"ES256":                 {"ES256", &p256.PublicKey, es256, true},
+		"ES384":                 {"ES384", &p384.PublicKey, esSig(p384, digest384[:]), true},
+		"none":                  {"none", &rsaKey.PublicKey, nil, false},
+		"HS256 with RSA key":    {"HS256", &rsaKey.PublicKey, rsSig, false},
+		"RS256 with EC key":     {"RS256", &p256.PublicKey, es256, false},
+		"ES256 with RSA key":    {"ES256", &rsaKey.PublicKey, rsSig, false},
+		"PS256 with PKCS1 sig":  {"PS256", &rsaKey.PublicKey, rsSig, false},
+		"ES256 with P-384 key":  {"ES256", &p384.PublicKey, esSig(p384, digest256[:]), false},
+		"ES256 short signature": {"ES256", &p256.PublicKey, es256[:len(es256)-1], false},
+		"ES256 ASN.1 signature": {"ES256", &p256.PublicKey, derSig, false},
+		"ES384 with P-256 key":  {"ES384", &p256.PublicKey, es256, false},
+	} {
+		err := verifyJWTSignature(tc.alg, tc.key, input, tc.signature)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: ok=%v, err=%v", name, tc.ok, err)
+		}
+	}
+}
+
+func TestJSONWebKeyRejectsInvalidKeys(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	valid := jsonWebKey{Kty: "EC", Crv: "P-256", X: encode(p256.X), Y: encode(p256.Y)}
+	if _, err := valid.publicKey(); err != nil {
+		t.Fatalf("valid EC key: %v", err)
+	}
+	for name, jwk := range map[string]jsonWebKey{
+		"symmetric":       {Kty: "oct"},
+		"unknown curve":   {Kty: "EC", Crv: "secp256k1", X: valid.X, Y: valid.Y},
+		"point off curve": {Kty: "EC", Crv: "P-256", X: valid.X, Y: encode(new(big.Int).Add(p256.Y, big.NewInt(1)))},
+		"wrong curve":     {Kty: "EC", Crv: "P-384", X: valid.X, Y: valid.Y},
+		"RSA exponent 1":  {Kty: "RSA", N: encode(p256.X), E: encode(big.NewInt(1))},
+	} {
+		if _, err := jwk.publicKey(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMapOIDCIdentity(t *testing.T) {
+	cfg := testOIDCConfig("https://idp.example.com")
+
+	identity, err := mapOIDCIdentity(cfg, map[string]any{"sub": "u", "email": "bob@example.com", "department": "marketing", "groups": "analysts"})
+	if err != nil {
+		t.Fatalf("mapOIDCIdentity: %v", err)
+	}
+	if identity.Role != auth.RoleEditor || identity.Username != "bob@example.com" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+
+	if _, err := mapOIDCIdentity(cfg, map[string]any{"sub": "u", "groups": []any{"guests"}}); !errors.Is(err, errOIDCNoRole) {
+		t.Fatalf("expected errOIDCNoRole, got %v", err)
+	}
+
+	cfg.DefaultRole = "viewer"
+	identity, err = mapOIDCIdentity(cfg, map[string]any{"sub": "u"})
+	if err != nil || identity.Role != auth.RoleViewer || identity.Username != "u" {
+		t.Fatalf("unexpected default identity: %+v, %v", identity, err)
+	}
+}
+
+func TestMergeUserinfoClaims(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	mock.userinfo = map[string]any{"sub": "user-3", "groups": []any{"ops"}, "preferred_username": "carol"}
+	provider, err := newOIDCProvider(context.Background(), testOIDCConfig(mock.server.URL), mock.server.Client())
+	if err != nil {
+		t.Fatalf("newOIDCProvider: %v", err)
+	}
+	claims := map[string]any{"sub": "user-3"}
+	mergeUserinfoClaims(context.Background(), provider, "access-token", claims)
+	if claims["preferred_username"] != "carol" || claims["groups"] == nil {
+		t.Fatalf("userinfo claims not merged: %v", claims)
+	}
+
+	mock.userinfo = map[string]any{"sub": "someone-else", "groups": []any{"ops"}}
+	claims = map[string]any{"sub": "user-3"}
+	mergeUserinfoClaims(context.Background(), provider, "access-token", claims)
+	if claims["groups"] != nil {
+		t.Fatalf("userinfo with different sub must be ignored: %v", claims)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/web"
+)
+
+const (
+	// rateBucketIdle 为桶的空闲回收时间，超过后视为已回满
+	rateBucketIdle = 10 * time.Minute
+	// rateSweepInterval 为清理空闲桶的最小间隔
+	rateSweepInterval = time.Minute
+)
+
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter 为按 key 计数的令牌桶，每分钟补充 perMinute 个令牌，容量为 burst。
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*rateBucket)}
+}
+
+// allow 消耗一个令牌；不足时返回 false 与建议的重试等待时间。perMinute <= 0 表示不限制。
+func (l *rateLimiter) allow(key string, perMinute, burst int, now time.Time) (bool, time.Duration) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+	capacity := float64(burst)
+	if burst <= 0 {
+		capacity = float64(perMinute)
+	}
+	ratePerSecond := float64(perMinute) / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepLocked(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateBucket{tokens: capacity, updated: now}
+		l.buckets[key] = bucket
+	} else {
+		elapsed := now.Sub(bucket.updated).Seconds()
+		if elapsed > 0 {
+			bucket.tokens = math.Min(capacity, bucket.tokens+elapsed*ratePerSecond)
+			bucket.updated = now
+		}
+		// 配置热加载后容量可能变小
+		bucket.tokens = math.Min(bucket.tokens, capacity)
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / ratePerSecond * float64(time.Second))
+	return false, wait
+}
+
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < rateSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) > rateBucketIdle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// abortRateLimited 写出 429，Retry-After 向上取整到秒
+func abortRateLimited(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error": "请求过于频繁，请稍后重试",
+	})
+}
+
+// ipRateLimitMiddleware 按客户端 IP 限流（system.rateLimit.ipPerMinute），在鉴权之前执行，
+// 无效凭据的请求同样计入。agent 推送接口按批次上报，不参与 IP 限流。
+func ipRateLimitMiddleware(limiter *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !strings.HasPrefix(path, "/api/") || web.IsAgentIngestPath(path) {
+			c.Next()
+			return
+		}
+		limits := config.GetRateLimitConfig()
+		if ok, wait := limiter.allow("ip:"+c.ClientIP(), limits.IPPerMinute, limits.Burst, time.Now()); !ok {
+			abortRateLimited(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterRefillsPerMinute(t *testing.T) {
+	limiter := newRateLimiter()
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("k", 60, 3, now); !ok {
+			t.Fatalf("request %d should pass within burst", i)
+		}
+	}
+	ok, wait := limiter.allow("k", 60, 3, now)
+	if ok {
+		t.Fatal("request beyond burst should be limited")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("unexpected retry wait %v", wait)
+	}
+	if ok, _ := limiter.allow("other", 60, 3, now); !ok {
+		t.Fatal("keys must be limited independently")
+	}
+	if ok, _ := limiter.allow("k", 60, 3, now.Add(time.Second)); !ok {
+		t.Fatal("one token should be refilled after a second")
+	}
+	if ok, _ := limiter.allow("k", 0, 0, now); !ok {
+		t.Fatal("zero limit means unlimited")
+	}
+}
+
+func TestTrustedProxiesDefaultIgnoresForwardedFor(t *testing.T) {
+	clientIP := func(proxies []string) string {
+		router := gin.New()
+		if err := configureTrustedProxies(router, proxies); err != nil {
+			t.Fatal(err)
+		}
+		router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = "10.0.0.5:4321"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	if got := clientIP(nil); got != "10.0.0.5" {
+		t.Fatalf("X-Forwarded-For must be ignored without trusted proxies, got %q", got)
+	}
+	if got := clientIP([]string{" 10.0.0.0/8 "}); got != "203.0.113.9" {
+		t.Fatalf("X-Forwarded-For from a trusted proxy should be used, got %q", got)
+	}
+	if got := clientIP([]string{"192.168.0.1"}); got != "10.0.0.5" {
+		t.Fatalf("X-Forwarded-For from an untrusted peer must be ignored, got %q", got)
+	}
+}
+
+func TestAPITokenUsageIsBatched(t *testing.T) {
+	tokens := &apiTokenAuth{usage: make(map[int64]*apiTokenUsage)}
+	now := time.Unix(1700000000, 0)
+
+	if uses, ip, flush := tokens.recordUse(1, "1.1.1.1", now); !flush || uses != 1 || ip != "1.1.1.1" {
+		t.Fatalf("first use should be written immediately, got %d %q %v", uses, ip, flush)
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, flush := tokens.recordUse(1, "2.2.2.2", now.Add(time.Second)); flush {
+			t.Fatal("uses within the interval should be batched")
+		}
+	}
+	tokens.restoreUse(1, 2)
+	uses, ip, flush := tokens.recordUse(1, "3.3.3.3", now.Add(apiTokenUsageInterval))
+	if !flush || uses != 6 || ip != "3.3.3.3" {
+		t.Fatalf("expected 6 batched uses from 3.3.3.3, got %d %q %v", uses, ip, flush)
+	}
+}
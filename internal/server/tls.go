@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/tlsutil"
+	"github.com/likaia/nginxpulse/internal/web"
+)
+
+// legacyIngestPath 为旧版 agent 的推送接口
+const legacyIngestPath = "/api/ingest/logs"
+
+// buildTLSConfig 根据 server.tls 构建 tls.Config。证书与客户端 CA 在握手时按文件修改时间热加载。
+// 配置 clientCaFile 时只校验客户端主动提供的证书，是否必须提供由 requireClientCertMiddleware 按路径判断，
+// 浏览器访问界面不受影响。
+func buildTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	certs, err := tlsutil.NewCertReloader(strings.TrimSpace(cfg.CertFile), strings.TrimSpace(cfg.KeyFile))
+	if err != nil {
+		return nil, err
+	}
+	minVersion, _ := config.TLSMinVersion(cfg.MinVersion)
+	base := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: certs.GetCertificate,
+	}
+	if cfg.DisableHTTP2 {
+		base.NextProtos = []string{"http/1.1"}
+	} else {
+		base.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	caFile := strings.TrimSpace(cfg.ClientCAFile)
+	if caFile == "" {
+		return base, nil
+	}
+	clientCAs, err := tlsutil.NewCAReloader(caFile)
+	if err != nil {
+		return nil, err
+	}
+	base.ClientAuth = tls.VerifyClientCertIfGiven
+	base.ClientCAs = clientCAs.Pool()
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			next := base.Clone()
+			next.ClientCAs = clientCAs.Pool()
+			return next, nil
+		},
+		MinVersion:     base.MinVersion,
+		NextProtos:     base.NextProtos,
+		GetCertificate: base.GetCertificate,
+	}, nil
+}
+
+// requireClientCertMiddleware 在启用 mTLS 时要求 agent 推送接口携带已校验的客户端证书。
+func requireClientCertMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !web.IsAgentIngestPath(path) && path != legacyIngestPath {
+			c.Next()
+			return
+		}
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "推送接口需要有效的客户端证书",
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -34,6 +34,18 @@ type NginxLogRecord struct {
 	UserDevice       string    `json:"user_device"`
 	DomesticLocation string    `json:"domestic_location"`
 	GlobalLocation   string    `json:"global_location"`
+	// Campaign 为从 URL 查询参数解析出的投放信息，写入 dim_campaign 维表。
+	Campaign Campaign `json:"-"`
+	// SampleWeight 是 agent 采样后的还原倍数（采样率 0.1 对应 10），写入日志表 sample_weight 列；<=1 表示未采样。
+	SampleWeight int `json:"-"`
+}
+
+// weight 返回该条日志代表的原始请求数，未采样时为 1。
+func (log NginxLogRecord) weight() int64 {
+	if log.SampleWeight > 1 {
+		return int64(log.SampleWeight)
+	}
+	return 1
 }
 
 type IPGeoAPIFailure struct {
@@ -101,6 +113,7 @@ func sanitizeLogRecord(log NginxLogRecord) NginxLogRecord {
 	log.UserDevice = sanitizeAndTruncate(log.UserDevice, maxUABytes)
 	log.DomesticLocation = sanitizeUTF8(log.DomesticLocation)
 	log.GlobalLocation = sanitizeUTF8(log.GlobalLocation)
+	log.Campaign = sanitizeCampaign(log.Campaign)
 	return log
 }
 
@@ -170,6 +183,11 @@ func (r *Repository) Init() error {
 	return r.createTables()
 }
 
+// EnsureWebsiteSchema 为站点创建日志表、维度表与聚合表（已存在时补齐缺失的聚合表），用于热加载新增站点
+func (r *Repository) EnsureWebsiteSchema(websiteID string) error {
+	return r.ensureWebsiteSchema(websiteID)
+}
+
 // 关闭数据库连接
 func (r *Repository) Close() error {
 	logrus.Info("关闭数据库")
@@ -1287,12 +1305,27 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 		return err
 	}
 	defer sessions.Close()
+	campaigns, err := prepareCampaignStatements(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer campaigns.Close()
+	errorAggStmt, err := prepareErrorAggStatement(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer errorAggStmt.Close()
+	urlTrafficStmt, err := prepareURLTrafficAggStatement(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer urlTrafficStmt.Close()
 
 	stmtNginx, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         INSERT INTO "%s" (
         ip_id, pageview_flag, timestamp, method, url_id, 
-        status_code, bytes_sent, referer_id, ua_id, location_id)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        status_code, bytes_sent, referer_id, ua_id, location_id, campaign_id, sample_weight)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `, logTable)))
 	if err != nil {
 		return err
@@ -1301,6 +1334,8 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 
 	cache := newDimCaches()
 	aggBatch := newAggBatch()
+	errorAgg := make(errorAggBatch)
+	urlTraffic := make(urlTrafficBatch)
 	sessionCache := make(map[string]sessionState)
 	// 会话聚合：在事务内先累加，提交前收敛落库，避免每条新会话都去争抢同一天聚合行。
 	sessionAggDaily := make(map[string]int64)
@@ -1355,9 +1390,14 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 			return err
 		}
 
+		campaignID, err := campaigns.campaignID(cache.campaign, log.Campaign)
+		if err != nil {
+			return err
+		}
+
 		_, err = stmtNginx.Exec(
 			ipID, log.PageviewFlag, log.Timestamp.Unix(), log.Method, urlID,
-			log.Status, log.BytesSent, refererID, uaID, locationID,
+			log.Status, log.BytesSent, refererID, uaID, locationID, campaignID, log.weight(),
 		)
 		if err != nil {
 			return err
@@ -1387,6 +1427,8 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 		}
 
 		aggBatch.add(log, ipID)
+		errorAgg.add(log, urlID, refererID)
+		urlTraffic.add(log, urlID)
 	}
 
 	// 统一顺序写入 first_seen：按 ip_id 升序，避免不同事务对同一批 key 的锁顺序不一致。
@@ -1409,6 +1451,12 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 	if err := applyAggUpdates(aggs, aggBatch); err != nil {
 		return err
 	}
+	if err := applyErrorAggUpdates(errorAggStmt, errorAgg); err != nil {
+		return err
+	}
+	if err := applyURLTrafficAggUpdates(urlTrafficStmt, urlTraffic); err != nil {
+		return err
+	}
 
 	// 在提交前的收敛阶段一次性写入会话聚合，并在每个 day 上使用 advisory lock 将并发写串行化（避免死锁）。
 	if err := applySessionAggUpdatesWithLocks(sessions, sessionAggDaily, sessionAggEntry); err != nil {
@@ -1503,6 +1551,17 @@ func (r *Repository) CleanOldLogs() error {
 		logrus.Infof("删除了 %d 条 %d 天前的日志记录", deletedCount, retentionDays)
 	}
 
+	if _, err := r.DeleteTrafficAnomaliesBefore(cutoffTime); err != nil {
+		logrus.WithError(err).Warn("清理过期流量异常记录失败")
+	}
+
+	auditCutoff := time.Now().AddDate(0, 0, -config.GetAuditRetentionDays())
+	if deleted, err := r.DeleteAuditEntriesBefore(auditCutoff); err != nil {
+		logrus.WithError(err).Warn("清理过期审计日志失败")
+	} else if deleted > 0 {
+		logrus.Infof("已清理 %d 条过期审计日志", deleted)
+	}
+
 	return nil
 }
 
@@ -1527,6 +1586,9 @@ func (r *Repository) ClearLogsForWebsite(websiteID string) error {
 	if err := r.clearSessionAggTablesForWebsite(websiteID); err != nil {
 		return fmt.Errorf("清空网站会话聚合表失败: %w", err)
 	}
+	if err := r.clearTrafficAnomaliesForWebsite(websiteID); err != nil {
+		return fmt.Errorf("清空网站流量异常记录失败: %w", err)
+	}
 	return nil
 }
 
@@ -1553,6 +1615,30 @@ func (r *Repository) createTables() error {
 	if err := r.ensureSystemNotificationTable(); err != nil {
 		return err
 	}
+	if err := r.ensureAgentIngestCursorTable(); err != nil {
+		return err
+	}
+	if err := r.ensureAgentRegistryTable(); err != nil {
+		return err
+	}
+	if err := r.ensureSavedViewTables(); err != nil {
+		return err
+	}
+	if err := r.ensureTrafficAnomalyTable(); err != nil {
+		return err
+	}
+	if err := r.ensureReportRunTable(); err != nil {
+		return err
+	}
+	if err := r.ensureUserTables(); err != nil {
+		return err
+	}
+	if err := r.ensureAuditTable(); err != nil {
+		return err
+	}
+	if err := r.ensureAPITokenTable(); err != nil {
+		return err
+	}
 	for _, id := range config.GetAllWebsiteIDs() {
 		if err := r.ensureWebsiteSchema(id); err != nil {
 			return err
@@ -1975,6 +2061,7 @@ type dimCaches struct {
 	referer  map[string]int64
 	ua       map[string]int64
 	location map[string]int64
+	campaign map[string]int64
 }
 
 type aggStatements struct {
@@ -2039,6 +2126,7 @@ func newDimCaches() dimCaches {
 		referer:  make(map[string]int64),
 		ua:       make(map[string]int64),
 		location: make(map[string]int64),
+		campaign: make(map[string]int64),
 	}
 }
 
@@ -2822,21 +2910,22 @@ func addCounts(counts *aggCounts, log NginxLogRecord) {
 	if counts == nil {
 		return
 	}
+	weight := log.weight()
 	if log.PageviewFlag == 1 {
-		counts.pv++
-		counts.traffic += int64(log.BytesSent)
+		counts.pv += weight
+		counts.traffic += int64(log.BytesSent) * weight
 	}
 	switch {
 	case log.Status >= 200 && log.Status < 300:
-		counts.s2xx++
+		counts.s2xx += weight
 	case log.Status >= 300 && log.Status < 400:
-		counts.s3xx++
+		counts.s3xx += weight
 	case log.Status >= 400 && log.Status < 500:
-		counts.s4xx++
+		counts.s4xx += weight
 	case log.Status >= 500 && log.Status < 600:
-		counts.s5xx++
+		counts.s5xx += weight
 	default:
-		counts.other++
+		counts.other += weight
 	}
 }
 
@@ -2965,6 +3054,7 @@ func (r *Repository) cleanupOrphanDims(websiteID string) error {
 		{table: fmt.Sprintf("%s_dim_referer", websiteID), column: "referer_id"},
 		{table: fmt.Sprintf("%s_dim_ua", websiteID), column: "ua_id"},
 		{table: fmt.Sprintf("%s_dim_location", websiteID), column: "location_id"},
+		{table: fmt.Sprintf("%s_dim_campaign", websiteID), column: "campaign_id"},
 	}
 
 	for _, dim := range dims {
@@ -2992,6 +3082,7 @@ func (r *Repository) clearDimTablesForWebsite(websiteID string) error {
 		fmt.Sprintf("%s_dim_referer", websiteID),
 		fmt.Sprintf("%s_dim_ua", websiteID),
 		fmt.Sprintf("%s_dim_location", websiteID),
+		fmt.Sprintf("%s_dim_campaign", websiteID),
 	}
 	for _, table := range dimTables {
 		exists, err := r.tableExists(table)
@@ -3092,6 +3183,12 @@ func (r *Repository) ensureWebsiteSchema(websiteID string) error {
 		if err := createSessionAggTables(r.db, websiteID); err != nil {
 			return err
 		}
+		if err := createErrorAggTable(r.db, websiteID); err != nil {
+			return err
+		}
+		if err := createURLTrafficAggTable(r.db, websiteID); err != nil {
+			return err
+		}
 		if err := r.backfillAggregatesIfEmpty(websiteID); err != nil {
 			return err
 		}
@@ -3116,6 +3213,12 @@ func (r *Repository) ensureWebsiteSchema(websiteID string) error {
 	if err := createDimTables(r.db, websiteID); err != nil {
 		return err
 	}
+	if err := ensureLogCampaignColumn(r.db, websiteID); err != nil {
+		return err
+	}
+	if err := ensureLogSampleWeightColumn(r.db, websiteID); err != nil {
+		return err
+	}
 	if err := createLogIndexes(r.db, websiteID); err != nil {
 		return err
 	}
@@ -3131,6 +3234,12 @@ func (r *Repository) ensureWebsiteSchema(websiteID string) error {
 	if err := createSessionAggTables(r.db, websiteID); err != nil {
 		return err
 	}
+	if err := r.ensureErrorAggregates(websiteID); err != nil {
+		return err
+	}
+	if err := r.ensureURLTrafficAggregates(websiteID); err != nil {
+		return err
+	}
 	if err := r.backfillAggregatesIfEmpty(websiteID); err != nil {
 		return err
 	}
@@ -3180,6 +3289,12 @@ func (r *Repository) migrateLegacyLogs(websiteID string) error {
 	if err := createSessionAggTables(tx, websiteID); err != nil {
 		return err
 	}
+	if err := createErrorAggTable(tx, websiteID); err != nil {
+		return err
+	}
+	if err := createURLTrafficAggTable(tx, websiteID); err != nil {
+		return err
+	}
 
 	if _, err = tx.Exec(fmt.Sprintf(
 		`INSERT INTO "%s_dim_ip"(ip) SELECT DISTINCT ip FROM "%s" ON CONFLICT DO NOTHING`,
@@ -3256,6 +3371,12 @@ func (r *Repository) migrateLegacyLogs(websiteID string) error {
 	if err := r.backfillAggregates(websiteID); err != nil {
 		return err
 	}
+	if err := r.backfillErrorAggregates(websiteID); err != nil {
+		return err
+	}
+	if err := r.backfillURLTrafficAggregates(websiteID); err != nil {
+		return err
+	}
 	if err := r.backfillFirstSeen(websiteID); err != nil {
 		return err
 	}
@@ -3370,7 +3491,7 @@ func createDimTables(execer sqlExecer, websiteID string) error {
 			return err
 		}
 	}
-	return nil
+	return createCampaignDimTable(execer, websiteID)
 }
 
 func createLogTable(execer sqlExecer, tableName string) error {
@@ -3387,6 +3508,8 @@ func createLogTable(execer sqlExecer, tableName string) error {
             referer_id BIGINT NOT NULL,
             ua_id BIGINT NOT NULL,
             location_id BIGINT NOT NULL,
+            campaign_id BIGINT NOT NULL DEFAULT 0,
+            sample_weight INT NOT NULL DEFAULT 1,
             PRIMARY KEY (id, timestamp)
         ) PARTITION BY RANGE (timestamp)`, tableName,
 	)
@@ -3402,6 +3525,15 @@ func createLogTable(execer sqlExecer, tableName string) error {
 	return err
 }
 
+// ensureLogSampleWeightColumn 为旧版日志表补充 sample_weight 列（agent 采样还原倍数，未采样为 1）。
+func ensureLogSampleWeightColumn(execer sqlExecer, websiteID string) error {
+	_, err := execer.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s_nginx_logs" ADD COLUMN IF NOT EXISTS sample_weight INT NOT NULL DEFAULT 1`,
+		websiteID,
+	))
+	return err
+}
+
 func createLogIndexes(execer sqlExecer, websiteID string) error {
 	tableName := fmt.Sprintf("%s_nginx_logs", websiteID)
 	stmts := []string{
@@ -3677,13 +3809,13 @@ func (r *Repository) backfillAggregates(websiteID string) error {
 		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
              (timestamp / 3600) * 3600 AS bucket,
-             SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
-             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END) AS traffic,
-             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 ELSE 0 END) AS s2xx,
-             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
-             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
-             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN pageview_flag = 1 THEN sample_weight ELSE 0 END) AS pv,
+             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_weight ELSE 0 END) AS traffic,
+             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_weight ELSE 0 END) AS s2xx,
+             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_weight ELSE 0 END) AS s3xx,
+             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_weight ELSE 0 END) AS s4xx,
+             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_weight ELSE 0 END) AS s5xx,
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_weight ELSE 0 END) AS other
          FROM "%s"
          GROUP BY bucket`, aggHourly, logTable,
 	)); err != nil {
@@ -3707,13 +3839,13 @@ func (r *Repository) backfillAggregates(websiteID string) error {
 		`INSERT INTO "%s" (day, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
              date(to_timestamp(timestamp)) AS day,
-             SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
-             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END) AS traffic,
-             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 ELSE 0 END) AS s2xx,
-             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
-             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
-             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN pageview_flag = 1 THEN sample_weight ELSE 0 END) AS pv,
+             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_weight ELSE 0 END) AS traffic,
+             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_weight ELSE 0 END) AS s2xx,
+             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_weight ELSE 0 END) AS s3xx,
+             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_weight ELSE 0 END) AS s4xx,
+             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_weight ELSE 0 END) AS s5xx,
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_weight ELSE 0 END) AS other
          FROM "%s"
          GROUP BY day`, aggDaily, logTable,
 	)); err != nil {
@@ -3974,6 +4106,12 @@ func (r *Repository) cleanupAggregates(websiteID string, cutoff time.Time) error
 	if err := r.rebuildDailyAggregate(websiteID, cutoffDay); err != nil {
 		return err
 	}
+	if err := r.cleanupErrorAggregates(websiteID, cutoff); err != nil {
+		return err
+	}
+	if err := r.cleanupURLTrafficAggregates(websiteID, cutoff); err != nil {
+		return err
+	}
 	return r.rebuildFirstSeen(websiteID)
 }
 
@@ -4149,13 +4287,13 @@ func (r *Repository) rebuildHourlyAggregate(websiteID string, bucket int64) erro
 		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
              (timestamp / 3600) * 3600 AS bucket,
-             SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
-             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END) AS traffic,
-             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 ELSE 0 END) AS s2xx,
-             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
-             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
-             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN pageview_flag = 1 THEN sample_weight ELSE 0 END) AS pv,
+             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_weight ELSE 0 END) AS traffic,
+             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_weight ELSE 0 END) AS s2xx,
+             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_weight ELSE 0 END) AS s3xx,
+             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_weight ELSE 0 END) AS s4xx,
+             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_weight ELSE 0 END) AS s5xx,
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_weight ELSE 0 END) AS other
          FROM "%s"
          WHERE timestamp >= ? AND timestamp < ?
          GROUP BY bucket`, aggHourly, logTable,
@@ -4217,13 +4355,13 @@ func (r *Repository) rebuildDailyAggregate(websiteID string, day string) error {
 		`INSERT INTO "%s" (day, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
              date(to_timestamp(timestamp)) AS day,
-             SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
-             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END) AS traffic,
-             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 ELSE 0 END) AS s2xx,
-             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
-             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
-             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN pageview_flag = 1 THEN sample_weight ELSE 0 END) AS pv,
+             SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent * sample_weight ELSE 0 END) AS traffic,
+             SUM(CASE WHEN status_code >= 200 AND status_code < 300 THEN sample_weight ELSE 0 END) AS s2xx,
+             SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN sample_weight ELSE 0 END) AS s3xx,
+             SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN sample_weight ELSE 0 END) AS s4xx,
+             SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN sample_weight ELSE 0 END) AS s5xx,
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN sample_weight ELSE 0 END) AS other
          FROM "%s"
          WHERE timestamp >= ? AND timestamp < ?
          GROUP BY day`, aggDaily, logTable,
@@ -4253,6 +4391,8 @@ func (r *Repository) clearAggregateTablesForWebsite(websiteID string) error {
 		fmt.Sprintf("%s_agg_hourly_ip", websiteID),
 		fmt.Sprintf("%s_agg_daily", websiteID),
 		fmt.Sprintf("%s_agg_daily_ip", websiteID),
+		fmt.Sprintf("%s_agg_error_daily", websiteID),
+		fmt.Sprintf("%s_agg_url_daily", websiteID),
 	}
 	for _, table := range aggTables {
 		exists, err := r.tableExists(table)
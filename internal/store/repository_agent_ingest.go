@@ -0,0 +1,72 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// AgentIngestCursor 记录某个 agent 在某个站点/来源下已确认的最大批次序号。
+// StreamID 在 agent 状态重置时会变化，此时序号从头开始计数。
+type AgentIngestCursor struct {
+	AgentID   string    `json:"agent_id"`
+	WebsiteID string    `json:"website_id"`
+	SourceID  string    `json:"source_id"`
+	StreamID  string    `json:"stream_id"`
+	LastSeq   int64     `json:"last_seq"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (r *Repository) ensureAgentIngestCursorTable() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "agent_ingest_cursors" (
+            agent_id TEXT NOT NULL,
+            website_id TEXT NOT NULL,
+            source_id TEXT NOT NULL,
+            stream_id TEXT NOT NULL DEFAULT '',
+            last_seq BIGINT NOT NULL DEFAULT 0,
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            PRIMARY KEY (agent_id, website_id, source_id)
+        )`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) GetAgentIngestCursor(agentID, websiteID, sourceID string) (AgentIngestCursor, bool, error) {
+	cursor := AgentIngestCursor{
+		AgentID:   agentID,
+		WebsiteID: websiteID,
+		SourceID:  sourceID,
+	}
+	row := r.db.QueryRow(
+		`SELECT stream_id, last_seq, updated_at
+         FROM "agent_ingest_cursors"
+         WHERE agent_id = $1 AND website_id = $2 AND source_id = $3`,
+		agentID, websiteID, sourceID,
+	)
+	if err := row.Scan(&cursor.StreamID, &cursor.LastSeq, &cursor.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return cursor, false, nil
+		}
+		return cursor, false, err
+	}
+	return cursor, true, nil
+}
+
+func (r *Repository) SaveAgentIngestCursor(cursor AgentIngestCursor) error {
+	_, err := r.db.Exec(
+		`INSERT INTO "agent_ingest_cursors" (agent_id, website_id, source_id, stream_id, last_seq, updated_at)
+         VALUES ($1, $2, $3, $4, $5, NOW())
+         ON CONFLICT (agent_id, website_id, source_id) DO UPDATE SET
+            stream_id = EXCLUDED.stream_id,
+            last_seq = EXCLUDED.last_seq,
+            updated_at = NOW()`,
+		cursor.AgentID, cursor.WebsiteID, cursor.SourceID, cursor.StreamID, cursor.LastSeq,
+	)
+	return err
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AgentRecord 是 agent 注册表中的一条记录，由心跳写入。
+type AgentRecord struct {
+	AgentID         string          `json:"agent_id"`
+	Hostname        string          `json:"hostname"`
+	Version         string          `json:"version"`
+	Protocol        string          `json:"protocol"`
+	StreamID        string          `json:"stream_id"`
+	RemoteAddr      string          `json:"remote_addr"`
+	IntervalSeconds int             `json:"interval_seconds"`
+	Files           json.RawMessage `json:"files"`
+	Backlog         json.RawMessage `json:"backlog"`
+	LastError       string          `json:"last_error"`
+	LastErrorAt     *time.Time      `json:"last_error_at,omitempty"`
+	ClockSkewMs     int64           `json:"clock_skew_ms"`
+	FirstSeenAt     time.Time       `json:"first_seen_at"`
+	LastSeenAt      time.Time       `json:"last_seen_at"`
+	// MissedNotified 表示本次心跳中断已经发出过通知，收到新心跳后重置。
+	MissedNotified bool `json:"missed_notified"`
+}
+
+func (r *Repository) ensureAgentRegistryTable() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "agent_registry" (
+            agent_id TEXT PRIMARY KEY,
+            hostname TEXT NOT NULL DEFAULT '',
+            version TEXT NOT NULL DEFAULT '',
+            protocol TEXT NOT NULL DEFAULT '',
+            stream_id TEXT NOT NULL DEFAULT '',
+            remote_addr TEXT NOT NULL DEFAULT '',
+            interval_seconds INTEGER NOT NULL DEFAULT 0,
+            files JSONB,
+            backlog JSONB,
+            last_error TEXT NOT NULL DEFAULT '',
+            last_error_at TIMESTAMPTZ,
+            clock_skew_ms BIGINT NOT NULL DEFAULT 0,
+            first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            missed_notified BOOLEAN NOT NULL DEFAULT FALSE
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_agent_registry_last_seen ON "agent_registry"(last_seen_at)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertAgentHeartbeat 写入一次心跳，返回写入前该 agent 是否处于“心跳中断已通知”状态。
+func (r *Repository) UpsertAgentHeartbeat(record AgentRecord) (bool, error) {
+	files := []byte(record.Files)
+	if len(files) == 0 {
+		files = nil
+	}
+	backlog := []byte(record.Backlog)
+	if len(backlog) == 0 {
+		backlog = nil
+	}
+	var wasMissed bool
+	row := r.db.QueryRow(
+		`WITH prev AS (
+            SELECT missed_notified FROM "agent_registry" WHERE agent_id = $1
+         )
+         INSERT INTO "agent_registry" (
+            agent_id, hostname, version, protocol, stream_id, remote_addr, interval_seconds,
+            files, backlog, last_error, last_error_at, clock_skew_ms, first_seen_at, last_seen_at, missed_notified
+         ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW(), NOW(), FALSE)
+         ON CONFLICT (agent_id) DO UPDATE SET
+            hostname = EXCLUDED.hostname,
+            version = EXCLUDED.version,
+            protocol = EXCLUDED.protocol,
+            stream_id = EXCLUDED.stream_id,
+            remote_addr = EXCLUDED.remote_addr,
+            interval_seconds = EXCLUDED.interval_seconds,
+            files = EXCLUDED.files,
+            backlog = EXCLUDED.backlog,
+            last_error = EXCLUDED.last_error,
+            last_error_at = EXCLUDED.last_error_at,
+            clock_skew_ms = EXCLUDED.clock_skew_ms,
+            last_seen_at = NOW(),
+            missed_notified = FALSE
+         RETURNING COALESCE((SELECT missed_notified FROM prev), FALSE)`,
+		record.AgentID, record.Hostname, record.Version, record.Protocol, record.StreamID,
+		record.RemoteAddr, record.IntervalSeconds, files, backlog, record.LastError,
+		record.LastErrorAt, record.ClockSkewMs,
+	)
+	if err := row.Scan(&wasMissed); err != nil {
+		return false, err
+	}
+	return wasMissed, nil
+}
+
+func (r *Repository) ListAgents() ([]AgentRecord, error) {
+	rows, err := r.db.Query(
+		`SELECT agent_id, hostname, version, protocol, stream_id, remote_addr, interval_seconds,
+                files, backlog, last_error, last_error_at, clock_skew_ms, first_seen_at, last_seen_at, missed_notified
+         FROM "agent_registry"
+         ORDER BY agent_id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := make([]AgentRecord, 0)
+	for rows.Next() {
+		var record AgentRecord
+		var files, backlog []byte
+		if err := rows.Scan(
+			&record.AgentID,
+			&record.Hostname,
+			&record.Version,
+			&record.Protocol,
+			&record.StreamID,
+			&record.RemoteAddr,
+			&record.IntervalSeconds,
+			&files,
+			&backlog,
+			&record.LastError,
+			&record.LastErrorAt,
+			&record.ClockSkewMs,
+			&record.FirstSeenAt,
+			&record.LastSeenAt,
+			&record.MissedNotified,
+		); err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			record.Files = json.RawMessage(files)
+		}
+		if len(backlog) > 0 {
+			record.Backlog = json.RawMessage(backlog)
+		}
+		agents = append(agents, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return agents, nil
+}
+
+// MarkAgentMissedNotified 标记心跳中断已通知，返回 false 表示其他请求已先一步标记（或 agent 已恢复）。
+func (r *Repository) MarkAgentMissedNotified(agentID string, lastSeenAt time.Time) (bool, error) {
+	result, err := r.db.Exec(
+		`UPDATE "agent_registry" SET missed_notified = TRUE
+         WHERE agent_id = $1 AND last_seen_at = $2 AND missed_notified = FALSE`,
+		agentID, lastSeenAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *Repository) DeleteAgent(agentID string) error {
+	_, err := r.db.Exec(`DELETE FROM "agent_registry" WHERE agent_id = $1`, agentID)
+	return err
+}
@@ -0,0 +1,239 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+// HourlyMetrics 是 _agg_hourly 中单个小时桶的检测指标。
+type HourlyMetrics struct {
+	PV      float64
+	UV      float64
+	S4xx    float64
+	S5xx    float64
+	Traffic float64
+}
+
+// Value 按指标名取值，未知指标返回 false。
+func (m HourlyMetrics) Value(metric string) (float64, bool) {
+	switch metric {
+	case "pv":
+		return m.PV, true
+	case "uv":
+		return m.UV, true
+	case "s4xx":
+		return m.S4xx, true
+	case "s5xx":
+		return m.S5xx, true
+	case "traffic":
+		return m.Traffic, true
+	default:
+		return 0, false
+	}
+}
+
+// TrafficAnomaly 是一条检测到的流量异常，bucket 为小时桶的起始时间戳。
+type TrafficAnomaly struct {
+	ID         int64     `json:"id"`
+	WebsiteID  string    `json:"website_id"`
+	Bucket     int64     `json:"bucket"`
+	Metric     string    `json:"metric"`
+	Value      float64   `json:"value"`
+	Baseline   float64   `json:"baseline"`
+	MAD        float64   `json:"mad"`
+	Score      float64   `json:"score"`
+	Severity   string    `json:"severity"`
+	Direction  string    `json:"direction"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+func (r *Repository) ensureTrafficAnomalyTable() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "traffic_anomalies" (
+            id BIGSERIAL PRIMARY KEY,
+            website_id TEXT NOT NULL,
+            bucket BIGINT NOT NULL,
+            metric TEXT NOT NULL,
+            value DOUBLE PRECISION NOT NULL DEFAULT 0,
+            baseline DOUBLE PRECISION NOT NULL DEFAULT 0,
+            mad DOUBLE PRECISION NOT NULL DEFAULT 0,
+            score DOUBLE PRECISION NOT NULL DEFAULT 0,
+            severity TEXT NOT NULL,
+            direction TEXT NOT NULL,
+            detected_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            UNIQUE (website_id, bucket, metric)
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_traffic_anomalies_bucket ON "traffic_anomalies"(bucket)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadHourlyMetrics 读取指定小时桶的指标，没有数据的桶不会出现在结果中。
+func (r *Repository) LoadHourlyMetrics(websiteID string, buckets []int64) (map[int64]HourlyMetrics, error) {
+	result := make(map[int64]HourlyMetrics, len(buckets))
+	if len(buckets) == 0 {
+		return result, nil
+	}
+	hourlyTable := fmt.Sprintf("%s_agg_hourly", websiteID)
+	hourlyIPTable := fmt.Sprintf("%s_agg_hourly_ip", websiteID)
+	placeholders := make([]string, len(buckets))
+	args := make([]interface{}, len(buckets))
+	for i, bucket := range buckets {
+		placeholders[i] = "?"
+		args[i] = bucket
+	}
+	inClause := strings.Join(placeholders, ",")
+
+	rows, err := r.db.Query(
+		sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT bucket, pv, s4xx, s5xx, traffic FROM "%s" WHERE bucket IN (%s)`,
+			hourlyTable, inClause,
+		)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket, pv, s4xx, s5xx, traffic int64
+		if err := rows.Scan(&bucket, &pv, &s4xx, &s5xx, &traffic); err != nil {
+			return nil, err
+		}
+		result[bucket] = HourlyMetrics{
+			PV:      float64(pv),
+			S4xx:    float64(s4xx),
+			S5xx:    float64(s5xx),
+			Traffic: float64(traffic),
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	uvRows, err := r.db.Query(
+		sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT bucket, COUNT(*) FROM "%s" WHERE bucket IN (%s) GROUP BY bucket`,
+			hourlyIPTable, inClause,
+		)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer uvRows.Close()
+	for uvRows.Next() {
+		var bucket, uv int64
+		if err := uvRows.Scan(&bucket, &uv); err != nil {
+			return nil, err
+		}
+		metrics := result[bucket]
+		metrics.UV = float64(uv)
+		result[bucket] = metrics
+	}
+	return result, uvRows.Err()
+}
+
+// LatestHourlyBucket 返回站点已有聚合数据的最新小时桶，没有数据时 ok 为 false。
+func (r *Repository) LatestHourlyBucket(websiteID string) (int64, bool, error) {
+	var bucket sql.NullInt64
+	err := r.db.QueryRow(fmt.Sprintf(`SELECT MAX(bucket) FROM "%s_agg_hourly"`, websiteID)).Scan(&bucket)
+	if err != nil {
+		return 0, false, err
+	}
+	return bucket.Int64, bucket.Valid, nil
+}
+
+// InsertTrafficAnomaly 写入异常记录，同一站点/小时/指标只保留首次检测结果，
+// 返回 false 表示该异常已经存在。
+func (r *Repository) InsertTrafficAnomaly(anomaly TrafficAnomaly) (bool, error) {
+	result, err := r.db.Exec(
+		`INSERT INTO "traffic_anomalies" (
+            website_id, bucket, metric, value, baseline, mad, score, severity, direction
+         ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+         ON CONFLICT (website_id, bucket, metric) DO NOTHING`,
+		anomaly.WebsiteID, anomaly.Bucket, anomaly.Metric, anomaly.Value, anomaly.Baseline,
+		anomaly.MAD, anomaly.Score, anomaly.Severity, anomaly.Direction,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// ListTrafficAnomalies 按时间范围 [startBucket, endBucket) 列出多个站点的异常。
+func (r *Repository) ListTrafficAnomalies(websiteIDs []string, startBucket, endBucket int64) ([]TrafficAnomaly, error) {
+	anomalies := make([]TrafficAnomaly, 0)
+	if len(websiteIDs) == 0 {
+		return anomalies, nil
+	}
+	placeholders := make([]string, len(websiteIDs))
+	args := make([]interface{}, 0, len(websiteIDs)+2)
+	for i, id := range websiteIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+	args = append(args, startBucket, endBucket)
+	query := fmt.Sprintf(
+		`SELECT id, website_id, bucket, metric, value, baseline, mad, score, severity, direction, detected_at
+         FROM "traffic_anomalies"
+         WHERE website_id IN (%s) AND bucket >= ? AND bucket < ?
+         ORDER BY bucket DESC, ABS(score) DESC`,
+		strings.Join(placeholders, ","),
+	)
+	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item TrafficAnomaly
+		if err := rows.Scan(
+			&item.ID,
+			&item.WebsiteID,
+			&item.Bucket,
+			&item.Metric,
+			&item.Value,
+			&item.Baseline,
+			&item.MAD,
+			&item.Score,
+			&item.Severity,
+			&item.Direction,
+			&item.DetectedAt,
+		); err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return anomalies, nil
+}
+
+// DeleteTrafficAnomaliesBefore 删除早于 cutoffBucket 的异常记录。
+func (r *Repository) DeleteTrafficAnomaliesBefore(cutoffBucket int64) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM "traffic_anomalies" WHERE bucket < $1`, cutoffBucket)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *Repository) clearTrafficAnomaliesForWebsite(websiteID string) error {
+	_, err := r.db.Exec(`DELETE FROM "traffic_anomalies" WHERE website_id = $1`, websiteID)
+	return err
+}
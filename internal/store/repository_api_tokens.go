@@ -0,0 +1,161 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrAPITokenNotFound 表示 API 令牌不存在、已吊销或已过期。
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+// APIToken 是只读的 API 令牌，库中只保存令牌的哈希。
+// Websites、StatsTypes 为空表示不限制；RateLimit 为每分钟请求数，0 表示使用 system.rateLimit 的默认值。
+type APIToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	TokenHint  string     `json:"token_hint"`
+	Websites   []string   `json:"websites"`
+	StatsTypes []string   `json:"stats_types"`
+	RateLimit  int        `json:"rate_limit"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UsageCount int64      `json:"usage_count"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (r *Repository) ensureAPITokenTable() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "api_tokens" (
+            id BIGSERIAL PRIMARY KEY,
+            name TEXT NOT NULL,
+            token_hash TEXT NOT NULL UNIQUE,
+            token_hint TEXT NOT NULL DEFAULT '',
+            websites JSONB NOT NULL DEFAULT '[]',
+            stats_types JSONB NOT NULL DEFAULT '[]',
+            rate_limit INTEGER NOT NULL DEFAULT 0,
+            expires_at TIMESTAMPTZ NOT NULL,
+            created_by TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            usage_count BIGINT NOT NULL DEFAULT 0,
+            last_used_at TIMESTAMPTZ,
+            last_used_ip TEXT NOT NULL DEFAULT '',
+            revoked_at TIMESTAMPTZ
+        )`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const apiTokenColumns = `id, name, token_hash, token_hint, websites, stats_types, rate_limit, expires_at, created_by, created_at, usage_count, last_used_at, last_used_ip, revoked_at`
+
+func scanAPIToken(scanner interface{ Scan(...interface{}) error }) (APIToken, error) {
+	var token APIToken
+	var websites, statsTypes []byte
+	if err := scanner.Scan(
+		&token.ID,
+		&token.Name,
+		&token.TokenHash,
+		&token.TokenHint,
+		&websites,
+		&statsTypes,
+		&token.RateLimit,
+		&token.ExpiresAt,
+		&token.CreatedBy,
+		&token.CreatedAt,
+		&token.UsageCount,
+		&token.LastUsedAt,
+		&token.LastUsedIP,
+		&token.RevokedAt,
+	); err != nil {
+		return token, err
+	}
+	token.Websites = decodeStringList(websites)
+	token.StatsTypes = decodeStringList(statsTypes)
+	return token, nil
+}
+
+func (r *Repository) CreateAPIToken(token APIToken) (APIToken, error) {
+	websites, err := encodeStringList(token.Websites)
+	if err != nil {
+		return token, err
+	}
+	statsTypes, err := encodeStringList(token.StatsTypes)
+	if err != nil {
+		return token, err
+	}
+	row := r.db.QueryRow(
+		`INSERT INTO "api_tokens" (name, token_hash, token_hint, websites, stats_types, rate_limit, expires_at, created_by)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+         RETURNING `+apiTokenColumns,
+		token.Name, token.TokenHash, token.TokenHint, websites, statsTypes, token.RateLimit, token.ExpiresAt, token.CreatedBy,
+	)
+	return scanAPIToken(row)
+}
+
+// ListAPITokens 返回全部令牌（含已吊销与已过期），按创建时间倒序
+func (r *Repository) ListAPITokens() ([]APIToken, error) {
+	rows, err := r.db.Query(`SELECT ` + apiTokenColumns + ` FROM "api_tokens" ORDER BY created_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// GetActiveAPIToken 查找未吊销且未过期的令牌，找不到时返回 ErrAPITokenNotFound
+func (r *Repository) GetActiveAPIToken(tokenHash string) (APIToken, error) {
+	row := r.db.QueryRow(
+		`SELECT `+apiTokenColumns+` FROM "api_tokens"
+         WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()`,
+		tokenHash,
+	)
+	token, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return token, ErrAPITokenNotFound
+	}
+	return token, err
+}
+
+// AddAPITokenUsage 累加令牌使用次数并记录最近使用时间与 IP
+func (r *Repository) AddAPITokenUsage(id, uses int64, ip string, usedAt time.Time) error {
+	_, err := r.db.Exec(
+		`UPDATE "api_tokens"
+         SET usage_count = usage_count + $2, last_used_at = $3, last_used_ip = $4
+         WHERE id = $1`,
+		id, uses, usedAt, ip,
+	)
+	return err
+}
+
+// RevokeAPIToken 吊销令牌，令牌不存在或已吊销时返回 ErrAPITokenNotFound
+func (r *Repository) RevokeAPIToken(id int64) (APIToken, error) {
+	row := r.db.QueryRow(
+		`UPDATE "api_tokens" SET revoked_at = NOW()
+         WHERE id = $1 AND revoked_at IS NULL
+         RETURNING `+apiTokenColumns,
+		id,
+	)
+	token, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return token, ErrAPITokenNotFound
+	}
+	return token, err
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+// AuditEntry 是一条管理操作审计记录，写入后不可修改，只会按保留天数清理。
+// IP 为按可信代理解析的客户端地址，RemoteIP 为连接的对端地址，未经代理时两者相同。
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	ActorKind string          `json:"actor_kind"`
+	ActorID   int64           `json:"actor_id,omitempty"`
+	ActorName string          `json:"actor_name"`
+	Action    string          `json:"action"`
+	WebsiteID string          `json:"website_id"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Diff      json.RawMessage `json:"diff,omitempty"`
+	IP        string          `json:"ip"`
+	RemoteIP  string          `json:"remote_ip"`
+	Success   bool            `json:"success"`
+}
+
+// AuditFilter 为审计日志查询条件，Action 以 . 结尾时按前缀匹配（例如 config.）。
+type AuditFilter struct {
+	Action    string
+	Actor     string
+	WebsiteID string
+	IP        string
+	Since     *time.Time
+	Until     *time.Time
+}
+
+func (r *Repository) ensureAuditTable() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "audit_log" (
+            id BIGSERIAL PRIMARY KEY,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            actor_kind TEXT NOT NULL,
+            actor_id BIGINT NOT NULL DEFAULT 0,
+            actor_name TEXT NOT NULL DEFAULT '',
+            action TEXT NOT NULL,
+            website_id TEXT NOT NULL DEFAULT '',
+            params JSONB,
+            diff JSONB,
+            ip TEXT NOT NULL DEFAULT '',
+            remote_ip TEXT NOT NULL DEFAULT '',
+            success BOOLEAN NOT NULL DEFAULT TRUE
+        )`,
+		`ALTER TABLE "audit_log" ADD COLUMN IF NOT EXISTS remote_ip TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_created ON "audit_log"(created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_action ON "audit_log"(action, created_at DESC)`,
+		// 只追加：拒绝修改已有记录
+		`CREATE OR REPLACE FUNCTION audit_log_reject_update() RETURNS trigger AS $$
+         BEGIN
+             RAISE EXCEPTION 'audit_log is append-only';
+         END;
+         $$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_log_no_update ON "audit_log"`,
+		`CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON "audit_log"
+         FOR EACH ROW EXECUTE PROCEDURE audit_log_reject_update()`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nullableJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return []byte(raw)
+}
+
+func (r *Repository) InsertAuditEntry(entry AuditEntry) error {
+	_, err := r.db.Exec(
+		`INSERT INTO "audit_log" (actor_kind, actor_id, actor_name, action, website_id, params, diff, ip, remote_ip, success)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		entry.ActorKind, entry.ActorID, entry.ActorName, entry.Action, entry.WebsiteID,
+		nullableJSON(entry.Params), nullableJSON(entry.Diff), entry.IP, entry.RemoteIP, entry.Success,
+	)
+	return err
+}
+
+// ListAuditEntries 按时间倒序分页查询审计日志，返回是否还有下一页
+func (r *Repository) ListAuditEntries(filter AuditFilter, page, pageSize int) ([]AuditEntry, bool, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+	if pageSize > 500 {
+		pageSize = 500
+	}
+	offset := (page - 1) * pageSize
+
+	whereParts := make([]string, 0, 6)
+	args := make([]interface{}, 0, 8)
+	if action := strings.TrimSpace(filter.Action); action != "" {
+		if strings.HasSuffix(action, ".") {
+			whereParts = append(whereParts, "action LIKE ?")
+			args = append(args, strings.ReplaceAll(action, "_", `\_`)+"%")
+		} else {
+			whereParts = append(whereParts, "action = ?")
+			args = append(args, action)
+		}
+	}
+	if actor := strings.TrimSpace(filter.Actor); actor != "" {
+		whereParts = append(whereParts, "actor_name = ?")
+		args = append(args, actor)
+	}
+	if websiteID := strings.TrimSpace(filter.WebsiteID); websiteID != "" {
+		whereParts = append(whereParts, "website_id = ?")
+		args = append(args, websiteID)
+	}
+	if ip := strings.TrimSpace(filter.IP); ip != "" {
+		whereParts = append(whereParts, "ip = ?")
+		args = append(args, ip)
+	}
+	if filter.Since != nil {
+		whereParts = append(whereParts, "created_at >= ?")
+		args = append(args, *filter.Since)
+	}
+	if filter.Until != nil {
+		whereParts = append(whereParts, "created_at < ?")
+		args = append(args, *filter.Until)
+	}
+	whereClause := ""
+	if len(whereParts) > 0 {
+		whereClause = "WHERE " + strings.Join(whereParts, " AND ")
+	}
+
+	query := fmt.Sprintf(
+		`SELECT id, created_at, actor_kind, actor_id, actor_name, action, website_id, params, diff, ip, remote_ip, success
+         FROM "audit_log"
+         %s
+         ORDER BY created_at DESC, id DESC
+         LIMIT ? OFFSET ?`,
+		whereClause,
+	)
+	args = append(args, pageSize+1, offset)
+
+	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(query), args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0, pageSize)
+	for rows.Next() {
+		var entry AuditEntry
+		var params, diff []byte
+		if err := rows.Scan(
+			&entry.ID, &entry.CreatedAt, &entry.ActorKind, &entry.ActorID, &entry.ActorName,
+			&entry.Action, &entry.WebsiteID, &params, &diff, &entry.IP, &entry.RemoteIP, &entry.Success,
+		); err != nil {
+			return nil, false, err
+		}
+		if len(params) > 0 {
+			entry.Params = json.RawMessage(params)
+		}
+		if len(diff) > 0 {
+			entry.Diff = json.RawMessage(diff)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	hasMore := len(entries) > pageSize
+	if hasMore {
+		entries = entries[:pageSize]
+	}
+	return entries, hasMore, nil
+}
+
+// DeleteAuditEntriesBefore 删除早于 cutoff 的审计日志（按保留天数清理）
+func (r *Repository) DeleteAuditEntriesBefore(cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM "audit_log" WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+// _agg_url_daily 按天记录每个 URL 的请求数与发送字节数（包含非 PV 请求），
+// 与只统计 PV 流量的 _agg_hourly.traffic 互补，用于静态资源带宽分析。
+
+func createURLTrafficAggTable(execer sqlExecer, websiteID string) error {
+	_, err := execer.Exec(fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS "%s_agg_url_daily" (
+            day DATE NOT NULL,
+            url_id BIGINT NOT NULL,
+            requests BIGINT NOT NULL DEFAULT 0,
+            traffic BIGINT NOT NULL DEFAULT 0,
+            not_modified BIGINT NOT NULL DEFAULT 0,
+            PRIMARY KEY(day, url_id)
+        )`, websiteID,
+	))
+	return err
+}
+
+// ensureURLTrafficAggregates 为已有站点补建 URL 流量聚合表，首次创建时从日志回填。
+func (r *Repository) ensureURLTrafficAggregates(websiteID string) error {
+	exists, err := r.tableExists(fmt.Sprintf("%s_agg_url_daily", websiteID))
+	if err != nil || exists {
+		return err
+	}
+	if err := createURLTrafficAggTable(r.db, websiteID); err != nil {
+		return err
+	}
+	return r.backfillURLTrafficAggregates(websiteID)
+}
+
+type urlTrafficKey struct {
+	day   string
+	urlID int64
+}
+
+type urlTrafficCounts struct {
+	requests    int64
+	traffic     int64
+	notModified int64
+}
+
+type urlTrafficBatch map[urlTrafficKey]*urlTrafficCounts
+
+func (b urlTrafficBatch) add(log NginxLogRecord, urlID int64) {
+	key := urlTrafficKey{day: dayBucket(log.Timestamp), urlID: urlID}
+	counts := b[key]
+	if counts == nil {
+		counts = &urlTrafficCounts{}
+		b[key] = counts
+	}
+	weight := log.weight()
+	counts.requests += weight
+	counts.traffic += int64(log.BytesSent) * weight
+	if log.Status == 304 {
+		counts.notModified += weight
+	}
+}
+
+func prepareURLTrafficAggStatement(tx *sql.Tx, websiteID string) (*sql.Stmt, error) {
+	table := fmt.Sprintf("%s_agg_url_daily", websiteID)
+	return tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%[1]s" (day, url_id, requests, traffic, not_modified)
+         VALUES (?, ?, ?, ?, ?)
+         ON CONFLICT(day, url_id) DO UPDATE SET
+             requests = "%[1]s".requests + excluded.requests,
+             traffic = "%[1]s".traffic + excluded.traffic,
+             not_modified = "%[1]s".not_modified + excluded.not_modified`, table,
+	)))
+}
+
+// applyURLTrafficAggUpdates 按 (day, url_id) 顺序写入，保证锁获取顺序稳定。
+func applyURLTrafficAggUpdates(stmt *sql.Stmt, batch urlTrafficBatch) error {
+	if stmt == nil || len(batch) == 0 {
+		return nil
+	}
+	keys := make([]urlTrafficKey, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].urlID < keys[j].urlID
+	})
+	for _, key := range keys {
+		counts := batch[key]
+		if _, err := stmt.Exec(
+			key.day, key.urlID, counts.requests, counts.traffic, counts.notModified,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const urlTrafficAggSelectSQL = `
+         SELECT
+             date(to_timestamp(timestamp)) AS day,
+             url_id,
+             SUM(sample_weight),
+             COALESCE(SUM(bytes_sent * sample_weight), 0),
+             SUM(CASE WHEN status_code = 304 THEN sample_weight ELSE 0 END)
+         FROM "%s"%s
+         GROUP BY day, url_id`
+
+func (r *Repository) backfillURLTrafficAggregates(websiteID string) error {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	aggTable := fmt.Sprintf("%s_agg_url_daily", websiteID)
+
+	logrus.WithField("website", websiteID).Info("开始回填 URL 流量聚合数据")
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s"`, aggTable)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(
+		`INSERT INTO "%s" (day, url_id, requests, traffic, not_modified)`+urlTrafficAggSelectSQL,
+		aggTable, logTable, "",
+	)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	logrus.WithField("website", websiteID).Info("URL 流量聚合数据回填完成")
+	return nil
+}
+
+// cleanupURLTrafficAggregates 删除保留期之前的 URL 流量聚合，并按剩余日志重建截止当天。
+func (r *Repository) cleanupURLTrafficAggregates(websiteID string, cutoff time.Time) error {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	aggTable := fmt.Sprintf("%s_agg_url_daily", websiteID)
+
+	exists, err := r.tableExists(aggTable)
+	if err != nil || !exists {
+		return err
+	}
+
+	cutoffDay := dayBucket(cutoff)
+	start, err := time.ParseInLocation("2006-01-02", cutoffDay, time.Local)
+	if err != nil {
+		return err
+	}
+	end := start.Add(24 * time.Hour)
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(
+		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE day <= ?`, aggTable)),
+		cutoffDay,
+	); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (day, url_id, requests, traffic, not_modified)`+urlTrafficAggSelectSQL,
+		aggTable, logTable, " WHERE timestamp >= ? AND timestamp < ?",
+	)), start.Unix(), end.Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
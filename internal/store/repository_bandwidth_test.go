@@ -0,0 +1,25 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestURLTrafficBatchAdd(t *testing.T) {
+	t.Parallel()
+
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	batch := urlTrafficBatch{}
+	batch.add(NginxLogRecord{Timestamp: day, Status: 200, BytesSent: 1000}, 1)
+	batch.add(NginxLogRecord{Timestamp: day, Status: 304, BytesSent: 0, SampleWeight: 5}, 1)
+	batch.add(NginxLogRecord{Timestamp: day, Status: 200, BytesSent: 200, SampleWeight: 4}, 1)
+	batch.add(NginxLogRecord{Timestamp: day.AddDate(0, 0, 1), Status: 200, BytesSent: 10}, 1)
+
+	if len(batch) != 2 {
+		t.Fatalf("unexpected batch keys %v", batch)
+	}
+	counts := batch[urlTrafficKey{day: dayBucket(day), urlID: 1}]
+	if counts == nil || counts.requests != 10 || counts.traffic != 1800 || counts.notModified != 5 {
+		t.Fatalf("unexpected counts %+v", counts)
+	}
+}
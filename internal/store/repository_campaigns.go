@@ -0,0 +1,193 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+const maxCampaignValueBytes = 200
+
+// Campaign 为入库时从 URL 查询参数解析出的投放归因信息。
+type Campaign struct {
+	Source  string `json:"source"`
+	Medium  string `json:"medium"`
+	Name    string `json:"campaign"`
+	Term    string `json:"term"`
+	Content string `json:"content"`
+	// ClickSource 为广告点击 ID 对应的平台（gclid → google、fbclid → facebook、msclkid → microsoft）。
+	ClickSource string `json:"clickSource"`
+	// Custom 为 system.campaigns.customParams 中配置的参数值。
+	Custom map[string]string `json:"custom,omitempty"`
+}
+
+// IsEmpty 判断是否未携带任何投放参数
+func (c Campaign) IsEmpty() bool {
+	return c.Source == "" && c.Medium == "" && c.Name == "" && c.Term == "" &&
+		c.Content == "" && c.ClickSource == "" && len(c.Custom) == 0
+}
+
+func (c Campaign) customJSON() string {
+	if len(c.Custom) == 0 {
+		return "{}"
+	}
+	// map 序列化时按 key 排序，同一组参数得到相同的文本
+	payload, err := json.Marshal(c.Custom)
+	if err != nil {
+		return "{}"
+	}
+	return string(payload)
+}
+
+func (c Campaign) cacheKey() string {
+	return strings.Join([]string{c.Source, c.Medium, c.Name, c.Term, c.Content, c.ClickSource, c.customJSON()}, "\x1f")
+}
+
+func sanitizeCampaign(c Campaign) Campaign {
+	c.Source = sanitizeAndTruncate(c.Source, maxCampaignValueBytes)
+	c.Medium = sanitizeAndTruncate(c.Medium, maxCampaignValueBytes)
+	c.Name = sanitizeAndTruncate(c.Name, maxCampaignValueBytes)
+	c.Term = sanitizeAndTruncate(c.Term, maxCampaignValueBytes)
+	c.Content = sanitizeAndTruncate(c.Content, maxCampaignValueBytes)
+	c.ClickSource = sanitizeAndTruncate(c.ClickSource, maxCampaignValueBytes)
+	if len(c.Custom) > 0 {
+		custom := make(map[string]string, len(c.Custom))
+		for key, value := range c.Custom {
+			custom[sanitizeAndTruncate(key, maxCampaignValueBytes)] = sanitizeAndTruncate(value, maxCampaignValueBytes)
+		}
+		c.Custom = custom
+	}
+	return c
+}
+
+func createCampaignDimTable(execer sqlExecer, websiteID string) error {
+	_, err := execer.Exec(fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS "%s_dim_campaign" (
+            id BIGSERIAL PRIMARY KEY,
+            source TEXT NOT NULL,
+            medium TEXT NOT NULL,
+            campaign TEXT NOT NULL,
+            term TEXT NOT NULL,
+            content TEXT NOT NULL,
+            click_source TEXT NOT NULL,
+            custom JSONB NOT NULL DEFAULT '{}'::jsonb,
+            UNIQUE(source, medium, campaign, term, content, click_source, custom)
+        )`, websiteID,
+	))
+	return err
+}
+
+// ensureLogCampaignColumn 为旧版日志表补充 campaign_id 列（0 表示未携带投放参数）。
+func ensureLogCampaignColumn(execer sqlExecer, websiteID string) error {
+	_, err := execer.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s_nginx_logs" ADD COLUMN IF NOT EXISTS campaign_id BIGINT NOT NULL DEFAULT 0`,
+		websiteID,
+	))
+	return err
+}
+
+type campaignStatements struct {
+	insert *sql.Stmt
+	sel    *sql.Stmt
+}
+
+func (s *campaignStatements) Close() {
+	if s.insert != nil {
+		s.insert.Close()
+	}
+	if s.sel != nil {
+		s.sel.Close()
+	}
+}
+
+func prepareCampaignStatements(tx *sql.Tx, websiteID string) (*campaignStatements, error) {
+	table := fmt.Sprintf("%s_dim_campaign", websiteID)
+	insert, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (source, medium, campaign, term, content, click_source, custom)
+         VALUES (?, ?, ?, ?, ?, ?, ?::jsonb) ON CONFLICT DO NOTHING`, table,
+	)))
+	if err != nil {
+		return nil, err
+	}
+	sel, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT id FROM "%s"
+         WHERE source = ? AND medium = ? AND campaign = ? AND term = ? AND content = ?
+           AND click_source = ? AND custom = ?::jsonb`, table,
+	)))
+	if err != nil {
+		insert.Close()
+		return nil, err
+	}
+	return &campaignStatements{insert: insert, sel: sel}, nil
+}
+
+// campaignID 返回投放维度 ID，未携带投放参数时返回 0。
+func (s *campaignStatements) campaignID(cache map[string]int64, c Campaign) (int64, error) {
+	if c.IsEmpty() {
+		return 0, nil
+	}
+	return getOrCreateDimID(
+		cache, s.insert, s.sel, c.cacheKey(),
+		c.Source, c.Medium, c.Name, c.Term, c.Content, c.ClickSource, c.customJSON(),
+	)
+}
+
+// LoadCampaigns 按 ID 读取投放维度。
+func (r *Repository) LoadCampaigns(websiteID string, ids []int64) (map[int64]Campaign, error) {
+	result := make(map[int64]Campaign, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	sorted := append([]int64(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	const chunkSize = 500
+	for start := 0; start < len(sorted); start += chunkSize {
+		end := start + chunkSize
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		chunk := sorted[start:end]
+		placeholders := make([]string, len(chunk))
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+		rows, err := r.db.Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+			`SELECT id, source, medium, campaign, term, content, click_source, custom::text
+             FROM "%s_dim_campaign" WHERE id IN (%s)`,
+			websiteID, strings.Join(placeholders, ","),
+		)), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				id     int64
+				item   Campaign
+				custom string
+			)
+			if err := rows.Scan(&id, &item.Source, &item.Medium, &item.Name, &item.Term,
+				&item.Content, &item.ClickSource, &custom); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if custom != "" && custom != "{}" {
+				_ = json.Unmarshal([]byte(custom), &item.Custom)
+			}
+			result[id] = item
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/sirupsen/logrus"
+)
+
+// _agg_error_daily 按天记录 4xx/5xx 请求：url_id + status_code 为错误路径，
+// referer_id 仅对 404 保留（用于定位失效的入站链接），其余状态码固定为 0。
+
+func createErrorAggTable(execer sqlExecer, websiteID string) error {
+	stmts := []string{
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_agg_error_daily" (
+                day DATE NOT NULL,
+                url_id BIGINT NOT NULL,
+                status_code INT NOT NULL,
+                referer_id BIGINT NOT NULL DEFAULT 0,
+                count BIGINT NOT NULL DEFAULT 0,
+                first_ts BIGINT NOT NULL,
+                last_ts BIGINT NOT NULL,
+                PRIMARY KEY(day, url_id, status_code, referer_id)
+            )`, websiteID,
+		),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS idx_%s_agg_error_daily_url ON "%s_agg_error_daily"(url_id, status_code)`,
+			websiteID, websiteID,
+		),
+	}
+	for _, stmt := range stmts {
+		if _, err := execer.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureErrorAggregates 为已有站点补建错误聚合表，首次创建时从日志回填。
+func (r *Repository) ensureErrorAggregates(websiteID string) error {
+	exists, err := r.tableExists(fmt.Sprintf("%s_agg_error_daily", websiteID))
+	if err != nil || exists {
+		return err
+	}
+	if err := createErrorAggTable(r.db, websiteID); err != nil {
+		return err
+	}
+	return r.backfillErrorAggregates(websiteID)
+}
+
+type errorAggKey struct {
+	day        string
+	urlID      int64
+	statusCode int
+	refererID  int64
+}
+
+type errorAggCounts struct {
+	count   int64
+	firstTs int64
+	lastTs  int64
+}
+
+type errorAggBatch map[errorAggKey]*errorAggCounts
+
+func (b errorAggBatch) add(log NginxLogRecord, urlID, refererID int64) {
+	if log.Status < 400 {
+		return
+	}
+	if log.Status != 404 {
+		refererID = 0
+	}
+	key := errorAggKey{
+		day:        dayBucket(log.Timestamp),
+		urlID:      urlID,
+		statusCode: log.Status,
+		refererID:  refererID,
+	}
+	weight := log.weight()
+	ts := log.Timestamp.Unix()
+	counts := b[key]
+	if counts == nil {
+		b[key] = &errorAggCounts{count: weight, firstTs: ts, lastTs: ts}
+		return
+	}
+	counts.count += weight
+	if ts < counts.firstTs {
+		counts.firstTs = ts
+	}
+	if ts > counts.lastTs {
+		counts.lastTs = ts
+	}
+}
+
+func prepareErrorAggStatement(tx *sql.Tx, websiteID string) (*sql.Stmt, error) {
+	table := fmt.Sprintf("%s_agg_error_daily", websiteID)
+	return tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%[1]s" (day, url_id, status_code, referer_id, count, first_ts, last_ts)
+         VALUES (?, ?, ?, ?, ?, ?, ?)
+         ON CONFLICT(day, url_id, status_code, referer_id) DO UPDATE SET
+             count = "%[1]s".count + excluded.count,
+             first_ts = LEAST("%[1]s".first_ts, excluded.first_ts),
+             last_ts = GREATEST("%[1]s".last_ts, excluded.last_ts)`, table,
+	)))
+}
+
+// applyErrorAggUpdates 按主键顺序写入，与 applyAggUpdates 一样保证锁获取顺序稳定。
+func applyErrorAggUpdates(stmt *sql.Stmt, batch errorAggBatch) error {
+	if stmt == nil || len(batch) == 0 {
+		return nil
+	}
+	keys := make([]errorAggKey, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.day != b.day {
+			return a.day < b.day
+		}
+		if a.urlID != b.urlID {
+			return a.urlID < b.urlID
+		}
+		if a.statusCode != b.statusCode {
+			return a.statusCode < b.statusCode
+		}
+		return a.refererID < b.refererID
+	})
+	for _, key := range keys {
+		counts := batch[key]
+		if _, err := stmt.Exec(
+			key.day, key.urlID, key.statusCode, key.refererID,
+			counts.count, counts.firstTs, counts.lastTs,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const errorAggSelectSQL = `
+         SELECT
+             date(to_timestamp(timestamp)) AS day,
+             url_id,
+             status_code,
+             CASE WHEN status_code = 404 THEN referer_id ELSE 0 END AS ref_id,
+             SUM(sample_weight),
+             MIN(timestamp),
+             MAX(timestamp)
+         FROM "%s"
+         WHERE status_code >= 400%s
+         GROUP BY day, url_id, status_code, ref_id`
+
+func (r *Repository) backfillErrorAggregates(websiteID string) error {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	aggTable := fmt.Sprintf("%s_agg_error_daily", websiteID)
+
+	logrus.WithField("website", websiteID).Info("开始回填错误聚合数据")
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s"`, aggTable)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(
+		`INSERT INTO "%s" (day, url_id, status_code, referer_id, count, first_ts, last_ts)`+errorAggSelectSQL,
+		aggTable, logTable, "",
+	)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	logrus.WithField("website", websiteID).Info("错误聚合数据回填完成")
+	return nil
+}
+
+// cleanupErrorAggregates 删除保留期之前的错误聚合，并按剩余日志重建截止当天。
+func (r *Repository) cleanupErrorAggregates(websiteID string, cutoff time.Time) error {
+	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
+	aggTable := fmt.Sprintf("%s_agg_error_daily", websiteID)
+
+	exists, err := r.tableExists(aggTable)
+	if err != nil || !exists {
+		return err
+	}
+
+	cutoffDay := dayBucket(cutoff)
+	start, err := time.ParseInLocation("2006-01-02", cutoffDay, time.Local)
+	if err != nil {
+		return err
+	}
+	end := start.Add(24 * time.Hour)
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(
+		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE day <= ?`, aggTable)),
+		cutoffDay,
+	); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (day, url_id, status_code, referer_id, count, first_ts, last_ts)`+errorAggSelectSQL,
+		aggTable, logTable, " AND timestamp >= ? AND timestamp < ?",
+	)), start.Unix(), end.Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,31 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorAggBatchAdd(t *testing.T) {
+	t.Parallel()
+
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	batch := errorAggBatch{}
+	batch.add(NginxLogRecord{Timestamp: day, Status: 200}, 1, 9)
+	batch.add(NginxLogRecord{Timestamp: day, Status: 404}, 1, 9)
+	batch.add(NginxLogRecord{Timestamp: day.Add(-time.Hour), Status: 404, SampleWeight: 10}, 1, 9)
+	batch.add(NginxLogRecord{Timestamp: day.Add(time.Hour), Status: 500}, 1, 9)
+	batch.add(NginxLogRecord{Timestamp: day.Add(2 * time.Hour), Status: 500}, 1, 7)
+
+	if len(batch) != 2 {
+		t.Fatalf("unexpected batch keys %v", batch)
+	}
+	notFound := batch[errorAggKey{day: dayBucket(day), urlID: 1, statusCode: 404, refererID: 9}]
+	if notFound == nil || notFound.count != 11 || notFound.firstTs != day.Add(-time.Hour).Unix() || notFound.lastTs != day.Unix() {
+		t.Fatalf("unexpected 404 counts %+v", notFound)
+	}
+	// 只有 404 按来源区分，其他错误的来源统一记为 0
+	serverErrors := batch[errorAggKey{day: dayBucket(day), urlID: 1, statusCode: 500}]
+	if serverErrors == nil || serverErrors.count != 2 || serverErrors.lastTs-serverErrors.firstTs != 3600 {
+		t.Fatalf("unexpected 500 counts %+v", serverErrors)
+	}
+}
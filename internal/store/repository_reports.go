@@ -0,0 +1,155 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+// ReportRun 是一次报表发送记录，定时触发的记录以 (report_name, scheduled_at) 去重。
+type ReportRun struct {
+	ID          int64      `json:"id"`
+	ReportName  string     `json:"report_name"`
+	Trigger     string     `json:"trigger"` // schedule / manual
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	Status      string     `json:"status"` // running / success / failed
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// ErrorURLCount 是某个 URL 在某个错误状态码下的请求数。
+type ErrorURLCount struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	Count      int    `json:"count"`
+}
+
+func (r *Repository) ensureReportRunTable() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "report_runs" (
+            id BIGSERIAL PRIMARY KEY,
+            report_name TEXT NOT NULL,
+            trigger TEXT NOT NULL,
+            scheduled_at TIMESTAMPTZ NOT NULL,
+            status TEXT NOT NULL DEFAULT 'running',
+            error TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            finished_at TIMESTAMPTZ
+        )`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_report_runs_schedule
+            ON "report_runs"(report_name, scheduled_at) WHERE trigger = 'schedule'`,
+		`CREATE INDEX IF NOT EXISTS idx_report_runs_name ON "report_runs"(report_name, id)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimReportRun 登记一次发送。定时触发时若同一计划时间已被登记（例如多实例或重启后重复触发）返回 false。
+func (r *Repository) ClaimReportRun(reportName, trigger string, scheduledAt time.Time) (int64, bool, error) {
+	var id int64
+	err := r.db.QueryRow(
+		`INSERT INTO "report_runs" (report_name, trigger, scheduled_at)
+         VALUES ($1, $2, $3)
+         ON CONFLICT (report_name, scheduled_at) WHERE trigger = 'schedule' DO NOTHING
+         RETURNING id`,
+		reportName, trigger, scheduledAt,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// FinishReportRun 记录发送结果，errMsg 为空表示成功。
+func (r *Repository) FinishReportRun(id int64, errMsg string) error {
+	status := "success"
+	if errMsg != "" {
+		status = "failed"
+	}
+	_, err := r.db.Exec(
+		`UPDATE "report_runs" SET status = $2, error = $3, finished_at = NOW() WHERE id = $1`,
+		id, status, errMsg,
+	)
+	return err
+}
+
+// ListReportRuns 返回报表最近的发送记录（按时间倒序）。
+func (r *Repository) ListReportRuns(reportName string, limit int) ([]ReportRun, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	rows, err := r.db.Query(
+		`SELECT id, report_name, trigger, scheduled_at, status, error, created_at, finished_at
+         FROM "report_runs" WHERE report_name = $1 ORDER BY id DESC LIMIT $2`,
+		reportName, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make([]ReportRun, 0)
+	for rows.Next() {
+		var run ReportRun
+		if err := rows.Scan(
+			&run.ID,
+			&run.ReportName,
+			&run.Trigger,
+			&run.ScheduledAt,
+			&run.Status,
+			&run.Error,
+			&run.CreatedAt,
+			&run.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// TopErrorURLs 返回时间范围内请求数最多的 4xx/5xx URL（按 URL + 状态码分组，包含非 PV 请求）。
+func (r *Repository) TopErrorURLs(websiteID string, startTs, endTs int64, limit int) ([]ErrorURLCount, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	query := fmt.Sprintf(`
+        SELECT u.url, l.status_code, SUM(l.sample_weight) AS hits
+        FROM "%[1]s_nginx_logs" l
+        JOIN "%[1]s_dim_url" u ON u.id = l.url_id
+        WHERE l.timestamp >= ? AND l.timestamp < ? AND l.status_code >= 400
+        GROUP BY u.url, l.status_code
+        ORDER BY hits DESC
+        LIMIT ?`, websiteID)
+	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(query), startTs, endTs, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]ErrorURLCount, 0)
+	for rows.Next() {
+		var item ErrorURLCount
+		if err := rows.Scan(&item.URL, &item.StatusCode, &item.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
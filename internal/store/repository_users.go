@@ -0,0 +1,326 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrUserNotFound 表示用户不存在。
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUsernameTaken 表示用户名已被占用。
+	ErrUsernameTaken = errors.New("username taken")
+)
+
+const (
+	// UserProviderLocal 为本地账号（用户名 + 密码）
+	UserProviderLocal = "local"
+	// UserProviderOIDC 为单点登录自动创建的账号，按 IdP 的 sub 识别
+	UserProviderOIDC = "oidc"
+)
+
+// User 是登录账号，Websites 为可读取的站点 ID，为空表示全部站点。
+// 单点登录账号的 Provider 为 oidc，ExternalID 为 IdP 的 sub，没有密码。
+type User struct {
+	ID           int64      `json:"id"`
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"-"`
+	Provider     string     `json:"provider"`
+	ExternalID   string     `json:"-"`
+	Role         string     `json:"role"`
+	Websites     []string   `json:"websites"`
+	Disabled     bool       `json:"disabled"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+}
+
+// UserSession 是登录会话，库中只保存令牌的哈希。
+type UserSession struct {
+	TokenHash  string    `json:"-"`
+	UserID     int64     `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+func (r *Repository) ensureUserTables() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "users" (
+            id BIGSERIAL PRIMARY KEY,
+            username TEXT NOT NULL UNIQUE,
+            password_hash TEXT NOT NULL DEFAULT '',
+            role TEXT NOT NULL,
+            websites JSONB NOT NULL DEFAULT '[]',
+            disabled BOOLEAN NOT NULL DEFAULT FALSE,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            last_login_at TIMESTAMPTZ
+        )`,
+		`CREATE TABLE IF NOT EXISTS "user_sessions" (
+            token_hash TEXT PRIMARY KEY,
+            user_id BIGINT NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            expires_at TIMESTAMPTZ NOT NULL,
+            last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            ip TEXT NOT NULL DEFAULT '',
+            user_agent TEXT NOT NULL DEFAULT ''
+        )`,
+		`ALTER TABLE "users" ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT 'local'`,
+		`ALTER TABLE "users" ADD COLUMN IF NOT EXISTS external_id TEXT NOT NULL DEFAULT ''`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_external ON "users"(provider, external_id) WHERE provider <> 'local'`,
+		`CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON "user_sessions"(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_sessions_expires ON "user_sessions"(expires_at)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeStringList(values []string) ([]byte, error) {
+	if values == nil {
+		values = []string{}
+	}
+	return json.Marshal(values)
+}
+
+func decodeStringList(raw []byte) []string {
+	values := make([]string, 0)
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &values)
+	}
+	return values
+}
+
+const userColumns = `id, username, password_hash, provider, external_id, role, websites, disabled, created_at, updated_at, last_login_at`
+
+func scanUser(scanner interface{ Scan(...interface{}) error }) (User, error) {
+	var user User
+	var websites []byte
+	if err := scanner.Scan(
+		&user.ID,
+		&user.Username,
+		&user.PasswordHash,
+		&user.Provider,
+		&user.ExternalID,
+		&user.Role,
+		&websites,
+		&user.Disabled,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.LastLoginAt,
+	); err != nil {
+		return user, err
+	}
+	user.Websites = decodeStringList(websites)
+	return user, nil
+}
+
+func (r *Repository) CreateUser(user User) (User, error) {
+	websites, err := encodeStringList(user.Websites)
+	if err != nil {
+		return user, err
+	}
+	row := r.db.QueryRow(
+		`INSERT INTO "users" (username, password_hash, role, websites, disabled)
+         VALUES ($1, $2, $3, $4, $5)
+         RETURNING `+userColumns,
+		user.Username, user.PasswordHash, user.Role, websites, user.Disabled,
+	)
+	created, err := scanUser(row)
+	if isSQLState(err, "23505") {
+		return user, ErrUsernameTaken
+	}
+	return created, err
+}
+
+// UpdateUser 更新角色、站点范围与禁用状态；PasswordHash 非空时同时更新密码。
+func (r *Repository) UpdateUser(user User) (User, error) {
+	websites, err := encodeStringList(user.Websites)
+	if err != nil {
+		return user, err
+	}
+	row := r.db.QueryRow(
+		`UPDATE "users"
+         SET role = $2, websites = $3, disabled = $4,
+             password_hash = CASE WHEN $5 = '' THEN password_hash ELSE $5 END,
+             updated_at = NOW()
+         WHERE id = $1
+         RETURNING `+userColumns,
+		user.ID, user.Role, websites, user.Disabled, user.PasswordHash,
+	)
+	updated, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return user, ErrUserNotFound
+	}
+	return updated, err
+}
+
+// UpsertExternalUser 按 provider 与 externalID 创建或更新单点登录账号，每次登录同步用户名、角色与站点范围；
+// 用户名被其他账号占用时返回 ErrUsernameTaken。
+func (r *Repository) UpsertExternalUser(user User) (User, error) {
+	websites, err := encodeStringList(user.Websites)
+	if err != nil {
+		return user, err
+	}
+	row := r.db.QueryRow(
+		`INSERT INTO "users" (username, provider, external_id, role, websites)
+         VALUES ($1, $2, $3, $4, $5)
+         ON CONFLICT (provider, external_id) WHERE provider <> 'local'
+         DO UPDATE SET username = EXCLUDED.username, role = EXCLUDED.role,
+                       websites = EXCLUDED.websites, updated_at = NOW()
+         RETURNING `+userColumns,
+		user.Username, user.Provider, user.ExternalID, user.Role, websites,
+	)
+	saved, err := scanUser(row)
+	if isSQLState(err, "23505") {
+		return user, ErrUsernameTaken
+	}
+	return saved, err
+}
+
+func (r *Repository) GetUser(id int64) (User, error) {
+	row := r.db.QueryRow(`SELECT `+userColumns+` FROM "users" WHERE id = $1`, id)
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (r *Repository) GetUserByUsername(username string) (User, error) {
+	row := r.db.QueryRow(`SELECT `+userColumns+` FROM "users" WHERE username = $1`, username)
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (r *Repository) ListUsers() ([]User, error) {
+	rows, err := r.db.Query(`SELECT ` + userColumns + ` FROM "users" ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *Repository) DeleteUser(id int64) error {
+	result, err := r.db.Exec(`DELETE FROM "users" WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// CountActiveUsers 统计未禁用的用户数；role 非空时只统计该角色。
+func (r *Repository) CountActiveUsers(role string) (int64, error) {
+	query := `SELECT COUNT(*) FROM "users" WHERE disabled = FALSE`
+	args := make([]interface{}, 0, 1)
+	if role != "" {
+		query += ` AND role = $1`
+		args = append(args, role)
+	}
+	var count int64
+	err := r.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) TouchUserLogin(id int64) error {
+	_, err := r.db.Exec(`UPDATE "users" SET last_login_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+func (r *Repository) CreateUserSession(session UserSession) error {
+	_, err := r.db.Exec(
+		`INSERT INTO "user_sessions" (token_hash, user_id, expires_at, ip, user_agent)
+         VALUES ($1, $2, $3, $4, $5)`,
+		session.TokenHash, session.UserID, session.ExpiresAt, session.IP, session.UserAgent,
+	)
+	return err
+}
+
+// GetSessionUser 按令牌哈希查找未过期会话及其用户（已禁用的用户视为无效），不存在时返回 ErrUserNotFound。
+func (r *Repository) GetSessionUser(tokenHash string) (User, UserSession, error) {
+	var session UserSession
+	row := r.db.QueryRow(
+		`SELECT s.token_hash, s.user_id, s.created_at, s.expires_at, s.last_seen_at, s.ip, s.user_agent,
+                u.id, u.username, u.password_hash, u.provider, u.external_id, u.role, u.websites, u.disabled, u.created_at, u.updated_at, u.last_login_at
+         FROM "user_sessions" s
+         JOIN "users" u ON u.id = s.user_id
+         WHERE s.token_hash = $1 AND s.expires_at > NOW() AND u.disabled = FALSE`,
+		tokenHash,
+	)
+	var user User
+	var websites []byte
+	err := row.Scan(
+		&session.TokenHash, &session.UserID, &session.CreatedAt, &session.ExpiresAt,
+		&session.LastSeenAt, &session.IP, &session.UserAgent,
+		&user.ID, &user.Username, &user.PasswordHash, &user.Provider, &user.ExternalID, &user.Role, &websites, &user.Disabled,
+		&user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
+	)
+	if err == sql.ErrNoRows {
+		return user, session, ErrUserNotFound
+	}
+	if err != nil {
+		return user, session, err
+	}
+	user.Websites = decodeStringList(websites)
+	return user, session, nil
+}
+
+// TouchUserSession 顺延会话有效期并更新最近使用时间
+func (r *Repository) TouchUserSession(tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`UPDATE "user_sessions" SET last_seen_at = NOW(), expires_at = $2 WHERE token_hash = $1`,
+		tokenHash, expiresAt,
+	)
+	return err
+}
+
+func (r *Repository) DeleteUserSession(tokenHash string) error {
+	_, err := r.db.Exec(`DELETE FROM "user_sessions" WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+// DeleteUserSessions 注销用户的全部会话（修改密码、禁用或降权后调用）。
+func (r *Repository) DeleteUserSessions(userID int64) error {
+	_, err := r.db.Exec(`DELETE FROM "user_sessions" WHERE user_id = $1`, userID)
+	return err
+}
+
+// CleanupExpiredSessions 删除已过期的会话
+func (r *Repository) CleanupExpiredSessions() (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM "user_sessions" WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,294 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrSavedViewNotFound 表示视图不存在。
+var ErrSavedViewNotFound = errors.New("saved view not found")
+
+// ErrSavedViewShareNotFound 表示分享令牌不存在。
+var ErrSavedViewShareNotFound = errors.New("saved view share not found")
+
+// SavedView 是保存在服务端的统计视图：统计类型 + 查询参数 + 分群条件。
+type SavedView struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	WebsiteID string            `json:"website_id"`
+	StatsType string            `json:"stats_type"`
+	Params    map[string]string `json:"params"`
+	Segment   map[string]string `json:"segment"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// SavedViewShare 是视图的只读分享令牌，库中只保存令牌的哈希。
+type SavedViewShare struct {
+	ID         int64      `json:"id"`
+	ViewID     int64      `json:"view_id"`
+	TokenHash  string     `json:"-"`
+	TokenHint  string     `json:"token_hint"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Active 判断分享令牌在 now 时刻是否仍然有效。
+func (s SavedViewShare) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+func (r *Repository) ensureSavedViewTables() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS "saved_views" (
+            id BIGSERIAL PRIMARY KEY,
+            name TEXT NOT NULL,
+            website_id TEXT NOT NULL,
+            stats_type TEXT NOT NULL,
+            params JSONB NOT NULL DEFAULT '{}',
+            segment JSONB NOT NULL DEFAULT '{}',
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_saved_views_website ON "saved_views"(website_id)`,
+		`CREATE TABLE IF NOT EXISTS "saved_view_shares" (
+            id BIGSERIAL PRIMARY KEY,
+            view_id BIGINT NOT NULL REFERENCES "saved_views"(id) ON DELETE CASCADE,
+            token_hash TEXT NOT NULL UNIQUE,
+            token_hint TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            expires_at TIMESTAMPTZ NOT NULL,
+            revoked_at TIMESTAMPTZ,
+            last_used_at TIMESTAMPTZ
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_saved_view_shares_view ON "saved_view_shares"(view_id)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeStringMap(values map[string]string) ([]byte, error) {
+	if values == nil {
+		values = map[string]string{}
+	}
+	return json.Marshal(values)
+}
+
+func decodeStringMap(raw []byte) map[string]string {
+	values := make(map[string]string)
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &values)
+	}
+	return values
+}
+
+const savedViewColumns = `id, name, website_id, stats_type, params, segment, created_at, updated_at`
+
+func scanSavedView(scanner interface{ Scan(...interface{}) error }) (SavedView, error) {
+	var view SavedView
+	var params, segment []byte
+	if err := scanner.Scan(
+		&view.ID,
+		&view.Name,
+		&view.WebsiteID,
+		&view.StatsType,
+		&params,
+		&segment,
+		&view.CreatedAt,
+		&view.UpdatedAt,
+	); err != nil {
+		return view, err
+	}
+	view.Params = decodeStringMap(params)
+	view.Segment = decodeStringMap(segment)
+	return view, nil
+}
+
+func (r *Repository) CreateSavedView(view SavedView) (SavedView, error) {
+	params, err := encodeStringMap(view.Params)
+	if err != nil {
+		return view, err
+	}
+	segment, err := encodeStringMap(view.Segment)
+	if err != nil {
+		return view, err
+	}
+	row := r.db.QueryRow(
+		`INSERT INTO "saved_views" (name, website_id, stats_type, params, segment)
+         VALUES ($1, $2, $3, $4, $5)
+         RETURNING `+savedViewColumns,
+		view.Name, view.WebsiteID, view.StatsType, params, segment,
+	)
+	return scanSavedView(row)
+}
+
+func (r *Repository) UpdateSavedView(view SavedView) (SavedView, error) {
+	params, err := encodeStringMap(view.Params)
+	if err != nil {
+		return view, err
+	}
+	segment, err := encodeStringMap(view.Segment)
+	if err != nil {
+		return view, err
+	}
+	row := r.db.QueryRow(
+		`UPDATE "saved_views"
+         SET name = $2, website_id = $3, stats_type = $4, params = $5, segment = $6, updated_at = NOW()
+         WHERE id = $1
+         RETURNING `+savedViewColumns,
+		view.ID, view.Name, view.WebsiteID, view.StatsType, params, segment,
+	)
+	updated, err := scanSavedView(row)
+	if err == sql.ErrNoRows {
+		return view, ErrSavedViewNotFound
+	}
+	return updated, err
+}
+
+func (r *Repository) GetSavedView(id int64) (SavedView, error) {
+	row := r.db.QueryRow(`SELECT `+savedViewColumns+` FROM "saved_views" WHERE id = $1`, id)
+	view, err := scanSavedView(row)
+	if err == sql.ErrNoRows {
+		return view, ErrSavedViewNotFound
+	}
+	return view, err
+}
+
+// ListSavedViews 列出视图，websiteID 为空时返回全部。
+func (r *Repository) ListSavedViews(websiteID string) ([]SavedView, error) {
+	query := `SELECT ` + savedViewColumns + ` FROM "saved_views"`
+	args := make([]interface{}, 0, 1)
+	if websiteID != "" {
+		query += ` WHERE website_id = $1`
+		args = append(args, websiteID)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := make([]SavedView, 0)
+	for rows.Next() {
+		view, err := scanSavedView(rows)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+func (r *Repository) DeleteSavedView(id int64) error {
+	result, err := r.db.Exec(`DELETE FROM "saved_views" WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSavedViewNotFound
+	}
+	return nil
+}
+
+const savedViewShareColumns = `id, view_id, token_hash, token_hint, created_at, expires_at, revoked_at, last_used_at`
+
+func scanSavedViewShare(scanner interface{ Scan(...interface{}) error }) (SavedViewShare, error) {
+	var share SavedViewShare
+	err := scanner.Scan(
+		&share.ID,
+		&share.ViewID,
+		&share.TokenHash,
+		&share.TokenHint,
+		&share.CreatedAt,
+		&share.ExpiresAt,
+		&share.RevokedAt,
+		&share.LastUsedAt,
+	)
+	return share, err
+}
+
+func (r *Repository) CreateSavedViewShare(share SavedViewShare) (SavedViewShare, error) {
+	row := r.db.QueryRow(
+		`INSERT INTO "saved_view_shares" (view_id, token_hash, token_hint, expires_at)
+         VALUES ($1, $2, $3, $4)
+         RETURNING `+savedViewShareColumns,
+		share.ViewID, share.TokenHash, share.TokenHint, share.ExpiresAt,
+	)
+	return scanSavedViewShare(row)
+}
+
+func (r *Repository) ListSavedViewShares(viewID int64) ([]SavedViewShare, error) {
+	rows, err := r.db.Query(
+		`SELECT `+savedViewShareColumns+` FROM "saved_view_shares" WHERE view_id = $1 ORDER BY id`,
+		viewID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := make([]SavedViewShare, 0)
+	for rows.Next() {
+		share, err := scanSavedViewShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// GetSavedViewShareByHash 按令牌哈希查找分享记录，不存在时返回 ErrSavedViewShareNotFound。
+func (r *Repository) GetSavedViewShareByHash(tokenHash string) (SavedViewShare, error) {
+	row := r.db.QueryRow(
+		`SELECT `+savedViewShareColumns+` FROM "saved_view_shares" WHERE token_hash = $1`,
+		tokenHash,
+	)
+	share, err := scanSavedViewShare(row)
+	if err == sql.ErrNoRows {
+		return share, ErrSavedViewShareNotFound
+	}
+	return share, err
+}
+
+// RevokeSavedViewShare 吊销分享令牌，返回 false 表示令牌不存在或已吊销。
+func (r *Repository) RevokeSavedViewShare(viewID, shareID int64) (bool, error) {
+	result, err := r.db.Exec(
+		`UPDATE "saved_view_shares" SET revoked_at = NOW()
+         WHERE id = $1 AND view_id = $2 AND revoked_at IS NULL`,
+		shareID, viewID,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *Repository) TouchSavedViewShare(shareID int64) error {
+	_, err := r.db.Exec(`UPDATE "saved_view_shares" SET last_used_at = NOW() WHERE id = $1`, shareID)
+	return err
+}
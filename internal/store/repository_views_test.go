@@ -0,0 +1,28 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSavedViewShareActive(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	revokedAt := now.Add(-time.Minute)
+	tests := []struct {
+		name  string
+		share SavedViewShare
+		want  bool
+	}{
+		{name: "active", share: SavedViewShare{ExpiresAt: now.Add(time.Hour)}, want: true},
+		{name: "expired", share: SavedViewShare{ExpiresAt: now.Add(-time.Second)}, want: false},
+		{name: "expires now", share: SavedViewShare{ExpiresAt: now}, want: false},
+		{name: "revoked", share: SavedViewShare{ExpiresAt: now.Add(time.Hour), RevokedAt: &revokedAt}, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.share.Active(now); got != tt.want {
+			t.Errorf("%s: Active() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package timeutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// 运行镜像不一定带时区数据库，内置一份以支持报表的 timezone 配置
+	_ "time/tzdata"
+)
+
+// CronSchedule 是解析后的 5 段 cron 表达式（分 时 日 月 周），按分钟粒度匹配。
+type CronSchedule struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	// 日与周同时受限时按标准 cron 语义取“或”
+	dayRestricted     bool
+	weekdayRestricted bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 1",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseCron 解析 cron 表达式，支持 *、列表、区间、步长（如 */15、1-5、0,30）
+// 以及 @hourly/@daily/@weekly/@monthly；周字段 0 与 7 均表示周日。
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[strings.ToLower(expr)]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron 表达式需要 5 段（分 时 日 月 周）: %q", expr)
+	}
+	schedule := &CronSchedule{}
+	if err := parseCronField(fields[0], 0, 59, schedule.minutes[:]); err != nil {
+		return nil, fmt.Errorf("分钟字段无效: %w", err)
+	}
+	if err := parseCronField(fields[1], 0, 23, schedule.hours[:]); err != nil {
+		return nil, fmt.Errorf("小时字段无效: %w", err)
+	}
+	if err := parseCronField(fields[2], 1, 31, schedule.days[:]); err != nil {
+		return nil, fmt.Errorf("日期字段无效: %w", err)
+	}
+	if err := parseCronField(fields[3], 1, 12, schedule.months[:]); err != nil {
+		return nil, fmt.Errorf("月份字段无效: %w", err)
+	}
+	var weekdays [8]bool
+	if err := parseCronField(fields[4], 0, 7, weekdays[:]); err != nil {
+		return nil, fmt.Errorf("星期字段无效: %w", err)
+	}
+	copy(schedule.weekdays[:], weekdays[:7])
+	if weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+	// 与 Vixie cron 一致：以 * 开头的字段（包括 */N）视为不受限
+	schedule.dayRestricted = !strings.HasPrefix(fields[2], "*")
+	schedule.weekdayRestricted = !strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int, target []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		if part == "" {
+			return fmt.Errorf("存在空项")
+		}
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			value, err := strconv.Atoi(part[idx+1:])
+			if err != nil || value <= 0 {
+				return fmt.Errorf("步长无效: %s", part)
+			}
+			step = value
+			part = part[:idx]
+		}
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			lo, err1 := strconv.Atoi(bounds[0])
+			hi, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || lo > hi {
+				return fmt.Errorf("区间无效: %s", part)
+			}
+			start, end = lo, hi
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return fmt.Errorf("数值无效: %s", part)
+			}
+			start, end = value, value
+			if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max {
+			return fmt.Errorf("取值超出范围 %d-%d: %s", min, max, part)
+		}
+		for v := start; v <= end; v += step {
+			target[v] = true
+		}
+	}
+	return nil
+}
+
+// Matches 判断 t 所在的分钟是否命中（按 t 自身的时区计算）。
+func (s *CronSchedule) Matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+	return s.matchesDay(t)
+}
+
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	dayMatch := s.days[t.Day()]
+	weekdayMatch := s.weekdays[int(t.Weekday())]
+	if s.dayRestricted && s.weekdayRestricted {
+		return dayMatch || weekdayMatch
+	}
+	return dayMatch && weekdayMatch
+}
+
+// Next 返回 after 之后（不含）第一个命中的分钟，在 loc 时区中计算；一年内无命中时返回零值。
+func (s *CronSchedule) Next(after time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.Local
+	}
+	t := after.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(1, 0, 0)
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+			continue
+		}
+		if s.Matches(t) {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,56 @@
+package timeutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "1,,2 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q): expected an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Date(2024, 3, 13, 8, 30, 0, 0, shanghai) // 周三
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 3, 13, 8, 45, 0, 0, shanghai)},
+		{"@hourly", time.Date(2024, 3, 13, 9, 0, 0, 0, shanghai)},
+		{"30 8 * * *", time.Date(2024, 3, 14, 8, 30, 0, 0, shanghai)},
+		{"@weekly", time.Date(2024, 3, 18, 0, 0, 0, 0, shanghai)},
+		{"0 9 * * 7", time.Date(2024, 3, 17, 9, 0, 0, 0, shanghai)},
+		{"0 9 * * 1-5", time.Date(2024, 3, 13, 9, 0, 0, 0, shanghai)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, shanghai)},
+		// 日与周同时受限时任一命中即可
+		{"0 0 20 * 5", time.Date(2024, 3, 15, 0, 0, 0, 0, shanghai)},
+		// */N 不算受限，日与周需同时命中
+		{"0 9 */2 * 1", time.Date(2024, 3, 25, 9, 0, 0, 0, shanghai)},
+		{"0 9 1 * */2", time.Date(2024, 6, 1, 9, 0, 0, 0, shanghai)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tc := range cases {
+		schedule, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.expr, err)
+		}
+		if got := schedule.Next(after, shanghai); !got.Equal(tc.want) {
+			t.Errorf("%q: Next = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	// 按 loc 计算：UTC 的 00:30 为上海时间 08:30
+	schedule, _ := ParseCron("0 9 * * *")
+	got := schedule.Next(time.Date(2024, 3, 13, 0, 30, 0, 0, time.UTC), shanghai)
+	if !got.Equal(time.Date(2024, 3, 13, 1, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Next should use the report timezone, got %v", got)
+	}
+}
@@ -2,6 +2,7 @@ package timeutil
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -15,6 +16,9 @@ func TimePeriod(timeRange string) (time.Time, time.Time, error) {
 		startTime := setTime(date, 0, 0, 0)
 		return startTime, setTime(date, 23, 59, 59), nil
 	}
+	if startDate, endDate, ok := ParseDateRange(timeRange); ok {
+		return setTime(startDate, 0, 0, 0), setTime(endDate, 23, 59, 59), nil
+	}
 
 	var startTime time.Time
 	switch timeRange {
@@ -55,6 +59,24 @@ func TimePointsAndLabels(
 		}
 		return timePoints, labels
 	}
+	if startDate, endDate, ok := ParseDateRange(timeRangeType); ok {
+		if startDate.Equal(endDate) {
+			return TimePointsAndLabels(FormatDate(startDate), viewType)
+		}
+		for day := startDate; !day.After(endDate); day = day.AddDate(0, 0, 1) {
+			dayLabel := FormatDateWithWeekday(day, false)
+			if viewType == "hourly" {
+				for hour := range 24 {
+					timePoints = append(timePoints, setTime(day, hour, 0, 0))
+					labels = append(labels, dayLabel)
+				}
+			} else {
+				timePoints = append(timePoints, day)
+				labels = append(labels, dayLabel)
+			}
+		}
+		return timePoints, labels
+	}
 
 	if timeRangeType == "today" {
 		for hour := 0; hour <= 23; hour++ {
@@ -121,6 +143,51 @@ func parseDateString(value string) (time.Time, bool) {
 	return parsed, true
 }
 
+// DateRangeSeparator 分隔自定义日期区间的起止日期，例如 2025-01-01~2025-01-31。
+const DateRangeSeparator = "~"
+
+// maxDateRangeDays 限制自定义区间的跨度，避免一次查询生成过多时间点。
+const maxDateRangeDays = 366
+
+// ParseDateRange 解析自定义日期区间（含首尾两天），跨度超过一年或起止颠倒时返回 false。
+func ParseDateRange(value string) (time.Time, time.Time, bool) {
+	parts := strings.Split(value, DateRangeSeparator)
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, false
+	}
+	start, ok := parseDateString(strings.TrimSpace(parts[0]))
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	end, ok := parseDateString(strings.TrimSpace(parts[1]))
+	if !ok || end.Before(start) || end.Sub(start) > maxDateRangeDays*24*time.Hour {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// FormatDate 返回 YYYY-MM-DD 格式的日期。
+func FormatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// FormatDateRange 返回 start~end 形式的自定义区间；同一天时返回单个日期。
+func FormatDateRange(start, end time.Time) string {
+	if FormatDate(start) == FormatDate(end) {
+		return FormatDate(start)
+	}
+	return FormatDate(start) + DateRangeSeparator + FormatDate(end)
+}
+
+// IsDateRange 判断是否为单个日期或自定义日期区间。
+func IsDateRange(value string) bool {
+	if _, ok := parseDateString(value); ok {
+		return true
+	}
+	_, _, ok := ParseDateRange(value)
+	return ok
+}
+
 // FormatDateWithWeekday 返回格式化的日期字符串，可选是否包含星期
 // 格式：M.D 或 M.D 周X
 func FormatDateWithWeekday(date time.Time, includeWeekday bool) string {
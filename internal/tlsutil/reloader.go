@@ -0,0 +1,160 @@
+// Package tlsutil 提供证书文件的热加载，供内置 HTTPS 服务与 agent 客户端证书共用。
+// 文件在握手时按间隔检查修改时间，证书轮换后无需重启；新文件无效时继续使用上一份证书。
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkInterval 为两次检查文件修改时间的最小间隔
+const checkInterval = 10 * time.Second
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(paths ...string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stamps, nil
+}
+
+func sameStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// watched 按修改时间缓存由一组文件加载出的值
+type watched[T any] struct {
+	paths []string
+	load  func() (T, error)
+
+	mu        sync.Mutex
+	value     T
+	stamps    []fileStamp
+	lastCheck time.Time
+}
+
+func newWatched[T any](load func() (T, error), paths ...string) (*watched[T], error) {
+	w := &watched[T]{paths: paths, load: load}
+	stamps, err := statFiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	w.value = value
+	w.stamps = stamps
+	w.lastCheck = time.Now()
+	return w, nil
+}
+
+func (w *watched[T]) current() T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	if now.Sub(w.lastCheck) < checkInterval {
+		return w.value
+	}
+	w.lastCheck = now
+	stamps, err := statFiles(w.paths...)
+	if err != nil || sameStamps(stamps, w.stamps) {
+		return w.value
+	}
+	value, err := w.load()
+	if err != nil {
+		logrus.WithError(err).WithField("files", w.paths).Warn("重新加载证书失败，继续使用当前证书")
+		return w.value
+	}
+	w.value = value
+	w.stamps = stamps
+	logrus.WithField("files", w.paths).Info("证书已重新加载")
+	return w.value
+}
+
+// CertReloader 加载证书与私钥，文件变化后自动重新加载。
+type CertReloader struct {
+	files *watched[*tls.Certificate]
+}
+
+// NewCertReloader 立即加载一次证书，文件不存在或不匹配时返回错误。
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	files, err := newWatched(func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}, certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载证书失败: %w", err)
+	}
+	return &CertReloader{files: files}, nil
+}
+
+// GetCertificate 用于服务端 tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.files.current(), nil
+}
+
+// GetClientCertificate 用于客户端 tls.Config.GetClientCertificate
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.files.current(), nil
+}
+
+// CAReloader 加载 PEM 格式的 CA 证书池，文件变化后自动重新加载。
+type CAReloader struct {
+	files *watched[*x509.CertPool]
+}
+
+func NewCAReloader(caFile string) (*CAReloader, error) {
+	files, err := newWatched(func() (*x509.CertPool, error) {
+		return LoadCertPool(caFile)
+	}, caFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载 CA 证书失败: %w", err)
+	}
+	return &CAReloader{files: files}, nil
+}
+
+// Pool 返回当前的 CA 证书池
+func (r *CAReloader) Pool() *x509.CertPool {
+	return r.files.current()
+}
+
+// LoadCertPool 读取 PEM 文件中的全部证书
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("文件中没有有效的 PEM 证书")
+	}
+	return pool, nil
+}
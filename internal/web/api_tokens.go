@@ -0,0 +1,222 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAPITokenExpireDays = 90
+	maxAPITokenExpireDays     = 3650
+	maxAPITokenRateLimit      = 100000
+)
+
+// IsAPITokenRequest 判断 API 令牌能否调用该接口：只允许统计查询、站点列表与当前身份的 GET 请求。
+func IsAPITokenRequest(method, path string) bool {
+	if method != http.MethodGet {
+		return false
+	}
+	return strings.HasPrefix(path, "/api/stats/") || path == "/api/websites" || path == "/api/auth/me"
+}
+
+type apiTokenPayload struct {
+	Name          string   `json:"name"`
+	Websites      []string `json:"websites"`
+	StatsTypes    []string `json:"stats_types"`
+	ExpiresInDays int      `json:"expires_in_days"`
+	RateLimit     int      `json:"rate_limit"`
+}
+
+func setupAPITokenRoutes(router *gin.Engine, statsFactory *analytics.StatsFactory) {
+	admin := auth.RequireRole(auth.RoleAdmin)
+	requireFactory := func(c *gin.Context) bool {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持 API 令牌",
+			})
+			return false
+		}
+		return true
+	}
+
+	router.GET("/api/tokens", admin, func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		tokens, err := statsFactory.Repo().ListAPITokens()
+		if err != nil {
+			logrus.WithError(err).Error("读取 API 令牌失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "读取 API 令牌失败",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"tokens": tokens,
+		})
+	})
+
+	// 创建令牌，明文令牌只在此处返回一次
+	router.POST("/api/tokens", admin, func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		var payload apiTokenPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		name := strings.TrimSpace(payload.Name)
+		if name == "" || len(name) > 64 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "令牌名称不能为空且不超过 64 个字符",
+			})
+			return
+		}
+		websites, err := normalizeUserWebsites(payload.Websites)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		statsTypes, err := normalizeStatsTypes(statsFactory, payload.StatsTypes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if payload.ExpiresInDays <= 0 {
+			payload.ExpiresInDays = defaultAPITokenExpireDays
+		}
+		if payload.ExpiresInDays > maxAPITokenExpireDays {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "令牌有效期不能超过 3650 天",
+			})
+			return
+		}
+		if payload.RateLimit < 0 || payload.RateLimit > maxAPITokenRateLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "rate_limit 超出范围",
+			})
+			return
+		}
+
+		token, tokenHash, err := auth.NewAPIToken()
+		if err != nil {
+			logrus.WithError(err).Error("生成 API 令牌失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "生成 API 令牌失败",
+			})
+			return
+		}
+		repo := statsFactory.Repo()
+		created, err := repo.CreateAPIToken(store.APIToken{
+			Name:       name,
+			TokenHash:  tokenHash,
+			TokenHint:  token[len(token)-4:],
+			Websites:   websites,
+			StatsTypes: statsTypes,
+			RateLimit:  payload.RateLimit,
+			ExpiresAt:  time.Now().AddDate(0, 0, payload.ExpiresInDays),
+			CreatedBy:  principalName(auth.FromContext(c)),
+		})
+		if err != nil {
+			logrus.WithError(err).Error("保存 API 令牌失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "保存 API 令牌失败",
+			})
+			return
+		}
+		RecordAudit(c, repo, AuditEvent{
+			Action: "tokens.create",
+			Params: gin.H{
+				"id":          created.ID,
+				"name":        created.Name,
+				"websites":    created.Websites,
+				"stats_types": created.StatsTypes,
+				"expires_at":  created.ExpiresAt,
+				"rate_limit":  created.RateLimit,
+			},
+			Success: true,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"token":     token,
+			"api_token": created,
+		})
+	})
+
+	router.DELETE("/api/tokens/:id", admin, func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "令牌 ID 无效",
+			})
+			return
+		}
+		repo := statsFactory.Repo()
+		revoked, err := repo.RevokeAPIToken(id)
+		if errors.Is(err, store.ErrAPITokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "令牌不存在或已吊销",
+			})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("吊销 API 令牌失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "吊销 API 令牌失败",
+			})
+			return
+		}
+		RecordAudit(c, repo, AuditEvent{
+			Action:  "tokens.revoke",
+			Params:  gin.H{"id": revoked.ID, "name": revoked.Name},
+			Success: true,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	})
+}
+
+// normalizeStatsTypes 校验统计类型，返回去重后的列表
+func normalizeStatsTypes(statsFactory *analytics.StatsFactory, values []string) ([]string, error) {
+	seen := make(map[string]bool, len(values))
+	statsTypes := make([]string, 0, len(values))
+	for _, value := range values {
+		statsType := strings.TrimSpace(value)
+		if statsType == "" || seen[statsType] {
+			continue
+		}
+		if _, ok := statsFactory.GetManager(statsType); !ok {
+			return nil, errors.New("统计类型不存在: " + statsType)
+		}
+		seen[statsType] = true
+		statsTypes = append(statsTypes, statsType)
+	}
+	return statsTypes, nil
+}
+
+// principalName 返回主体的显示名称，访问密钥等没有用户名的主体使用其类型
+func principalName(principal *auth.Principal) string {
+	if principal.Username != "" {
+		return principal.Username
+	}
+	return principal.Kind
+}
@@ -0,0 +1,172 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditEvent 为一次需要写入审计日志的管理操作
+type AuditEvent struct {
+	Action    string
+	WebsiteID string
+	// Params 为操作参数，Diff 为配置差异，均按 JSON 保存，不应包含密钥
+	Params  any
+	Diff    any
+	Success bool
+	// Actor 为空时使用当前请求的主体；登录接口在鉴权前调用，需要显式给出
+	Actor *auth.Principal
+}
+
+// RecordAudit 写入审计日志。写入失败只记录警告，不影响接口本身的结果。
+func RecordAudit(c *gin.Context, repo *store.Repository, event AuditEvent) {
+	if repo == nil {
+		return
+	}
+	if err := repo.InsertAuditEntry(newAuditEntry(c, event)); err != nil {
+		logrus.WithError(err).WithField("action", event.Action).Warn("写入审计日志失败")
+	}
+}
+
+// newAuditEntry 生成审计记录：IP 为按可信代理解析的客户端地址，RemoteIP 为连接的对端地址
+func newAuditEntry(c *gin.Context, event AuditEvent) store.AuditEntry {
+	actor := event.Actor
+	if actor == nil {
+		actor = auth.FromContext(c)
+	}
+	return store.AuditEntry{
+		ActorKind: actor.Kind,
+		ActorID:   actor.UserID,
+		ActorName: principalName(actor),
+		Action:    event.Action,
+		WebsiteID: event.WebsiteID,
+		Params:    auditJSON(event.Params),
+		Diff:      auditJSON(event.Diff),
+		IP:        c.ClientIP(),
+		RemoteIP:  c.RemoteIP(),
+		Success:   event.Success,
+	}
+}
+
+func auditJSON(value any) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		logrus.WithError(err).Warn("序列化审计参数失败")
+		return nil
+	}
+	return data
+}
+
+// parseAuditTime 支持 RFC3339 与 2006-01-02（按本地时区的当天零点）
+func parseAuditTime(value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return &parsed, nil
+	}
+	parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func setupAuditRoutes(router *gin.Engine, statsFactory *analytics.StatsFactory) {
+	router.GET("/api/audit", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持审计日志",
+			})
+			return
+		}
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+		since, err := parseAuditTime(c.Query("since"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "since 时间格式错误",
+			})
+			return
+		}
+		until, err := parseAuditTime(c.Query("until"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "until 时间格式错误",
+			})
+			return
+		}
+		// 只给日期时包含当天
+		if until != nil && !strings.Contains(c.Query("until"), "T") {
+			next := until.AddDate(0, 0, 1)
+			until = &next
+		}
+
+		entries, hasMore, err := statsFactory.Repo().ListAuditEntries(store.AuditFilter{
+			Action:    c.Query("action"),
+			Actor:     c.Query("actor"),
+			WebsiteID: c.Query("website_id"),
+			IP:        c.Query("ip"),
+			Since:     since,
+			Until:     until,
+		}, page, pageSize)
+		if err != nil {
+			logrus.WithError(err).Error("读取审计日志失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("读取审计日志失败: %v", err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"entries":  entries,
+			"has_more": hasMore,
+		})
+	})
+}
+
+// auditRepo 返回写审计日志用的仓库，初始化模式下为 nil（不记录）
+func auditRepo(statsFactory *analytics.StatsFactory) *store.Repository {
+	if statsFactory == nil {
+		return nil
+	}
+	return statsFactory.Repo()
+}
+
+// auditError 把失败原因放进审计参数，成功时返回 nil
+func auditError(err error) any {
+	if err == nil {
+		return nil
+	}
+	return gin.H{"error": err.Error()}
+}
+
+func auditErrorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func exportAuditParams(job *LogsExportJob, params map[string]string, err error) gin.H {
+	result := gin.H{"params": params}
+	if job != nil {
+		result["job_id"] = job.ID
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	return result
+}
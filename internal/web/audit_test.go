@@ -0,0 +1,80 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/auth"
+)
+
+func TestParseAuditTime(t *testing.T) {
+	if parsed, err := parseAuditTime("  "); parsed != nil || err != nil {
+		t.Fatalf("empty value: %v %v", parsed, err)
+	}
+	parsed, err := parseAuditTime("2024-05-01T08:30:00+08:00")
+	if err != nil || !parsed.Equal(time.Date(2024, 5, 1, 0, 30, 0, 0, time.UTC)) {
+		t.Fatalf("rfc3339: %v %v", parsed, err)
+	}
+	parsed, err = parseAuditTime("2024-05-01")
+	if err != nil || !parsed.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("date: %v %v", parsed, err)
+	}
+	if _, err := parseAuditTime("05/01/2024"); err == nil {
+		t.Fatal("expected an error for an unsupported format")
+	}
+}
+
+func TestAuditJSON(t *testing.T) {
+	if auditJSON(nil) != nil {
+		t.Fatal("nil params must not be stored")
+	}
+	if got := string(auditJSON(gin.H{"name": "blog"})); got != `{"name":"blog"}` {
+		t.Fatalf("unexpected json %s", got)
+	}
+	if auditJSON(make(chan int)) != nil {
+		t.Fatal("unserializable params must be dropped")
+	}
+	if auditError(nil) != nil || auditErrorText(nil) != "" {
+		t.Fatal("success must not carry an error")
+	}
+}
+
+func TestAuditRouteRequiresAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for role, want := range map[auth.Role]int{
+		auth.RoleViewer: http.StatusForbidden,
+		auth.RoleEditor: http.StatusForbidden,
+		// 初始化模式下没有数据库，管理员通过鉴权后返回 503
+		auth.RoleAdmin: http.StatusServiceUnavailable,
+	} {
+		router := gin.New()
+		principal := &auth.Principal{Kind: auth.PrincipalSession, Role: role}
+		router.Use(func(c *gin.Context) { auth.SetPrincipal(c, principal) })
+		setupAuditRoutes(router, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/audit?since=2024-05-01", nil))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", role, rec.Code, want)
+		}
+	}
+}
+
+func TestNewAuditEntryRecordsPeerAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/config", nil)
+	c.Request.RemoteAddr = "10.0.0.5:4321"
+	c.Request.Header.Set("X-Forwarded-For", "203.0.113.9")
+	auth.SetPrincipal(c, &auth.Principal{Kind: auth.PrincipalAccessKey, Role: auth.RoleAdmin})
+
+	entry := newAuditEntry(c, AuditEvent{Action: "config.save", Success: true})
+	if entry.RemoteIP != "10.0.0.5" {
+		t.Fatalf("remote ip = %q, want the connection peer", entry.RemoteIP)
+	}
+	if entry.IP != c.ClientIP() || entry.Action != "config.save" || !entry.Success {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+}
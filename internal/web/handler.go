@@ -14,6 +14,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
 	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/ingest"
 	"github.com/likaia/nginxpulse/internal/version"
@@ -28,7 +29,8 @@ func SetupRoutes(
 
 	// 获取所有网站列表
 	router.GET("/api/websites", func(c *gin.Context) {
-		websiteIDs := config.GetAllWebsiteIDs()
+		principal := auth.FromContext(c)
+		websiteIDs := principal.FilterWebsiteIDs(config.GetAllWebsiteIDs())
 
 		websites := make([]map[string]string, 0, len(websiteIDs))
 		for _, id := range websiteIDs {
@@ -43,8 +45,16 @@ func SetupRoutes(
 			})
 		}
 
+		groups := make([]config.WebsiteGroup, 0)
+		for _, group := range config.GetWebsiteGroups() {
+			if principal.CanAccessWebsite(group.ID) {
+				groups = append(groups, group)
+			}
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"websites": websites,
+			"groups":   groups,
 		})
 	})
 
@@ -73,6 +83,8 @@ func SetupRoutes(
 			"migration_required":                      migrationRequired,
 			"setup_required":                          config.IsSetupMode(),
 			"config_readonly":                         config.ConfigReadOnly(),
+			"config_version":                          cfg.Version,
+			"config_deprecations":                     cfg.Deprecations(),
 		})
 	})
 
@@ -150,7 +162,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.GET("/api/config", func(c *gin.Context) {
+	router.GET("/api/config", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
 		cfg, err := config.ReadRawConfig()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -158,6 +170,7 @@ func SetupRoutes(
 			})
 			return
 		}
+		config.RedactSecrets(cfg)
 		defaultLogPath := ""
 		if config.IsSetupMode() {
 			defaultLogPath = config.SuggestDefaultLogPath()
@@ -173,8 +186,8 @@ func SetupRoutes(
 		})
 	})
 
-	router.POST("/api/config/validate", func(c *gin.Context) {
-		cfg, err := bindConfigPayload(c)
+	router.POST("/api/config/validate", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
+		cfg, _, err := bindConfigPayload(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "请求参数错误",
@@ -187,7 +200,7 @@ func SetupRoutes(
 		c.JSON(http.StatusOK, result)
 	})
 
-	router.POST("/api/config/save", func(c *gin.Context) {
+	router.POST("/api/config/save", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
 		if config.ConfigReadOnly() {
 			c.JSON(http.StatusForbidden, gin.H{
 				"error": "配置来自环境变量，无法保存",
@@ -195,7 +208,7 @@ func SetupRoutes(
 			return
 		}
 
-		cfg, err := bindConfigPayload(c)
+		cfg, previous, err := bindConfigPayload(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "请求参数错误",
@@ -211,21 +224,88 @@ func SetupRoutes(
 			return
 		}
 
+		changes := config.DiffConfig(previous, cfg)
 		if err := config.WriteConfigFile(cfg); err != nil {
 			logrus.WithError(err).Error("保存配置失败")
+			RecordAudit(c, auditRepo(statsFactory), AuditEvent{
+				Action: "config.save",
+				Params: gin.H{"error": err.Error()},
+				Diff:   changes,
+			})
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": fmt.Sprintf("保存配置失败: %v", err),
 			})
 			return
 		}
+		RecordAudit(c, auditRepo(statsFactory), AuditEvent{
+			Action:  "config.save",
+			Diff:    changes,
+			Success: true,
+		})
 
+		if config.IsSetupMode() {
+			c.JSON(http.StatusOK, gin.H{
+				"success":          true,
+				"restart_required": true,
+			})
+			return
+		}
+		diff, err := config.ReloadConfig()
+		if err != nil {
+			logrus.WithError(err).Warn("配置已保存，但热加载失败")
+			c.JSON(http.StatusOK, gin.H{
+				"success":          true,
+				"restart_required": true,
+				"reload_error":     err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"success":          true,
-			"restart_required": true,
+			"reloaded":         true,
+			"restart_required": len(diff.RestartRequired) > 0,
+			"diff":             diff,
 		})
 	})
 
-	router.POST("/api/system/restart", func(c *gin.Context) {
+	router.POST("/api/config/reload", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
+		if config.IsSetupMode() {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "初始化模式不支持热加载，请保存配置后重启服务",
+			})
+			return
+		}
+		diff, err := config.ReloadConfig()
+		RecordAudit(c, auditRepo(statsFactory), AuditEvent{
+			Action:  "config.reload",
+			Params:  auditError(err),
+			Diff:    diff,
+			Success: err == nil,
+		})
+		if err != nil {
+			var validationErr *config.ReloadValidationError
+			switch {
+			case errors.As(err, &validationErr):
+				c.JSON(http.StatusBadRequest, validationErr.Result)
+			case errors.Is(err, config.ErrReloadInProgress):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":          true,
+			"restart_required": len(diff.RestartRequired) > 0,
+			"diff":             diff,
+		})
+	})
+
+	router.POST("/api/system/restart", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
+		RecordAudit(c, auditRepo(statsFactory), AuditEvent{
+			Action:  "system.restart",
+			Success: true,
+		})
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
 		})
@@ -245,7 +325,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.POST("/api/logs/reparse", func(c *gin.Context) {
+	router.POST("/api/logs/reparse", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		if logParser == nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error": "初始化模式暂不支持日志解析",
@@ -274,8 +354,18 @@ func SetupRoutes(
 				return
 			}
 		}
+		if !auth.CheckWebsite(c, websiteScopeOrAll(websiteID)) {
+			return
+		}
 
-		if err := logParser.TriggerReparse(websiteID); err != nil {
+		err := logParser.TriggerReparse(websiteID)
+		RecordAudit(c, auditRepo(statsFactory), AuditEvent{
+			Action:    "logs.reparse",
+			WebsiteID: websiteID,
+			Params:    gin.H{"migration": req.Migration, "error": auditErrorText(err)},
+			Success:   err == nil,
+		})
+		if err != nil {
 			if errors.Is(err, ingest.ErrParsingInProgress) {
 				c.JSON(http.StatusConflict, gin.H{
 					"error": err.Error(),
@@ -301,7 +391,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.GET("/api/ip-geo/failures", func(c *gin.Context) {
+	router.GET("/api/ip-geo/failures", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		if statsFactory == nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error": "初始化模式暂不支持失败记录",
@@ -313,6 +403,9 @@ func SetupRoutes(
 		websiteID := strings.TrimSpace(c.DefaultQuery("id", ""))
 		reason := strings.TrimSpace(c.DefaultQuery("reason", ""))
 		keyword := strings.TrimSpace(c.DefaultQuery("keyword", ""))
+		if !auth.CheckWebsite(c, websiteScopeOrAll(websiteID)) {
+			return
+		}
 
 		repo := statsFactory.Repo()
 		failures, hasMore, err := repo.ListIPGeoAPIFailuresFiltered(websiteID, reason, keyword, page, pageSize)
@@ -329,7 +422,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.GET("/api/ip-geo/failures/export", func(c *gin.Context) {
+	router.GET("/api/ip-geo/failures/export", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		if statsFactory == nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error": "初始化模式暂不支持失败记录导出",
@@ -340,6 +433,9 @@ func SetupRoutes(
 		websiteID := strings.TrimSpace(c.DefaultQuery("id", ""))
 		reason := strings.TrimSpace(c.DefaultQuery("reason", ""))
 		keyword := strings.TrimSpace(c.DefaultQuery("keyword", ""))
+		if !auth.CheckWebsite(c, websiteScopeOrAll(websiteID)) {
+			return
+		}
 		websiteLabel := "all"
 		if websiteID != "" {
 			if site, ok := config.GetWebsiteByID(websiteID); ok && strings.TrimSpace(site.Name) != "" {
@@ -392,13 +488,19 @@ func SetupRoutes(
 			return
 		}
 
+		RecordAudit(c, repo, AuditEvent{
+			Action:    "ip_geo.failures.export",
+			WebsiteID: websiteID,
+			Params:    gin.H{"reason": reason, "keyword": keyword},
+			Success:   true,
+		})
 		filename := fmt.Sprintf("ip_geo_failures_%s.csv", time.Now().Format("20060102_150405"))
 		c.Header("Content-Type", "text/csv; charset=utf-8")
 		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 		c.String(http.StatusOK, buffer.String())
 	})
 
-	router.GET("/api/logs/export", func(c *gin.Context) {
+	router.GET("/api/logs/export", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		if statsFactory == nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error": "初始化模式暂不支持日志导出",
@@ -433,7 +535,16 @@ func SetupRoutes(
 			})
 			return
 		}
+		if !auth.CheckWebsite(c, query.WebsiteID) {
+			return
+		}
 
+		RecordAudit(c, statsFactory.Repo(), AuditEvent{
+			Action:    "logs.export.download",
+			WebsiteID: query.WebsiteID,
+			Params:    params,
+			Success:   true,
+		})
 		filename := fmt.Sprintf("nginxpulse_logs_%s.xlsx", time.Now().Format("20060102_150405"))
 		c.Header("Content-Type", logsExportContentType)
 		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
@@ -445,7 +556,7 @@ func SetupRoutes(
 		}
 	})
 
-	router.POST("/api/logs/export", func(c *gin.Context) {
+	router.POST("/api/logs/export", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		if statsFactory == nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error": "初始化模式暂不支持日志导出",
@@ -490,9 +601,18 @@ func SetupRoutes(
 			})
 			return
 		}
+		if !auth.CheckWebsite(c, query.WebsiteID) {
+			return
+		}
 
 		lang := params["lang"]
 		job, err := exportJobs.Create(statsFactory, query, lang, params)
+		RecordAudit(c, statsFactory.Repo(), AuditEvent{
+			Action:    "logs.export.create",
+			WebsiteID: query.WebsiteID,
+			Params:    exportAuditParams(job, params, err),
+			Success:   err == nil,
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
@@ -507,7 +627,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.GET("/api/logs/export/status", func(c *gin.Context) {
+	router.GET("/api/logs/export/status", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		jobID := strings.TrimSpace(c.Query("id"))
 		if jobID == "" {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -522,6 +642,9 @@ func SetupRoutes(
 			})
 			return
 		}
+		if !auth.CheckWebsite(c, job.WebsiteID) {
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"id":         job.ID,
 			"status":     job.Status,
@@ -535,7 +658,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.GET("/api/logs/export/list", func(c *gin.Context) {
+	router.GET("/api/logs/export/list", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		websiteID := strings.TrimSpace(c.Query("website_id"))
 		if websiteID == "" {
 			websiteID = strings.TrimSpace(c.Query("websiteId"))
@@ -543,6 +666,9 @@ func SetupRoutes(
 		if websiteID == "" {
 			websiteID = strings.TrimSpace(c.Query("id"))
 		}
+		if !auth.CheckWebsite(c, websiteScopeOrAll(websiteID)) {
+			return
+		}
 		page := 1
 		pageSize := 20
 		if rawPage := strings.TrimSpace(c.Query("page")); rawPage != "" {
@@ -564,7 +690,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.POST("/api/logs/export/cancel", func(c *gin.Context) {
+	router.POST("/api/logs/export/cancel", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		type cancelRequest struct {
 			ID string `json:"id"`
 		}
@@ -582,6 +708,9 @@ func SetupRoutes(
 			})
 			return
 		}
+		if existing, ok := exportJobs.Get(jobID); ok && !auth.CheckWebsite(c, existing.WebsiteID) {
+			return
+		}
 		job, err := exportJobs.Cancel(jobID)
 		if err != nil {
 			c.JSON(http.StatusConflict, gin.H{
@@ -595,7 +724,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.POST("/api/logs/export/retry", func(c *gin.Context) {
+	router.POST("/api/logs/export/retry", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		type retryRequest struct {
 			ID string `json:"id"`
 		}
@@ -634,8 +763,17 @@ func SetupRoutes(
 			})
 			return
 		}
+		if !auth.CheckWebsite(c, query.WebsiteID) {
+			return
+		}
 		lang := params["lang"]
 		job, err := exportJobs.Create(statsFactory, query, lang, params)
+		RecordAudit(c, statsFactory.Repo(), AuditEvent{
+			Action:    "logs.export.retry",
+			WebsiteID: query.WebsiteID,
+			Params:    exportAuditParams(job, params, err),
+			Success:   err == nil,
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
@@ -649,7 +787,7 @@ func SetupRoutes(
 		})
 	})
 
-	router.GET("/api/logs/export/download", func(c *gin.Context) {
+	router.GET("/api/logs/export/download", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
 		jobID := strings.TrimSpace(c.Query("id"))
 		if jobID == "" {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -664,6 +802,9 @@ func SetupRoutes(
 			})
 			return
 		}
+		if !auth.CheckWebsite(c, job.WebsiteID) {
+			return
+		}
 		websiteID := strings.TrimSpace(c.Query("website_id"))
 		if websiteID == "" {
 			websiteID = strings.TrimSpace(c.Query("websiteId"))
@@ -693,6 +834,12 @@ func SetupRoutes(
 			return
 		}
 
+		RecordAudit(c, auditRepo(statsFactory), AuditEvent{
+			Action:    "logs.export.download",
+			WebsiteID: job.WebsiteID,
+			Params:    gin.H{"job_id": job.ID, "file": job.FileName},
+			Success:   true,
+		})
 		filename := job.FileName
 		if filename == "" {
 			filename = fmt.Sprintf("nginxpulse_logs_%s.xlsx", time.Now().Format("20060102_150405"))
@@ -703,7 +850,7 @@ func SetupRoutes(
 		c.File(job.FilePath)
 	})
 
-	router.POST("/api/ingest/logs", func(c *gin.Context) {
+	router.POST("/api/ingest/logs", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
 		if logParser == nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error": "初始化模式暂不支持日志解析",
@@ -714,6 +861,8 @@ func SetupRoutes(
 			WebsiteID string   `json:"website_id"`
 			SourceID  string   `json:"source_id"`
 			Lines     []string `json:"lines"`
+			// SampleRates 可选，与 Lines 一一对应的 agent 采样率。
+			SampleRates []float64 `json:"sample_rates"`
 		}
 
 		var req ingestRequest
@@ -744,7 +893,26 @@ func SetupRoutes(
 			return
 		}
 
-		accepted, deduped, err := logParser.IngestLines(websiteID, strings.TrimSpace(req.SourceID), req.Lines)
+		if len(req.SampleRates) > 0 && len(req.SampleRates) != len(req.Lines) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "采样率数量与日志行数不一致",
+			})
+			return
+		}
+
+		accepted, deduped, err := logParser.IngestSampledLines(websiteID, strings.TrimSpace(req.SourceID), req.Lines, req.SampleRates)
+		RecordAudit(c, auditRepo(statsFactory), AuditEvent{
+			Action:    "ingest.logs",
+			WebsiteID: websiteID,
+			Params: gin.H{
+				"source_id": strings.TrimSpace(req.SourceID),
+				"lines":     len(req.Lines),
+				"accepted":  accepted,
+				"deduped":   deduped,
+				"error":     auditErrorText(err),
+			},
+			Success: err == nil,
+		})
 		if err != nil {
 			logrus.WithError(err).Error("日志推送解析失败")
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -761,6 +929,16 @@ func SetupRoutes(
 		})
 	})
 
+	setupIngestV2Routes(router, statsFactory, logParser)
+	setupAgentRoutes(router, statsFactory, logParser)
+	setupSavedViewRoutes(router, statsFactory)
+	setupReportRoutes(router, statsFactory)
+	setupQueryRoutes(router, statsFactory)
+	setupUserRoutes(router, statsFactory)
+	setupAuditRoutes(router, statsFactory)
+	setupAPITokenRoutes(router, statsFactory)
+	setupPublicRoutes(router, statsFactory)
+
 	// 查询接口
 	router.GET("/api/stats/:type", func(c *gin.Context) {
 		if statsFactory == nil {
@@ -770,6 +948,12 @@ func SetupRoutes(
 			return
 		}
 		statsType := c.Param("type")
+		if !auth.FromContext(c).CanQueryStats(statsType) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "无权查询该统计类型",
+			})
+			return
+		}
 		params := make(map[string]string)
 		for key, values := range c.Request.URL.Query() {
 			if len(values) > 0 {
@@ -785,9 +969,18 @@ func SetupRoutes(
 			})
 			return
 		}
+		if !auth.CheckWebsite(c, query.WebsiteID) {
+			return
+		}
 
 		// 执行查询
 		result, err := statsFactory.QueryStats(statsType, query)
+		if errors.Is(err, analytics.ErrAnomaliesSegment) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
 		if err != nil {
 			logrus.WithError(err).Errorf("查询统计数据[%s]失败", statsType)
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -801,7 +994,17 @@ func SetupRoutes(
 
 }
 
-func bindConfigPayload(c *gin.Context) (*config.Config, error) {
+// websiteScopeOrAll 把表示“全部站点”的空 id 转为 all，用于站点范围校验
+func websiteScopeOrAll(websiteID string) string {
+	if websiteID == "" {
+		return config.ScopeAllWebsites
+	}
+	return websiteID
+}
+
+// bindConfigPayload 读取提交的配置，并把仍为脱敏占位符的密钥恢复为当前配置中的原值。
+// 同时返回当前配置（未解析密钥引用），读取失败时为 nil。
+func bindConfigPayload(c *gin.Context) (*config.Config, *config.Config, error) {
 	payload := struct {
 		Config config.Config `json:"config"`
 	}{
@@ -809,9 +1012,14 @@ func bindConfigPayload(c *gin.Context) (*config.Config, error) {
 	}
 
 	if err := c.ShouldBindJSON(&payload); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	previous, err := config.ReadRawConfig()
+	if err != nil {
+		previous = nil
 	}
-	return &payload.Config, nil
+	config.RestoreRedactedSecrets(&payload.Config, previous)
+	return &payload.Config, previous, nil
 }
 
 func migrationMarkerPath() string {
@@ -0,0 +1,430 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/likaia/nginxpulse/internal/agentproto"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxIngestBodyBytes         = 32 << 20
+	maxIngestDecompressedBytes = 256 << 20
+)
+
+var errIngestBodyTooLarge = errors.New("请求体过大")
+
+// IsAgentIngestPath 判断是否为允许使用 agent 令牌访问的推送接口（由访问密钥中间件放行，在此处鉴权）。
+func IsAgentIngestPath(path string) bool {
+	return path == agentproto.CapabilitiesPath || path == agentproto.IngestV2Path || path == agentproto.HeartbeatPath
+}
+
+// agentAuth 是一次 v2 推送请求的鉴权结果。
+type agentAuth struct {
+	// viaToken 表示请求携带了有效的 agent 令牌；否则请求已通过访问密钥中间件，按请求主体鉴权。
+	viaToken bool
+	// websiteID 非空时表示令牌绑定的站点，请求只能写入该站点。
+	websiteID  string
+	agentID    string
+	hmacSecret string
+}
+
+// authenticateAgent 校验 agent 令牌；未携带令牌时表示请求已通过访问密钥中间件（或未启用访问密钥）。
+func authenticateAgent(c *gin.Context) (agentAuth, bool) {
+	token := strings.TrimSpace(c.GetHeader(agentproto.HeaderAgentToken))
+	if token == "" {
+		return agentAuth{}, true
+	}
+	cfg := config.ReadConfig()
+	for _, item := range cfg.System.AgentTokens {
+		expected := strings.TrimSpace(item.Token)
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+			continue
+		}
+		return agentAuth{
+			viaToken:   true,
+			websiteID:  strings.TrimSpace(item.WebsiteID),
+			agentID:    strings.TrimSpace(item.AgentID),
+			hmacSecret: item.HMACSecret,
+		}, true
+	}
+	return agentAuth{}, false
+}
+
+// requireAgentAuth 完成写入类推送接口的鉴权：携带 agent 令牌时按令牌校验；
+// 未携带令牌时与 /api/ingest/logs 一致，要求请求主体为管理员。失败时写出响应并返回 false。
+func requireAgentAuth(c *gin.Context) (agentAuth, bool) {
+	agent, ok := authenticateAgent(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "agent 令牌无效",
+		})
+		return agent, false
+	}
+	if !agent.viaToken && !auth.FromContext(c).Role.Includes(auth.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "权限不足",
+		})
+		return agent, false
+	}
+	return agent, true
+}
+
+func setupIngestV2Routes(router *gin.Engine, statsFactory *analytics.StatsFactory, logParser *ingest.LogParser) {
+	router.GET(agentproto.CapabilitiesPath, func(c *gin.Context) {
+		if _, ok := authenticateAgent(c); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "agent 令牌无效",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, agentproto.Capabilities{
+			Versions:   []int{1, 2},
+			Encodings:  []string{agentproto.EncodingZstd, agentproto.EncodingGzip, agentproto.EncodingIdentity},
+			Signatures: []string{"hmac-sha256"},
+			Features:   []string{agentproto.FeatureHeartbeat},
+		})
+	})
+
+	router.POST(agentproto.IngestV2Path, func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持日志解析",
+			})
+			return
+		}
+		agent, ok := requireAgentAuth(c)
+		if !ok {
+			return
+		}
+
+		meta, err := parseBatchMeta(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if agent.websiteID != "" && meta.WebsiteID != agent.websiteID {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "agent 令牌未授权该站点",
+			})
+			return
+		}
+		if agent.agentID != "" && meta.AgentID != agent.agentID {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "agent 令牌与 agent ID 不匹配",
+			})
+			return
+		}
+		if !agent.viaToken && !auth.CheckWebsite(c, meta.WebsiteID) {
+			return
+		}
+		if _, ok := config.GetWebsiteByID(meta.WebsiteID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "站点不存在",
+			})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIngestBodyBytes+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "读取请求体失败",
+			})
+			return
+		}
+		if len(body) > maxIngestBodyBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": errIngestBodyTooLarge.Error(),
+			})
+			return
+		}
+
+		signature := strings.TrimSpace(c.GetHeader(agentproto.HeaderSignature))
+		if agent.hmacSecret != "" || signature != "" {
+			if agent.hmacSecret == "" || !agentproto.Verify(agent.hmacSecret, meta, body, signature, time.Now()) {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "签名校验失败",
+				})
+				return
+			}
+		}
+
+		lines, sampleRates, err := decodeNDJSONBatch(c.GetHeader("Content-Encoding"), body)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errIngestBodyTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		result, err := logParser.IngestAgentBatch(ingest.AgentBatch{
+			AgentID:     meta.AgentID,
+			WebsiteID:   meta.WebsiteID,
+			SourceID:    meta.SourceID,
+			StreamID:    meta.StreamID,
+			Seq:         meta.Seq,
+			Lines:       lines,
+			SampleRates: sampleRates,
+		})
+		if err != nil {
+			logrus.WithError(err).Error("日志推送解析失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("解析失败: %v", err),
+			})
+			return
+		}
+
+		if result.Accepted > 0 {
+			statsFactory.ClearCache()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"accepted":  result.Accepted,
+			"deduped":   result.Deduped,
+			"duplicate": result.Duplicate,
+			"ack_seq":   result.AckSeq,
+		})
+	})
+
+	// 心跳不做签名校验：时钟偏差正是心跳要暴露的问题，签名时间戳会因此被拒绝。
+	router.POST(agentproto.HeartbeatPath, func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持 agent 心跳",
+			})
+			return
+		}
+		agent, ok := requireAgentAuth(c)
+		if !ok {
+			return
+		}
+		receivedAt := time.Now()
+
+		var hb agentproto.Heartbeat
+		if err := c.ShouldBindJSON(&hb); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "无效的心跳数据",
+			})
+			return
+		}
+		hb.AgentID = strings.TrimSpace(hb.AgentID)
+		if hb.AgentID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "agentID 不能为空",
+			})
+			return
+		}
+		// 心跳按 agentID 更新 agent 记录，未限定 agentId 的令牌可以冒充任意 agent，不允许上报
+		if agent.viaToken && agent.agentID == "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "上报心跳需要限定 agentId 的 agent 令牌",
+			})
+			return
+		}
+		if agent.agentID != "" && hb.AgentID != agent.agentID {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "agent 令牌与 agent ID 不匹配",
+			})
+			return
+		}
+		if agent.websiteID != "" {
+			for _, file := range hb.Files {
+				if websiteID := strings.TrimSpace(file.WebsiteID); websiteID != "" && websiteID != agent.websiteID {
+					c.JSON(http.StatusForbidden, gin.H{
+						"error": "agent 令牌未授权该站点",
+					})
+					return
+				}
+			}
+		}
+
+		ack, err := logParser.RecordAgentHeartbeat(hb, c.ClientIP(), receivedAt)
+		if err != nil {
+			logrus.WithError(err).Error("记录 agent 心跳失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("记录心跳失败: %v", err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, ack)
+	})
+}
+
+func setupAgentRoutes(router *gin.Engine, statsFactory *analytics.StatsFactory, logParser *ingest.LogParser) {
+	router.GET("/api/agents", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持 agent 列表",
+			})
+			return
+		}
+		agents, err := logParser.ListAgentStatuses(time.Now())
+		if err != nil {
+			logrus.WithError(err).Error("读取 agent 列表失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "读取 agent 列表失败",
+			})
+			return
+		}
+		online := 0
+		for _, agent := range agents {
+			if agent.Online {
+				online++
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"agents":  agents,
+			"total":   len(agents),
+			"online":  online,
+			"missing": len(agents) - online,
+		})
+	})
+
+	router.DELETE("/api/agents/:id", auth.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持 agent 列表",
+			})
+			return
+		}
+		agentID := strings.TrimSpace(c.Param("id"))
+		if agentID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "agentID 不能为空",
+			})
+			return
+		}
+		if err := logParser.DeleteAgent(agentID); err != nil {
+			logrus.WithError(err).Error("删除 agent 失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "删除 agent 失败",
+			})
+			return
+		}
+		RecordAudit(c, auditRepo(statsFactory), AuditEvent{
+			Action:  "agents.delete",
+			Params:  gin.H{"agent_id": agentID},
+			Success: true,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	})
+}
+
+func parseBatchMeta(c *gin.Context) (agentproto.BatchMeta, error) {
+	meta := agentproto.BatchMeta{
+		AgentID:   strings.TrimSpace(c.GetHeader(agentproto.HeaderAgentID)),
+		WebsiteID: strings.TrimSpace(c.GetHeader(agentproto.HeaderWebsiteID)),
+		SourceID:  strings.TrimSpace(c.GetHeader(agentproto.HeaderSourceID)),
+		StreamID:  strings.TrimSpace(c.GetHeader(agentproto.HeaderStreamID)),
+	}
+	if meta.WebsiteID == "" {
+		return meta, errors.New("缺少站点ID")
+	}
+	if raw := strings.TrimSpace(c.GetHeader(agentproto.HeaderBatchSeq)); raw != "" {
+		seq, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seq < 0 {
+			return meta, errors.New("批次序号格式错误")
+		}
+		meta.Seq = seq
+	}
+	if raw := strings.TrimSpace(c.GetHeader(agentproto.HeaderTimestamp)); raw != "" {
+		ts, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return meta, errors.New("时间戳格式错误")
+		}
+		meta.Timestamp = ts
+	}
+	return meta, nil
+}
+
+// decodeNDJSONBatch 解压并解析 NDJSON；所有行都未采样时返回的 sampleRates 为 nil。
+func decodeNDJSONBatch(encoding string, body []byte) ([]string, []float64, error) {
+	var reader io.Reader = bytes.NewReader(body)
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", agentproto.EncodingIdentity:
+	case agentproto.EncodingGzip:
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gzip 解压失败: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	case agentproto.EncodingZstd:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zstd 解压失败: %w", err)
+		}
+		defer decoder.Close()
+		reader = decoder
+	default:
+		return nil, nil, fmt.Errorf("不支持的压缩格式: %s", encoding)
+	}
+
+	limited := &io.LimitedReader{R: reader, N: maxIngestDecompressedBytes + 1}
+	scanner := bufio.NewScanner(limited)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	lines := make([]string, 0, 256)
+	var sampleRates []float64
+	for scanner.Scan() {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var record agentproto.Record
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return nil, nil, fmt.Errorf("NDJSON 格式错误: %w", err)
+		}
+		if record.Line == "" {
+			continue
+		}
+		if record.SampleRate > 0 && record.SampleRate < 1 && sampleRates == nil {
+			sampleRates = make([]float64, len(lines), cap(lines))
+			for j := range sampleRates {
+				sampleRates[j] = 1
+			}
+		}
+		if sampleRates != nil {
+			rate := record.SampleRate
+			if rate <= 0 || rate > 1 {
+				rate = 1
+			}
+			sampleRates = append(sampleRates, rate)
+		}
+		lines = append(lines, record.Line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("读取请求体失败: %w", err)
+	}
+	if limited.N <= 0 {
+		return nil, nil, errIngestBodyTooLarge
+	}
+	if len(lines) == 0 {
+		return nil, nil, errors.New("日志内容为空")
+	}
+	return lines, sampleRates, nil
+}
@@ -0,0 +1,116 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/likaia/nginxpulse/internal/agentproto"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
+)
+
+const ndjsonBody = `{"line":"GET /a 200"}
+
+{"line":"GET /b 200","sample_rate":0.25}
+{"line":""}
+{"line":"GET /c 200","sample_rate":7}
+`
+
+func TestDecodeNDJSONBatchEncodings(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(ndjsonBody))
+	gz.Close()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstded := encoder.EncodeAll([]byte(ndjsonBody), nil)
+	encoder.Close()
+
+	bodies := map[string][]byte{
+		"":                          []byte(ndjsonBody),
+		agentproto.EncodingIdentity: []byte(ndjsonBody),
+		agentproto.EncodingGzip:     gzipped.Bytes(),
+		" ZSTD ":                    zstded,
+	}
+	for encoding, body := range bodies {
+		lines, rates, err := decodeNDJSONBatch(encoding, body)
+		if err != nil {
+			t.Fatalf("encoding %q: %v", encoding, err)
+		}
+		if len(lines) != 3 || lines[0] != "GET /a 200" || lines[2] != "GET /c 200" {
+			t.Fatalf("encoding %q: unexpected lines %q", encoding, lines)
+		}
+		// 出现采样行后，之前与之后的行都补齐为 1，非法采样率按未采样处理
+		if len(rates) != 3 || rates[0] != 1 || rates[1] != 0.25 || rates[2] != 1 {
+			t.Fatalf("encoding %q: unexpected sample rates %v", encoding, rates)
+		}
+	}
+}
+
+func TestDecodeNDJSONBatchErrors(t *testing.T) {
+	lines, rates, err := decodeNDJSONBatch("", []byte(`{"line":"GET / 200"}`))
+	if err != nil || len(lines) != 1 || rates != nil {
+		t.Fatalf("unsampled batch: lines=%q rates=%v err=%v", lines, rates, err)
+	}
+	cases := map[string]struct {
+		encoding string
+		body     string
+	}{
+		"bad json":    {"", "GET / 200\n"},
+		"empty":       {"", "\n{\"line\":\"\"}\n"},
+		"bad gzip":    {agentproto.EncodingGzip, "not gzip"},
+		"unsupported": {"br", `{"line":"GET / 200"}`},
+	}
+	for name, tc := range cases {
+		if _, _, err := decodeNDJSONBatch(tc.encoding, []byte(tc.body)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHeartbeatTokenAuthorization(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("CONFIG_JSON", `{"version":2,"database":{"dsn":"postgres://localhost/test"},"websites":[{"name":"blog","sources":[{"id":"blog","type":"local","path":"/var/log/blog.log"}]}],
+		"system":{"agentTokens":[
+			{"token":"open","websiteId":"site-a"},
+			{"token":"bound","websiteId":"site-a","agentId":"agent-1"}]}}`)
+	if _, err := config.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	setupIngestV2Routes(router, nil, &ingest.LogParser{})
+
+	cases := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"unknown token", "nope", `{"agent_id":"agent-1"}`, http.StatusUnauthorized},
+		{"token without agentId", "open", `{"agent_id":"agent-1"}`, http.StatusForbidden},
+		{"other agent", "bound", `{"agent_id":"agent-2"}`, http.StatusForbidden},
+		{"other website", "bound", `{"agent_id":"agent-1","files":[{"website_id":"site-b"}]}`, http.StatusForbidden},
+		{"missing agent id", "bound", `{}`, http.StatusBadRequest},
+		// 通过鉴权后才会写入注册表，测试中没有数据库
+		{"authorized", "bound", `{"agent_id":"agent-1","files":[{"website_id":"site-a"}]}`, http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, agentproto.HeartbeatPath, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(agentproto.HeaderAgentToken, tc.token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d (%s)", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+}
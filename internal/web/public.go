@@ -0,0 +1,273 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	publicPathPrefix = "/api/public/"
+	// publicCacheTTL 为公开统计的缓存时间，匿名访问不会直接打到数据库
+	publicCacheTTL = 5 * time.Minute
+	// publicQueryLimit 为明细查询的行数，去掉查询参数合并后再取前 publicTopLimit 行
+	publicQueryLimit   = 100
+	publicTopLimit     = 10
+	publicDefaultRange = "last7days"
+)
+
+var publicTimeRanges = map[string]bool{
+	"today":      true,
+	"yesterday":  true,
+	"week":       true,
+	"last7days":  true,
+	"month":      true,
+	"last30days": true,
+}
+
+// IsPublicDashboardPath 判断是否为公开统计接口（由访问密钥中间件放行，在此处校验站点是否公开）。
+func IsPublicDashboardPath(path string) bool {
+	return strings.HasPrefix(path, publicPathPrefix)
+}
+
+type publicRow struct {
+	Key string `json:"key"`
+	PV  int    `json:"pv"`
+	UV  int    `json:"uv"`
+}
+
+// publicQuery 描述一种公开统计：查询的统计类型与参数，以及把结果转换为公开数据的方法。
+// 转换结果只包含聚合计数，不含 IP、原始日志与会话。
+type publicQuery struct {
+	statsType string
+	params    func(timeRange string) map[string]string
+	project   func(result analytics.StatsResult, roundTo int) interface{}
+}
+
+var publicQueries = map[string]publicQuery{
+	"overall": {
+		statsType: "overall",
+		params: func(timeRange string) map[string]string {
+			return map[string]string{"timeRange": timeRange}
+		},
+		project: func(result analytics.StatsResult, roundTo int) interface{} {
+			stats, _ := result.(analytics.OverallStats)
+			return gin.H{
+				"pv":       roundPublicCount(stats.PV, roundTo),
+				"uv":       roundPublicCount(stats.UV, roundTo),
+				"sessions": roundPublicCount(stats.SessionCount, roundTo),
+			}
+		},
+	},
+	"timeseries": {
+		statsType: "timeseries",
+		params: func(timeRange string) map[string]string {
+			viewType := "daily"
+			if timeRange == "today" || timeRange == "yesterday" {
+				viewType = "hourly"
+			}
+			return map[string]string{"timeRange": timeRange, "viewType": viewType}
+		},
+		project: func(result analytics.StatsResult, roundTo int) interface{} {
+			stats, _ := result.(analytics.TimeSeriesStats)
+			pageviews := make([]int, len(stats.Pageviews))
+			for i, value := range stats.Pageviews {
+				pageviews[i] = roundPublicCount(value, roundTo)
+			}
+			visitors := make([]int, len(stats.Visitors))
+			for i, value := range stats.Visitors {
+				visitors[i] = roundPublicCount(value, roundTo)
+			}
+			return gin.H{
+				"labels":    stats.Labels,
+				"pageviews": pageviews,
+				"visitors":  visitors,
+			}
+		},
+	},
+	"pages": {
+		statsType: "url",
+		params:    publicListParams(nil),
+		project:   publicListProjector(publicPageKey),
+	},
+	"referrers": {
+		statsType: "referer",
+		params:    publicListParams(nil),
+		project:   publicListProjector(publicRefererKey),
+	},
+	"countries": {
+		statsType: "location",
+		params:    publicListParams(map[string]string{"locationType": "global"}),
+		project:   publicListProjector(strings.TrimSpace),
+	},
+}
+
+func publicListParams(extra map[string]string) func(string) map[string]string {
+	return func(timeRange string) map[string]string {
+		params := map[string]string{
+			"timeRange": timeRange,
+			"limit":     strconv.Itoa(publicQueryLimit),
+		}
+		for key, value := range extra {
+			params[key] = value
+		}
+		return params
+	}
+}
+
+// publicListProjector 按 normalize 后的键合并明细行（合并行的 UV 为各行之和，是近似值），
+// 丢弃 PV 低于取整粒度的行，按 PV 倒序取前 publicTopLimit 行。
+func publicListProjector(normalize func(string) string) func(analytics.StatsResult, int) interface{} {
+	return func(result analytics.StatsResult, roundTo int) interface{} {
+		stats, _ := result.(analytics.ClientStats)
+		merged := make(map[string]*publicRow, len(stats.Key))
+		for i, raw := range stats.Key {
+			key := normalize(raw)
+			if key == "" || i >= len(stats.PV) || i >= len(stats.UV) {
+				continue
+			}
+			row, ok := merged[key]
+			if !ok {
+				row = &publicRow{Key: key}
+				merged[key] = row
+			}
+			row.PV += stats.PV[i]
+			row.UV += stats.UV[i]
+		}
+		rows := make([]publicRow, 0, len(merged))
+		for _, row := range merged {
+			if row.PV < roundTo {
+				continue
+			}
+			rows = append(rows, publicRow{
+				Key: row.Key,
+				PV:  roundPublicCount(row.PV, roundTo),
+				UV:  roundPublicCount(row.UV, roundTo),
+			})
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].PV != rows[j].PV {
+				return rows[i].PV > rows[j].PV
+			}
+			return rows[i].Key < rows[j].Key
+		})
+		if len(rows) > publicTopLimit {
+			rows = rows[:publicTopLimit]
+		}
+		return gin.H{"items": rows}
+	}
+}
+
+// publicPageKey 去掉查询参数与锚点，避免公开链接中的令牌等信息
+func publicPageKey(raw string) string {
+	key := strings.TrimSpace(raw)
+	if idx := strings.IndexAny(key, "?#"); idx >= 0 {
+		key = key[:idx]
+	}
+	return key
+}
+
+// publicRefererKey 只保留来源域名；直接访问与站内访问保留原有的分类名称
+func publicRefererKey(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "直接输入网址访问" || raw == "站内访问" {
+		return raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// roundPublicCount 按粒度四舍五入
+func roundPublicCount(value, roundTo int) int {
+	if roundTo <= 1 || value <= 0 {
+		return value
+	}
+	return (value + roundTo/2) / roundTo * roundTo
+}
+
+func setupPublicRoutes(router *gin.Engine, statsFactory *analytics.StatsFactory) {
+	cache := analytics.NewStatsCache()
+
+	router.GET(publicPathPrefix+"websites/:id/:type", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持公开统计",
+			})
+			return
+		}
+		websiteID := strings.TrimSpace(c.Param("id"))
+		public, ok := config.GetPublicDashboard(websiteID)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "站点不存在或未公开",
+			})
+			return
+		}
+		statsName := c.Param("type")
+		spec, ok := publicQueries[statsName]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "不支持的公开统计类型",
+			})
+			return
+		}
+		timeRange := strings.TrimSpace(c.Query("timeRange"))
+		if timeRange == "" {
+			timeRange = publicDefaultRange
+		}
+		if !publicTimeRanges[timeRange] {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "timeRange 仅支持 today、yesterday、week、last7days、month、last30days",
+			})
+			return
+		}
+
+		cacheKey := strings.Join([]string{websiteID, statsName, timeRange, strconv.Itoa(public.RoundTo)}, "|")
+		if cached, ok := cache.Get(cacheKey, publicCacheTTL); ok {
+			c.Header("Cache-Control", "public, max-age=60")
+			c.JSON(http.StatusOK, cached)
+			return
+		}
+
+		params := spec.params(timeRange)
+		params["id"] = websiteID
+		query, err := statsFactory.BuildQueryFromRequest(spec.statsType, params)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		result, err := statsFactory.QueryStats(spec.statsType, query)
+		if err != nil {
+			logrus.WithError(err).Errorf("查询站点[%s]公开统计 %s 失败", websiteID, statsName)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "查询失败",
+			})
+			return
+		}
+		website, _ := config.GetWebsiteByID(websiteID)
+		response := gin.H{
+			"website":      gin.H{"id": websiteID, "name": website.Name},
+			"type":         statsName,
+			"time_range":   timeRange,
+			"round_to":     public.RoundTo,
+			"generated_at": time.Now(),
+			"data":         spec.project(result, public.RoundTo),
+		}
+		cache.Set(cacheKey, response)
+		c.Header("Cache-Control", "public, max-age=60")
+		c.JSON(http.StatusOK, response)
+	})
+}
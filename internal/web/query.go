@@ -0,0 +1,65 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/querylang"
+	"github.com/sirupsen/logrus"
+)
+
+func setupQueryRoutes(router *gin.Engine, statsFactory *analytics.StatsFactory) {
+	// 表达式查询：语句只能引用白名单字段，字面量均作为绑定参数
+	router.POST("/api/query", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持统计查询",
+			})
+			return
+		}
+		if !config.GetQueryAPILimits().Enabled {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "表达式查询已关闭",
+			})
+			return
+		}
+		var req analytics.AdhocQueryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		if !auth.CheckWebsite(c, strings.TrimSpace(req.WebsiteID)) {
+			return
+		}
+
+		result, err := statsFactory.RunAdhocQuery(req)
+		if err != nil {
+			var queryErr *querylang.Error
+			switch {
+			case errors.As(err, &queryErr):
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":    queryErr.Error(),
+					"position": queryErr.Pos,
+				})
+			case errors.Is(err, analytics.ErrAdhocQueryTimeout):
+				c.JSON(http.StatusGatewayTimeout, gin.H{
+					"error": "查询超时，请缩小时间范围或减少分组维度",
+				})
+			default:
+				logrus.WithError(err).WithField("website", req.WebsiteID).Error("表达式查询失败")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+			}
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+}
@@ -0,0 +1,168 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+	"github.com/likaia/nginxpulse/internal/worker"
+	"github.com/sirupsen/logrus"
+)
+
+type reportSummary struct {
+	Name      string     `json:"name"`
+	Website   string     `json:"website"`
+	Schedule  string     `json:"schedule"`
+	Timezone  string     `json:"timezone"`
+	TimeRange string     `json:"timeRange"`
+	Email     []string   `json:"email"`
+	Webhooks  int        `json:"webhooks"`
+	Disabled  bool       `json:"disabled"`
+	NextRunAt *time.Time `json:"nextRunAt,omitempty"`
+}
+
+func newReportSummary(report config.ReportConfig, now time.Time) reportSummary {
+	summary := reportSummary{
+		Name:      report.Name,
+		Website:   report.Website,
+		Schedule:  report.Schedule,
+		Timezone:  report.Timezone,
+		TimeRange: report.TimeRange,
+		Email:     report.Email,
+		Webhooks:  len(report.Webhooks),
+		Disabled:  report.Disabled,
+	}
+	if summary.Email == nil {
+		summary.Email = []string{}
+	}
+	if report.Disabled {
+		return summary
+	}
+	schedule, err := timeutil.ParseCron(report.Schedule)
+	if err != nil {
+		return summary
+	}
+	loc, err := config.ReportLocation(report)
+	if err != nil {
+		return summary
+	}
+	if next := schedule.Next(now, loc); !next.IsZero() {
+		summary.NextRunAt = &next
+	}
+	return summary
+}
+
+func setupReportRoutes(router *gin.Engine, statsFactory *analytics.StatsFactory) {
+	var reporter *worker.Reporter
+	if statsFactory != nil {
+		reporter = worker.NewReporter(statsFactory)
+	}
+	// loadReport 读取报表配置，不可用或不存在时直接写出响应。
+	loadReport := func(c *gin.Context) (config.ReportConfig, bool) {
+		if reporter == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持报表",
+			})
+			return config.ReportConfig{}, false
+		}
+		report, ok := config.GetReport(c.Param("name"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "报表不存在",
+			})
+			return report, false
+		}
+		if websiteID, _, _ := config.ResolveReportWebsites(report.Website); !auth.CheckWebsite(c, websiteID) {
+			return report, false
+		}
+		return report, true
+	}
+
+	router.GET("/api/reports", func(c *gin.Context) {
+		now := time.Now()
+		principal := auth.FromContext(c)
+		reports := make([]reportSummary, 0)
+		for _, report := range config.GetReports() {
+			if websiteID, _, _ := config.ResolveReportWebsites(report.Website); !principal.CanAccessWebsite(websiteID) {
+				continue
+			}
+			reports = append(reports, newReportSummary(report, now))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"reports": reports,
+		})
+	})
+
+	router.GET("/api/reports/:name/runs", func(c *gin.Context) {
+		report, ok := loadReport(c)
+		if !ok {
+			return
+		}
+		runs, err := statsFactory.Repo().ListReportRuns(report.Name, 20)
+		if err != nil {
+			logrus.WithError(err).Error("读取报表发送记录失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "读取报表发送记录失败",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"runs": runs,
+		})
+	})
+
+	// 预览：按当前数据生成报表但不发送，format 为 html（默认）、markdown 或 json。
+	router.GET("/api/reports/:name/preview", func(c *gin.Context) {
+		report, ok := loadReport(c)
+		if !ok {
+			return
+		}
+		format := c.DefaultQuery("format", worker.ReportFormatHTML)
+		digest, err := reporter.BuildDigest(report)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		body, contentType, err := worker.RenderReport(digest, format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.Data(http.StatusOK, contentType, body)
+	})
+
+	// 立即发送：忽略 schedule 与 disabled，发送给报表配置的全部收件人与 webhook。
+	router.POST("/api/reports/:name/send", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
+		report, ok := loadReport(c)
+		if !ok {
+			return
+		}
+		digest, err := reporter.SendNow(report)
+		websiteID, _, _ := config.ResolveReportWebsites(report.Website)
+		RecordAudit(c, statsFactory.Repo(), AuditEvent{
+			Action:    "reports.send",
+			WebsiteID: websiteID,
+			Params:    gin.H{"report": report.Name, "error": auditErrorText(err)},
+			Success:   err == nil,
+		})
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":  err.Error(),
+				"digest": digest,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"digest":  digest,
+		})
+	})
+}
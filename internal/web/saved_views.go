@@ -0,0 +1,551 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sharedViewPathPrefix    = "/api/shared/views/"
+	shareTokenPrefix        = "npv_"
+	defaultShareExpireHours = 7 * 24
+	maxShareExpireHours     = 365 * 24
+)
+
+// IsSharedViewPath 判断是否为分享令牌访问的只读视图接口（由访问密钥中间件放行，在此处校验令牌）。
+func IsSharedViewPath(path string) bool {
+	return strings.HasPrefix(path, sharedViewPathPrefix)
+}
+
+type savedViewPayload struct {
+	Name      string            `json:"name"`
+	WebsiteID string            `json:"website_id"`
+	StatsType string            `json:"stats_type"`
+	Params    map[string]string `json:"params"`
+	Segment   map[string]string `json:"segment"`
+}
+
+// savedViewQueryParams 合并视图参数与分群条件，overrides 用于分享页切换时间范围。
+func savedViewQueryParams(view store.SavedView, overrides map[string]string) map[string]string {
+	params := make(map[string]string, len(view.Params)+len(view.Segment)+1)
+	for key, value := range view.Params {
+		params[key] = value
+	}
+	for key, value := range view.Segment {
+		params[key] = value
+	}
+	for key, value := range overrides {
+		params[key] = value
+	}
+	params["id"] = view.WebsiteID
+	return params
+}
+
+// buildSavedView 校验请求并转换为视图，查询参数会按统计类型完整校验一遍。
+func buildSavedView(statsFactory *analytics.StatsFactory, payload savedViewPayload) (store.SavedView, error) {
+	view := store.SavedView{
+		Name:      strings.TrimSpace(payload.Name),
+		WebsiteID: strings.TrimSpace(payload.WebsiteID),
+		StatsType: strings.TrimSpace(payload.StatsType),
+		Params:    make(map[string]string),
+		Segment:   make(map[string]string),
+	}
+	if view.Name == "" {
+		return view, errors.New("视图名称不能为空")
+	}
+	if view.WebsiteID == "" {
+		return view, errors.New("website_id 不能为空")
+	}
+	if _, ok := config.GetWebsiteByID(view.WebsiteID); !ok && !config.IsWebsiteScope(view.WebsiteID) {
+		return view, errors.New("站点不存在")
+	}
+	for key, value := range payload.Params {
+		if key == "id" {
+			continue
+		}
+		if analytics.IsSegmentParam(key) {
+			view.Segment[key] = value
+			continue
+		}
+		view.Params[key] = value
+	}
+	for key, value := range payload.Segment {
+		if !analytics.IsSegmentParam(key) {
+			return view, errors.New("未知的分群参数: " + key)
+		}
+		view.Segment[key] = value
+	}
+	if _, err := statsFactory.BuildQueryFromRequest(view.StatsType, savedViewQueryParams(view, nil)); err != nil {
+		return view, err
+	}
+	return view, nil
+}
+
+func newShareToken() (string, string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := shareTokenPrefix + hex.EncodeToString(buf)
+	return token, auth.HashToken(token), nil
+}
+
+func parseViewID(c *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "ID 无效",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// loadSavedView 读取视图，不存在或出错时直接写出响应。
+func loadSavedView(c *gin.Context, repo *store.Repository, id int64) (store.SavedView, bool) {
+	view, err := repo.GetSavedView(id)
+	if errors.Is(err, store.ErrSavedViewNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "视图不存在",
+		})
+		return view, false
+	}
+	if err != nil {
+		logrus.WithError(err).Error("读取视图失败")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "读取视图失败",
+		})
+		return view, false
+	}
+	return view, true
+}
+
+// loadAccessibleView 读取视图并校验当前主体能否访问视图所属站点。
+func loadAccessibleView(c *gin.Context, repo *store.Repository, id int64) (store.SavedView, bool) {
+	view, ok := loadSavedView(c, repo, id)
+	if !ok || !auth.CheckWebsite(c, view.WebsiteID) {
+		return view, false
+	}
+	return view, true
+}
+
+// querySavedView 执行视图对应的统计查询
+func querySavedView(
+	c *gin.Context, statsFactory *analytics.StatsFactory, view store.SavedView, overrides map[string]string,
+) (analytics.StatsResult, bool) {
+	query, err := statsFactory.BuildQueryFromRequest(view.StatsType, savedViewQueryParams(view, overrides))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return nil, false
+	}
+	result, err := statsFactory.QueryStats(view.StatsType, query)
+	if err != nil {
+		logrus.WithError(err).Errorf("查询视图[%d]失败", view.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "查询失败",
+		})
+		return nil, false
+	}
+	return result, true
+}
+
+func setupSavedViewRoutes(router *gin.Engine, statsFactory *analytics.StatsFactory) {
+	requireFactory := func(c *gin.Context) bool {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持视图",
+			})
+			return false
+		}
+		return true
+	}
+
+	router.GET("/api/views", func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		websiteID := strings.TrimSpace(c.Query("website_id"))
+		if websiteID != "" && !auth.CheckWebsite(c, websiteID) {
+			return
+		}
+		views, err := statsFactory.Repo().ListSavedViews(websiteID)
+		if err != nil {
+			logrus.WithError(err).Error("读取视图列表失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "读取视图列表失败",
+			})
+			return
+		}
+		principal := auth.FromContext(c)
+		visible := make([]store.SavedView, 0, len(views))
+		for _, view := range views {
+			if principal.CanAccessWebsite(view.WebsiteID) {
+				visible = append(visible, view)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"views": visible,
+		})
+	})
+
+	router.POST("/api/views", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		var payload savedViewPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		view, err := buildSavedView(statsFactory, payload)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if !auth.CheckWebsite(c, view.WebsiteID) {
+			return
+		}
+		created, err := statsFactory.Repo().CreateSavedView(view)
+		if err != nil {
+			logrus.WithError(err).Error("保存视图失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "保存视图失败",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"view": created,
+		})
+	})
+
+	router.GET("/api/views/:id", func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseViewID(c, "id")
+		if !ok {
+			return
+		}
+		view, ok := loadAccessibleView(c, statsFactory.Repo(), id)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"view": view,
+		})
+	})
+
+	router.PUT("/api/views/:id", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseViewID(c, "id")
+		if !ok {
+			return
+		}
+		var payload savedViewPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		view, err := buildSavedView(statsFactory, payload)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if !auth.CheckWebsite(c, view.WebsiteID) {
+			return
+		}
+		if _, ok := loadAccessibleView(c, statsFactory.Repo(), id); !ok {
+			return
+		}
+		view.ID = id
+		updated, err := statsFactory.Repo().UpdateSavedView(view)
+		if errors.Is(err, store.ErrSavedViewNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "视图不存在",
+			})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("更新视图失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "更新视图失败",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"view": updated,
+		})
+	})
+
+	router.DELETE("/api/views/:id", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseViewID(c, "id")
+		if !ok {
+			return
+		}
+		if _, ok := loadAccessibleView(c, statsFactory.Repo(), id); !ok {
+			return
+		}
+		err := statsFactory.Repo().DeleteSavedView(id)
+		if errors.Is(err, store.ErrSavedViewNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "视图不存在",
+			})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("删除视图失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "删除视图失败",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	})
+
+	// 按视图保存的参数查询统计数据
+	router.GET("/api/views/:id/data", func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseViewID(c, "id")
+		if !ok {
+			return
+		}
+		view, ok := loadAccessibleView(c, statsFactory.Repo(), id)
+		if !ok {
+			return
+		}
+		result, ok := querySavedView(c, statsFactory, view, sharedViewOverrides(c))
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"view":   view,
+			"result": result,
+		})
+	})
+
+	router.GET("/api/views/:id/shares", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseViewID(c, "id")
+		if !ok {
+			return
+		}
+		if _, ok := loadAccessibleView(c, statsFactory.Repo(), id); !ok {
+			return
+		}
+		shares, err := statsFactory.Repo().ListSavedViewShares(id)
+		if err != nil {
+			logrus.WithError(err).Error("读取分享列表失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "读取分享列表失败",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"shares": shares,
+		})
+	})
+
+	// 创建分享令牌，明文令牌只在此处返回一次
+	router.POST("/api/views/:id/shares", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseViewID(c, "id")
+		if !ok {
+			return
+		}
+		payload := struct {
+			ExpiresInHours int `json:"expires_in_hours"`
+		}{}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&payload); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "请求参数错误",
+				})
+				return
+			}
+		}
+		if payload.ExpiresInHours <= 0 {
+			payload.ExpiresInHours = defaultShareExpireHours
+		}
+		if payload.ExpiresInHours > maxShareExpireHours {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "分享有效期不能超过 365 天",
+			})
+			return
+		}
+		view, ok := loadAccessibleView(c, statsFactory.Repo(), id)
+		if !ok {
+			return
+		}
+		token, tokenHash, err := newShareToken()
+		if err != nil {
+			logrus.WithError(err).Error("生成分享令牌失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "生成分享令牌失败",
+			})
+			return
+		}
+		share, err := statsFactory.Repo().CreateSavedViewShare(store.SavedViewShare{
+			ViewID:    id,
+			TokenHash: tokenHash,
+			TokenHint: token[len(token)-4:],
+			ExpiresAt: time.Now().Add(time.Duration(payload.ExpiresInHours) * time.Hour),
+		})
+		if err != nil {
+			logrus.WithError(err).Error("保存分享令牌失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "保存分享令牌失败",
+			})
+			return
+		}
+		RecordAudit(c, statsFactory.Repo(), AuditEvent{
+			Action:    "views.share.create",
+			WebsiteID: view.WebsiteID,
+			Params:    gin.H{"view_id": id, "share_id": share.ID, "expires_at": share.ExpiresAt},
+			Success:   true,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"share": share,
+			"token": token,
+			"path":  sharedViewPathPrefix + token,
+		})
+	})
+
+	router.DELETE("/api/views/:id/shares/:shareId", auth.RequireRole(auth.RoleEditor), func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseViewID(c, "id")
+		if !ok {
+			return
+		}
+		shareID, ok := parseViewID(c, "shareId")
+		if !ok {
+			return
+		}
+		view, ok := loadAccessibleView(c, statsFactory.Repo(), id)
+		if !ok {
+			return
+		}
+		revoked, err := statsFactory.Repo().RevokeSavedViewShare(id, shareID)
+		if err != nil {
+			logrus.WithError(err).Error("吊销分享令牌失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "吊销分享令牌失败",
+			})
+			return
+		}
+		if !revoked {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "分享令牌不存在或已吊销",
+			})
+			return
+		}
+		RecordAudit(c, statsFactory.Repo(), AuditEvent{
+			Action:    "views.share.revoke",
+			WebsiteID: view.WebsiteID,
+			Params:    gin.H{"view_id": id, "share_id": shareID},
+			Success:   true,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	})
+
+	// 分享访问：只读，只能查询令牌对应的视图
+	router.GET(sharedViewPathPrefix+":token", func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		token := strings.TrimSpace(c.Param("token"))
+		if !strings.HasPrefix(token, shareTokenPrefix) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "分享令牌无效",
+			})
+			return
+		}
+		repo := statsFactory.Repo()
+		share, err := repo.GetSavedViewShareByHash(auth.HashToken(token))
+		if errors.Is(err, store.ErrSavedViewShareNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "分享令牌无效",
+			})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("读取分享令牌失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "读取分享令牌失败",
+			})
+			return
+		}
+		if !share.Active(time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "分享令牌已过期或已吊销",
+			})
+			return
+		}
+		view, ok := loadSavedView(c, repo, share.ViewID)
+		if !ok {
+			return
+		}
+		result, ok := querySavedView(c, statsFactory, view, sharedViewOverrides(c))
+		if !ok {
+			return
+		}
+		if err := repo.TouchSavedViewShare(share.ID); err != nil {
+			logrus.WithError(err).Warn("更新分享令牌使用时间失败")
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"view": gin.H{
+				"name":       view.Name,
+				"stats_type": view.StatsType,
+			},
+			"expires_at": share.ExpiresAt,
+			"result":     result,
+		})
+	})
+}
+
+// sharedViewOverrides 只允许调用方切换时间范围，其余参数以视图保存的为准。
+func sharedViewOverrides(c *gin.Context) map[string]string {
+	timeRange := strings.TrimSpace(c.Query("timeRange"))
+	if timeRange == "" {
+		return nil
+	}
+	return map[string]string{"timeRange": timeRange}
+}
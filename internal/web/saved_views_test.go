@@ -0,0 +1,57 @@
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+func TestNewShareToken(t *testing.T) {
+	token, hash, err := newShareToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, shareTokenPrefix) || len(token) != len(shareTokenPrefix)+48 {
+		t.Fatalf("unexpected token %q", token)
+	}
+	if hash != auth.HashToken(token) || strings.Contains(hash, token) {
+		t.Fatalf("hash %q does not match token", hash)
+	}
+	other, otherHash, err := newShareToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token || otherHash == hash {
+		t.Fatal("share tokens must be random")
+	}
+}
+
+func TestSharedViewOnlyOverridesTimeRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", sharedViewPathPrefix+"npv_x?timeRange=week&id=other&statusCode=200&limit=1000", nil)
+	overrides := sharedViewOverrides(c)
+	if len(overrides) != 1 || overrides["timeRange"] != "week" {
+		t.Fatalf("unexpected overrides %v", overrides)
+	}
+
+	view := store.SavedView{
+		WebsiteID: "ab12",
+		Params:    map[string]string{"timeRange": "today", "limit": "10", "id": "other"},
+		Segment:   map[string]string{"excludeSpider": "true"},
+	}
+	params := savedViewQueryParams(view, overrides)
+	if params["id"] != "ab12" || params["timeRange"] != "week" || params["limit"] != "10" || params["excludeSpider"] != "true" {
+		t.Fatalf("unexpected params %v", params)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", sharedViewPathPrefix+"npv_x", nil)
+	if overrides := sharedViewOverrides(c); overrides != nil {
+		t.Fatalf("no override expected, got %v", overrides)
+	}
+}
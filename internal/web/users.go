@@ -0,0 +1,505 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/auth"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	loginPath       = "/api/auth/login"
+	logoutPath      = "/api/auth/logout"
+	authOptionsPath = "/api/auth/options"
+)
+
+// IsPublicAuthPath 判断是否为无需预先鉴权的登录相关接口（由接口自身校验凭据）。
+func IsPublicAuthPath(path string) bool {
+	return path == loginPath || path == logoutPath || path == authOptionsPath
+}
+
+type userPayload struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Role     string   `json:"role"`
+	Websites []string `json:"websites"`
+	Disabled bool     `json:"disabled"`
+}
+
+// normalizeUserWebsites 校验站点范围，返回去重排序后的站点 ID
+func normalizeUserWebsites(values []string) ([]string, error) {
+	seen := make(map[string]bool, len(values))
+	websites := make([]string, 0, len(values))
+	for _, value := range values {
+		id := strings.TrimSpace(value)
+		if id == "" || seen[id] {
+			continue
+		}
+		if _, ok := config.GetWebsiteByID(id); !ok {
+			return nil, errors.New("站点不存在: " + id)
+		}
+		seen[id] = true
+		websites = append(websites, id)
+	}
+	sort.Strings(websites)
+	return websites, nil
+}
+
+// SessionCookiePath 让会话 Cookie 只作用于 WebBasePath 之下
+func SessionCookiePath() string {
+	return config.WebBasePathPrefix() + "/"
+}
+
+// IsSecureRequest 判断请求是否经由 HTTPS（含反向代理转发），决定 Cookie 是否带 Secure
+func IsSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.SessionCookieName, token, maxAge, SessionCookiePath(), "", IsSecureRequest(c), true)
+}
+
+// refreshUserAccounts 在用户变更后更新“是否存在启用用户”的标记
+func refreshUserAccounts(repo *store.Repository) {
+	count, err := repo.CountActiveUsers("")
+	if err != nil {
+		logrus.WithError(err).Warn("读取用户数量失败")
+		return
+	}
+	auth.SetUserAccountsEnabled(count > 0)
+}
+
+// StartUserSession 为用户创建会话并写入 Cookie，返回令牌与过期时间
+func StartUserSession(c *gin.Context, repo *store.Repository, user store.User) (string, time.Time, error) {
+	token, tokenHash, err := auth.NewSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(auth.SessionTTL)
+	if err := repo.CreateUserSession(store.UserSession{
+		TokenHash: tokenHash,
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := repo.TouchUserLogin(user.ID); err != nil {
+		logrus.WithError(err).Warn("更新登录时间失败")
+	}
+	if _, err := repo.CleanupExpiredSessions(); err != nil {
+		logrus.WithError(err).Warn("清理过期会话失败")
+	}
+	setSessionCookie(c, token, int(auth.SessionTTL.Seconds()))
+	return token, expiresAt, nil
+}
+
+// EndUserSession 注销当前请求携带的会话并清除 Cookie
+func EndUserSession(c *gin.Context, repo *store.Repository) {
+	if token := auth.SessionToken(c); token != "" && repo != nil {
+		if err := repo.DeleteUserSession(auth.HashToken(token)); err != nil {
+			logrus.WithError(err).Warn("注销会话失败")
+		}
+	}
+	setSessionCookie(c, "", -1)
+}
+
+func parseUserID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "ID 无效",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+func setupUserRoutes(router *gin.Engine, statsFactory *analytics.StatsFactory) {
+	requireFactory := func(c *gin.Context) bool {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持用户管理",
+			})
+			return false
+		}
+		return true
+	}
+
+	router.POST(loginPath, func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		var payload struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		repo := statsFactory.Repo()
+		user, err := repo.GetUserByUsername(strings.TrimSpace(payload.Username))
+		if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+			logrus.WithError(err).Error("读取用户失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "登录失败",
+			})
+			return
+		}
+		if err != nil {
+			auth.BurnPasswordCheck(payload.Password)
+		}
+		if err != nil || user.Disabled || !auth.CheckPassword(user.PasswordHash, payload.Password) {
+			RecordAudit(c, repo, AuditEvent{
+				Action: "auth.login",
+				Params: gin.H{"provider": store.UserProviderLocal},
+				Actor:  &auth.Principal{Kind: auth.PrincipalGuest, Username: strings.TrimSpace(payload.Username)},
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "用户名或密码错误",
+			})
+			return
+		}
+		token, expiresAt, err := StartUserSession(c, repo, user)
+		if err != nil {
+			logrus.WithError(err).Error("创建登录会话失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "登录失败",
+			})
+			return
+		}
+		RecordAudit(c, repo, AuditEvent{
+			Action:  "auth.login",
+			Params:  gin.H{"provider": store.UserProviderLocal},
+			Success: true,
+			Actor:   &auth.Principal{Kind: auth.PrincipalSession, UserID: user.ID, Username: user.Username},
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"token":      token,
+			"expires_at": expiresAt,
+			"user":       user,
+		})
+	})
+
+	router.POST(logoutPath, func(c *gin.Context) {
+		var repo *store.Repository
+		if statsFactory != nil {
+			repo = statsFactory.Repo()
+		}
+		EndUserSession(c, repo)
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	})
+
+	// 登录页使用：返回可用的登录方式
+	router.GET(authOptionsPath, func(c *gin.Context) {
+		oidc := gin.H{"enabled": false}
+		if cfg := config.GetOIDCConfig(); cfg != nil {
+			oidc = gin.H{
+				"enabled": true,
+				"label":   cfg.ButtonLabel,
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"password": statsFactory != nil,
+			"oidc":     oidc,
+		})
+	})
+
+	router.GET("/api/auth/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"principal": auth.FromContext(c),
+		})
+	})
+
+	admin := auth.RequireRole(auth.RoleAdmin)
+
+	router.GET("/api/users", admin, func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		users, err := statsFactory.Repo().ListUsers()
+		if err != nil {
+			logrus.WithError(err).Error("读取用户列表失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "读取用户列表失败",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"users": users,
+		})
+	})
+
+	router.POST("/api/users", admin, func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		var payload userPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		username := strings.TrimSpace(payload.Username)
+		if username == "" || len(username) > 64 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "用户名不能为空且不超过 64 个字符",
+			})
+			return
+		}
+		role, ok := auth.ParseRole(payload.Role)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "role 仅支持 admin、editor、viewer",
+			})
+			return
+		}
+		websites, err := normalizeUserWebsites(payload.Websites)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		hash, err := auth.HashPassword(payload.Password)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		repo := statsFactory.Repo()
+		// 没有可用的管理员且未配置访问密钥时，第一个账号必须是管理员，否则启用登录后将无人能管理
+		if role != auth.RoleAdmin && len(config.ReadConfig().System.AccessKeys) == 0 {
+			count, err := repo.CountActiveUsers(string(auth.RoleAdmin))
+			if err != nil {
+				logrus.WithError(err).Error("读取管理员数量失败")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "创建用户失败",
+				})
+				return
+			}
+			if count == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "请先创建管理员账号",
+				})
+				return
+			}
+		}
+		created, err := repo.CreateUser(store.User{
+			Username:     username,
+			PasswordHash: hash,
+			Role:         string(role),
+			Websites:     websites,
+			Disabled:     payload.Disabled,
+		})
+		if errors.Is(err, store.ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "用户名已存在",
+			})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("创建用户失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "创建用户失败",
+			})
+			return
+		}
+		refreshUserAccounts(repo)
+		RecordAudit(c, repo, AuditEvent{
+			Action:  "users.create",
+			Params:  gin.H{"id": created.ID, "username": created.Username, "role": created.Role, "websites": created.Websites, "disabled": created.Disabled},
+			Success: true,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"user": created,
+		})
+	})
+
+	router.PUT("/api/users/:id", admin, func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseUserID(c)
+		if !ok {
+			return
+		}
+		var payload userPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		role, ok := auth.ParseRole(payload.Role)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "role 仅支持 admin、editor、viewer",
+			})
+			return
+		}
+		websites, err := normalizeUserWebsites(payload.Websites)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		hash := ""
+		if payload.Password != "" {
+			if hash, err = auth.HashPassword(payload.Password); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+		}
+
+		repo := statsFactory.Repo()
+		current, err := repo.GetUser(id)
+		if errors.Is(err, store.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "用户不存在",
+			})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("读取用户失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "更新用户失败",
+			})
+			return
+		}
+		if hash != "" && current.Provider != store.UserProviderLocal {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "单点登录用户不能设置密码",
+			})
+			return
+		}
+		demoted := current.Role == string(auth.RoleAdmin) && !current.Disabled &&
+			(role != auth.RoleAdmin || payload.Disabled)
+		if demoted && !ensureOtherAdmin(c, repo) {
+			return
+		}
+
+		updated, err := repo.UpdateUser(store.User{
+			ID:           id,
+			PasswordHash: hash,
+			Role:         string(role),
+			Websites:     websites,
+			Disabled:     payload.Disabled,
+		})
+		if err != nil {
+			logrus.WithError(err).Error("更新用户失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "更新用户失败",
+			})
+			return
+		}
+		// 密码、禁用状态或权限变化后注销已有会话，要求重新登录
+		if hash != "" || updated.Disabled || updated.Role != current.Role ||
+			strings.Join(updated.Websites, ",") != strings.Join(current.Websites, ",") {
+			if err := repo.DeleteUserSessions(id); err != nil {
+				logrus.WithError(err).Warn("注销用户会话失败")
+			}
+		}
+		refreshUserAccounts(repo)
+		RecordAudit(c, repo, AuditEvent{
+			Action: "users.update",
+			Params: gin.H{"id": updated.ID, "username": updated.Username, "password_changed": hash != ""},
+			Diff: gin.H{
+				"before": gin.H{"role": current.Role, "websites": current.Websites, "disabled": current.Disabled},
+				"after":  gin.H{"role": updated.Role, "websites": updated.Websites, "disabled": updated.Disabled},
+			},
+			Success: true,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"user": updated,
+		})
+	})
+
+	router.DELETE("/api/users/:id", admin, func(c *gin.Context) {
+		if !requireFactory(c) {
+			return
+		}
+		id, ok := parseUserID(c)
+		if !ok {
+			return
+		}
+		repo := statsFactory.Repo()
+		current, err := repo.GetUser(id)
+		if errors.Is(err, store.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "用户不存在",
+			})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("读取用户失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "删除用户失败",
+			})
+			return
+		}
+		if current.Role == string(auth.RoleAdmin) && !current.Disabled && !ensureOtherAdmin(c, repo) {
+			return
+		}
+		if err := repo.DeleteUser(id); err != nil {
+			logrus.WithError(err).Error("删除用户失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "删除用户失败",
+			})
+			return
+		}
+		refreshUserAccounts(repo)
+		RecordAudit(c, repo, AuditEvent{
+			Action:  "users.delete",
+			Params:  gin.H{"id": current.ID, "username": current.Username, "role": current.Role},
+			Success: true,
+		})
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	})
+}
+
+// ensureOtherAdmin 在移除或降级管理员前确认仍有其他可用的管理员；
+// 配置了访问密钥时可以用密钥恢复，不做限制。
+func ensureOtherAdmin(c *gin.Context, repo *store.Repository) bool {
+	if len(config.ReadConfig().System.AccessKeys) > 0 {
+		return true
+	}
+	count, err := repo.CountActiveUsers(string(auth.RoleAdmin))
+	if err != nil {
+		logrus.WithError(err).Error("读取管理员数量失败")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "读取管理员数量失败",
+		})
+		return false
+	}
+	if count <= 1 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "至少需要保留一个启用的管理员",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,355 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/analytics"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	reportTriggerSchedule = "schedule"
+	reportTriggerManual   = "manual"
+	reportCheckInterval   = 30 * time.Second
+)
+
+var reportTimeRangeLabels = map[string]string{
+	"today":      "今天",
+	"yesterday":  "昨天",
+	"week":       "本周",
+	"last7days":  "最近 7 天",
+	"month":      "本月",
+	"last30days": "最近 30 天",
+}
+
+// ReportDigest 是一份渲染前的报表摘要，同时作为 JSON webhook 的消息体。
+type ReportDigest struct {
+	Report       string                    `json:"report"`
+	Title        string                    `json:"title"`
+	WebsiteID    string                    `json:"websiteId"`
+	WebsiteName  string                    `json:"websiteName"`
+	TimeRange    string                    `json:"timeRange"`
+	PeriodStart  time.Time                 `json:"periodStart"`
+	PeriodEnd    time.Time                 `json:"periodEnd"`
+	GeneratedAt  time.Time                 `json:"generatedAt"`
+	Overall      ReportOverall             `json:"overall"`
+	Previous     analytics.OverallSnapshot `json:"previous"`
+	Changes      ReportChanges             `json:"changes"`
+	TopURLs      []ReportTopItem           `json:"topUrls"`
+	TopReferers  []ReportTopItem           `json:"topReferers"`
+	TopLocations []ReportTopItem           `json:"topLocations"`
+	Errors       ReportErrors              `json:"errors"`
+}
+
+type ReportOverall struct {
+	PV                    int   `json:"pv"`
+	UV                    int   `json:"uv"`
+	Traffic               int64 `json:"traffic"`
+	SessionCount          int   `json:"sessionCount"`
+	NewVisitorCount       int   `json:"newVisitorCount"`
+	ReturningVisitorCount int   `json:"returningVisitorCount"`
+}
+
+// ReportChanges 为相对上一期的变化百分比，上一期为 0 时为 null。
+type ReportChanges struct {
+	PV           *float64 `json:"pv"`
+	UV           *float64 `json:"uv"`
+	SessionCount *float64 `json:"sessionCount"`
+}
+
+type ReportTopItem struct {
+	Key string `json:"key"`
+	PV  int    `json:"pv"`
+	UV  int    `json:"uv"`
+}
+
+type ReportErrors struct {
+	S4xx         int                     `json:"s4xx"`
+	S5xx         int                     `json:"s5xx"`
+	PrevS4xx     int                     `json:"prevS4xx"`
+	PrevS5xx     int                     `json:"prevS5xx"`
+	ErrorRate    float64                 `json:"errorRate"` // 4xx+5xx 占全部请求的百分比
+	TopURLs      []store.ErrorURLCount   `json:"topUrls"`
+	Anomalies    []analytics.AnomalyItem `json:"anomalies"`
+	AnomalyTotal int                     `json:"anomalyTotal"`
+}
+
+// Reporter 负责生成并投递定时报表。
+type Reporter struct {
+	factory *analytics.StatsFactory
+}
+
+func NewReporter(factory *analytics.StatsFactory) *Reporter {
+	return &Reporter{factory: factory}
+}
+
+// RunReportScheduler 周期性检查报表计划，到点后生成并发送报表，直到 ctx 取消。
+// 服务停机期间错过的计划不会补发。
+func RunReportScheduler(ctx context.Context, reporter *Reporter) {
+	ticker := time.NewTicker(reportCheckInterval)
+	defer ticker.Stop()
+
+	// checked 之前（含）的分钟都已检查过
+	checked := time.Now().Truncate(time.Minute)
+	for {
+		select {
+		case now := <-ticker.C:
+			reporter.runDue(checked, now)
+			checked = now.Truncate(time.Minute)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Reporter) runDue(after, now time.Time) {
+	for _, report := range config.GetReports() {
+		if report.Disabled {
+			continue
+		}
+		schedule, err := timeutil.ParseCron(report.Schedule)
+		if err != nil {
+			continue
+		}
+		loc, err := config.ReportLocation(report)
+		if err != nil {
+			continue
+		}
+		for next := schedule.Next(after, loc); !next.IsZero() && !next.After(now); next = schedule.Next(next, loc) {
+			go func(report config.ReportConfig, scheduledAt time.Time) {
+				if _, err := r.Run(report, reportTriggerSchedule, scheduledAt); err != nil {
+					logrus.WithError(err).Warnf("报表 %s 发送失败", report.Name)
+				}
+			}(report, next)
+		}
+	}
+}
+
+// SendNow 立即生成并发送报表，返回发送的摘要。
+func (r *Reporter) SendNow(report config.ReportConfig) (*ReportDigest, error) {
+	return r.Run(report, reportTriggerManual, time.Now())
+}
+
+// Run 登记并执行一次发送。定时触发的同一计划时间只会执行一次，重复时返回 nil 摘要。
+func (r *Reporter) Run(report config.ReportConfig, trigger string, scheduledAt time.Time) (*ReportDigest, error) {
+	repo := r.factory.Repo()
+	runID, claimed, err := repo.ClaimReportRun(report.Name, trigger, scheduledAt.Truncate(time.Minute))
+	if err != nil {
+		return nil, fmt.Errorf("登记报表发送失败: %w", err)
+	}
+	if !claimed {
+		return nil, nil
+	}
+
+	digest, err := r.BuildDigest(report)
+	if err == nil {
+		err = r.Deliver(report, digest)
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	if finishErr := repo.FinishReportRun(runID, errMsg); finishErr != nil {
+		logrus.WithError(finishErr).Warn("记录报表发送结果失败")
+	}
+	if err == nil {
+		logrus.Infof("报表 %s 已发送（%s）", report.Name, trigger)
+	}
+	return digest, err
+}
+
+// BuildDigest 汇总报表所需的统计数据。
+func (r *Reporter) BuildDigest(report config.ReportConfig) (*ReportDigest, error) {
+	scopeID, sites, ok := config.ResolveReportWebsites(report.Website)
+	if !ok {
+		return nil, fmt.Errorf("报表 %s 的站点不存在: %s", report.Name, report.Website)
+	}
+	start, end, err := timeutil.TimePeriod(report.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	digest := &ReportDigest{
+		Report:       report.Name,
+		WebsiteID:    scopeID,
+		WebsiteName:  reportWebsiteName(scopeID),
+		TimeRange:    report.TimeRange,
+		PeriodStart:  start,
+		PeriodEnd:    end,
+		GeneratedAt:  time.Now(),
+		TopURLs:      make([]ReportTopItem, 0),
+		TopReferers:  make([]ReportTopItem, 0),
+		TopLocations: make([]ReportTopItem, 0),
+		Errors: ReportErrors{
+			TopURLs:   make([]store.ErrorURLCount, 0),
+			Anomalies: make([]analytics.AnomalyItem, 0),
+		},
+	}
+	digest.Title = fmt.Sprintf("%s · %s访问报表", digest.WebsiteName, reportTimeRangeLabel(report.TimeRange))
+
+	baseParams := map[string]string{"id": scopeID, "timeRange": report.TimeRange}
+	overallResult, err := r.query("overall", baseParams, nil)
+	if err != nil {
+		return nil, err
+	}
+	overall, ok := overallResult.(analytics.OverallStats)
+	if !ok {
+		return nil, errors.New("总体统计结果类型错误")
+	}
+	digest.Overall = ReportOverall{
+		PV:                    overall.PV,
+		UV:                    overall.UV,
+		Traffic:               overall.Traffic,
+		SessionCount:          overall.SessionCount,
+		NewVisitorCount:       overall.NewVisitorCount,
+		ReturningVisitorCount: overall.ReturningVisitorCount,
+	}
+	digest.Previous = overall.Compare.Previous
+	digest.Changes = ReportChanges{
+		PV:           percentChange(overall.PV, overall.Compare.Previous.PV),
+		UV:           percentChange(overall.UV, overall.Compare.Previous.UV),
+		SessionCount: percentChange(overall.SessionCount, overall.Compare.Previous.SessionCount),
+	}
+	hits := overall.StatusCodeHits
+	digest.Errors.S4xx = hits.S4xx
+	digest.Errors.S5xx = hits.S5xx
+	digest.Errors.PrevS4xx = overall.StatusCodeHitsPrevious.S4xx
+	digest.Errors.PrevS5xx = overall.StatusCodeHitsPrevious.S5xx
+	if total := hits.S2xx + hits.S3xx + hits.S4xx + hits.S5xx + hits.Other; total > 0 {
+		digest.Errors.ErrorRate = math.Round(float64(hits.S4xx+hits.S5xx)/float64(total)*10000) / 100
+	}
+
+	limit := strconv.Itoa(report.TopN)
+	topQueries := []struct {
+		statsType string
+		extra     map[string]string
+		target    *[]ReportTopItem
+	}{
+		{"url", map[string]string{"limit": limit}, &digest.TopURLs},
+		{"referer", map[string]string{"limit": limit}, &digest.TopReferers},
+		{"location", map[string]string{"limit": limit, "locationType": "global"}, &digest.TopLocations},
+	}
+	for _, item := range topQueries {
+		result, err := r.query(item.statsType, baseParams, item.extra)
+		if err != nil {
+			logrus.WithError(err).Warnf("报表 %s 获取 %s 排行失败", report.Name, item.statsType)
+			continue
+		}
+		if stats, ok := result.(analytics.ClientStats); ok {
+			*item.target = topItems(stats)
+		}
+	}
+
+	errorURLs, err := r.topErrorURLs(sites, start, end, report.TopN)
+	if err != nil {
+		logrus.WithError(err).Warnf("报表 %s 获取错误 URL 失败", report.Name)
+	} else {
+		digest.Errors.TopURLs = errorURLs
+	}
+
+	anomalyResult, err := r.query("anomalies", baseParams, map[string]string{"severity": "medium"})
+	if err != nil {
+		logrus.WithError(err).Warnf("报表 %s 获取流量异常失败", report.Name)
+	} else if anomalies, ok := anomalyResult.(analytics.AnomaliesStats); ok {
+		digest.Errors.AnomalyTotal = anomalies.Summary.Total
+		items := anomalies.Items
+		if len(items) > report.TopN {
+			items = items[:report.TopN]
+		}
+		digest.Errors.Anomalies = items
+	}
+
+	return digest, nil
+}
+
+func (r *Reporter) query(statsType string, base, extra map[string]string) (analytics.StatsResult, error) {
+	params := make(map[string]string, len(base)+len(extra))
+	for key, value := range base {
+		params[key] = value
+	}
+	for key, value := range extra {
+		params[key] = value
+	}
+	query, err := r.factory.BuildQueryFromRequest(statsType, params)
+	if err != nil {
+		return nil, err
+	}
+	return r.factory.QueryStats(statsType, query)
+}
+
+// topErrorURLs 合并多个站点的错误 URL，跨站点时在 URL 前加上站点名称。
+func (r *Reporter) topErrorURLs(sites []string, start, end time.Time, limit int) ([]store.ErrorURLCount, error) {
+	merged := make([]store.ErrorURLCount, 0)
+	for _, websiteID := range sites {
+		items, err := r.factory.Repo().TopErrorURLs(websiteID, start.Unix(), end.Unix(), limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(sites) > 1 {
+			name := reportWebsiteName(websiteID)
+			for i := range items {
+				items[i].URL = "[" + name + "] " + items[i].URL
+			}
+		}
+		merged = append(merged, items...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Count > merged[j].Count
+	})
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged, nil
+}
+
+func topItems(stats analytics.ClientStats) []ReportTopItem {
+	items := make([]ReportTopItem, 0, len(stats.Key))
+	for i, key := range stats.Key {
+		item := ReportTopItem{Key: key}
+		if i < len(stats.PV) {
+			item.PV = stats.PV[i]
+		}
+		if i < len(stats.UV) {
+			item.UV = stats.UV[i]
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func percentChange(current, previous int) *float64 {
+	if previous <= 0 {
+		return nil
+	}
+	value := math.Round(float64(current-previous)/float64(previous)*1000) / 10
+	return &value
+}
+
+func reportWebsiteName(id string) string {
+	if id == config.ScopeAllWebsites {
+		return "全部站点"
+	}
+	if strings.HasPrefix(id, config.ScopeGroupPrefix) {
+		return "分组 " + strings.TrimPrefix(id, config.ScopeGroupPrefix)
+	}
+	if site, ok := config.GetWebsiteByID(id); ok {
+		return site.Name
+	}
+	return id
+}
+
+func reportTimeRangeLabel(timeRange string) string {
+	if label, ok := reportTimeRangeLabels[timeRange]; ok {
+		return label
+	}
+	return timeRange
+}
@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+const (
+	reportWebhookTimeout = 15 * time.Second
+	reportSMTPTimeout    = 30 * time.Second
+)
+
+var reportHTTPClient = &http.Client{Timeout: reportWebhookTimeout}
+
+// Deliver 将报表发送给全部邮件收件人与 webhook，部分失败时返回合并后的错误。
+func (r *Reporter) Deliver(report config.ReportConfig, digest *ReportDigest) error {
+	var errs []error
+	if len(report.Email) > 0 {
+		if err := sendReportEmail(report, digest); err != nil {
+			errs = append(errs, fmt.Errorf("邮件发送失败: %w", err))
+		}
+	}
+	for _, hook := range report.Webhooks {
+		if err := sendReportWebhook(hook, digest); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s 发送失败: %w", redactWebhookURL(hook.URL), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func sendReportWebhook(hook config.ReportWebhookConfig, digest *ReportDigest) error {
+	var body []byte
+	switch hook.Format {
+	case ReportFormatMarkdown:
+		text, _, err := RenderReport(digest, ReportFormatMarkdown)
+		if err != nil {
+			return err
+		}
+		body, err = json.Marshal(map[string]string{
+			"title": digest.Title,
+			"text":  string(text),
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		payload, _, err := RenderReport(digest, ReportFormatJSON)
+		if err != nil {
+			return err
+		}
+		body = payload
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := reportHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return nil
+}
+
+// redactWebhookURL 只保留协议与主机，避免在日志和发送记录中泄露 URL 中的令牌。
+func redactWebhookURL(raw string) string {
+	if idx := strings.Index(raw, "://"); idx >= 0 {
+		rest := raw[idx+3:]
+		if slash := strings.Index(rest, "/"); slash >= 0 {
+			return raw[:idx+3+slash] + "/…"
+		}
+	}
+	return raw
+}
+
+func sendReportEmail(report config.ReportConfig, digest *ReportDigest) error {
+	smtpCfg := config.ReadConfig().System.SMTP
+	if smtpCfg == nil || strings.TrimSpace(smtpCfg.Host) == "" {
+		return errors.New("未配置 system.smtp")
+	}
+	from, err := mail.ParseAddress(strings.TrimSpace(smtpCfg.From))
+	if err != nil {
+		return fmt.Errorf("smtp.from 无效: %w", err)
+	}
+	recipients := make([]string, 0, len(report.Email))
+	for _, raw := range report.Email {
+		addr, err := mail.ParseAddress(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("收件人无效: %s", raw)
+		}
+		recipients = append(recipients, addr.Address)
+	}
+
+	htmlBody, _, err := RenderReport(digest, ReportFormatHTML)
+	if err != nil {
+		return err
+	}
+	textBody, _, err := RenderReport(digest, ReportFormatMarkdown)
+	if err != nil {
+		return err
+	}
+	message := buildReportMessage(from, recipients, digest.Title, textBody, htmlBody)
+	return sendSMTP(smtpCfg, from.Address, recipients, message)
+}
+
+func buildReportMessage(from *mail.Address, recipients []string, subject string, textBody, htmlBody []byte) []byte {
+	boundaryBytes := make([]byte, 12)
+	_, _ = rand.Read(boundaryBytes)
+	boundary := "np-" + hex.EncodeToString(boundaryBytes)
+
+	var buf bytes.Buffer
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", strings.Join(recipients, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf(`multipart/alternative; boundary="%s"`, boundary)},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+	buf.WriteString("\r\n")
+	parts := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"text/plain; charset=utf-8", textBody},
+		{"text/html; charset=utf-8", htmlBody},
+	}
+	for _, part := range parts {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s\r\nContent-Transfer-Encoding: base64\r\n\r\n", part.contentType)
+		writeBase64Lines(&buf, part.body)
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes()
+}
+
+func sendSMTP(cfg *config.SMTPConfig, from string, recipients []string, message []byte) error {
+	security := strings.ToLower(strings.TrimSpace(cfg.Security))
+	if security == "" {
+		security = "starttls"
+	}
+	port := cfg.Port
+	if port <= 0 {
+		switch security {
+		case "tls":
+			port = 465
+		case "none":
+			port = 25
+		default:
+			port = 587
+		}
+	}
+	host := strings.TrimSpace(cfg.Host)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: reportSMTPTimeout}
+	if security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(reportSMTPTimeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if security == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS 失败: %w", err)
+		}
+	}
+	if cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return fmt.Errorf("SMTP 认证失败: %w", err)
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("收件人 %s 被拒绝: %w", rcpt, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// writeBase64Lines 按 RFC 2045 要求以 76 字符换行写出 base64 内容。
+func writeBase64Lines(buf *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+}
@@ -0,0 +1,225 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// 报表渲染格式
+const (
+	ReportFormatHTML     = "html"
+	ReportFormatMarkdown = "markdown"
+	ReportFormatJSON     = "json"
+)
+
+var reportTemplateFuncs = map[string]interface{}{
+	"bytes":  formatReportBytes,
+	"change": formatReportChange,
+	"num":    formatReportNumber,
+	"date": func(layout string, t interface{ Format(string) string }) string {
+		return t.Format(layout)
+	},
+	"inc": func(i int) int { return i + 1 },
+	"mdcell": func(value string) string {
+		return strings.NewReplacer("|", `\|`, "\n", " ").Replace(value)
+	},
+}
+
+var reportMarkdownTemplate = texttemplate.Must(texttemplate.New("markdown").Funcs(reportTemplateFuncs).Parse(
+	`## {{.Title}}
+{{date "2006-01-02 15:04" .PeriodStart}} ~ {{date "2006-01-02 15:04" .PeriodEnd}}
+
+**概览**
+- PV：{{num .Overall.PV}}（{{change .Changes.PV}}）
+- UV：{{num .Overall.UV}}（{{change .Changes.UV}}）
+- 会话：{{num .Overall.SessionCount}}（{{change .Changes.SessionCount}}）
+- 新访客 / 老访客：{{num .Overall.NewVisitorCount}} / {{num .Overall.ReturningVisitorCount}}
+- 流量：{{bytes .Overall.Traffic}}
+
+**错误**
+- 4xx：{{num .Errors.S4xx}}（上期 {{num .Errors.PrevS4xx}}），5xx：{{num .Errors.S5xx}}（上期 {{num .Errors.PrevS5xx}}），错误率 {{printf "%.2f" .Errors.ErrorRate}}%
+{{- range .Errors.TopURLs}}
+- ` + "`{{.StatusCode}}`" + ` {{mdcell .URL}} × {{num .Count}}
+{{- end}}
+{{- if .Errors.Anomalies}}
+
+**流量异常**（共 {{.Errors.AnomalyTotal}} 条）
+{{- range .Errors.Anomalies}}
+- [{{.Severity}}] {{.Time}} {{.Name}} {{.Metric}} {{if eq .Direction "drop"}}骤降{{else}}突增{{end}}：{{printf "%.0f" .Value}}（基线 {{printf "%.0f" .Baseline}}）
+{{- end}}
+{{- end}}
+{{- if .TopURLs}}
+
+**热门页面**
+| # | URL | PV | UV |
+| --- | --- | --- | --- |
+{{- range $i, $item := .TopURLs}}
+| {{inc $i}} | {{mdcell $item.Key}} | {{num $item.PV}} | {{num $item.UV}} |
+{{- end}}
+{{- end}}
+{{- if .TopReferers}}
+
+**来源**
+| # | 来源 | PV | UV |
+| --- | --- | --- | --- |
+{{- range $i, $item := .TopReferers}}
+| {{inc $i}} | {{mdcell $item.Key}} | {{num $item.PV}} | {{num $item.UV}} |
+{{- end}}
+{{- end}}
+{{- if .TopLocations}}
+
+**地区**
+| # | 地区 | PV | UV |
+| --- | --- | --- | --- |
+{{- range $i, $item := .TopLocations}}
+| {{inc $i}} | {{mdcell $item.Key}} | {{num $item.PV}} | {{num $item.UV}} |
+{{- end}}
+{{- end}}
+`))
+
+var reportHTMLTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(reportTemplateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="margin:0;padding:24px;background:#f5f7fa;font-family:-apple-system,'Segoe UI','PingFang SC','Microsoft YaHei',sans-serif;color:#1f2937;">
+<div style="max-width:720px;margin:0 auto;background:#ffffff;border-radius:8px;padding:24px;">
+  <h2 style="margin:0 0 4px;">{{.Title}}</h2>
+  <div style="color:#6b7280;font-size:13px;margin-bottom:20px;">{{date "2006-01-02 15:04" .PeriodStart}} ~ {{date "2006-01-02 15:04" .PeriodEnd}}</div>
+  <table style="width:100%;border-collapse:collapse;margin-bottom:20px;">
+    <tr>
+      <td style="padding:12px;border:1px solid #e5e7eb;"><div style="color:#6b7280;font-size:12px;">PV</div><div style="font-size:20px;font-weight:600;">{{num .Overall.PV}}</div><div style="font-size:12px;color:#6b7280;">{{change .Changes.PV}}</div></td>
+      <td style="padding:12px;border:1px solid #e5e7eb;"><div style="color:#6b7280;font-size:12px;">UV</div><div style="font-size:20px;font-weight:600;">{{num .Overall.UV}}</div><div style="font-size:12px;color:#6b7280;">{{change .Changes.UV}}</div></td>
+      <td style="padding:12px;border:1px solid #e5e7eb;"><div style="color:#6b7280;font-size:12px;">会话</div><div style="font-size:20px;font-weight:600;">{{num .Overall.SessionCount}}</div><div style="font-size:12px;color:#6b7280;">{{change .Changes.SessionCount}}</div></td>
+      <td style="padding:12px;border:1px solid #e5e7eb;"><div style="color:#6b7280;font-size:12px;">流量</div><div style="font-size:20px;font-weight:600;">{{bytes .Overall.Traffic}}</div><div style="font-size:12px;color:#6b7280;">新 {{num .Overall.NewVisitorCount}} / 老 {{num .Overall.ReturningVisitorCount}}</div></td>
+    </tr>
+  </table>
+
+  <h3 style="margin:0 0 8px;">错误</h3>
+  <p style="margin:0 0 8px;font-size:14px;">4xx：{{num .Errors.S4xx}}（上期 {{num .Errors.PrevS4xx}}） · 5xx：{{num .Errors.S5xx}}（上期 {{num .Errors.PrevS5xx}}） · 错误率 {{printf "%.2f" .Errors.ErrorRate}}%</p>
+  {{if .Errors.TopURLs}}
+  <table style="width:100%;border-collapse:collapse;font-size:13px;margin-bottom:12px;">
+    <tr style="background:#f9fafb;"><th style="text-align:left;padding:6px;border:1px solid #e5e7eb;">状态码</th><th style="text-align:left;padding:6px;border:1px solid #e5e7eb;">URL</th><th style="text-align:right;padding:6px;border:1px solid #e5e7eb;">次数</th></tr>
+    {{range .Errors.TopURLs}}<tr><td style="padding:6px;border:1px solid #e5e7eb;">{{.StatusCode}}</td><td style="padding:6px;border:1px solid #e5e7eb;word-break:break-all;">{{.URL}}</td><td style="text-align:right;padding:6px;border:1px solid #e5e7eb;">{{num .Count}}</td></tr>
+    {{end}}
+  </table>
+  {{end}}
+  {{if .Errors.Anomalies}}
+  <p style="margin:12px 0 6px;font-size:14px;font-weight:600;">流量异常（共 {{.Errors.AnomalyTotal}} 条）</p>
+  <ul style="margin:0 0 12px;padding-left:20px;font-size:13px;">
+    {{range .Errors.Anomalies}}<li>[{{.Severity}}] {{.Time}} {{.Name}} {{.Metric}} {{if eq .Direction "drop"}}骤降{{else}}突增{{end}}：{{printf "%.0f" .Value}}（基线 {{printf "%.0f" .Baseline}}）</li>
+    {{end}}
+  </ul>
+  {{end}}
+
+  {{range $section := .Sections}}{{if $section.Items}}
+  <h3 style="margin:20px 0 8px;">{{$section.Title}}</h3>
+  <table style="width:100%;border-collapse:collapse;font-size:13px;">
+    <tr style="background:#f9fafb;"><th style="text-align:left;padding:6px;border:1px solid #e5e7eb;">{{$section.KeyTitle}}</th><th style="text-align:right;padding:6px;border:1px solid #e5e7eb;">PV</th><th style="text-align:right;padding:6px;border:1px solid #e5e7eb;">UV</th></tr>
+    {{range $section.Items}}<tr><td style="padding:6px;border:1px solid #e5e7eb;word-break:break-all;">{{.Key}}</td><td style="text-align:right;padding:6px;border:1px solid #e5e7eb;">{{num .PV}}</td><td style="text-align:right;padding:6px;border:1px solid #e5e7eb;">{{num .UV}}</td></tr>
+    {{end}}
+  </table>
+  {{end}}{{end}}
+
+  <div style="color:#9ca3af;font-size:12px;margin-top:24px;">NginxPulse · 报表 {{.Report}} · 生成于 {{date "2006-01-02 15:04:05" .GeneratedAt}}</div>
+</div>
+</body>
+</html>
+`))
+
+type reportSection struct {
+	Title    string
+	KeyTitle string
+	Items    []ReportTopItem
+}
+
+// reportHTMLData 在摘要之外补充 HTML 模板按区块渲染排行所需的数据。
+type reportHTMLData struct {
+	*ReportDigest
+	Sections []reportSection
+}
+
+// RenderReport 将摘要渲染为指定格式，返回内容与 Content-Type。
+func RenderReport(digest *ReportDigest, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch format {
+	case ReportFormatHTML:
+		data := reportHTMLData{
+			ReportDigest: digest,
+			Sections: []reportSection{
+				{Title: "热门页面", KeyTitle: "URL", Items: digest.TopURLs},
+				{Title: "来源", KeyTitle: "来源", Items: digest.TopReferers},
+				{Title: "地区", KeyTitle: "地区", Items: digest.TopLocations},
+			},
+		}
+		if err := reportHTMLTemplate.Execute(&buf, data); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "text/html; charset=utf-8", nil
+	case ReportFormatMarkdown:
+		if err := reportMarkdownTemplate.Execute(&buf, digest); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "text/markdown; charset=utf-8", nil
+	case ReportFormatJSON:
+		payload, err := json.Marshal(digest)
+		if err != nil {
+			return nil, "", err
+		}
+		return payload, "application/json; charset=utf-8", nil
+	default:
+		return nil, "", fmt.Errorf("不支持的报表格式: %s", format)
+	}
+}
+
+func formatReportNumber(value interface{}) string {
+	var n int64
+	switch v := value.(type) {
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	default:
+		return fmt.Sprint(value)
+	}
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	raw := fmt.Sprintf("%d", n)
+	var out strings.Builder
+	for i, ch := range raw {
+		if i > 0 && (len(raw)-i)%3 == 0 {
+			out.WriteByte(',')
+		}
+		out.WriteRune(ch)
+	}
+	return sign + out.String()
+}
+
+func formatReportBytes(value int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size := float64(value)
+	idx := 0
+	for size >= 1024 && idx < len(units)-1 {
+		size /= 1024
+		idx++
+	}
+	if idx == 0 {
+		return fmt.Sprintf("%d B", value)
+	}
+	return fmt.Sprintf("%.2f %s", size, units[idx])
+}
+
+func formatReportChange(value *float64) string {
+	if value == nil {
+		return "上期无数据"
+	}
+	if *value > 0 {
+		return fmt.Sprintf("环比 +%.1f%%", *value)
+	}
+	return fmt.Sprintf("环比 %.1f%%", *value)
+}
@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatReportValues(t *testing.T) {
+	numbers := map[interface{}]string{
+		0:               "0",
+		999:             "999",
+		1000:            "1,000",
+		int64(-1234567): "-1,234,567",
+		"n/a":           "n/a",
+	}
+	for value, want := range numbers {
+		if got := formatReportNumber(value); got != want {
+			t.Errorf("formatReportNumber(%v) = %q, want %q", value, got, want)
+		}
+	}
+
+	bytes := map[int64]string{
+		512:                    "512 B",
+		1536:                   "1.50 KB",
+		5 * 1024 * 1024 * 1024: "5.00 GB",
+		3 << 50:                "3072.00 TB",
+	}
+	for value, want := range bytes {
+		if got := formatReportBytes(value); got != want {
+			t.Errorf("formatReportBytes(%d) = %q, want %q", value, got, want)
+		}
+	}
+
+	up, down := 12.34, -5.0
+	for value, want := range map[*float64]string{nil: "上期无数据", &up: "环比 +12.3%", &down: "环比 -5.0%"} {
+		if got := formatReportChange(value); got != want {
+			t.Errorf("formatReportChange = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRenderReport(t *testing.T) {
+	digest := &ReportDigest{
+		Title:       "周报 <blog>",
+		WebsiteName: "blog",
+		Overall:     ReportOverall{PV: 12345, Traffic: 2048},
+		TopURLs:     []ReportTopItem{{Key: "/a?<script>", PV: 10, UV: 3}},
+	}
+	for format, contentType := range map[string]string{
+		ReportFormatHTML:     "text/html; charset=utf-8",
+		ReportFormatMarkdown: "text/markdown; charset=utf-8",
+		ReportFormatJSON:     "application/json; charset=utf-8",
+	} {
+		body, gotType, err := RenderReport(digest, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if gotType != contentType || len(body) == 0 {
+			t.Fatalf("%s: content type %q, %d bytes", format, gotType, len(body))
+		}
+		if format == ReportFormatHTML && (strings.Contains(string(body), "<script>") || !strings.Contains(string(body), "12,345")) {
+			t.Fatalf("html report must escape keys and format numbers:\n%s", body)
+		}
+	}
+	if _, _, err := RenderReport(digest, "pdf"); err == nil {
+		t.Fatal("expected an error for an unsupported format")
+	}
+}
@@ -97,6 +97,14 @@ func ExecutePeriodicTasks(parser *ingest.LogParser, interval time.Duration) {
 			logrus.Infof("IP 归属地回填完成: %d 个 IP", processed)
 		}
 	}
+
+	{ // 6 agent 心跳检查
+		parser.CheckAgentHeartbeats()
+	}
+
+	{ // 7 流量异常检测
+		parser.DetectTrafficAnomalies()
+	}
 }
 
 func backfillBudget(interval time.Duration) (time.Duration, int64) {
@@ -3,6 +3,8 @@ import client from './client';
 import type {
   AppStatusResponse,
   ApiResponse,
+  AuthOptions,
+  AuthPrincipal,
   ConfigPayload,
   ConfigResponse,
   ConfigSaveResponse,
@@ -11,6 +13,7 @@ import type {
   LogsExportStartResponse,
   LogsExportStatusResponse,
   LogsExportListResponse,
+  LoginResponse,
   IPGeoAPIFailureListResponse,
   RefererIPBatchStats,
   SimpleSeriesStats,
@@ -62,11 +65,39 @@ export const saveConfig = async (config: ConfigPayload): Promise<ConfigSaveRespo
   return response.data;
 };
 
+export const reloadConfig = async (): Promise<ConfigSaveResponse> => {
+  const response = await client.post<ApiResponse<ConfigSaveResponse>>('api/config/reload');
+  return response.data;
+};
+
 export const restartSystem = async (): Promise<{ success: boolean }> => {
   const response = await client.post<ApiResponse<{ success: boolean }>>('api/system/restart');
   return response.data;
 };
 
+export const login = async (username: string, password: string): Promise<LoginResponse> => {
+  const response = await client.post<ApiResponse<LoginResponse>>('api/auth/login', {
+    username,
+    password,
+  });
+  return response.data;
+};
+
+export const logout = async (): Promise<{ success: boolean }> => {
+  const response = await client.post<ApiResponse<{ success: boolean }>>('api/auth/logout');
+  return response.data;
+};
+
+export const fetchAuthOptions = async (): Promise<AuthOptions> => {
+  const response = await client.get<ApiResponse<AuthOptions>>('api/auth/options');
+  return response.data;
+};
+
+export const fetchCurrentPrincipal = async (): Promise<AuthPrincipal> => {
+  const response = await client.get<ApiResponse<{ principal: AuthPrincipal }>>('api/auth/me');
+  return response.data.principal;
+};
+
 export const reparseLogs = async (websiteId: string): Promise<void> => {
   await client.post<ApiResponse<{ success: boolean }>>('api/logs/reparse', {
     id: websiteId,
@@ -25,6 +25,8 @@ export interface AppStatusResponse {
   migration_required?: boolean;
   setup_required?: boolean;
   config_readonly?: boolean;
+  config_version?: number;
+  config_deprecations?: FieldError[];
 }
 
 export interface SourceConfig {
@@ -83,6 +85,7 @@ export interface PVFilterConfig {
 }
 
 export interface ConfigPayload {
+  version?: number;
   system: SystemConfig;
   server: ServerConfig;
   database: DatabaseConfig;
@@ -93,6 +96,8 @@ export interface ConfigPayload {
 export interface FieldError {
   field: string;
   message: string;
+  file?: string;
+  line?: number;
 }
 
 export interface ConfigValidationResult {
@@ -107,9 +112,33 @@ export interface ConfigResponse {
   default_log_path?: string;
 }
 
+export interface WebsiteRef {
+  id: string;
+  name: string;
+}
+
+export interface ConfigReloadDiff {
+  websitesAdded: WebsiteRef[];
+  websitesRemoved: WebsiteRef[];
+  websitesChanged: WebsiteRef[];
+  sourcesChanged: WebsiteRef[];
+  whitelistChanged: WebsiteRef[];
+  parseChanged: WebsiteRef[];
+  pvFilterChanged: boolean;
+  websiteGroupsChanged: boolean;
+  reportsChanged: boolean;
+  accessKeys: { added: number; removed: number };
+  agentTokens: { added: number; removed: number };
+  systemChanged: string[];
+  restartRequired: string[];
+}
+
 export interface ConfigSaveResponse {
   success: boolean;
   restart_required?: boolean;
+  reloaded?: boolean;
+  reload_error?: string;
+  diff?: ConfigReloadDiff;
 }
 
 export interface TimeSeriesStats {
@@ -230,4 +259,38 @@ export interface SystemNotificationListResponse {
   unread_count?: number;
 }
 
+export type UserRole = 'admin' | 'editor' | 'viewer';
+
+export interface AuthUser {
+  id: number;
+  username: string;
+  provider: 'local' | 'oidc';
+  role: UserRole;
+  websites: string[];
+  disabled: boolean;
+  created_at?: string;
+  updated_at?: string;
+  last_login_at?: string | null;
+}
+
+export interface LoginResponse {
+  token: string;
+  expires_at: string;
+  user: AuthUser;
+}
+
+export interface AuthPrincipal {
+  kind: 'anonymous' | 'guest' | 'access_key' | 'session';
+  userId?: number;
+  username?: string;
+  provider?: 'local' | 'oidc';
+  role: UserRole | '';
+  websites: string[] | null;
+}
+
+export interface AuthOptions {
+  password: boolean;
+  oidc: { enabled: boolean; label?: string };
+}
+
 export type ApiResponse<T> = T;
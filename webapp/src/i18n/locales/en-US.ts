@@ -105,13 +105,19 @@ const enUS = {
     iosGuideDone: 'Got it',
   },
   access: {
-    title: 'Access Key Required',
-    subtitle: 'Enter the configured access key to continue using NginxPulse.',
+    title: 'Sign In or Access Key Required',
+    subtitle: 'Sign in with your account, or fill in only the access key to continue using NginxPulse.',
     placeholder: 'Enter access key',
     submitting: 'Verifying...',
     submit: 'Enter',
-    required: 'Please enter an access key',
+    required: 'Please enter an access key or password',
     invalid: 'Invalid access key',
+    usernamePlaceholder: 'Username (leave empty for access key)',
+    passwordPlaceholder: 'Enter password',
+    loginFailed: 'Invalid username or password',
+    sessionExpired: 'Session expired, please sign in again',
+    sso: 'Sign in with SSO',
+    logout: 'Sign out',
   },
   theme: {
     toggle: 'Toggle theme',
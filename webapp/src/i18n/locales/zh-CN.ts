@@ -105,13 +105,19 @@ const zhCN = {
     iosGuideDone: '知道了',
   },
   access: {
-    title: '需要访问密钥',
-    subtitle: '请输入配置的访问密钥后继续使用 NginxPulse。',
+    title: '需要登录或访问密钥',
+    subtitle: '请使用账号登录，或只填写访问密钥后继续使用 NginxPulse。',
     placeholder: '输入访问密钥',
     submitting: '验证中...',
     submit: '进入系统',
-    required: '请输入访问密钥',
+    required: '请输入访问密钥或密码',
     invalid: '访问密钥无效',
+    usernamePlaceholder: '用户名（使用访问密钥时留空）',
+    passwordPlaceholder: '输入密码',
+    loginFailed: '用户名或密码错误',
+    sessionExpired: '登录已失效，请重新登录',
+    sso: '使用单点登录',
+    logout: '退出登录',
   },
   theme: {
     toggle: '切换主题',